@@ -0,0 +1,121 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/accounts/abi"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// ABIPrefix stores the json ABI registered for a contract address. The registry
+// is node local metadata for readable query answers, it isn't part of the state.
+var ABIPrefix = []byte("abi-")
+
+func abiKey(addr common.Address) []byte {
+	return append(append([]byte{}, ABIPrefix...), addr.Bytes()...)
+}
+
+// RegisterABI records the json ABI of the contract at addr, replacing any ABI
+// registered before.
+func (app *EVMApp) RegisterABI(addr common.Address, abiJSON []byte) error {
+	if _, err := abi.JSON(bytes.NewReader(abiJSON)); err != nil {
+		return fmt.Errorf("invalid abi: %v", err)
+	}
+	return app.stateDb.Put(abiKey(addr), abiJSON)
+}
+
+// decodeInput decodes the call input with the ABI registered for to, falling
+// back to the raw input.
+func (app *EVMApp) decodeInput(to *common.Address, input []byte) *rtypes.DecodedInput {
+	decoded := &rtypes.DecodedInput{Raw: input}
+	if to == nil || len(input) < 4 {
+		return decoded
+	}
+	abiJSON, err := app.stateDb.Get(abiKey(*to))
+	if err != nil || len(abiJSON) == 0 {
+		return decoded
+	}
+	contractABI, err := abi.JSON(bytes.NewReader(abiJSON))
+	if err != nil {
+		return decoded
+	}
+	method, err := contractABI.MethodById(input[:4])
+	if err != nil {
+		return decoded
+	}
+	values, err := method.Inputs.UnpackValues(input[4:])
+	if err != nil || len(values) != len(method.Inputs) {
+		return decoded
+	}
+	decoded.Method = method.Name
+	decoded.Args = make([]rtypes.DecodedArg, len(values))
+	for i, value := range values {
+		decoded.Args[i] = rtypes.DecodedArg{
+			Name:  method.Inputs[i].Name,
+			Type:  method.Inputs[i].Type.String(),
+			Value: formatABIValue(value),
+		}
+	}
+	return decoded
+}
+
+func formatABIValue(value interface{}) string {
+	switch v := value.(type) {
+	case common.Address:
+		return v.Hex()
+	case []byte:
+		return hexutil.Encode(v)
+	case [32]byte:
+		return hexutil.Encode(v[:])
+	case *big.Int:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// queryDecodeInput returns the json rtypes.DecodedInput of the input of the tx
+// with the 32 bytes hash.
+func (app *EVMApp) queryDecodeInput(load []byte) gtypes.Result {
+	if len(load) != common.HashLength {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid hash")
+	}
+	data, err := app.core.Query(gtypes.QueryTx, load)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	result, ok := data.(*gtypes.ResultTransaction)
+	if !ok {
+		return gtypes.NewError(gtypes.CodeType_InternalError, "unexpected tx query answer")
+	}
+	tx := new(etypes.Transaction)
+	if err := rlp.DecodeBytes(result.RawTransaction, tx); err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	decoded, err := json.Marshal(app.decodeInput(tx.To(), tx.Data()))
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(decoded, "")
+}
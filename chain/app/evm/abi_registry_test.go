@@ -0,0 +1,92 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/accounts/abi"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+const testTransferABI = `[{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}]`
+
+func queryTestDecodeInput(t *testing.T, app *EVMApp, hash common.Hash) rtypes.DecodedInput {
+	res := app.Query(append([]byte{rtypes.QueryType_DecodeInput}, hash.Bytes()...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var decoded rtypes.DecodedInput
+	if err := json.Unmarshal(res.Data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestQueryDecodeInput(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	core := &testCore{txs: make(map[common.Hash][]byte)}
+	app.SetCore(core)
+
+	contractABI, err := abi.JSON(strings.NewReader(testTransferABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, err := contractABI.Pack("transfer", common.HexToAddress("0x1234"), big.NewInt(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := testKey(t, testKeyA)
+	token := common.HexToAddress("0xabcd")
+	raw := signTestTx(t, key, etypes.NewTransaction(0, token, big.NewInt(0), testGas, big.NewInt(0), input))
+	core.txs[txHash(raw)] = raw
+
+	// no abi registered, raw input only
+	if decoded := queryTestDecodeInput(t, app, txHash(raw)); decoded.Method != "" || !reflect.DeepEqual([]byte(decoded.Raw), input) {
+		t.Fatalf("unexpected undecoded input %+v", decoded)
+	}
+
+	if err := app.RegisterABI(token, []byte(testTransferABI)); err != nil {
+		t.Fatal(err)
+	}
+	decoded := queryTestDecodeInput(t, app, txHash(raw))
+	expected := []rtypes.DecodedArg{
+		{Name: "to", Type: "address", Value: common.HexToAddress("0x1234").Hex()},
+		{Name: "value", Type: "uint256", Value: "1000"},
+	}
+	if decoded.Method != "transfer" || !reflect.DeepEqual(decoded.Args, expected) || !reflect.DeepEqual([]byte(decoded.Raw), input) {
+		t.Fatalf("unexpected decoded input %+v", decoded)
+	}
+
+	// input not matching the abi falls back to raw
+	bad := signTestTx(t, key, etypes.NewTransaction(1, token, big.NewInt(0), testGas, big.NewInt(0), input[:10]))
+	core.txs[txHash(bad)] = bad
+	if decoded := queryTestDecodeInput(t, app, txHash(bad)); decoded.Method != "" || len(decoded.Raw) != 10 {
+		t.Fatalf("unexpected decoding of a truncated input %+v", decoded)
+	}
+
+	if err := app.RegisterABI(token, []byte("{")); err == nil {
+		t.Fatal("expected invalid abi to be rejected")
+	}
+	if res := app.Query(append([]byte{rtypes.QueryType_DecodeInput}, make([]byte, 32)...)); res.IsOK() {
+		t.Fatal("expected unknown tx to fail")
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/core/vm"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// AppMessagesPrefix indexes the rlp encoded rtypes.AppMessageBatch of the blocks
+// sending app messages by height
+var AppMessagesPrefix = []byte("appmessages-")
+
+func appMessagesKey(height uint64) []byte {
+	key := make([]byte, len(AppMessagesPrefix)+8)
+	copy(key, AppMessagesPrefix)
+	binary.BigEndian.PutUint64(key[len(AppMessagesPrefix):], height)
+	return key
+}
+
+// Besides the nonce of the last message sent, the storage of
+// vm.AppMessagesAddress holds, all words big endian:
+//
+//	slot 1                         root of the messages of the last block sending some
+//	slot keccak256("inbound", app) nonce of the last message delivered from app
+var appMessagesRootSlot = common.BigToHash(big.NewInt(1))
+
+func appMessagesInboundSlot(from string) common.Hash {
+	return crypto.Keccak256Hash([]byte("inbound"), []byte(from))
+}
+
+// appMessageTxs lists the app message txs of a block, for their trie root
+type appMessageTxs [][]byte
+
+func (l appMessageTxs) Len() int { return len(l) }
+
+func (l appMessageTxs) GetRlp(i int) []byte {
+	enc, _ := rlp.EncodeToBytes(l[i])
+	return enc
+}
+
+// withAppMessages returns config with the app messages precompile enabled or not
+func withAppMessages(config *params.ChainConfig, enabled bool) *params.ChainConfig {
+	if !enabled {
+		return config
+	}
+	messages := *config
+	messages.AppMessages = true
+	return &messages
+}
+
+// deliverAppMessages runs the app message txs of block sent to the evm app, in
+// block order before the block txs, as calls from vm.AppMessagesAddress to the
+// target contract with the payload as input. A message whose nonce isn't above
+// the last one delivered from its app is dropped, so a message routed twice is
+// delivered once; a failed call counts as delivered. The calls draw their gas
+// from the system gas reserve of the block, see systemGas. It returns the logs
+// of the calls.
+func (app *EVMApp) deliverAppMessages(exec *blockExecution, block *gtypes.Block) []*etypes.Log {
+	if !app.chainConfig.AppMessages {
+		return nil
+	}
+	state := exec.state
+	blockHash := common.BytesToHash(block.Hash())
+	reserve := app.newSystemGas()
+	var logs []*etypes.Log
+	for i, tx := range block.Data.ExTxs {
+		if !gtypes.IsAppMessageTx(tx) {
+			continue
+		}
+		msg, err := gtypes.DecodeAppMessageTx(tx)
+		if err != nil {
+			log.Warn("[evm execute] invalid app message", zap.Error(err), zap.Int64("height", block.Height))
+			continue
+		}
+		slot := appMessagesInboundSlot(msg.From)
+		if msg.To != AppName || msg.Nonce <= state.GetState(vm.AppMessagesAddress, slot).Big().Uint64() {
+			continue
+		}
+		// a nonce keeps the precompile account from being removed as an empty account
+		if state.GetNonce(vm.AppMessagesAddress) == 0 {
+			state.SetNonce(vm.AppMessagesAddress, 1)
+		}
+		state.SetState(vm.AppMessagesAddress, slot, common.BigToHash(new(big.Int).SetUint64(msg.Nonce)))
+
+		gas, config, err := reserve.draw(app.appMessageGas)
+		if err != nil {
+			log.Warn("[evm execute] app message call failed", zap.String("from", msg.From), zap.Uint64("nonce", msg.Nonce), zap.Error(err))
+			continue
+		}
+		txHash := common.BytesToHash(gtypes.Tx(tx).Hash())
+		state.Prepare(txHash, blockHash, i)
+		target := common.BytesToAddress(msg.Target)
+		call := etypes.NewMessage(vm.AppMessagesAddress, &target, 0, big.NewInt(0), gas, big.NewInt(0), msg.Payload, false)
+		env := vm.NewEVM(core.NewEVMContext(call, exec.header, NewBlockChain(app.stateDb), nil), state, app.chainConfig, config)
+		_, _, err = env.Call(vm.AccountRef(vm.AppMessagesAddress), target, msg.Payload, gas, big.NewInt(0))
+		reserve.used(gas, env, err)
+		if err != nil {
+			log.Warn("[evm execute] app message call failed", zap.String("from", msg.From), zap.Uint64("nonce", msg.Nonce), zap.Error(err))
+		}
+		logs = append(logs, state.GetLogs(txHash)...)
+	}
+	return logs
+}
+
+// collectAppMessages gathers the messages the block sent, from the logs of its
+// receipts and the inbound logs of deliverAppMessages, in nonce order, which is
+// the order they were sent in, and commits their root into the state.
+func (app *EVMApp) collectAppMessages(exec *blockExecution, inbound []*etypes.Log) {
+	if !app.chainConfig.AppMessages {
+		return
+	}
+	logs := inbound
+	for _, receipt := range exec.receipts {
+		logs = append(logs, receipt.Logs...)
+	}
+	var msgs []*gtypes.AppMessage
+	for _, l := range logs {
+		if l.Address != vm.AppMessagesAddress || len(l.Topics) != 4 {
+			continue
+		}
+		msgs = append(msgs, &gtypes.AppMessage{
+			From:    AppName,
+			To:      string(bytes.TrimRight(l.Topics[0].Bytes(), "\x00")),
+			Nonce:   l.Topics[3].Big().Uint64(),
+			Sender:  common.BytesToAddress(l.Topics[2].Bytes()).Bytes(),
+			Target:  l.Topics[1].Bytes(),
+			Payload: l.Data,
+		})
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Nonce < msgs[j].Nonce })
+	txs := make(appMessageTxs, len(msgs))
+	for i, msg := range msgs {
+		txs[i] = gtypes.TagAppMessageTx(msg)
+	}
+	exec.appMessages = txs
+	exec.state.SetState(vm.AppMessagesAddress, appMessagesRootSlot, etypes.DeriveSha(txs))
+}
+
+// saveAppMessages stores the messages of the block at height OnExecute collected
+func (app *EVMApp) saveAppMessages(height uint64) error {
+	if len(app.appMessages) == 0 {
+		return nil
+	}
+	data, err := rlp.EncodeToBytes(&rtypes.AppMessageBatch{
+		Height: height,
+		Root:   etypes.DeriveSha(appMessageTxs(app.appMessages)),
+		Txs:    app.appMessages,
+	})
+	if err != nil {
+		return err
+	}
+	return app.stateDb.Put(appMessagesKey(height), data)
+}
+
+// AppMessages returns the messages the committed block at height sent to the
+// sibling apps of the node, for the node to route them: each tx goes to the app
+// of its message. The batch of a block sending none is empty.
+func (app *EVMApp) AppMessages(height uint64) (*rtypes.AppMessageBatch, error) {
+	if height == 0 || height > uint64(atomic.LoadInt64(&app.committedHeight)) {
+		return nil, fmt.Errorf("no committed block at height %d", height)
+	}
+	key := appMessagesKey(height)
+	if has, err := app.stateDb.Has(key); err != nil || !has {
+		return &rtypes.AppMessageBatch{Height: height}, err
+	}
+	data, err := app.stateDb.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	batch := &rtypes.AppMessageBatch{}
+	if err := rlp.DecodeBytes(data, batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// queryAppMessages takes an optional 8 bytes big endian height, the committed
+// height by default, and returns the rlp encoded rtypes.AppMessageBatch of the
+// block there.
+func (app *EVMApp) queryAppMessages(load []byte) gtypes.Result {
+	height := uint64(atomic.LoadInt64(&app.committedHeight))
+	switch len(load) {
+	case 0:
+	case 8:
+		height = binary.BigEndian.Uint64(load)
+	default:
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong height")
+	}
+	batch, err := app.AppMessages(height)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(batch)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,147 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/core/vm"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// messengerCode deploys a contract storing the input word 0 at slot 0 when
+// called by the app messages precompile, and passing its input on to the
+// precompile otherwise
+var messengerCode = common.FromHex("6024600c60003960246000f3" +
+	"3360fd14601c57" + "366000600037" + "60006000366000600060fd5af15000" + "5b60003560005500")
+
+// stubSiblingApp stands for an app running next to the evm app on the node: it
+// keeps the messages routed to it and numbers the ones it sends
+type stubSiblingApp struct {
+	name  string
+	nonce uint64
+	inbox []*gtypes.AppMessage
+}
+
+func (s *stubSiblingApp) receive(t *testing.T, txs [][]byte) {
+	for _, tx := range txs {
+		msg, err := gtypes.DecodeAppMessageTx(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.To == s.name {
+			s.inbox = append(s.inbox, msg)
+		}
+	}
+}
+
+func (s *stubSiblingApp) send(to string, target, payload []byte) []byte {
+	s.nonce++
+	return gtypes.TagAppMessageTx(&gtypes.AppMessage{From: s.name, To: to, Nonce: s.nonce, Target: target, Payload: payload})
+}
+
+func queryTestAppMessages(t *testing.T, app *EVMApp, height uint64) *rtypes.AppMessageBatch {
+	load := make([]byte, 8)
+	binary.BigEndian.PutUint64(load, height)
+	res := app.Query(append([]byte{rtypes.QueryType_AppMessages}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	batch := &rtypes.AppMessageBatch{}
+	if err := rlp.DecodeBytes(res.Data, batch); err != nil {
+		t.Fatal(err)
+	}
+	return batch
+}
+
+func TestAppMessages(t *testing.T) {
+	conf := viper.New()
+	conf.Set("app_messages", true)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	sibling := &stubSiblingApp{name: "kv"}
+
+	// block 1 deploys the messenger and sends a message to the sibling
+	key, addr := testKey(t, testKeyA)
+	messenger := crypto.CreateAddress(addr, 0)
+	target := common.LeftPadBytes([]byte("alice"), common.HashLength)
+	input := append(common.RightPadBytes([]byte(sibling.name), common.HashLength), target...)
+	input = append(input, []byte("ping")...)
+	commitTestBlock(t, app, makeTestBlock(1,
+		signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), messengerCode)),
+		signTestTx(t, key, etypes.NewTransaction(1, messenger, big.NewInt(0), testGas, big.NewInt(0), input))))
+
+	batch := queryTestAppMessages(t, app, 1)
+	if len(batch.Txs) != 1 || batch.Height != 1 {
+		t.Fatalf("expected one message sent by block 1, got %+v", batch)
+	}
+	if root := app.state.GetState(vm.AppMessagesAddress, appMessagesRootSlot); root != batch.Root || root != etypes.DeriveSha(appMessageTxs(batch.Txs)) {
+		t.Fatal("expected the messages root committed into the state")
+	}
+	sibling.receive(t, batch.Txs)
+	if len(sibling.inbox) != 1 {
+		t.Fatal("expected the sibling to receive the message")
+	}
+	msg := sibling.inbox[0]
+	if msg.From != AppName || msg.Nonce != 1 || !bytes.Equal(msg.Sender, messenger.Bytes()) || !bytes.Equal(msg.Target, target) || string(msg.Payload) != "ping" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+
+	// block 2 carries the answer, routed twice, as a call of the messenger
+	reply := sibling.send(AppName, messenger.Bytes(), common.RightPadBytes([]byte("pong"), common.HashLength))
+	block := makeTestBlock(2)
+	block.Data.ExTxs = append(block.Data.ExTxs, reply, reply)
+	commitTestBlock(t, app, block)
+	if stored := app.state.GetState(messenger, common.Hash{}); stored != common.BytesToHash(common.RightPadBytes([]byte("pong"), common.HashLength)) {
+		t.Fatalf("expected the answer delivered to the messenger, got %x", stored)
+	}
+	// a message of a nonce already delivered is dropped
+	stale := gtypes.TagAppMessageTx(&gtypes.AppMessage{From: sibling.name, To: AppName, Nonce: 1, Target: messenger.Bytes(), Payload: common.RightPadBytes([]byte("again"), common.HashLength)})
+	block = makeTestBlock(3)
+	block.Data.ExTxs = append(block.Data.ExTxs, stale)
+	commitTestBlock(t, app, block)
+	if stored := app.state.GetState(messenger, common.Hash{}); stored != common.BytesToHash(common.RightPadBytes([]byte("pong"), common.HashLength)) {
+		t.Fatal("expected the stale message to be dropped")
+	}
+	if batch := queryTestAppMessages(t, app, 2); len(batch.Txs) != 0 {
+		t.Fatal("expected block 2 to send no message")
+	}
+}
+
+func TestAppMessagesDisabled(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	key, addr := testKey(t, testKeyA)
+	messenger := crypto.CreateAddress(addr, 0)
+	input := append(common.RightPadBytes([]byte("kv"), 2*common.HashLength), []byte("ping")...)
+	commitTestBlock(t, app, makeTestBlock(1,
+		signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), messengerCode)),
+		signTestTx(t, key, etypes.NewTransaction(1, messenger, big.NewInt(0), testGas, big.NewInt(0), input))))
+	if batch := queryTestAppMessages(t, app, 1); len(batch.Txs) != 0 {
+		t.Fatal("expected no message without app_messages")
+	}
+	if app.state.Exist(vm.AppMessagesAddress) {
+		t.Fatal("expected the precompile account untouched")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+	"fmt"
+
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/core/vm"
+	"github.com/dappledger/AnnChain/eth/params"
+)
+
+var (
+	// ErrBlockGasExhausted fails a tx whose gas limit doesn't fit in the user gas
+	// the txs before it left in the block, CheckTx refuses the txs not fitting in
+	// an empty block
+	ErrBlockGasExhausted = errors.New("user gas of the block used up")
+	// errSystemGasExhausted fails a system call once the block used up the gas
+	// reserved for the system work
+	errSystemGasExhausted = errors.New("system gas reserve of the block used up")
+)
+
+// withBlockGas returns config with the block gas limit and the system gas reserve set
+func withBlockGas(config *params.ChainConfig, limit, reserve uint64) (*params.ChainConfig, error) {
+	if limit > 0 && reserve >= limit {
+		return nil, fmt.Errorf("system_gas_reserve %d leaves no gas of block_gas_limit %d to the txs", reserve, limit)
+	}
+	if limit == 0 && reserve == 0 {
+		return config, nil
+	}
+	limited := *config
+	limited.BlockGasLimit, limited.SystemGasReserve = limit, reserve
+	return &limited, nil
+}
+
+// userBlockGas is the gas of a block the user txs may take, the block gas limit
+// minus the system reserve, 0 for no limit. Both are consensus settings checked
+// against the chain's on start, so CheckTx, Reap and the execution of a block
+// all count against the limit the chain recorded.
+func (app *EVMApp) userBlockGas() uint64 {
+	if app.chainConfig.BlockGasLimit == 0 {
+		return 0
+	}
+	return app.chainConfig.BlockGasLimit - app.chainConfig.SystemGasReserve
+}
+
+// checkBlockGas fails a tx once its gas limit doesn't fit in the user gas left by
+// the blockGas the txs executed before it in the block used. Like the gas pool
+// of ethereum, the limit is checked before the execution and the gas used is
+// counted after it.
+func (app *EVMApp) checkBlockGas(blockGas uint64, tx *etypes.Transaction) error {
+	limit := app.userBlockGas()
+	if limit > 0 && (blockGas > limit || tx.Gas() > limit-blockGas) {
+		return ErrBlockGasExhausted
+	}
+	return nil
+}
+
+// reapGasBudget counts the gas limits of the txs reaped for a proposal against
+// the user gas of the block, so the proposal doesn't carry txs its execution
+// would invalidate
+type reapGasBudget struct {
+	limit, used uint64
+}
+
+// take counts tx in the budget, false when it doesn't fit
+func (b *reapGasBudget) take(tx *etypes.Transaction) bool {
+	if b.limit == 0 {
+		return true
+	}
+	if tx.Gas() > b.limit-b.used {
+		return false
+	}
+	b.used += tx.Gas()
+	return true
+}
+
+// systemGas is the gas reserve the system calls of a block draw from. Each call
+// gets its own cap, or what is left of the reserve when less, as the execution
+// gas of its evm. A call running out of it fails like any call out of gas and,
+// like any failed call, uses up the gas it drew; once the reserve is used up the
+// calls fail without running. Either way the block goes on, the same way on
+// every node.
+type systemGas struct {
+	metered bool
+	left    uint64
+}
+
+func (app *EVMApp) newSystemGas() *systemGas {
+	reserve := app.chainConfig.SystemGasReserve
+	return &systemGas{metered: reserve > 0, left: reserve}
+}
+
+// draw returns the gas of a system call capped at callCap, and the vm config
+// of the evm running it
+func (g *systemGas) draw(callCap uint64) (uint64, vm.Config, error) {
+	config := evmConfig
+	if !g.metered {
+		return callCap, config, nil
+	}
+	if g.left == 0 {
+		return 0, config, errSystemGasExhausted
+	}
+	gas := callCap
+	if gas > g.left {
+		gas = g.left
+	}
+	config.EVMGasLimit = gas
+	return gas, config, nil
+}
+
+// used counts the execution gas env used of the gas drawn for its call, all of
+// it when the call failed with err
+func (g *systemGas) used(gas uint64, env *vm.EVM, err error) {
+	if !g.metered {
+		return
+	}
+	if err != nil {
+		g.left -= gas
+		return
+	}
+	g.left -= gas - env.GasLeft()
+}
@@ -0,0 +1,184 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/core/vm"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/params"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// transferGas is the gas a plain transfer uses
+const transferGas = 21000
+
+func newBlockGasTestApp(t *testing.T, limit, reserve uint64) (*EVMApp, func()) {
+	conf := viper.New()
+	conf.Set("app_messages", true)
+	conf.Set("block_gas_limit", limit)
+	conf.Set("system_gas_reserve", reserve)
+	return newTestAppWithConfig(t, conf)
+}
+
+func execTestBlockWith(t *testing.T, app *EVMApp, block *gtypes.Block) gtypes.ExecuteResult {
+	res, err := app.OnExecute(block.Height, 0, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.OnCommit(block.Height, 0, block); err != nil {
+		t.Fatal(err)
+	}
+	return res.(gtypes.ExecuteResult)
+}
+
+func TestBlockGasReserve(t *testing.T) {
+	// the user txs may take testGas, enough for the messenger deployment
+	app, clean := newBlockGasTestApp(t, testGas+100000, 100000)
+	defer clean()
+	sibling := &stubSiblingApp{name: "kv"}
+	key, addr := testKey(t, testKeyA)
+	messenger := crypto.CreateAddress(addr, 0)
+	commitTestBlock(t, app, makeTestBlock(1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), messengerCode))))
+
+	// a block of transfers to the brim, the transfers beyond the user gas are
+	// invalid and the message is still delivered
+	fit := uint64(testGas / transferGas)
+	var txs [][]byte
+	for nonce := uint64(1); nonce <= fit+2; nonce++ {
+		txs = append(txs, signTestTx(t, key, etypes.NewTransaction(nonce, common.HexToAddress("0x01"), big.NewInt(0), transferGas, big.NewInt(0), nil)))
+	}
+	block := makeTestBlock(2, txs...)
+	block.Data.ExTxs = append(block.Data.ExTxs, sibling.send(AppName, messenger.Bytes(), common.RightPadBytes([]byte("pong"), common.HashLength)))
+	res := execTestBlockWith(t, app, block)
+	if uint64(len(res.ValidTxs)) != fit || len(res.InvalidTxs) != 2 {
+		t.Fatalf("expected %d valid txs, got %d valid %d invalid", fit, len(res.ValidTxs), len(res.InvalidTxs))
+	}
+	for _, invalid := range res.InvalidTxs {
+		if invalid.Error != ErrBlockGasExhausted {
+			t.Fatalf("unexpected error %v", invalid.Error)
+		}
+	}
+	if stored := app.state.GetState(messenger, common.Hash{}); stored != common.BytesToHash(common.RightPadBytes([]byte("pong"), common.HashLength)) {
+		t.Fatalf("expected the message delivered in a full block, got %x", stored)
+	}
+
+	// a tx not fitting in an empty block is refused, the pool reaps up to the
+	// user gas
+	if err := app.CheckTx(signTestTx(t, key, etypes.NewTransaction(fit+1, common.HexToAddress("0x01"), big.NewInt(0), testGas+1, big.NewInt(0), nil))); err != ErrBlockGasExhausted {
+		t.Fatalf("expected ErrBlockGasExhausted, got %v", err)
+	}
+	for nonce := fit + 3; nonce > fit; nonce-- {
+		raw := signTestTx(t, key, etypes.NewTransaction(nonce, common.HexToAddress("0x01"), big.NewInt(0), testGas/2, big.NewInt(0), nil))
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.pool.updateToState()
+	if txs := app.pool.Reap(-1); len(txs) != 2 {
+		t.Fatalf("expected 2 txs reaped, got %d", len(txs))
+	}
+}
+
+func TestSystemGasReserveExceeded(t *testing.T) {
+	key, addr := testKey(t, testKeyA)
+	messenger := crypto.CreateAddress(addr, 0)
+	payload := func(word string) []byte { return common.RightPadBytes([]byte(word), common.HashLength) }
+
+	var roots [2]common.Hash
+	for node := range roots {
+		// the reserve covers the first store of the messenger, not the second one,
+		// whose failed call uses it up and fails the third one
+		app, clean := newBlockGasTestApp(t, 0, 24000)
+		sibling := &stubSiblingApp{name: "kv"}
+		commitTestBlock(t, app, makeTestBlock(1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), messengerCode))))
+
+		block := makeTestBlock(2, signTestTx(t, key, etypes.NewTransaction(1, common.HexToAddress("0x01"), big.NewInt(0), testGas, big.NewInt(0), nil)))
+		for _, word := range []string{"pong", "late", "later"} {
+			block.Data.ExTxs = append(block.Data.ExTxs, sibling.send(AppName, messenger.Bytes(), payload(word)))
+		}
+		res := execTestBlockWith(t, app, block)
+		if len(res.ValidTxs) != 1 || len(res.InvalidTxs) != 0 {
+			t.Fatalf("expected the block tx valid, got %d valid %d invalid", len(res.ValidTxs), len(res.InvalidTxs))
+		}
+		if stored := app.state.GetState(messenger, common.Hash{}); stored != common.BytesToHash(payload("pong")) {
+			t.Fatalf("expected the calls beyond the reserve failed, got %x", stored)
+		}
+		if nonce := app.state.GetState(vm.AppMessagesAddress, appMessagesInboundSlot(sibling.name)).Big().Uint64(); nonce != 3 {
+			t.Fatalf("expected the failed messages counted as delivered, got nonce %d", nonce)
+		}
+		roots[node] = app.getLastAppHash()
+		clean()
+	}
+	if roots[0] != roots[1] {
+		t.Fatalf("state roots differ, %x and %x", roots[0], roots[1])
+	}
+
+	if _, err := withBlockGas(params.TestChainConfig, 100000, 100000); err == nil {
+		t.Fatal("expected a reserve leaving no gas to the txs refused")
+	}
+}
+
+func TestBlockGasRecorded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	withGas := func(limit, reserve uint64) *viper.Viper {
+		conf := viper.New()
+		conf.Set("block_gas_limit", limit)
+		conf.Set("system_gas_reserve", reserve)
+		return conf
+	}
+	limit := uint64(3*transferGas + 10000)
+	app, err := startTestApp(dir, withGas(limit, 10000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Stop()
+
+	// a validator restarted with other gas settings would fork off, it's refused
+	for _, gas := range [][2]uint64{{0, 0}, {limit + 1, 10000}, {limit, 0}} {
+		if _, err := startTestApp(dir, withGas(gas[0], gas[1])); err == nil || !strings.Contains(err.Error(), "gas") {
+			t.Fatalf("expected block gas %v refused, got %v", gas, err)
+		}
+	}
+	if app, err = startTestApp(dir, withGas(limit, 10000)); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Stop()
+
+	// the proposals keep to the recorded user gas
+	key, _ := testKey(t, testKeyA)
+	for nonce := uint64(5); nonce > 0; nonce-- {
+		raw := signTestTx(t, key, etypes.NewTransaction(nonce-1, common.HexToAddress("0x01"), big.NewInt(0), transferGas, big.NewInt(0), nil))
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.pool.updateToState()
+	if txs := app.pool.Reap(-1); len(txs) != 3 {
+		t.Fatalf("expected the 3 txs fitting the recorded user gas reaped, got %d", len(txs))
+	}
+}
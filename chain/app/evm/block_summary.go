@@ -0,0 +1,150 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// BlockSummaryPrefix indexes the rlp encoded rtypes.BlockSummary of each block by height
+var BlockSummaryPrefix = []byte("blocksummary-")
+
+func blockSummaryKey(height uint64) []byte {
+	key := make([]byte, len(BlockSummaryPrefix)+8)
+	copy(key, BlockSummaryPrefix)
+	binary.BigEndian.PutUint64(key[len(BlockSummaryPrefix):], height)
+	return key
+}
+
+// saveBlockSummary stores the summary of block, committed to appHash with the
+// receipts of its valid txs.
+func (app *EVMApp) saveBlockSummary(block *gtypes.Block, appHash common.Hash) error {
+	summary := &rtypes.BlockSummary{
+		Height:  uint64(block.Height),
+		AppHash: appHash,
+		Txs:     uint64(len(block.Data.Txs)),
+		Time:    uint64(block.Time.Unix()),
+	}
+	for _, receipt := range app.receipts {
+		summary.GasUsed += receipt.GasUsed
+	}
+	data, err := rlp.EncodeToBytes(summary)
+	if err != nil {
+		return err
+	}
+	return app.stateDb.Put(blockSummaryKey(summary.Height), data)
+}
+
+// RecentBlocks returns the summaries of the last n committed blocks, newest
+// first. The list ends early at the first block, or at a block committed before
+// the summaries were indexed.
+func (app *EVMApp) RecentBlocks(n uint64) ([]*rtypes.BlockSummary, error) {
+	summaries := make([]*rtypes.BlockSummary, 0)
+	for height := uint64(atomic.LoadInt64(&app.committedHeight)); height > 0 && uint64(len(summaries)) < n; height-- {
+		data, err := app.stateDb.Get(blockSummaryKey(height))
+		if err != nil {
+			break
+		}
+		summary := &rtypes.BlockSummary{}
+		if err := rlp.DecodeBytes(data, summary); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// queryRecentBlocks takes an optional 8 bytes big endian number of blocks, up to
+// recent_blocks_limit which is also the default, and returns the rlp encoded
+// []rtypes.BlockSummary of the last committed blocks, newest first.
+func (app *EVMApp) queryRecentBlocks(load []byte) gtypes.Result {
+	n := uint64(app.recentBlocksLimit)
+	switch len(load) {
+	case 0:
+	case 8:
+		n = binary.BigEndian.Uint64(load)
+		if n > uint64(app.recentBlocksLimit) {
+			return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, fmt.Sprintf("too many blocks, limit %d", app.recentBlocksLimit))
+		}
+	default:
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong number of blocks")
+	}
+	summaries, err := app.RecentBlocks(n)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(summaries)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+// GasOverRange sums the gas used by the committed blocks from to to, included,
+// out of their summaries. to 0, or past the committed height, is the committed
+// height. The range holds up to gas_range_limit blocks; the blocks without a
+// summary are counted unavailable.
+func (app *EVMApp) GasOverRange(from, to uint64) (*rtypes.GasOverRange, error) {
+	if committed := uint64(atomic.LoadInt64(&app.committedHeight)); to == 0 || to > committed {
+		to = committed
+	}
+	if from == 0 {
+		from = 1
+	}
+	if from > to {
+		return nil, fmt.Errorf("from %d past to %d", from, to)
+	}
+	if to-from >= uint64(app.gasRangeLimit) {
+		return nil, fmt.Errorf("too many blocks, limit %d", app.gasRangeLimit)
+	}
+	gas := &rtypes.GasOverRange{From: from, To: to}
+	for height := from; height <= to; height++ {
+		data, err := app.stateDb.Get(blockSummaryKey(height))
+		if err != nil {
+			gas.Unavailable++
+			continue
+		}
+		summary := &rtypes.BlockSummary{}
+		if err := rlp.DecodeBytes(data, summary); err != nil {
+			return nil, err
+		}
+		gas.GasUsed += summary.GasUsed
+		gas.Blocks++
+	}
+	return gas, nil
+}
+
+// queryGasOverRange takes the from and to heights as 8 bytes big endian each and
+// returns the rlp encoded rtypes.GasOverRange of the blocks between them.
+func (app *EVMApp) queryGasOverRange(load []byte) gtypes.Result {
+	if len(load) != 16 {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong heights")
+	}
+	gas, err := app.GasOverRange(binary.BigEndian.Uint64(load[:8]), binary.BigEndian.Uint64(load[8:]))
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(gas)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
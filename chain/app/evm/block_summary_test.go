@@ -0,0 +1,157 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func queryTestRecentBlocks(t *testing.T, app *EVMApp, load []byte) []rtypes.BlockSummary {
+	res := app.Query(append([]byte{rtypes.QueryType_RecentBlocks}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var summaries []rtypes.BlockSummary
+	if err := rlp.DecodeBytes(res.Data, &summaries); err != nil {
+		t.Fatal(err)
+	}
+	return summaries
+}
+
+func TestQueryRecentBlocks(t *testing.T) {
+	conf := viper.New()
+	conf.Set("recent_blocks_limit", 4)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	to := common.HexToAddress("0x1234")
+	var nonce uint64
+	appHashes := make(map[uint64]common.Hash)
+	// block h holds h-1 transfers
+	for height := int64(1); height <= 5; height++ {
+		var txs [][]byte
+		for i := int64(1); i < height; i++ {
+			txs = append(txs, signTestTx(t, key, etypes.NewTransaction(nonce, to, big.NewInt(0), testGas, big.NewInt(0), nil)))
+			nonce++
+		}
+		block := makeTestBlock(height, txs...)
+		block.Time = time.Unix(1600000000+height, 0)
+		commitTestBlock(t, app, block)
+		appHashes[uint64(height)] = app.getLastAppHash()
+	}
+
+	load := make([]byte, 8)
+	binary.BigEndian.PutUint64(load, 3)
+	summaries := queryTestRecentBlocks(t, app, load)
+	if len(summaries) != 3 {
+		t.Fatalf("expected 3 summaries, got %d", len(summaries))
+	}
+	for i, summary := range summaries {
+		height := uint64(5 - i)
+		expected := rtypes.BlockSummary{
+			Height:  height,
+			AppHash: appHashes[height],
+			Txs:     height - 1,
+			GasUsed: 21000 * (height - 1),
+			Time:    1600000000 + height,
+		}
+		if summary != expected {
+			t.Fatalf("expected summary %+v, got %+v", expected, summary)
+		}
+	}
+
+	// the limit by default
+	if summaries := queryTestRecentBlocks(t, app, nil); len(summaries) != 4 || summaries[0].Height != 5 || summaries[3].Height != 2 {
+		t.Fatalf("unexpected summaries %+v", summaries)
+	}
+	binary.BigEndian.PutUint64(load, 5)
+	if res := app.Query(append([]byte{rtypes.QueryType_RecentBlocks}, load...)); !res.IsErr() {
+		t.Fatal("expected more blocks than recent_blocks_limit to be refused")
+	}
+}
+
+func queryTestGasOverRange(t *testing.T, app *EVMApp, from, to uint64) (*rtypes.GasOverRange, error) {
+	load := make([]byte, 16)
+	binary.BigEndian.PutUint64(load[:8], from)
+	binary.BigEndian.PutUint64(load[8:], to)
+	res := app.Query(append([]byte{rtypes.QueryType_GasOverRange}, load...))
+	if res.IsErr() {
+		return nil, errors.New(res.Log)
+	}
+	gas := &rtypes.GasOverRange{}
+	if err := rlp.DecodeBytes(res.Data, gas); err != nil {
+		t.Fatal(err)
+	}
+	return gas, nil
+}
+
+func TestQueryGasOverRange(t *testing.T) {
+	conf := viper.New()
+	conf.Set("gas_range_limit", 3)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	to := common.HexToAddress("0x1234")
+	var nonce uint64
+	// block h holds h-1 transfers
+	for height := int64(1); height <= 5; height++ {
+		var txs [][]byte
+		for i := int64(1); i < height; i++ {
+			txs = append(txs, signTestTx(t, key, etypes.NewTransaction(nonce, to, big.NewInt(0), testGas, big.NewInt(0), nil)))
+			nonce++
+		}
+		commitTestBlock(t, app, makeTestBlock(height, txs...))
+	}
+
+	gas, err := queryTestGasOverRange(t, app, 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (rtypes.GasOverRange{From: 2, To: 4, GasUsed: 21000 * 6, Blocks: 3}); *gas != expected {
+		t.Fatalf("expected %+v, got %+v", expected, *gas)
+	}
+	// to 0 is the committed height
+	if gas, err = queryTestGasOverRange(t, app, 4, 0); err != nil || gas.To != 5 || gas.GasUsed != 21000*7 {
+		t.Fatalf("unexpected gas %+v, err %v", gas, err)
+	}
+
+	// a block without its summary, committed before they were indexed, makes
+	// the sum partial
+	if err := app.stateDb.Delete(blockSummaryKey(3)); err != nil {
+		t.Fatal(err)
+	}
+	if gas, err = queryTestGasOverRange(t, app, 2, 4); err != nil || gas.GasUsed != 21000*4 || gas.Blocks != 2 || gas.Unavailable != 1 {
+		t.Fatalf("expected a partial sum, got %+v, err %v", gas, err)
+	}
+
+	if _, err := queryTestGasOverRange(t, app, 1, 5); err == nil {
+		t.Fatal("expected more blocks than gas_range_limit to be refused")
+	}
+	if _, err := queryTestGasOverRange(t, app, 4, 2); err == nil {
+		t.Fatal("expected from past to to be refused")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"math/big"
+	"time"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core/vm"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// callDepthTracer aborts the evm when a frame at the cap depth tries to call or
+// create, keeping the frames up the stack at that point.
+type callDepthTracer struct {
+	cap    int
+	frames []*vm.Contract
+	path   []rtypes.CallFrame
+}
+
+func (t *callDepthTracer) CaptureStart(from common.Address, to common.Address, call bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *callDepthTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if len(t.frames) < depth || t.frames[depth-1] != contract {
+		t.frames = append(t.frames[:depth-1], contract)
+	}
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL, vm.CREATE, vm.CREATE2:
+		if depth >= t.cap && t.path == nil {
+			t.path = make([]rtypes.CallFrame, len(t.frames))
+			for i, frame := range t.frames {
+				t.path[i].Address = frame.Address()
+				copy(t.path[i].Selector[:], frame.Input)
+			}
+			env.Cancel()
+		}
+	}
+	return nil
+}
+
+func (t *callDepthTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *callDepthTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+// queryContractDepthCapped runs the contract query of the rlp encoded tx followed
+// by the 2 bytes big endian cap on the latest state, failing any call or create
+// deeper than cap, and returns the rlp encoded rtypes.CallDepthResult. The query
+// target runs at depth 1.
+func (app *EVMApp) queryContractDepthCapped(load []byte) gtypes.Result {
+	if len(load) < 2 {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong depth cap")
+	}
+	cap := binary.BigEndian.Uint16(load[len(load)-2:])
+	if cap == 0 || uint64(cap) >= params.CallCreateDepth {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong depth cap")
+	}
+	tracer := &callDepthTracer{cap: int(cap)}
+	vmConfig := evmConfig
+	vmConfig.Debug, vmConfig.Tracer = true, tracer
+	txMsg, err := app.queryTxMessage(load[:len(load)-2])
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	txMsg, note := clampGas(txMsg)
+	ret, err := app.simulateContract(txMsg, 0, vmConfig)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	res := rtypes.CallDepthResult{Ret: ret, Capped: tracer.path != nil, Path: tracer.path}
+	if res.Capped {
+		res.Ret = nil
+	}
+	data, err := rlp.EncodeToBytes(&res)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, note)
+}
@@ -0,0 +1,128 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// pingPongCode deploys a contract calling the address in its input word 1 with
+// selector 0xdeadbeef and its own address, so two instances recurse into each other
+var pingPongCode = common.FromHex("601f600c600039601f6000f3" +
+	"63deadbeef60e01b600052" + "30600452" + "60006000602460006000600435" + "5af100")
+
+func queryTestDepthCapped(t *testing.T, app *EVMApp, tx []byte, cap uint16) rtypes.CallDepthResult {
+	load := make([]byte, 2)
+	binary.BigEndian.PutUint16(load, cap)
+	res := app.Query(append(append([]byte{rtypes.QueryType_ContractDepthCapped}, tx...), load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var result rtypes.CallDepthResult
+	if err := rlp.DecodeBytes(res.Data, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestQueryContractDepthCapped(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	a, b := crypto.CreateAddress(addr, 0), crypto.CreateAddress(addr, 1)
+	execTestBlock(t, app, 1,
+		signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), pingPongCode)),
+		signTestTx(t, key, etypes.NewContractCreation(1, big.NewInt(0), testGas, big.NewInt(0), pingPongCode)))
+
+	input := append(common.FromHex("deadbeef"), common.LeftPadBytes(b.Bytes(), 32)...)
+	query := signTestTx(t, key, etypes.NewTransaction(2, a, big.NewInt(0), testGas, big.NewInt(0), input))
+	result := queryTestDepthCapped(t, app, query, 5)
+	if !result.Capped || len(result.Path) != 5 {
+		t.Fatalf("expected the call path up to depth 5, got %+v", result)
+	}
+	for i, frame := range result.Path {
+		want := a
+		if i%2 == 1 {
+			want = b
+		}
+		if frame.Address != want || frame.Selector != [4]byte{0xde, 0xad, 0xbe, 0xef} {
+			t.Fatalf("unexpected frame %d of the path %+v", i, frame)
+		}
+	}
+
+	// a call staying under the cap answers its output
+	query = signTestTx(t, key, etypes.NewTransaction(2, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), nil))
+	if result := queryTestDepthCapped(t, app, query, 1); result.Capped || len(result.Path) != 0 {
+		t.Fatalf("unexpected capped result %+v", result)
+	}
+
+	for _, cap := range []uint16{0, 1024} {
+		load := make([]byte, 2)
+		binary.BigEndian.PutUint16(load, cap)
+		if res := app.Query(append(append([]byte{rtypes.QueryType_ContractDepthCapped}, query...), load...)); res.IsOK() {
+			t.Fatalf("expected cap %d to be rejected", cap)
+		}
+	}
+}
+
+// recursionCode deploys a contract calling itself with its input word plus one
+// until the call fails, then storing at slot 0 and returning the deepest word
+// reached.
+var recursionCode = common.FromHex("602f600c600039602f6000f3" +
+	"600035600101600052" + "60206020602060006000" + "30" + "60645a03" + "f1" + "602257" +
+	"600035602052" + "5b" + "60205180600055" + "60206020f3")
+
+func TestSimulationCallDepth(t *testing.T) {
+	conf := viper.New()
+	conf.Set("simulation_call_depth", 16)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	recursion := crypto.CreateAddress(addr, 0)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), recursionCode)))
+
+	input := make([]byte, 32)
+	out, err := app.CallContract(etypes.NewMessage(addr, &recursion, 0, big.NewInt(0), 20*testGas, big.NewInt(0), input, false), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth := new(big.Int).SetBytes(out).Uint64(); depth != 16 {
+		t.Fatalf("expected the simulation to stop at depth 16, got %d", depth)
+	}
+
+	// blocks keep the standard depth
+	execTestBlock(t, app, 2, signTestTx(t, key, etypes.NewTransaction(1, recursion, big.NewInt(0), 20*testGas, big.NewInt(0), input)))
+	if depth := app.state.GetState(recursion, common.Hash{}).Big().Uint64(); depth != params.CallCreateDepth {
+		t.Fatalf("expected the block to reach depth %d, got %d", params.CallCreateDepth, depth)
+	}
+
+	conf = viper.New()
+	conf.Set("simulation_call_depth", params.CallCreateDepth+1)
+	if _, err := NewEVMApp(conf); err == nil {
+		t.Fatal("expected a depth over the standard one to be rejected")
+	}
+}
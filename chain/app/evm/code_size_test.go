@@ -0,0 +1,91 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// appHashCore serves block metas carrying the app hashes the app committed,
+// block h+1 carrying the app hash of block h
+type appHashCore struct {
+	testCore
+	appHashes map[int64]common.Hash
+}
+
+func (c *appHashCore) GetBlockMeta(height int64) (*gtypes.BlockMeta, error) {
+	appHash, ok := c.appHashes[height-1]
+	if !ok {
+		return nil, fmt.Errorf("no block %d", height)
+	}
+	return &gtypes.BlockMeta{Header: &gtypes.Header{Height: height, AppHash: appHash.Bytes()}}, nil
+}
+
+func queryTestCodeSize(t *testing.T, app *EVMApp, load []byte) uint64 {
+	res := app.Query(append([]byte{rtypes.QueryType_CodeSize}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var size uint64
+	if err := rlp.DecodeBytes(res.Data, &size); err != nil {
+		t.Fatal(err)
+	}
+	return size
+}
+
+func TestQueryCodeSize(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	core := &appHashCore{appHashes: map[int64]common.Hash{0: app.getLastAppHash()}}
+	app.SetCore(core)
+
+	key, addr := testKey(t, testKeyA)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), callerCode)))
+	core.appHashes[1] = app.getLastAppHash()
+	contract := crypto.CreateAddress(addr, 0)
+	code := app.state.GetCode(contract)
+	if len(code) == 0 {
+		t.Fatal("expected the contract deployed")
+	}
+
+	if size := queryTestCodeSize(t, app, contract.Bytes()); size != uint64(len(code)) {
+		t.Fatalf("expected code size %d, got %d", len(code), size)
+	}
+	if size := queryTestCodeSize(t, app, addr.Bytes()); size != 0 {
+		t.Fatalf("expected no code at an account, got %d", size)
+	}
+
+	load := append(contract.Bytes(), make([]byte, 8)...)
+	if size := queryTestCodeSize(t, app, load); size != 0 {
+		t.Fatalf("expected no code before the deploy, got %d", size)
+	}
+	binary.BigEndian.PutUint64(load[common.AddressLength:], 1)
+	if size := queryTestCodeSize(t, app, load); size != uint64(len(code)) {
+		t.Fatalf("expected code size %d at the deploy height, got %d", len(code), size)
+	}
+	binary.BigEndian.PutUint64(load[common.AddressLength:], 2)
+	if res := app.Query(append([]byte{rtypes.QueryType_CodeSize}, load...)); res.IsOK() {
+		t.Fatal("expected a height not committed yet to be rejected")
+	}
+}
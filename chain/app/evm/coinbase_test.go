@@ -0,0 +1,62 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+)
+
+// coinbaseCode deploys a contract storing block.coinbase in slot 0 and returning it
+var coinbaseCode = common.FromHex("600d600c600039600d6000f3" + "41600055" + "41600052" + "60206000f3")
+
+func TestCoinbase(t *testing.T) {
+	coinbase := common.HexToAddress("0xc0ffee")
+	conf := viper.New()
+	conf.Set("coinbase", coinbase.Hex())
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	fundTestAccounts(t, app, big.NewInt(10*testGas), addr)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(1), coinbaseCode)))
+	contract := crypto.CreateAddress(addr, 0)
+	call := signTestTx(t, key, etypes.NewTransaction(1, contract, big.NewInt(0), testGas, big.NewInt(1), nil))
+	execTestBlock(t, app, 2, call)
+
+	if stored := app.state.GetState(contract, common.Hash{}); common.BytesToAddress(stored.Bytes()) != coinbase {
+		t.Fatalf("expected block.coinbase %x in execution, got %x", coinbase, stored)
+	}
+	if app.state.GetBalance(coinbase).Sign() <= 0 {
+		t.Fatal("expected the fees paid to the coinbase")
+	}
+	res := app.Query(append([]byte{rtypes.QueryType_Contract}, call...))
+	if res.IsErr() || !bytes.Equal(res.Data, common.LeftPadBytes(coinbase.Bytes(), 32)) {
+		t.Fatalf("expected block.coinbase %x in queries, got %x %s", coinbase, res.Data, res.Log)
+	}
+
+	conf = viper.New()
+	conf.Set("coinbase", "0x1234")
+	if _, err := NewEVMApp(conf); err == nil {
+		t.Fatal("expected an invalid coinbase to be rejected")
+	}
+}
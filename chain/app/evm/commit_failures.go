@@ -0,0 +1,56 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/eth/metrics"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+)
+
+var commitFailuresMeter = metrics.NewRegisteredMeter("evm/commit/failures", nil)
+
+// countCommit counts the consecutive failed commits, err is the result of
+// committing the block at height. The consensus goes on after a failed commit,
+// so a node failing every commit, eg. on a full disk, would go on failing; once
+// commit_failure_limit commits in a row failed the node is stopped instead.
+func (app *EVMApp) countCommit(height int64, err error) {
+	if err == nil {
+		app.commitFailures = 0
+		return
+	}
+	app.commitFailures++
+	commitFailuresMeter.Mark(1)
+	log.Error("commit failed", zap.Int64("height", height), zap.Int("failures", app.commitFailures), zap.Error(err))
+	if app.commitFailureLimit > 0 && app.commitFailures >= app.commitFailureLimit {
+		app.stopNode(fmt.Errorf("%d commits failed in a row, the last at height %d: %v", app.commitFailures, height, err))
+	}
+}
+
+// stopNode stops the node the way an operator's interrupt does, so it stops
+// gracefully, or halts it where the process can't signal itself.
+func stopNode(err error) {
+	log.Error("FATAL: stopping the node", zap.Error(err))
+	p, serr := os.FindProcess(os.Getpid())
+	if serr == nil {
+		serr = p.Signal(os.Interrupt)
+	}
+	if serr != nil {
+		haltNode(err)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+)
+
+var errTestDiskFull = errors.New("disk full")
+
+// fullDatabase fails every write, like a full disk
+type fullDatabase struct {
+	ethdb.Database
+}
+
+func (db *fullDatabase) Put(key []byte, value []byte) error { return errTestDiskFull }
+
+func (db *fullDatabase) NewBatch() ethdb.Batch { return &fullBatch{db.Database.NewBatch()} }
+
+type fullBatch struct {
+	ethdb.Batch
+}
+
+func (b *fullBatch) Write() error { return errTestDiskFull }
+
+func TestCommitFailureLimit(t *testing.T) {
+	conf := viper.New()
+	conf.Set("commit_failure_limit", 3)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	var stopped error
+	app.stopNode = func(err error) { stopped = err }
+
+	key, _ := testKey(t, testKeyA)
+	raw := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), nil))
+	block := makeTestBlock(1, raw)
+	healthy := app.commitDb
+	commit := func(full bool) error {
+		if full {
+			app.commitDb = newCountingDatabase(&fullDatabase{app.stateDb})
+		} else {
+			app.commitDb = healthy
+		}
+		if _, err := app.OnExecute(block.Height, 0, block); err != nil {
+			t.Fatal(err)
+		}
+		_, err := app.OnCommit(block.Height, 0, block)
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := commit(true); err == nil {
+			t.Fatal("expected the commit to fail")
+		}
+	}
+	// a commit going through resets the count
+	if err := commit(false); err != nil {
+		t.Fatal(err)
+	}
+	block = makeTestBlock(2)
+	block.Data.Txs = append(block.Data.Txs, signTestTx(t, key, etypes.NewTransaction(1, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), nil)))
+	for i := 1; i <= 3; i++ {
+		if err := commit(true); err == nil {
+			t.Fatal("expected the commit to fail")
+		}
+		if i < 3 && stopped != nil {
+			t.Fatalf("node stopped after %d failures in a row", i)
+		}
+	}
+	if stopped == nil {
+		t.Fatal("expected the node to stop after 3 failures in a row")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/metrics"
+)
+
+var (
+	trieNodesMeter     = metrics.NewRegisteredMeter("evm/commit/trie/nodes", nil)
+	trieBytesMeter     = metrics.NewRegisteredMeter("evm/commit/trie/bytes", nil)
+	trieCommitTimer    = metrics.NewRegisteredTimer("evm/commit/trie/time", nil)
+	receiptBytesMeter  = metrics.NewRegisteredMeter("evm/commit/receipts/bytes", nil)
+	receiptCommitTimer = metrics.NewRegisteredTimer("evm/commit/receipts/time", nil)
+)
+
+// countingDatabase counts the entries and bytes actually written to the wrapped
+// database, batches count when written.
+type countingDatabase struct {
+	ethdb.Database
+	puts  uint64
+	bytes uint64
+}
+
+func newCountingDatabase(db ethdb.Database) *countingDatabase {
+	return &countingDatabase{Database: db}
+}
+
+func (db *countingDatabase) add(puts, bytes uint64) {
+	atomic.AddUint64(&db.puts, puts)
+	atomic.AddUint64(&db.bytes, bytes)
+}
+
+// reset returns the counters and clears them.
+func (db *countingDatabase) reset() (puts, bytes uint64) {
+	return atomic.SwapUint64(&db.puts, 0), atomic.SwapUint64(&db.bytes, 0)
+}
+
+func (db *countingDatabase) Put(key []byte, value []byte) error {
+	if err := db.Database.Put(key, value); err != nil {
+		return err
+	}
+	db.add(1, uint64(len(key)+len(value)))
+	return nil
+}
+
+func (db *countingDatabase) NewBatch() ethdb.Batch {
+	return &countingBatch{Batch: db.Database.NewBatch(), db: db}
+}
+
+type countingBatch struct {
+	ethdb.Batch
+	db    *countingDatabase
+	puts  uint64
+	bytes uint64
+}
+
+func (b *countingBatch) Put(key, value []byte) error {
+	if err := b.Batch.Put(key, value); err != nil {
+		return err
+	}
+	b.puts++
+	b.bytes += uint64(len(key) + len(value))
+	return nil
+}
+
+func (b *countingBatch) Write() error {
+	if err := b.Batch.Write(); err != nil {
+		return err
+	}
+	b.db.add(b.puts, b.bytes)
+	return nil
+}
+
+func (b *countingBatch) Reset() {
+	b.Batch.Reset()
+	b.puts, b.bytes = 0, 0
+}
+
+// commitStatsWindow keeps the stats of the latest committed blocks.
+type commitStatsWindow struct {
+	mtx   sync.Mutex
+	size  int
+	stats []rtypes.CommitStats // oldest first
+}
+
+func newCommitStatsWindow(size int) *commitStatsWindow {
+	return &commitStatsWindow{size: size}
+}
+
+func (w *commitStatsWindow) add(stats rtypes.CommitStats) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.size <= 0 {
+		return
+	}
+	w.stats = append(w.stats, stats)
+	if len(w.stats) > w.size {
+		w.stats = append(w.stats[:0:0], w.stats[len(w.stats)-w.size:]...)
+	}
+}
+
+func (w *commitStatsWindow) list() []rtypes.CommitStats {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return append([]rtypes.CommitStats{}, w.stats...)
+}
+
+// listCommitStats lists the commit stats of the latest blocks, oldest first, the
+// token is the height of a block.
+func (app *EVMApp) listCommitStats(load, token []byte, limit int) ([]listItem, []byte, error) {
+	from, err := parseIndexToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats := app.commitStats.list()
+	i := sort.Search(len(stats), func(i int) bool { return stats[i].Height >= from })
+	items := make([]listItem, 0)
+	for ; i < len(stats) && len(items) < limit; i++ {
+		items = append(items, listItem{indexToken(stats[i].Height), stats[i]})
+	}
+	if i < len(stats) {
+		return items, indexToken(stats[i].Height), nil
+	}
+	return items, nil, nil
+}
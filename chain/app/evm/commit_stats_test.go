@@ -0,0 +1,59 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+// storageHeavyCode is init code storing i at slot i for i in 1..32, deploying a one byte STOP.
+var storageHeavyCode = common.FromHex("60005b6001018080558060201160025760016000f3")
+
+func TestCommitStats(t *testing.T) {
+	conf := viper.New()
+	conf.Set("commit_stats_window", 2)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil)))
+	execTestBlock(t, app, 2, signTestTx(t, key, etypes.NewContractCreation(1, big.NewInt(0), testGas, big.NewInt(0), storageHeavyCode)))
+	execTestBlock(t, app, 3, signTestTx(t, key, etypes.NewTransaction(2, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil)))
+
+	res := app.Query([]byte{rtypes.QueryType_CommitStats})
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var stats []rtypes.CommitStats
+	if _, err := decodePage(res.Data, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[0].Height != 2 || stats[1].Height != 3 {
+		t.Fatalf("expected stats of blocks 2 and 3, got %+v", stats)
+	}
+	heavy, plain := stats[0], stats[1]
+	if heavy.TrieNodes < 4*plain.TrieNodes || heavy.TrieBytes < 4*plain.TrieBytes {
+		t.Fatalf("expected storage heavy block to spike, got %+v and %+v", heavy, plain)
+	}
+	if plain.TrieNodes == 0 || plain.ReceiptBytes == 0 || heavy.TrieDuration == 0 {
+		t.Fatalf("missing counters %+v", plain)
+	}
+}
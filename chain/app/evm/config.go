@@ -0,0 +1,130 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"github.com/spf13/viper"
+)
+
+// configKey is a key of the app config and its default, which also tells the
+// type of its values: bool, int, string or, for the keys holding a table
+// without default, map[string]string
+type configKey struct {
+	name  string
+	value interface{}
+}
+
+// configKeys are all the keys the app reads, the ones of the node excepted
+var configKeys = []configKey{
+	{"balances_batch_limit", 100},         // max number of addresses in one balances query
+	{"storage_keys_limit", 100},           // max number of storage keys in one storage query
+	{"tx_status_limit", 100000},           // max number of tx statuses kept
+	{"tx_status_retention", 86400},        // seconds to keep terminal tx statuses
+	{"receipts_migration_batch", 1000},    // receipts rewritten to the current format per batch
+	{"receipts_migration_paused", false},  // pause the background receipts migration
+	{"receipts_retention", 0},             // blocks whose receipts are kept, older receipts and their indexes are pruned, 0 to keep all
+	{"receipts_dedup_logs", false},        // store the log data of at least receipts_dedup_min_size bytes once for all the receipts emitting it
+	{"receipts_dedup_min_size", 128},      // min bytes of the log data stored apart by receipts_dedup_logs
+	{"idle_commit_skip", true},            // blocks leaving the state untouched carry the previous roots forward without a trie commit nor receipts write
+	{"commit_failure_limit", 3},           // commits failing in a row before the node stops, 0 to never stop
+	{"commit_stats_window", 128},          // number of latest blocks whose commit stats are kept
+	{"gas_limit_height", 0},               // height of the first block whose txs over EVMGasLimit are invalidated, CheckTx refuses them anyway, 0 for none, must match on all validators
+	{"max_tx_data_size", 0},               // max bytes of tx data accepted by CheckTx, 0 for no limit
+	{"check_tx_signature", false},         // CheckTx rejects txs with an empty signature or one not recovering to a sender
+	{"min_gas_price", "0"},                // min gas price of txs accepted by CheckTx, decimal
+	{"duplicate_nonce", "reject"},         // pooled tx of the same sender and nonce: reject the new tx, or replace the pooled one when paying a higher gas price
+	{"reap_prevalidate", false},           // skip txs failing nonce or balance checks when reaping a proposal
+	{"reap_order", "nonce"},               // order of the pending txs reaped for a proposal: nonce (sender after sender) or price (highest gas price first, each sender's txs in nonce order)
+	{"reap_tie_break", "arrival"},         // order of the txs of the same gas price under reap_order price: arrival (earliest received, then lowest hash) or hash (lowest hash)
+	{"reap_demote_backoff", 10},           // blocks txs demoted when reaping are held back, doubled on each demotion, 0 to retry at once
+	{"sender_cache_size", 10000},          // max number of recovered tx senders cached, 0 to disable
+	{"sender_cache_idle", 600},            // seconds a cached tx sender is kept unused
+	{"max_tx_log_data", 0},                // max log data bytes of one tx, 0 for no cap, must match on all validators
+	{"max_block_log_data", 0},             // max log data bytes of one block, 0 for no cap, must match on all validators
+	{"max_txs_per_sender", 0},             // max txs of one sender in a block, 0 for no limit, must match on all validators
+	{"max_creations_per_block", 0},        // max contract creations of one block, internal CREATE and CREATE2 included, 0 for no limit, must match on all validators
+	{"app_messages", false},               // contracts may send messages to the sibling apps of the node through the precompile at 0xfd, must match on all validators
+	{"app_message_gas", 1000000},          // gas of the call delivering an inbound app message, must match on all validators
+	{"min_account_balance_wei", "0"},      // min balance a transfer may leave its sender with, decimal, 0 for no min, must match on all validators
+	{"max_tx_value", "0"},                 // max value in wei of a tx, decimal, 0 for only the 2^256-1 bound, must match on all validators
+	{"max_tx_gas_price", "0"},             // max gas price in wei of a tx, decimal, 0 for only the 2^256-1 bound, must match on all validators
+	{"tx_bounds_height", 0},               // height of the first block whose txs out of the value, gas price and nonce bounds are invalidated, CheckTx and the pool refuse them anyway, 0 for none, must match on all validators
+	{"block_gas_limit", 0},                // gas of a block, user txs and system work, 0 for no limit, must match on all validators
+	{"system_gas_reserve", 0},             // gas of a block reserved for the system work, the inbound app messages, which the user txs can't take, 0 for unmetered system work, must match on all validators
+	{"tx_order_policy", "none"},           // canonical order of block txs, blocks out of it are rejected: none, hash or sender-nonce, must match on all validators
+	{"tx_order", "off"},                   // txs of a sender out of nonce order in a block: off, check (log them) or reorder (execute in nonce order), must match on all validators
+	{"exec_nonce_gap", "state"},           // block txs with a nonce above the sender's next one: state (fail on execution), reject (fail before the per block limits count them) or defer (retry after the rest of the block), must match on all validators
+	{"zero_address_policy", "reject"},     // txs to the zero address: reject (data refused, value credited to it) or burn (data refused, value burnt), must match on all validators
+	{"zero_address_height", 0},            // height of the first block zero_address_policy applies to, 0 for none, must match on all validators
+	{"receipts_hash", "simple"},           // algorithm of the block receipts hash: simple (merkle of the storage encodings) or derive-sha (ethereum receipts trie), must match on all validators
+	{"exec_memory_soft_limit", 0},         // memory bytes executing a block may take before memory is given back to the OS and a warning logged, 0 for no limit
+	{"exec_memory_hard_limit", 0},         // memory bytes executing a block may take before the node halts with diagnostics, 0 for no limit
+	{"expected_genesis_hash", ""},         // hex genesis hash the node refuses to start without, see GenesisHash, empty for no check
+	{"genesis_root_check", true},          // refuse to start on a state database initialized with another genesis than the app's
+	{"db_backend", "local"},               // state database: local (leveldb in the datadir) or remote (remotedb server at db_remote_endpoint)
+	{"db_remote_endpoint", ""},            // url of the remotedb server of db_backend remote, eg. http://10.0.0.2:46680
+	{"db_remote_token", ""},               // bearer token of the remotedb server of db_backend remote, required with it
+	{"db_remote_cache", 100000},           // values of the remote state database cached, 0 to disable
+	{"db_recover", false},                 // try to recover a corrupted state database on start, WARNING: recovery may drop data
+	{"coinbase", ""},                      // address collecting the fees and read by COINBASE, empty for the zero address, must match on all validators
+	{"sync_lag_threshold", 2},             // blocks the app may lag the core's latest block before it counts as syncing
+	{"syncing_queries", "answer"},         // queries while syncing: answer, warn (syncing note in the result log) or refuse
+	{"pool_drop_stats_hours", 6},          // hours of per minute counts of the txs the pool refused or dropped by reason kept for QueryType_PoolDropStats, 0 to disable
+	{"catch_up_mode", false},              // while replaying the blocks the core stored past it, hold the mirror deliveries back until the last one
+	{"http_query_laddr", ""},              // address of the http query endpoints, eg. 127.0.0.1:46660, empty to disable
+	{"query_acl_file", ""},                // json policies by client of the http query endpoints, requests must then be signed, see HTTPQueryPolicy, empty for open endpoints
+	{"tx_import_laddr", ""},               // address of the admin bulk tx import endpoint, eg. 127.0.0.1:46661, empty to disable
+	{"tx_import_token", ""},               // bearer token the tx import requests must carry, required with tx_import_laddr
+	{"misbehavior_max_age", 10000},        // blocks after which evidence is no longer recorded, 0 for no limit, must match on all validators
+	{"misbehavior_block_limit", 10},       // max evidence records of one block, the evidence beyond waits in the pool for the next blocks, 0 for no limit, must match on all validators
+	{"historical_query_limit", 16},        // max queries running on historical states at once, 0 for no limit
+	{"historical_query_wait", 0},          // milliseconds a historical query waits for a free slot, 0 to answer busy at once
+	{"view_call_sender", ""},              // address unsigned view call queries run from, empty to refuse them
+	{"simulation_cache_size", 0},          // bytes of contract and call query results memoized until the next block, 0 to disable
+	{"simulation_call_depth", 1024},       // max call stack depth of contract and call queries, up to 1024, blocks always run with 1024
+	{"simulate_block_query", false},       // answer the admin block simulation query, which executes whole blocks on a copy of the state
+	{"check_tx_limit", 0},                 // max CheckTx calls running at once, 0 for no limit
+	{"check_tx_wait", 0},                  // milliseconds a CheckTx call waits for a free slot, 0 to answer busy at once
+	{"warmup_mode", "off"},                // database warmup after start: off, head (account trie) or recent-N (also receipts of the last N blocks)
+	{"warmup_node_budget", 100000},        // max account trie nodes read by the warmup
+	{"state_diff_limit", 1000},            // max accounts answered by one state diff query page, 0 for no limit
+	{"light_header_range_limit", 1000},    // max light headers answered by one range query
+	{"recent_blocks_limit", 100},          // max block summaries answered by one recent blocks query
+	{"gas_range_limit", 10000},            // max blocks summed by one gas over range query
+	{"query_max_response_bytes", 4 << 20}, // max bytes of the items answered by one list query page, the list goes on in the next page, 0 for no limit
+	{"logs_range_limit", 1000},            // max blocks scanned by one GetLogs call
+	{"state_snapshot_on_stop", false},     // write a state snapshot to state_snapshot_file on graceful stop
+	{"state_snapshot_load", false},        // start from state_snapshot_file instead of genesis when the state database is empty
+	{"state_snapshot_file", ""},           // state snapshot path, empty for state.snapshot in db_dir
+	{"mirror_retry_interval", 5},          // seconds before retrying the delivery to a failing mirror sink
+	{"config_check", "auto"},              // config keys unknown to the app and the node, or with values of the wrong type: strict (fail the start), lenient (log them) or auto (strict on a new datadir, lenient otherwise)
+	// fork_schedule maps block heights to comma separated forks activated there, eg. {"100" = "eip150,eip158"};
+	// forks not scheduled keep their mainnet blocks. Empty by default.
+	{"fork_schedule", map[string]string(nil)},
+	// gas_price_floors maps target contract addresses to decimal min gas prices overriding min_gas_price for
+	// txs calling them, eg. {"0x1234..." = "0"} for free calls. Empty by default.
+	{"gas_price_floors", map[string]string(nil)},
+	// db_shards maps key prefixes to database directories under db_dir, eg. {"receipts-" = "receipts"};
+	// keys with other prefixes, trie nodes included, stay in chaindata. Empty by default.
+	{"db_shards", map[string]string(nil)},
+}
+
+// setDefaults sets the default configs for evm app
+func setDefaults(conf *viper.Viper) {
+	for _, key := range configKeys {
+		if _, table := key.value.(map[string]string); !table {
+			conf.SetDefault(key.name, key.value)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	gconfig "github.com/dappledger/AnnChain/gemmill/config"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+)
+
+// config_check values, how the problems of the config are handled
+const (
+	configCheckAuto    = "auto"    // strict on a new datadir, lenient otherwise
+	configCheckStrict  = "strict"  // fail the start
+	configCheckLenient = "lenient" // log them
+)
+
+// checkConfig looks for the keys of the config file unknown to the app and the
+// node, and for the app keys with values of the wrong type, which viper would
+// silently read as the zero value or the default. A new datadir is strict by
+// default, so a node started from a mistyped config fails at once, while the
+// nodes already running a config keep starting.
+func checkConfig(conf *viper.Viper, datadir string) error {
+	mode := conf.GetString("config_check")
+	switch mode {
+	case configCheckStrict, configCheckLenient:
+	case configCheckAuto:
+		mode = configCheckLenient
+		if _, err := os.Stat(filepath.Join(datadir, AppName+".db")); os.IsNotExist(err) {
+			mode = configCheckStrict
+		}
+	default:
+		return fmt.Errorf("invalid config_check %q", mode)
+	}
+	problems, err := configProblems(conf)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if mode == configCheckStrict {
+		return fmt.Errorf("config check failed: %s", strings.Join(problems, "; "))
+	}
+	for _, problem := range problems {
+		log.Warn("config check", zap.String("problem", problem))
+	}
+	return nil
+}
+
+// configProblems lists the unknown keys of the config file in key order, then
+// the app keys with values of the wrong type in configKeys order.
+func configProblems(conf *viper.Viper) ([]string, error) {
+	known := make(map[string]bool)
+	tables := make(map[string]bool)
+	for _, key := range gconfig.Keys() {
+		known[key] = true
+	}
+	for _, key := range configKeys {
+		known[key.name] = true
+		if _, table := key.value.(map[string]string); table {
+			tables[key.name] = true
+		}
+	}
+
+	var problems []string
+	if file := conf.ConfigFileUsed(); file != "" {
+		// only the file, the defaults and the keys set by the node are known
+		fileConf := viper.New()
+		fileConf.SetConfigFile(file)
+		if err := fileConf.ReadInConfig(); err != nil {
+			return nil, err
+		}
+		keys := fileConf.AllKeys()
+		sort.Strings(keys)
+		for _, key := range keys {
+			if i := strings.IndexByte(key, '.'); known[key] || i > 0 && tables[key[:i]] {
+				continue
+			}
+			problem := fmt.Sprintf("unknown key %s", key)
+			if closest := closestConfigKey(key, known); closest != "" {
+				problem += fmt.Sprintf(", did you mean %s", closest)
+			}
+			problems = append(problems, problem)
+		}
+	}
+	for _, key := range configKeys {
+		if err := checkConfigValue(key.value, conf.Get(key.name)); err != nil {
+			problems = append(problems, fmt.Sprintf("key %s: %v", key.name, err))
+		}
+	}
+	return problems, nil
+}
+
+// checkConfigValue checks value has the type of the default def, or is a
+// string parsed as such, like the values of environment variables
+func checkConfigValue(def, value interface{}) error {
+	switch def.(type) {
+	case bool:
+		switch v := value.(type) {
+		case bool:
+			return nil
+		case string:
+			if _, err := strconv.ParseBool(v); err == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%#v is not a bool", value)
+	case int:
+		switch v := value.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			return nil
+		case float64:
+			if v == math.Trunc(v) {
+				return nil
+			}
+		case string:
+			if _, err := strconv.ParseInt(v, 0, 64); err == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%#v is not an integer", value)
+	case string:
+		switch value.(type) {
+		case map[string]interface{}, map[string]string, []interface{}, []string:
+			return fmt.Errorf("%#v is not a string", value)
+		}
+		return nil
+	case map[string]string:
+		switch value.(type) {
+		case nil, map[string]interface{}, map[string]string:
+			return nil
+		}
+		return fmt.Errorf("%#v is not a table", value)
+	}
+	return nil
+}
+
+// closestConfigKey is the known key at most 2 edits away from key, the first
+// one in key order when several are as close, empty for none
+func closestConfigKey(key string, known map[string]bool) string {
+	closest, best := "", 3
+	for name := range known {
+		if d := editDistance(key, name); d < best || d == best && name < closest {
+			closest, best = name, d
+		}
+	}
+	return closest
+}
+
+// editDistance is the levenshtein distance of a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,105 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// readTestConfig reads the config file of content, like the node reads its
+// config.toml
+func readTestConfig(t *testing.T, dir, content string) *viper.Viper {
+	file := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf := viper.New()
+	conf.SetConfigFile(file)
+	if err := conf.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	conf.Set("db_dir", filepath.Join(dir, "data"))
+	setDefaults(conf)
+	return conf
+}
+
+const testConfigTypos = `
+moniker = "node"
+min_gas_prise = "10"
+reap_ordr = "price"
+foo_bar = 1
+idle_commit_skip = "maybe"
+logs_range_limit = "many"
+coinbase = [1, 2]
+receipts_retention = "100"
+
+[fork_schedule]
+"100" = "eip150"
+
+[raft]
+empty_block_interval = "1s"
+`
+
+func TestConfigCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = NewEVMApp(readTestConfig(t, dir, testConfigTypos))
+	expected := "app error: config check failed: " +
+		"unknown key foo_bar; " +
+		"unknown key min_gas_prise, did you mean min_gas_price; " +
+		"unknown key reap_ordr, did you mean reap_order; " +
+		`key idle_commit_skip: "maybe" is not a bool; ` +
+		`key coinbase: []interface {}{1, 2} is not a string; ` +
+		`key logs_range_limit: "many" is not an integer`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+
+	// a config of the node and app keys only starts
+	conf := readTestConfig(t, dir, "moniker = \"node\"\nmin_gas_price = 10\nreap_order = \"price\"\n\n[fork_schedule]\n\"100\" = \"eip150\"\n")
+	if err := checkConfig(conf, conf.GetString("db_dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	// lenient only logs the problems, so does auto on a datadir in use
+	lenient := readTestConfig(t, dir, testConfigTypos)
+	lenient.Set("config_check", configCheckLenient)
+	if err := checkConfig(lenient, dir); err != nil {
+		t.Fatalf("expected the lenient check to pass, got %v", err)
+	}
+	auto := readTestConfig(t, dir, testConfigTypos)
+	if err := checkConfig(auto, dir); err == nil {
+		t.Fatal("expected the check of a new datadir strict")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, AppName+".db"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkConfig(auto, dir); err != nil {
+		t.Fatalf("expected the check of a datadir in use lenient, got %v", err)
+	}
+
+	auto.Set("config_check", "loose")
+	if err := checkConfig(auto, dir); err == nil {
+		t.Fatal("expected an invalid config_check refused")
+	}
+}
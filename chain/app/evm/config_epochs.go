@@ -0,0 +1,237 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var (
+	// ConfigEpochsKey indexes the start heights of the config epochs, a rlp list
+	// in ascending order
+	ConfigEpochsKey = []byte("config-epochs")
+	// ConfigEpochPrefix stores each config epoch by its 8 bytes big endian start height
+	ConfigEpochPrefix = []byte("config-epoch-")
+)
+
+// configEpoch is a run of committed blocks executed with the same consensus
+// parameters. A new epoch starts at a fork activation height, or at the first
+// block committed after a restart with other consensus settings.
+type configEpoch struct {
+	Start       uint64
+	Rules       rtypes.ChainRules // Height is Start
+	EVMGasLimit uint64
+	ChainConfig []byte // json encoded params.ChainConfig
+}
+
+// configEpochs keeps the start heights of the recorded epochs and the last
+// epoch, to tell when the parameters of a committed block start another one.
+type configEpochs struct {
+	mtx    sync.Mutex
+	starts []uint64
+	last   *configEpoch
+}
+
+func configEpochKey(start uint64) []byte {
+	key := make([]byte, len(ConfigEpochPrefix)+8)
+	copy(key, ConfigEpochPrefix)
+	binary.BigEndian.PutUint64(key[len(ConfigEpochPrefix):], start)
+	return key
+}
+
+// effectiveConfig returns the consensus parameters the block at height is
+// executed with, as an epoch starting there.
+func (app *EVMApp) effectiveConfig(height uint64) (*configEpoch, error) {
+	config, err := json.Marshal(app.chainConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &configEpoch{
+		Start:       height,
+		Rules:       *app.chainRules(height),
+		EVMGasLimit: EVMGasLimit,
+		ChainConfig: config,
+	}, nil
+}
+
+// sameParams tells whether a and b hold the same parameters, whatever their start
+func (a *configEpoch) sameParams(b *configEpoch) bool {
+	ca, cb := *a, *b
+	ca.Start, ca.Rules.Height, cb.Start, cb.Rules.Height = 0, 0, 0, 0
+	da, errA := rlp.EncodeToBytes(&ca)
+	db, errB := rlp.EncodeToBytes(&cb)
+	return errA == nil && errB == nil && bytes.Equal(da, db)
+}
+
+// loadConfigEpochs reads the recorded epochs on start
+func (app *EVMApp) loadConfigEpochs() error {
+	app.configEpochs.mtx.Lock()
+	defer app.configEpochs.mtx.Unlock()
+	app.configEpochs.starts, app.configEpochs.last = nil, nil
+	data, err := app.stateDb.Get(ConfigEpochsKey)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	if err := rlp.DecodeBytes(data, &app.configEpochs.starts); err != nil {
+		return fmt.Errorf("decode config epochs: %v", err)
+	}
+	if n := len(app.configEpochs.starts); n > 0 {
+		if app.configEpochs.last, err = app.loadConfigEpoch(app.configEpochs.starts[n-1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *EVMApp) loadConfigEpoch(start uint64) (*configEpoch, error) {
+	data, err := app.stateDb.Get(configEpochKey(start))
+	if err != nil {
+		return nil, fmt.Errorf("get config epoch %d: %v", start, err)
+	}
+	epoch := &configEpoch{}
+	if err := rlp.DecodeBytes(data, epoch); err != nil {
+		return nil, fmt.Errorf("decode config epoch %d: %v", start, err)
+	}
+	return epoch, nil
+}
+
+// saveConfigEpoch starts an epoch at height if the parameters of the block
+// committed there differ from the last epoch's. A block committed again after a
+// crash replaces the epochs recorded from its height on.
+func (app *EVMApp) saveConfigEpoch(height uint64) error {
+	epoch, err := app.effectiveConfig(height)
+	if err != nil {
+		return err
+	}
+	epochs := &app.configEpochs
+	epochs.mtx.Lock()
+	defer epochs.mtx.Unlock()
+	if epochs.last != nil && epochs.last.Start < height && epochs.last.sameParams(epoch) {
+		return nil
+	}
+	keep := sort.Search(len(epochs.starts), func(i int) bool { return epochs.starts[i] >= height })
+	starts := append(epochs.starts[:keep:keep], height)
+	index, err := rlp.EncodeToBytes(starts)
+	if err != nil {
+		return err
+	}
+	data, err := rlp.EncodeToBytes(epoch)
+	if err != nil {
+		return err
+	}
+	batch := app.stateDb.NewBatch()
+	if err := batch.Put(configEpochKey(height), data); err != nil {
+		return err
+	}
+	if err := batch.Put(ConfigEpochsKey, index); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	epochs.starts, epochs.last = starts, epoch
+	return nil
+}
+
+// truncateConfigEpochs drops the epochs starting above height, the committed
+// block a rollback goes back to.
+func (app *EVMApp) truncateConfigEpochs(height uint64) error {
+	if err := app.loadConfigEpochs(); err != nil {
+		return err
+	}
+	epochs := &app.configEpochs
+	epochs.mtx.Lock()
+	defer epochs.mtx.Unlock()
+	keep := sort.Search(len(epochs.starts), func(i int) bool { return epochs.starts[i] > height })
+	if keep == len(epochs.starts) {
+		return nil
+	}
+	starts := epochs.starts[:keep:keep]
+	index, err := rlp.EncodeToBytes(starts)
+	if err != nil {
+		return err
+	}
+	batch := app.stateDb.NewBatch()
+	for _, start := range epochs.starts[keep:] {
+		if err := batch.Delete(configEpochKey(start)); err != nil {
+			return err
+		}
+	}
+	if err := batch.Put(ConfigEpochsKey, index); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	var last *configEpoch
+	if keep > 0 {
+		if last, err = app.loadConfigEpoch(starts[keep-1]); err != nil {
+			return err
+		}
+	}
+	epochs.starts, epochs.last = starts, last
+	return nil
+}
+
+// configAt returns the epoch covering the committed block at height
+func (app *EVMApp) configAt(height uint64) (*configEpoch, error) {
+	if committed := uint64(atomic.LoadInt64(&app.committedHeight)); height == 0 || height > committed {
+		return nil, fmt.Errorf("height %d not committed, last committed %d", height, committed)
+	}
+	app.configEpochs.mtx.Lock()
+	starts := app.configEpochs.starts
+	app.configEpochs.mtx.Unlock()
+	i := sort.Search(len(starts), func(i int) bool { return starts[i] > height })
+	if i == 0 {
+		return nil, fmt.Errorf("no config recorded at height %d", height)
+	}
+	return app.loadConfigEpoch(starts[i-1])
+}
+
+// queryConfigAtHeight returns the rlp encoded rtypes.ConfigSnapshot of the 8
+// bytes big endian height in load, the consensus parameters the committed block
+// at that height was executed with.
+func (app *EVMApp) queryConfigAtHeight(load []byte) gtypes.Result {
+	if len(load) != 8 {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong height")
+	}
+	height := binary.BigEndian.Uint64(load)
+	epoch, err := app.configAt(height)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	snapshot := &rtypes.ConfigSnapshot{
+		Height:      height,
+		EpochStart:  epoch.Start,
+		Rules:       epoch.Rules,
+		EVMGasLimit: epoch.EVMGasLimit,
+		ChainConfig: epoch.ChainConfig,
+	}
+	snapshot.Rules.Height = height
+	data, err := rlp.EncodeToBytes(snapshot)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,108 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func queryTestConfigAt(t *testing.T, app *EVMApp, height uint64) (*rtypes.ConfigSnapshot, *params.ChainConfig) {
+	load := make([]byte, 8)
+	binary.BigEndian.PutUint64(load, height)
+	res := app.Query(append([]byte{rtypes.QueryType_ConfigAtHeight}, load...))
+	if res.IsErr() {
+		t.Fatalf("height %d: %s", height, res.Log)
+	}
+	snapshot := &rtypes.ConfigSnapshot{}
+	if err := rlp.DecodeBytes(res.Data, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	config := &params.ChainConfig{}
+	if err := json.Unmarshal(snapshot.ChainConfig, config); err != nil {
+		t.Fatal(err)
+	}
+	return snapshot, config
+}
+
+func TestQueryConfigAtHeight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	schedule := map[string]string{"3": "homestead", "5": "byzantium"}
+	conf := viper.New()
+	conf.Set("fork_schedule", schedule)
+	app, err := startTestApp(dir, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for h := int64(1); h <= 6; h++ {
+		commitTestBlock(t, app, makeTestBlock(h))
+	}
+	app.Stop()
+
+	// a fork scheduled on restart starts an epoch at the next block
+	schedule["9"] = "constantinople"
+	conf = viper.New()
+	conf.Set("fork_schedule", schedule)
+	if app, err = startTestApp(dir, conf); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Stop()
+	commitTestBlock(t, app, makeTestBlock(7))
+
+	for _, c := range []struct {
+		height, start        uint64
+		homestead, byzantium bool
+		constantinople       bool
+	}{
+		{1, 1, false, false, false},
+		{2, 1, false, false, false},
+		{3, 3, true, false, false},
+		{4, 3, true, false, false},
+		{5, 5, true, true, false},
+		{6, 5, true, true, false},
+		{7, 7, true, true, true},
+	} {
+		snapshot, config := queryTestConfigAt(t, app, c.height)
+		if snapshot.Height != c.height || snapshot.EpochStart != c.start || snapshot.Rules.Height != c.height {
+			t.Fatalf("height %d: unexpected snapshot %+v", c.height, snapshot)
+		}
+		if snapshot.Rules.Homestead != c.homestead || snapshot.Rules.Byzantium != c.byzantium {
+			t.Fatalf("height %d: unexpected rules %+v", c.height, snapshot.Rules)
+		}
+		if (config.ConstantinopleBlock != nil) != c.constantinople || config.HomesteadBlock.Uint64() != 3 || snapshot.EVMGasLimit != EVMGasLimit {
+			t.Fatalf("height %d: unexpected config %s", c.height, snapshot.ChainConfig)
+		}
+	}
+	for _, height := range []uint64{0, 8} {
+		load := make([]byte, 8)
+		binary.BigEndian.PutUint64(load, height)
+		if res := app.Query(append([]byte{rtypes.QueryType_ConfigAtHeight}, load...)); res.IsOK() {
+			t.Fatalf("expected height %d to be rejected", height)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+)
+
+// ConsensusConfigKey stores the consensus settings the chain was initialized
+// with, a rlp list of consensusValue
+var ConsensusConfigKey = []byte("consensus-config")
+
+// consensusSetting is a config key every validator must set the same, value is
+// the canonical form of the setting the app runs with and legacy the one the
+// blocks committed before the setting existed were executed with
+type consensusSetting struct {
+	key    string
+	value  func(app *EVMApp) string
+	legacy string
+}
+
+// consensusSettings change which txs of a block execute and how, so a validator
+// running with another value than the rest forks off at the first block they
+// tell apart. They're recorded when the chain is initialized, or on the first
+// start of a chain initialized before they were, and a start with another value
+// is refused. Only the legacy value is backfilled, any other would apply the
+// setting to the blocks already committed without it.
+var consensusSettings = []consensusSetting{
+	{"max_tx_log_data", func(app *EVMApp) string { return fmt.Sprint(app.chainConfig.MaxTxLogData) }, "0"},
+	{"max_block_log_data", func(app *EVMApp) string { return fmt.Sprint(app.chainConfig.MaxBlockLogData) }, "0"},
+	{"misbehavior_block_limit", func(app *EVMApp) string { return fmt.Sprint(app.misbehaviorBlockLimit) }, "10"},
+	{"zero_address_policy", func(app *EVMApp) string { return app.Config.GetString("zero_address_policy") }, "reject"},
+	{"gas_limit_height", func(app *EVMApp) string { return fmt.Sprint(app.gasLimitHeight) }, "0"},
+	{"zero_address_height", func(app *EVMApp) string { return fmt.Sprint(app.zeroAddressHeight) }, "0"},
+	{"tx_order_policy", func(app *EVMApp) string { return app.Config.GetString("tx_order_policy") }, "none"},
+	{"tx_order", func(app *EVMApp) string { return app.txOrder }, "off"},
+	{"exec_nonce_gap", func(app *EVMApp) string { return app.nonceGap }, "state"},
+	{"max_txs_per_sender", func(app *EVMApp) string { return fmt.Sprint(app.chainConfig.MaxTxsPerSender) }, "0"},
+	{"max_creations_per_block", func(app *EVMApp) string { return fmt.Sprint(app.chainConfig.MaxCreationsPerBlock) }, "0"},
+	{"min_account_balance_wei", func(app *EVMApp) string { return decimalWei(app.chainConfig.MinAccountBalance) }, "0"},
+	{"max_tx_value", func(app *EVMApp) string { return decimalWei(app.chainConfig.MaxTxValue) }, "0"},
+	{"max_tx_gas_price", func(app *EVMApp) string { return decimalWei(app.chainConfig.MaxTxGasPrice) }, "0"},
+	{"tx_bounds_height", func(app *EVMApp) string { return fmt.Sprint(app.txBoundsHeight) }, "0"},
+	{"coinbase", func(app *EVMApp) string { return app.coinbase.Hex() }, common.Address{}.Hex()},
+	{"misbehavior_max_age", func(app *EVMApp) string { return fmt.Sprint(app.misbehaviorMaxAge) }, "10000"},
+	{"app_messages", func(app *EVMApp) string { return fmt.Sprint(app.chainConfig.AppMessages) }, "false"},
+	{"app_message_gas", func(app *EVMApp) string { return fmt.Sprint(app.appMessageGas) }, "1000000"},
+	{"block_gas_limit", func(app *EVMApp) string { return fmt.Sprint(app.chainConfig.BlockGasLimit) }, "0"},
+	{"system_gas_reserve", func(app *EVMApp) string { return fmt.Sprint(app.chainConfig.SystemGasReserve) }, "0"},
+}
+
+// activeAt tells if a rule activated at height activation applies to the block
+// at height. Rules invalidating txs come with one, so the blocks committed
+// before they existed replay the way they were executed; 0 never activates.
+func activeAt(activation uint64, height int64) bool {
+	return activation > 0 && uint64(height) >= activation
+}
+
+// decimalWei is the canonical form of a wei setting, unset ones are 0
+func decimalWei(wei *big.Int) string {
+	if wei == nil {
+		return "0"
+	}
+	return wei.String()
+}
+
+type consensusValue struct {
+	Key   string
+	Value string
+}
+
+func (app *EVMApp) consensusValues() []consensusValue {
+	values := make([]consensusValue, len(consensusSettings))
+	for i, setting := range consensusSettings {
+		values[i] = consensusValue{Key: setting.key, Value: setting.value(app)}
+	}
+	return values
+}
+
+func (app *EVMApp) putConsensusValues(values []consensusValue) error {
+	data, err := rlp.EncodeToBytes(values)
+	if err != nil {
+		return err
+	}
+	return app.stateDb.Put(ConsensusConfigKey, data)
+}
+
+// saveConsensusConfig stores the consensus settings of a chain being initialized
+func (app *EVMApp) saveConsensusConfig() error {
+	return app.putConsensusValues(app.consensusValues())
+}
+
+// checkConsensusConfig refuses consensus settings other than the ones the chain
+// was initialized with. The settings not recorded yet are backfilled when the
+// app runs with their legacy value, and refused otherwise.
+func (app *EVMApp) checkConsensusConfig() error {
+	var stored []consensusValue
+	if data, err := app.stateDb.Get(ConsensusConfigKey); err == nil && len(data) > 0 {
+		if err := rlp.DecodeBytes(data, &stored); err != nil {
+			return fmt.Errorf("decode consensus config: %v", err)
+		}
+	}
+	recorded := make(map[string]string, len(stored))
+	for _, v := range stored {
+		recorded[v.Key] = v.Value
+	}
+	var problems []string
+	backfilled := false
+	for i, v := range app.consensusValues() {
+		value, ok := recorded[v.Key]
+		if !ok {
+			if legacy := consensusSettings[i].legacy; v.Value != legacy {
+				problems = append(problems, fmt.Sprintf("%s %q, the chain's blocks were executed with %q before it was recorded", v.Key, v.Value, legacy))
+				continue
+			}
+			stored = append(stored, v)
+			backfilled = true
+			log.Info("backfilled the consensus setting", zap.String("key", v.Key), zap.String("value", v.Value))
+			continue
+		}
+		if value != v.Value {
+			problems = append(problems, fmt.Sprintf("%s %q, the chain uses %q", v.Key, v.Value, value))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("consensus settings differ from the chain's: %s", strings.Join(problems, "; "))
+	}
+	if backfilled {
+		return app.putConsensusValues(stored)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestConsensusConfigCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	withSettings := func(settings map[string]interface{}) *viper.Viper {
+		conf := viper.New()
+		for key, value := range settings {
+			conf.Set(key, value)
+		}
+		return conf
+	}
+	initial := map[string]interface{}{
+		"max_tx_log_data": 100,
+	}
+	app, err := startTestApp(dir, withSettings(initial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Stop()
+
+	// every setting other than the chain's is refused, a setting left to its
+	// default counts like any other value
+	others := map[string]interface{}{
+		"max_tx_log_data":         200,
+		"max_block_log_data":      1000,
+		"misbehavior_block_limit": 5,
+		"zero_address_policy":     "burn",
+		"tx_order_policy":         "hash",
+		"tx_order":                "reorder",
+		"exec_nonce_gap":          "defer",
+		"max_txs_per_sender":      3,
+		"max_creations_per_block": 2,
+		"min_account_balance_wei": "1000",
+		"max_tx_value":            "1000000",
+		"max_tx_gas_price":        "100",
+		"coinbase":                "0x00000000000000000000000000000000000000cb",
+		"misbehavior_max_age":     100,
+		"app_messages":            true,
+		"app_message_gas":         50000,
+		"block_gas_limit":         10000000,
+		"system_gas_reserve":      100000,
+	}
+	for key, value := range others {
+		settings := map[string]interface{}{key: value}
+		for k, v := range initial {
+			if k != key {
+				settings[k] = v
+			}
+		}
+		if _, err := startTestApp(dir, withSettings(settings)); err == nil || !strings.Contains(err.Error(), key) {
+			t.Fatalf("expected %s %v refused, got %v", key, value, err)
+		}
+	}
+	if app, err = startTestApp(dir, withSettings(initial)); err != nil {
+		t.Fatalf("expected the chain's settings to start, got %v", err)
+	}
+	app.Stop()
+	// the settings compare in their canonical form
+	if app, err = startTestApp(dir, withSettings(map[string]interface{}{"max_tx_log_data": 100, "max_tx_value": "000"})); err != nil {
+		t.Fatalf("expected the same value in another form to start, got %v", err)
+	}
+
+	// a chain initialized before the settings were recorded has them backfilled
+	// with the values its blocks were executed with only
+	if err := app.stateDb.Delete(ConsensusConfigKey); err != nil {
+		t.Fatal(err)
+	}
+	app.Stop()
+	if _, err := startTestApp(dir, withSettings(initial)); err == nil || !strings.Contains(err.Error(), "max_tx_log_data") {
+		t.Fatalf("expected a setting other than the legacy value refused, got %v", err)
+	}
+	if app, err = startTestApp(dir, withSettings(map[string]interface{}{})); err != nil {
+		t.Fatal(err)
+	}
+	app.Stop()
+	if _, err := startTestApp(dir, withSettings(initial)); err == nil {
+		t.Fatal("expected the backfilled settings checked")
+	}
+}
+
+func TestConsensusLegacyValues(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	// a node upgraded with the default config backfills every setting
+	for i, v := range app.consensusValues() {
+		if legacy := consensusSettings[i].legacy; v.Value != legacy {
+			t.Errorf("%s: expected the default %q to be the legacy value, got %q", v.Key, v.Value, legacy)
+		}
+	}
+}
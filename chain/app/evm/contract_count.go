@@ -0,0 +1,127 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/eth/trie"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// ContractCountKey stores the number of live contracts, accounts with code, as
+// the 8 bytes big endian height it was counted at followed by the 8 bytes big
+// endian count. It's written before the last block, so a count ahead of the
+// last block is known to be left by a crash.
+var ContractCountKey = []byte("contract-count")
+
+// contractCountDelta returns the change of the number of contracts the
+// executing block makes, from the accounts it touched. Must be called before
+// the state commit.
+func (app *EVMApp) contractCountDelta(touched []common.Address) int64 {
+	var delta int64
+	app.stateMtx.Lock()
+	defer app.stateMtx.Unlock()
+	for _, addr := range touched {
+		before := app.state.GetCodeSize(addr) > 0
+		after := app.currentState.GetCodeSize(addr) > 0 && !app.currentState.HasSuicided(addr)
+		switch {
+		case after && !before:
+			delta++
+		case before && !after:
+			delta--
+		}
+	}
+	return delta
+}
+
+// saveContractCount stores the number of contracts at height, the executing
+// block, when it changes.
+func (app *EVMApp) saveContractCount(height uint64, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	count := uint64(int64(atomic.LoadUint64(&app.contractCount)) + delta)
+	if err := app.stateDb.Put(ContractCountKey, encodeContractCount(height, count)); err != nil {
+		return err
+	}
+	atomic.StoreUint64(&app.contractCount, count)
+	return nil
+}
+
+func encodeContractCount(height, count uint64) []byte {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value, height)
+	binary.BigEndian.PutUint64(value[8:], count)
+	return value
+}
+
+// loadContractCount loads the number of contracts, counting them in the state
+// at root when the stored count is missing, eg. in a datadir older than the
+// count, or ahead of height, the last block.
+func (app *EVMApp) loadContractCount(height uint64, root common.Hash) error {
+	if value, err := app.stateDb.Get(ContractCountKey); err == nil && len(value) == 16 {
+		if binary.BigEndian.Uint64(value) <= height {
+			atomic.StoreUint64(&app.contractCount, binary.BigEndian.Uint64(value[8:]))
+			return nil
+		}
+	}
+	log.Info("counting contracts", zap.Uint64("height", height))
+	count, err := app.countContracts(root)
+	if err != nil {
+		return err
+	}
+	if err := app.stateDb.Put(ContractCountKey, encodeContractCount(height, count)); err != nil {
+		return err
+	}
+	atomic.StoreUint64(&app.contractCount, count)
+	return nil
+}
+
+// countContracts walks the accounts of the state at root
+func (app *EVMApp) countContracts(root common.Hash) (uint64, error) {
+	tr, err := trie.New(root, estate.NewDatabase(app.stateDb).TrieDB())
+	if err != nil {
+		return 0, err
+	}
+	var count uint64
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		var account estate.Account
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			return 0, err
+		}
+		if rtypes.HasCode(account.CodeHash) {
+			count++
+		}
+	}
+	return count, it.Err
+}
+
+// queryContractCount returns the rlp encoded number of live contracts
+func (app *EVMApp) queryContractCount() gtypes.Result {
+	data, err := rlp.EncodeToBytes(atomic.LoadUint64(&app.contractCount))
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,115 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func queryTestContractCount(t *testing.T, app *EVMApp) uint64 {
+	res := app.Query([]byte{rtypes.QueryType_ContractCount})
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var count uint64
+	if err := rlp.DecodeBytes(res.Data, &count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestQueryContractCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app, err := startTestApp(dir, viper.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the genesis admin contract is counted
+	if count := queryTestContractCount(t, app); count != 1 {
+		t.Fatalf("expected the genesis contract, got %d", count)
+	}
+
+	key, addr := testKey(t, testKeyA)
+	create := func(nonce uint64, code []byte) []byte {
+		return signTestTx(t, key, etypes.NewContractCreation(nonce, big.NewInt(0), testGas, big.NewInt(0), code))
+	}
+	call := func(nonce uint64, to common.Address) []byte {
+		return signTestTx(t, key, etypes.NewTransaction(nonce, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+	}
+	execTestBlock(t, app, 1, create(0, callerCode), create(1, selfDestructInitCode), create(2, selfDestructInitCode))
+	if count := queryTestContractCount(t, app); count != 4 {
+		t.Fatalf("expected 4 contracts, got %d", count)
+	}
+	execTestBlock(t, app, 2, call(3, crypto.CreateAddress(addr, 1)))
+	if count := queryTestContractCount(t, app); count != 3 {
+		t.Fatalf("expected 3 contracts after a self-destruct, got %d", count)
+	}
+	// deployed and destroyed in the same block
+	execTestBlock(t, app, 3, create(4, selfDestructInitCode), call(5, crypto.CreateAddress(addr, 4)))
+	if count := queryTestContractCount(t, app); count != 3 {
+		t.Fatalf("expected 3 contracts after a short lived one, got %d", count)
+	}
+	execTestBlock(t, app, 4, call(6, addr))
+	if count := queryTestContractCount(t, app); count != 3 {
+		t.Fatalf("expected 3 contracts after a plain transfer, got %d", count)
+	}
+
+	app.Stop()
+	if app, err = startTestApp(dir, viper.New()); err != nil {
+		t.Fatal(err)
+	}
+	if count := queryTestContractCount(t, app); count != 3 {
+		t.Fatalf("expected 3 contracts recovered on restart, got %d", count)
+	}
+
+	// a missing count, or one ahead of the last block, is recounted
+	for _, value := range [][]byte{nil, encodeContractCount(5, 7)} {
+		if value == nil {
+			err = app.stateDb.Delete(ContractCountKey)
+		} else {
+			err = app.stateDb.Put(ContractCountKey, value)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.Stop()
+		if app, err = startTestApp(dir, viper.New()); err != nil {
+			t.Fatal(err)
+		}
+		if count := queryTestContractCount(t, app); count != 3 {
+			t.Fatalf("expected 3 contracts recounted from %x, got %d", value, count)
+		}
+	}
+	execTestBlock(t, app, 5, call(7, crypto.CreateAddress(addr, 2)))
+	if count := queryTestContractCount(t, app); count != 2 {
+		t.Fatalf("expected 2 contracts after a recount and a self-destruct, got %d", count)
+	}
+	app.Stop()
+}
@@ -0,0 +1,96 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// CreatorPrefix records the address which deployed each contract, by contract
+// address. Only contracts created by a tx are recorded, not the ones created
+// from contract code.
+var CreatorPrefix = []byte("creator-")
+
+func creatorKey(addr common.Address) []byte {
+	return append(append([]byte{}, CreatorPrefix...), addr.Bytes()...)
+}
+
+// contractCreation is a contract deployed by the executing block
+type contractCreation struct {
+	contract common.Address
+	creator  common.Address
+}
+
+// txContractCreation returns the contract created by tx, with receipt, if any.
+func (app *EVMApp) txContractCreation(tx *etypes.Transaction, receipt *etypes.Receipt) (*contractCreation, error) {
+	if tx.To() != nil || receipt.Status != etypes.ReceiptStatusSuccessful {
+		return nil, nil
+	}
+	from, err := etypes.Sender(app.Signer, tx)
+	if err != nil {
+		return nil, err
+	}
+	return &contractCreation{contract: receipt.ContractAddress, creator: from}, nil
+}
+
+// saveContractCreators records the creators of the block's contracts, a contract
+// created again at an address takes the creator of the latest creation.
+func (app *EVMApp) saveContractCreators(creations []*contractCreation) error {
+	if len(creations) == 0 {
+		return nil
+	}
+	batch := app.stateDb.NewBatch()
+	for _, c := range creations {
+		if err := batch.Put(creatorKey(c.contract), c.creator.Bytes()); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// ContractCreator returns the address which deployed the contract at addr.
+func (app *EVMApp) ContractCreator(addr common.Address) (common.Address, bool) {
+	value, err := app.stateDb.Get(creatorKey(addr))
+	if err != nil || len(value) != common.AddressLength {
+		return common.Address{}, false
+	}
+	return common.BytesToAddress(value), true
+}
+
+// queryPredictAddress returns the rlp encoded address of the contract deployed
+// as the rlp encoded rtypes.PredictAddressQuery in load asks, computed without
+// executing anything: keccak256(rlp(sender, nonce)) for CREATE, and
+// keccak256(0xff || sender || salt || keccak256(init code)) for CREATE2.
+func (app *EVMApp) queryPredictAddress(load []byte) gtypes.Result {
+	query := &rtypes.PredictAddressQuery{}
+	if err := rlp.DecodeBytes(load, query); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	var addr common.Address
+	if query.Create2 {
+		addr = crypto.CreateAddress2(query.Sender, query.Salt, crypto.Keccak256(query.InitCode))
+	} else {
+		addr = crypto.CreateAddress(query.Sender, query.Nonce)
+	}
+	data, err := rlp.EncodeToBytes(addr)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,135 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func TestContractCreator(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), logContractCode)))
+	contract := crypto.CreateAddress(addr, 0)
+
+	creator, ok := app.ContractCreator(contract)
+	if !ok || creator != addr {
+		t.Fatalf("expected creator %x, got %x", addr, creator)
+	}
+	if _, ok := app.ContractCreator(addr); ok {
+		t.Fatal("expected no creator of an account without code")
+	}
+
+	load, err := rlp.EncodeToBytes(etypes.NewTransaction(0, contract, big.NewInt(0), 0, big.NewInt(0), app.state.GetCodeHash(contract).Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := app.Query(append([]byte{rtypes.QueryType_Existence}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	if len(res.Data) != 21 || res.Data[0] != 0x01 || !bytes.Equal(res.Data[1:], addr.Bytes()) {
+		t.Fatalf("expected the existence answer to carry the creator, got %x", res.Data)
+	}
+}
+
+func TestContractExistenceCodeHashes(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), logContractCode)))
+	contract := crypto.CreateAddress(addr, 0)
+	codeHash := crypto.Keccak256(app.state.GetCode(contract))
+	missing := common.HexToAddress("0x1234")
+
+	for _, c := range []struct {
+		to     common.Address
+		hash   []byte
+		exists bool
+	}{
+		{contract, codeHash, true},
+		{contract, rtypes.EmptyCodeHash.Bytes(), false},
+		{contract, nil, false},
+		{addr, nil, false},
+		{addr, rtypes.EmptyCodeHash.Bytes(), false},
+		{addr, common.Hash{}.Bytes(), false},
+		{missing, nil, false},
+		{missing, common.Hash{}.Bytes(), false},
+	} {
+		load, err := rlp.EncodeToBytes(etypes.NewTransaction(0, c.to, big.NewInt(0), 0, big.NewInt(0), c.hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := app.Query(append([]byte{rtypes.QueryType_Existence}, load...))
+		if res.IsErr() || len(res.Data) == 0 || (res.Data[0] == 0x01) != c.exists {
+			t.Fatalf("%x with code hash %x: expected existence %v, got %x", c.to, c.hash, c.exists, res.Data)
+		}
+	}
+}
+
+func queryPredictAddress(t *testing.T, app *EVMApp, query *rtypes.PredictAddressQuery) common.Address {
+	load, err := rlp.EncodeToBytes(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := app.Query(append([]byte{rtypes.QueryType_PredictAddress}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var addr common.Address
+	if err := rlp.DecodeBytes(res.Data, &addr); err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+func TestPredictAddress(t *testing.T) {
+	conf := viper.New()
+	conf.Set("fork_schedule", map[string]string{"1": "constantinople"})
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	factory := queryPredictAddress(t, app, &rtypes.PredictAddressQuery{Sender: addr, Nonce: 0})
+	// the factory CREATE2s its call data as init code, with salt 0x2a
+	factoryCode := common.FromHex("6016600c60003960166000f3" + "366000600037" + "602a" + "36" + "60006000" + "f5" + "600052" + "60206000f3")
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), factoryCode)))
+	if creator, ok := app.ContractCreator(factory); !ok || creator != addr {
+		t.Fatalf("expected the factory deployed at the predicted address %x", factory)
+	}
+
+	predicted := queryPredictAddress(t, app, &rtypes.PredictAddressQuery{
+		Sender: factory, Create2: true, Salt: common.BigToHash(big.NewInt(0x2a)), InitCode: callerCode,
+	})
+	if app.state.GetCodeSize(predicted) != 0 {
+		t.Fatal("expected nothing deployed yet at the predicted address")
+	}
+	execTestBlock(t, app, 2, signTestTx(t, key, etypes.NewTransaction(1, factory, big.NewInt(0), testGas, big.NewInt(0), callerCode)))
+	if app.state.GetCodeSize(predicted) == 0 {
+		t.Fatalf("expected the CREATE2 deployment at the predicted address %x", predicted)
+	}
+}
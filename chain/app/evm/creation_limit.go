@@ -0,0 +1,44 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/params"
+)
+
+var errTooManyCreations = errors.New("contract creations limit of the block reached")
+
+// withCreationsLimit returns config with the contract creations limit of a block set
+func withCreationsLimit(config *params.ChainConfig, limit uint64) *params.ChainConfig {
+	if limit == 0 {
+		return config
+	}
+	limited := *config
+	limited.MaxCreationsPerBlock = limit
+	return &limited
+}
+
+// checkCreationsLimit fails a contract creation tx once the txs executed before
+// it in the block used up the creations limit. Internal creations are counted
+// and failed by the evm.
+func (app *EVMApp) checkCreationsLimit(blockCreations uint64, tx *etypes.Transaction) error {
+	limit := app.chainConfig.MaxCreationsPerBlock
+	if limit > 0 && tx.To() == nil && blockCreations >= limit {
+		return errTooManyCreations
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// factoryLoopCode deploys a contract running as many empty CREATEs as its input
+// word and returning the number of contracts created.
+var factoryLoopCode = common.FromHex("602c600c600039602c6000f3" +
+	"600035600060005b82811015602257600060006000f01515820191506001016007565b5060005260206000f3")
+
+func TestCreationsLimit(t *testing.T) {
+	key, addr := testKey(t, testKeyA)
+	factory := crypto.CreateAddress(addr, 0)
+	loop := func(nonce uint64, n int64) []byte {
+		input := common.LeftPadBytes(big.NewInt(n).Bytes(), 32)
+		return signTestTx(t, key, etypes.NewTransaction(nonce, factory, big.NewInt(0), testGas, big.NewInt(0), input))
+	}
+	deployLate := signTestTx(t, key, etypes.NewContractCreation(3, big.NewInt(0), testGas, big.NewInt(0), nil))
+	blocks := [][][]byte{
+		{signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), factoryLoopCode))},
+		// 3 creations, then 2 of the 10 asked for, then a creation tx over the limit
+		{loop(1, 3), loop(2, 10), deployLate},
+		// the limit is per block
+		{loop(3, 3)},
+	}
+
+	// two apps executing the blocks on their own cut the creations off alike
+	var appHashes [2][][]byte
+	for i := range appHashes {
+		conf := viper.New()
+		conf.Set("max_creations_per_block", 5)
+		app, clean := newTestAppWithConfig(t, conf)
+		defer clean()
+
+		for h, txs := range blocks {
+			block := makeTestBlock(int64(h+1), txs...)
+			res, err := app.OnExecute(block.Height, 0, block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h == 1 {
+				invalid := res.(gtypes.ExecuteResult).InvalidTxs
+				if len(invalid) != 1 || !bytes.Equal(invalid[0].Bytes, deployLate) || invalid[0].Error != errTooManyCreations {
+					t.Fatalf("expected the creation tx over the limit to be invalid, got %+v", invalid)
+				}
+			}
+			commit, err := app.OnCommit(block.Height, 0, block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			appHashes[i] = append(appHashes[i], commit.(gtypes.CommitResult).AppHash)
+		}
+
+		stats := app.commitStats.list()
+		for h, expected := range []uint64{1, 5, 3} {
+			if stats[h].Creations != expected {
+				t.Fatalf("block %d: expected %d creations, got %d", h+1, expected, stats[h].Creations)
+			}
+		}
+		// the factory nonce counts its creations
+		if nonce := app.state.GetNonce(factory); nonce != 3+2+3 {
+			t.Fatalf("expected 8 contracts created by the factory, got %d", nonce)
+		}
+	}
+	for h := range blocks {
+		if !bytes.Equal(appHashes[0][h], appHashes[1][h]) {
+			t.Fatalf("block %d: app hashes differ, %x and %x", h+1, appHashes[0][h], appHashes[1][h])
+		}
+	}
+}
+
+func TestCreationsLimitRecorded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	withLimit := func(limit int) *viper.Viper {
+		conf := viper.New()
+		conf.Set("max_creations_per_block", limit)
+		return conf
+	}
+	key, addr := testKey(t, testKeyA)
+	factory := crypto.CreateAddress(addr, 0)
+
+	app, err := startTestApp(dir, withLimit(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploy := makeTestBlock(1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), factoryLoopCode)))
+	if _, err := app.OnExecute(1, 0, deploy); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.OnCommit(1, 0, deploy); err != nil {
+		t.Fatal(err)
+	}
+	app.Stop()
+
+	// a validator restarted with another limit would fork off, it's refused
+	for _, limit := range []int{0, 6} {
+		if _, err := startTestApp(dir, withLimit(limit)); err == nil || !strings.Contains(err.Error(), "max_creations_per_block") {
+			t.Fatalf("expected max_creations_per_block %d refused, got %v", limit, err)
+		}
+	}
+	if app, err = startTestApp(dir, withLimit(5)); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Stop()
+	input := common.LeftPadBytes(big.NewInt(10).Bytes(), 32)
+	loop := makeTestBlock(2, signTestTx(t, key, etypes.NewTransaction(1, factory, big.NewInt(0), testGas, big.NewInt(0), input)))
+	if _, err := app.OnExecute(2, 0, loop); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.OnCommit(2, 0, loop); err != nil {
+		t.Fatal(err)
+	}
+	if nonce := app.state.GetNonce(factory); nonce != 5 {
+		t.Fatalf("expected the recorded limit of 5 creations, got %d", nonce)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/ethdb/remotedb"
+)
+
+// db_backend values, where the state database is kept
+const (
+	dbBackendLocal  = "local"  // leveldb in the datadir, sharded by db_shards
+	dbBackendRemote = "remote" // remotedb server at db_remote_endpoint
+)
+
+// openStateBackend opens the state database of the db_backend. The app
+// database, holding the last block, stays in the datadir either way.
+func openStateBackend(datadir string, config *viper.Viper) (ethdb.Database, error) {
+	shards := config.GetStringMapString("db_shards")
+	switch backend := config.GetString("db_backend"); backend {
+	case dbBackendLocal:
+		return openStateDatabase(datadir, shards, config.GetBool("db_recover"))
+	case dbBackendRemote:
+		if len(shards) > 0 {
+			return nil, fmt.Errorf("db_shards can't be used with db_backend %q", backend)
+		}
+		endpoint := config.GetString("db_remote_endpoint")
+		if endpoint == "" {
+			return nil, fmt.Errorf("db_backend %q without db_remote_endpoint", backend)
+		}
+		token := config.GetString("db_remote_token")
+		if token == "" {
+			return nil, fmt.Errorf("db_backend %q without db_remote_token", backend)
+		}
+		return remotedb.Dial(endpoint, token, config.GetInt("db_remote_cache"))
+	default:
+		return nil, fmt.Errorf("invalid db_backend %q", backend)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/ethdb/remotedb"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// testFixtureChain returns blocks 1 to n mixing transfers, contract creations,
+// contract calls and empty blocks
+func testFixtureChain(t *testing.T, n int) []*gtypes.Block {
+	key, _ := testKey(t, testKeyA)
+	var (
+		blocks []*gtypes.Block
+		nonce  uint64
+	)
+	for height := int64(1); height <= int64(n); height++ {
+		var txs [][]byte
+		switch {
+		case height%10 == 1:
+			txs = append(txs, signTestTx(t, key, etypes.NewContractCreation(nonce, big.NewInt(0), testGas, big.NewInt(0), logContractCode)))
+			nonce++
+		case height%3 == 0:
+			// empty block
+		default:
+			for i := int64(0); i < height%4+1; i++ {
+				to := common.BigToAddress(big.NewInt(0x1000 + height*4 + i))
+				txs = append(txs, signTestTx(t, key, etypes.NewTransaction(nonce, to, big.NewInt(0), testGas, big.NewInt(0), nil)))
+				nonce++
+			}
+		}
+		blocks = append(blocks, makeTestBlock(height, txs...))
+	}
+	return blocks
+}
+
+const testRemoteToken = "remotedb-test-token"
+
+func newTestStateServer(t *testing.T) (*httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "remotedb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := ethdb.NewLDBDatabase(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := remotedb.NewServer(db, testRemoteToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	return server, func() {
+		server.Close()
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRemoteStateBackend(t *testing.T) {
+	server, cleanServer := newTestStateServer(t)
+	defer cleanServer()
+
+	local, cleanLocal := newTestApp(t)
+	defer cleanLocal()
+	conf := viper.New()
+	conf.Set("db_backend", dbBackendRemote)
+	conf.Set("db_remote_endpoint", server.URL)
+	conf.Set("db_remote_token", testRemoteToken)
+	remote, cleanRemote := newTestAppWithConfig(t, conf)
+	defer cleanRemote()
+
+	blocks := testFixtureChain(t, 100)
+	for _, block := range blocks {
+		expected, got := commitTestBlock(t, local, block), commitTestBlock(t, remote, block)
+		if !bytes.Equal(expected.AppHash, got.AppHash) || !bytes.Equal(expected.ReceiptsHash, got.ReceiptsHash) {
+			t.Fatalf("block %d: expected the commit %+v, got %+v", block.Height, expected, got)
+		}
+	}
+	last := blocks[len(blocks)-1].Data.Txs[0]
+	if receipt, err := remote.GetReceipt(txHash(last)); err != nil || receipt.Status != etypes.ReceiptStatusSuccessful {
+		t.Fatalf("expected the receipt kept by the remote database, got %+v %v", receipt, err)
+	}
+
+	// a node restarting on the remote database resumes from its state
+	remote.Stop()
+	if remote, err := startTestApp(conf.GetString("db_dir"), conf); err != nil {
+		t.Fatal(err)
+	} else if remote.getLastAppHash() != local.getLastAppHash() {
+		t.Fatal("expected the restarted node to resume from the remote state")
+	} else {
+		remote.Stop()
+	}
+}
+
+func TestRemoteStateBackendDown(t *testing.T) {
+	server, cleanServer := newTestStateServer(t)
+	defer cleanServer()
+	conf := viper.New()
+	conf.Set("db_backend", dbBackendRemote)
+	conf.Set("db_remote_endpoint", server.URL)
+	conf.Set("db_remote_token", testRemoteToken)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	commitTestBlock(t, app, makeTestBlock(1))
+	block := makeTestBlock(2, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), logContractCode)))
+	if _, err := app.OnExecute(2, 0, block); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	if _, err := app.OnCommit(2, 0, block); err == nil {
+		t.Fatal("expected the commit to fail with the state server down")
+	}
+	if lb, err := app.loadLastBlock(); err != nil || lb.Height != 1 {
+		t.Fatalf("expected the last block to stay at 1, got %+v %v", lb, err)
+	}
+}
+
+func TestRemoteReceiptsWriteFailure(t *testing.T) {
+	server, cleanServer := newTestStateServer(t)
+	defer cleanServer()
+	key, _ := testKey(t, testKeyA)
+	raw := signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), logContractCode))
+
+	// the server refuses the batch writing the receipt of raw, the trie commit
+	// before it goes through
+	var refuse int32
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if atomic.LoadInt32(&refuse) == 1 && bytes.Contains(body, receiptKey(txHash(raw))) {
+			http.Error(w, "refused", http.StatusInternalServerError)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	})
+	conf := viper.New()
+	conf.Set("db_backend", dbBackendRemote)
+	conf.Set("db_remote_endpoint", server.URL)
+	conf.Set("db_remote_token", testRemoteToken)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	commitTestBlock(t, app, makeTestBlock(1))
+	block := makeTestBlock(2, raw)
+	if _, err := app.OnExecute(2, 0, block); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&refuse, 1)
+	if _, err := app.OnCommit(2, 0, block); err == nil {
+		t.Fatal("expected the commit to fail with the receipts write refused")
+	}
+	if lb, err := app.loadLastBlock(); err != nil || lb.Height != 1 {
+		t.Fatalf("expected the last block to stay at 1, got %+v %v", lb, err)
+	}
+	if height := atomic.LoadInt64(&app.committedHeight); height != 1 {
+		t.Fatalf("expected the committed height to stay at 1, got %d", height)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockHolder returns the pid of the process holding the flock of file, found
+// by its inode in /proc/locks, or 0.
+func lockHolder(file string) int {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+	locks, err := os.Open("/proc/locks")
+	if err != nil {
+		return 0
+	}
+	defer locks.Close()
+
+	// eg. "1: FLOCK  ADVISORY  WRITE 1234 08:01:5678 0 EOF"
+	inode := ":" + strconv.FormatUint(stat.Ino, 10)
+	scanner := bufio.NewScanner(locks)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for i := 1; i < len(fields); i++ {
+			if strings.Count(fields[i], ":") == 2 && strings.HasSuffix(fields[i], inode) {
+				if pid, err := strconv.Atoi(fields[i-1]); err == nil {
+					return pid
+				}
+			}
+		}
+	}
+	return 0
+}
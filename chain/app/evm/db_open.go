@@ -0,0 +1,92 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
+)
+
+// DatabaseErrorKind classifies the failures to open a database
+type DatabaseErrorKind int
+
+const (
+	DatabaseErrorOther DatabaseErrorKind = iota
+	DatabaseErrorLocked
+	DatabaseErrorPermission
+	DatabaseErrorDiskFull
+	DatabaseErrorCorrupted
+)
+
+// DatabaseError is a failure to open the database at Path, with a hint for the operator
+type DatabaseError struct {
+	Kind DatabaseErrorKind
+	Path string
+	PID  int // process holding the lock of a locked database, 0 when unknown
+	Hint string
+	Err  error
+}
+
+func (e *DatabaseError) Error() string {
+	return fmt.Sprintf("open database %s: %v; %s", e.Path, e.Err, e.Hint)
+}
+
+// diagnoseDatabaseError classifies err, failing to open the database at path,
+// into a DatabaseError. Errors of no known class are kept as DatabaseErrorOther.
+func diagnoseDatabaseError(path string, err error) *DatabaseError {
+	e := &DatabaseError{Kind: DatabaseErrorOther, Path: path, Err: err}
+	switch errno := unwrapErrno(err); {
+	case lerrors.IsCorrupted(err):
+		e.Kind = DatabaseErrorCorrupted
+		e.Hint = "the database is corrupted, back it up and restart with db_recover = true to try to recover it, recovery may drop data"
+	case errno == syscall.EWOULDBLOCK || errno == syscall.EAGAIN:
+		e.Kind = DatabaseErrorLocked
+		e.PID = lockHolder(filepath.Join(path, "LOCK"))
+		if e.PID > 0 {
+			e.Hint = fmt.Sprintf("the database is locked by process %d, another node may be running on the same datadir", e.PID)
+		} else {
+			e.Hint = "the database is locked by another process, another node may be running on the same datadir"
+		}
+	case errno == syscall.EACCES || errno == syscall.EPERM || errno == syscall.EROFS:
+		e.Kind = DatabaseErrorPermission
+		e.Hint = "the database directory isn't writable, check its owner and permissions and that the mount isn't read-only"
+	case errno == syscall.ENOSPC:
+		e.Kind = DatabaseErrorDiskFull
+		e.Hint = "the disk is full, free some space or move the datadir"
+	default:
+		e.Hint = "check the database directory"
+	}
+	return e
+}
+
+func unwrapErrno(err error) syscall.Errno {
+	for {
+		switch e := err.(type) {
+		case syscall.Errno:
+			return e
+		case *os.PathError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		case *os.LinkError:
+			err = e.Err
+		default:
+			return 0
+		}
+	}
+}
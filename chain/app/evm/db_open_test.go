@@ -0,0 +1,69 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestOpenDatabaseLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-db-open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(dir, chainDataName, DatabaseCache, DatabaseHandles, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = OpenDatabase(dir, chainDataName, DatabaseCache, DatabaseHandles, false)
+	dbErr, ok := err.(*DatabaseError)
+	if !ok || dbErr.Kind != DatabaseErrorLocked {
+		t.Fatalf("expected a locked database error, got %v", err)
+	}
+	if dbErr.Path != filepath.Join(dir, chainDataName) || !strings.Contains(dbErr.Error(), dbErr.Path) {
+		t.Fatalf("expected the error to name the database path, got %v", dbErr)
+	}
+	if runtime.GOOS == "linux" && dbErr.PID != os.Getpid() {
+		t.Fatalf("expected the lock held by %d, got %d", os.Getpid(), dbErr.PID)
+	}
+}
+
+func TestOpenDatabaseReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions don't apply to root")
+	}
+	dir, err := ioutil.TempDir("", "evm-db-open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+
+	_, err = OpenDatabase(dir, chainDataName, DatabaseCache, DatabaseHandles, false)
+	if dbErr, ok := err.(*DatabaseError); !ok || dbErr.Kind != DatabaseErrorPermission {
+		t.Fatalf("expected a permission database error, got %v", err)
+	}
+}
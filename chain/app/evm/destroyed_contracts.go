@@ -0,0 +1,83 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// DestroyedPrefix records self-destructed contracts, the value is the 8 bytes
+// big endian height of the destroying block.
+var DestroyedPrefix = []byte("destroyed-")
+
+func destroyedKey(addr common.Address) []byte {
+	return append(append([]byte{}, DestroyedPrefix...), addr.Bytes()...)
+}
+
+// recreatedContracts returns the accounts of touched which have code in the
+// executing state and were destroyed in an earlier block, ie. contracts created
+// again at their address, eg. by CREATE2. Must be called before the state commit.
+func (app *EVMApp) recreatedContracts(touched []common.Address) []common.Address {
+	var addrs []common.Address
+	for _, addr := range touched {
+		if app.currentState.GetCodeSize(addr) == 0 || app.currentState.HasSuicided(addr) {
+			continue
+		}
+		if has, _ := app.stateDb.Has(destroyedKey(addr)); has {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func (app *EVMApp) saveDestroyedContracts(height uint64, destroyed, recreated []common.Address) error {
+	if len(destroyed) == 0 && len(recreated) == 0 {
+		return nil
+	}
+	batch := app.stateDb.NewBatch()
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, height)
+	for _, addr := range destroyed {
+		if err := batch.Put(destroyedKey(addr), value); err != nil {
+			return err
+		}
+	}
+	for _, addr := range recreated {
+		if err := batch.Delete(destroyedKey(addr)); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// queryIsDestroyed returns the rlp encoded rtypes.DestroyedStatus of the 20 bytes address
+func (app *EVMApp) queryIsDestroyed(load []byte) gtypes.Result {
+	if len(load) != common.AddressLength {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid address")
+	}
+	var status rtypes.DestroyedStatus
+	if value, err := app.stateDb.Get(destroyedKey(common.BytesToAddress(load))); err == nil && len(value) == 8 {
+		status.Destroyed, status.Height = true, binary.BigEndian.Uint64(value)
+	}
+	data, err := rlp.EncodeToBytes(&status)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,89 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+var (
+	// selfDestructInitCode deploys a contract self-destructing on any call
+	selfDestructInitCode = common.FromHex("6002600c60003960026000f3" + "33ff")
+	// create2FactoryCode deploys a contract creating selfDestructInitCode with
+	// CREATE2 and salt 0 on any call, so always at the same address
+	create2FactoryCode = common.FromHex("601f600c600039601f6000f3" +
+		"600e6011600039" + "6000600e60006000f500" + "6002600c60003960026000f333ff")
+)
+
+func queryTestDestroyed(t *testing.T, app *EVMApp, addr common.Address) rtypes.DestroyedStatus {
+	res := app.Query(append([]byte{rtypes.QueryType_IsDestroyed}, addr.Bytes()...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var status rtypes.DestroyedStatus
+	if err := rlp.DecodeBytes(res.Data, &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestQueryIsDestroyed(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	call := func(nonce uint64, to common.Address) []byte {
+		return signTestTx(t, key, etypes.NewTransaction(nonce, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+	}
+	contract := crypto.CreateAddress(addr, 0)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), selfDestructInitCode)))
+	if status := queryTestDestroyed(t, app, contract); status.Destroyed {
+		t.Fatalf("unexpected status of a live contract %+v", status)
+	}
+	execTestBlock(t, app, 2, call(1, contract))
+	if status := queryTestDestroyed(t, app, contract); !status.Destroyed || status.Height != 2 {
+		t.Fatalf("unexpected status of a destroyed contract %+v", status)
+	}
+	if status := queryTestDestroyed(t, app, common.HexToAddress("0x1234")); status.Destroyed {
+		t.Fatalf("unexpected status of a never deployed address %+v", status)
+	}
+
+	// re-creation at the same address clears the record
+	factory := crypto.CreateAddress(addr, 2)
+	child := crypto.CreateAddress2(factory, [32]byte{}, crypto.Keccak256(selfDestructInitCode))
+	execTestBlock(t, app, 3, signTestTx(t, key, etypes.NewContractCreation(2, big.NewInt(0), testGas, big.NewInt(0), create2FactoryCode)))
+	execTestBlock(t, app, 4, call(3, factory))
+	if len(app.state.GetCode(child)) == 0 {
+		t.Fatal("expected the factory to create the child")
+	}
+	execTestBlock(t, app, 5, call(4, child))
+	if status := queryTestDestroyed(t, app, child); !status.Destroyed || status.Height != 5 {
+		t.Fatalf("unexpected status of the destroyed child %+v", status)
+	}
+	execTestBlock(t, app, 6, call(5, factory))
+	if status := queryTestDestroyed(t, app, child); status.Destroyed {
+		t.Fatalf("unexpected status of the re-created child %+v", status)
+	}
+
+	if res := app.Query([]byte{rtypes.QueryType_IsDestroyed, 1}); res.IsOK() {
+		t.Fatal("expected invalid address to be rejected")
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+// duplicate_nonce values, how the pool takes a tx whose sender already has a
+// pooled tx of the same nonce
+const (
+	duplicateNonceReject  = "reject"  // the pooled tx stays, the new one is refused
+	duplicateNonceReplace = "replace" // the new tx replaces the pooled one if it pays a higher gas price
+)
+
+var (
+	// ErrDuplicateNonce refuses a tx whose nonce is taken by a pooled tx of the same sender
+	ErrDuplicateNonce = errors.New("tx of the same sender and nonce already in pool")
+	// ErrReplaceUnderpriced refuses a replacement not paying more than the pooled tx
+	ErrReplaceUnderpriced = errors.New("replacement tx gas price not higher than the pooled tx")
+)
+
+func validDuplicateNonce(policy string) bool {
+	return policy == duplicateNonceReject || policy == duplicateNonceReplace
+}
+
+// takeNonce makes room for tx of from, refusing it when a pooled tx has its
+// nonce, unless the duplicate_nonce policy lets it replace that tx. A replaced
+// waiting tx is dropped, a replaced pending tx is swapped for tx in place, and
+// inPending tells the caller tx is already pooled.
+func (tp *ethTxPool) takeNonce(tx *etypes.Transaction, from common.Address) (inPending bool, err error) {
+	queue, pending := tp.waiting[from], false
+	if queue == nil || queue.Get(tx.Nonce()) == nil {
+		queue, pending = tp.pending[from], true
+	}
+	if queue == nil || queue.Get(tx.Nonce()) == nil {
+		return false, nil
+	}
+	pooled := queue.Get(tx.Nonce())
+	if tp.duplicateNonce != duplicateNonceReplace {
+		return false, ErrDuplicateNonce
+	}
+	if tx.GasPrice().Cmp(pooled.GasPrice()) <= 0 {
+		return false, ErrReplaceUnderpriced
+	}
+	if pending {
+		queue.Put(tx)
+	} else {
+		queue.Remove(tx.Nonce())
+	}
+	tp.forget(pooled.Hash())
+	tp.app.txStatus.replaced(pooled.Hash(), tx.Hash())
+	tp.app.poolDrops.record(rtypes.PoolDrop_Replaced)
+	return pending, nil
+}
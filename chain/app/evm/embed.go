@@ -0,0 +1,267 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+var (
+	// ErrSyncing answers the reads refused by syncing_queries=refuse
+	ErrSyncing = errors.New("node syncing")
+	// ErrReceiptNotFound answers the lookups of unknown receipts
+	ErrReceiptNotFound = errors.New("receipt not found")
+)
+
+// StateReader is the typed access to the receipts and states of the app, for
+// binaries embedding it in process instead of going through Query. A height of
+// 0 reads the latest state, any other the state committed at that height. The
+// reads follow the rules of the matching queries: syncing_queries and the
+// historical query limit apply alike.
+type StateReader interface {
+	GetReceipt(hash common.Hash) (*etypes.Receipt, error)
+	GetBalance(addr common.Address, height uint64) (*big.Int, error)
+	GetNonce(addr common.Address, height uint64) (uint64, error)
+	CallContract(msg etypes.Message, height uint64) ([]byte, error)
+	GetLogs(filter *LogFilter) ([]*etypes.Log, error)
+}
+
+var _ StateReader = (*EVMApp)(nil)
+
+// LogFilter selects the logs of the blocks FromBlock to ToBlock included
+type LogFilter struct {
+	FromBlock uint64
+	ToBlock   uint64
+	Addresses []common.Address // any address when empty
+	// Topics[i] are the alternatives of the i-th topic, an empty position matches
+	// any topic
+	Topics [][]common.Hash
+}
+
+func (f *LogFilter) match(l *etypes.Log) bool {
+	if len(f.Addresses) > 0 {
+		found := false
+		for _, addr := range f.Addresses {
+			if l.Address == addr {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Topics) > len(l.Topics) {
+		return false
+	}
+	for i, alternatives := range f.Topics {
+		if len(alternatives) == 0 {
+			continue
+		}
+		found := false
+		for _, topic := range alternatives {
+			if l.Topics[i] == topic {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRead returns ErrSyncing if syncing_queries refuses the query of type
+// action, which the typed read stands for.
+func (app *EVMApp) checkRead(action byte) error {
+	if app.syncingQueries != syncingQueriesRefuse {
+		return nil
+	}
+	if msg := app.syncingQuery(action); msg != "" {
+		return ErrSyncing
+	}
+	return nil
+}
+
+// readState runs read on the latest state, under the state lock, or on the
+// state committed at height within the historical query limit.
+func (app *EVMApp) readState(height uint64, read func(*estate.StateDB)) error {
+	if height == 0 {
+		app.stateMtx.Lock()
+		read(app.state)
+		app.stateMtx.Unlock()
+		return nil
+	}
+	if err := app.historical.acquire(); err != nil {
+		return err
+	}
+	defer app.historical.release()
+	state, _, err := app.historicalState(height)
+	if err != nil {
+		return err
+	}
+	read(state)
+	return nil
+}
+
+// GetReceipt returns the receipt of the tx of hash, ErrReceiptNotFound when the
+// tx is unknown or its receipt pruned.
+func (app *EVMApp) GetReceipt(hash common.Hash) (*etypes.Receipt, error) {
+	if err := app.checkRead(rtypes.QueryType_Receipt); err != nil {
+		return nil, err
+	}
+	env, err := app.storedReceipt(hash)
+	if err != nil {
+		return nil, err
+	}
+	return (*etypes.Receipt)(env.Receipt), nil
+}
+
+func (app *EVMApp) storedReceipt(hash common.Hash) (*receiptEnvelope, error) {
+	data, err := app.stateDb.Get(receiptKey(hash))
+	if err != nil {
+		return nil, ErrReceiptNotFound
+	}
+	return app.decodeReceipt(data)
+}
+
+// GetBalance returns the balance of addr at height
+func (app *EVMApp) GetBalance(addr common.Address, height uint64) (*big.Int, error) {
+	if err := app.checkRead(rtypes.QueryType_BalancesBatch); err != nil {
+		return nil, err
+	}
+	var balance *big.Int
+	err := app.readState(height, func(state *estate.StateDB) {
+		balance = state.GetBalance(addr)
+	})
+	return balance, err
+}
+
+// VerifyBalance tells if the latest balance of addr is expected, and returns
+// the balance read so a mismatch can be reported.
+func (app *EVMApp) VerifyBalance(addr common.Address, expected *big.Int) (bool, *big.Int) {
+	app.stateMtx.Lock()
+	actual := new(big.Int).Set(app.state.GetBalance(addr))
+	app.stateMtx.Unlock()
+	return expected != nil && actual.Cmp(expected) == 0, actual
+}
+
+// GetNonce returns the nonce of addr at height
+func (app *EVMApp) GetNonce(addr common.Address, height uint64) (uint64, error) {
+	if err := app.checkRead(rtypes.QueryType_Nonce); err != nil {
+		return 0, err
+	}
+	var nonce uint64
+	err := app.readState(height, func(state *estate.StateDB) {
+		nonce = state.GetNonce(addr)
+	})
+	return nonce, err
+}
+
+// CallContract returns the evm output of msg simulated on the state at height,
+// with its gas clamped to EVMGasLimit. Nothing is committed.
+func (app *EVMApp) CallContract(msg etypes.Message, height uint64) ([]byte, error) {
+	if err := app.checkRead(rtypes.QueryType_Call); err != nil {
+		return nil, err
+	}
+	msg, _ = clampGas(msg)
+	return app.simulateContract(msg, height, evmConfig)
+}
+
+// LogsPrunedError answers the log lookups starting at a block whose receipts
+// are pruned, rather than no logs. The blocks after PrunedTo are available.
+type LogsPrunedError struct {
+	FromBlock uint64
+	PrunedTo  uint64
+}
+
+func (e *LogsPrunedError) Error() string {
+	return fmt.Sprintf("logs from block %d unavailable, the receipts of blocks up to %d are pruned", e.FromBlock, e.PrunedTo)
+}
+
+// logsPruned returns a *LogsPrunedError if the receipts of the block at from
+// are pruned.
+func (app *EVMApp) logsPruned(from uint64) error {
+	if pruned := atomic.LoadUint64(&app.receiptsPruned); from <= pruned {
+		return &LogsPrunedError{FromBlock: from, PrunedTo: pruned}
+	}
+	return nil
+}
+
+// GetLogs returns the logs matching filter, in chain order, scanning at most
+// logs_range_limit blocks. The logs are filled with their block and tx refs.
+// A range starting at a pruned block fails with a *LogsPrunedError, so does a
+// scan the pruning caught up with, an empty result always means no logs.
+func (app *EVMApp) GetLogs(filter *LogFilter) ([]*etypes.Log, error) {
+	if err := app.checkRead(rtypes.QueryType_Receipt); err != nil {
+		return nil, err
+	}
+	if filter.FromBlock == 0 || filter.FromBlock > filter.ToBlock {
+		return nil, fmt.Errorf("invalid block range %d-%d", filter.FromBlock, filter.ToBlock)
+	}
+	if filter.ToBlock-filter.FromBlock >= uint64(app.logsRangeLimit) {
+		return nil, fmt.Errorf("too many blocks, limit %d", app.logsRangeLimit)
+	}
+	if err := app.logsPruned(filter.FromBlock); err != nil {
+		return nil, err
+	}
+	var logs []*etypes.Log
+	for height := filter.FromBlock; height <= filter.ToBlock; height++ {
+		index, err := app.stateDb.Get(blockReceiptsKey(height))
+		if err != nil || len(index) == 0 {
+			// no receipts, or not indexed
+			continue
+		}
+		var txHashes []common.Hash
+		if err := rlp.DecodeBytes(index, &txHashes); err != nil {
+			return nil, fmt.Errorf("decode receipts index of block %d: %v", height, err)
+		}
+		var logIndex uint
+		for txIndex, hash := range txHashes {
+			env, err := app.storedReceipt(hash)
+			if err == ErrReceiptNotFound {
+				if pruned := app.logsPruned(filter.FromBlock); pruned != nil {
+					return nil, pruned
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, l := range env.Receipt.Logs {
+				if filter.match(l) {
+					found := *l
+					found.BlockNumber, found.BlockHash = height, env.BlockHash
+					found.TxHash, found.TxIndex, found.Index = hash, uint(txIndex), logIndex
+					logs = append(logs, &found)
+				}
+				logIndex++
+			}
+		}
+	}
+	// the blocks pruned while scanning were skipped as blocks without receipts
+	if err := app.logsPruned(filter.FromBlock); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
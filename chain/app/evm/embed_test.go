@@ -0,0 +1,124 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+)
+
+func TestStateReader(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	execTestBlock(t, app, 1,
+		signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), callerCode)),
+		signTestTx(t, key, etypes.NewContractCreation(1, big.NewInt(0), testGas, big.NewInt(0), logContractCode)))
+	caller, logger := crypto.CreateAddress(addr, 0), crypto.CreateAddress(addr, 1)
+	call := signTestTx(t, key, etypes.NewTransaction(2, logger, big.NewInt(0), testGas, big.NewInt(0), nil))
+	execTestBlock(t, app, 2, call)
+
+	receipt, err := app.GetReceipt(txHash(call))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != etypes.ReceiptStatusSuccessful || len(receipt.Logs) != 1 {
+		t.Fatalf("unexpected receipt %+v", receipt)
+	}
+	if _, err := app.GetReceipt(common.HexToHash("0x1234")); err != ErrReceiptNotFound {
+		t.Fatalf("expected %v, got %v", ErrReceiptNotFound, err)
+	}
+
+	if nonce, err := app.GetNonce(addr, 0); err != nil || nonce != 3 {
+		t.Fatalf("expected nonce 3, got %d %v", nonce, err)
+	}
+	if balance, err := app.GetBalance(addr, 0); err != nil || balance.Sign() != 0 {
+		t.Fatalf("expected no balance, got %v %v", balance, err)
+	}
+
+	out, err := app.CallContract(etypes.NewMessage(addr, &caller, 0, big.NewInt(0), 10*EVMGasLimit, big.NewInt(0), nil, false), 0)
+	if err != nil || !bytes.Equal(out, common.LeftPadBytes(addr.Bytes(), 32)) {
+		t.Fatalf("unexpected call output %x %v", out, err)
+	}
+
+	logs, err := app.GetLogs(&LogFilter{FromBlock: 1, ToBlock: 2, Addresses: []common.Address{logger}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Address != logger || logs[0].BlockNumber != 2 || logs[0].TxHash != txHash(call) {
+		t.Fatalf("unexpected logs %+v", logs)
+	}
+	for _, filter := range []*LogFilter{
+		{FromBlock: 1, ToBlock: 2, Addresses: []common.Address{caller}},
+		{FromBlock: 1, ToBlock: 2, Topics: [][]common.Hash{{common.HexToHash("0x01")}}},
+		{FromBlock: 1, ToBlock: 1},
+	} {
+		if logs, err := app.GetLogs(filter); err != nil || len(logs) != 0 {
+			t.Fatalf("expected no logs matching %+v, got %+v %v", filter, logs, err)
+		}
+	}
+	app.logsRangeLimit = 1
+	if _, err := app.GetLogs(&LogFilter{FromBlock: 1, ToBlock: 2}); err == nil {
+		t.Fatal("expected a range over the limit to fail")
+	}
+}
+
+func TestVerifyBalance(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	_, addr := testKey(t, testKeyA)
+	fundTestAccounts(t, app, big.NewInt(100), addr)
+	if ok, actual := app.VerifyBalance(addr, big.NewInt(100)); !ok || actual.Int64() != 100 {
+		t.Fatalf("expected the balance 100 to match, got %v", actual)
+	}
+	for _, expected := range []*big.Int{big.NewInt(99), big.NewInt(0), nil} {
+		if ok, actual := app.VerifyBalance(addr, expected); ok || actual.Int64() != 100 {
+			t.Fatalf("expected %v to mismatch the balance 100, got %v %v", expected, ok, actual)
+		}
+	}
+	if ok, actual := app.VerifyBalance(common.HexToAddress("0x1234"), big.NewInt(0)); !ok || actual.Sign() != 0 {
+		t.Fatalf("expected an unknown account to have no balance, got %v", actual)
+	}
+}
+
+func TestStateReaderSyncing(t *testing.T) {
+	conf := viper.New()
+	conf.Set("syncing_queries", syncingQueriesRefuse)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	core := &testCore{height: 100000}
+	app.SetCore(core)
+	execTestBlock(t, app, 1)
+
+	_, addr := testKey(t, testKeyA)
+	if _, err := app.GetNonce(addr, 0); err != ErrSyncing {
+		t.Fatalf("expected %v, got %v", ErrSyncing, err)
+	}
+	if _, err := app.GetReceipt(common.Hash{}); err != ErrSyncing {
+		t.Fatalf("expected %v, got %v", ErrSyncing, err)
+	}
+	core.height = 1
+	if _, err := app.GetNonce(addr, 0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -14,13 +14,16 @@
 package evm
 
 import (
-	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math/big"
+	"net/http"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -39,7 +42,6 @@ import (
 	"github.com/dappledger/AnnChain/eth/params"
 	"github.com/dappledger/AnnChain/eth/rlp"
 	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
-	"github.com/dappledger/AnnChain/gemmill/modules/go-merkle"
 	gtypes "github.com/dappledger/AnnChain/gemmill/types"
 )
 
@@ -58,7 +60,7 @@ const (
 	EVMGasLimit uint64 = 100000000
 )
 
-//reference ethereum BlockChain
+// reference ethereum BlockChain
 type BlockChainEvm struct {
 	db ethdb.Database
 }
@@ -104,9 +106,98 @@ type EVMApp struct {
 	stateMtx     sync.Mutex
 	state        *estate.StateDB
 	currentState *estate.StateDB
-
+	// header of the block app.state was committed by and the root of app.state,
+	// swapped along with it under stateMtx so the latest committed reads never
+	// see the executing block
+	committedHeader *etypes.Header
+	committedRoot   common.Hash
+
+	// held while a block is executed and while it's committed, and by rollbacks,
+	// blockInFlight tells a block was executed and isn't committed yet
+	blockMtx      sync.Mutex
+	blockInFlight int32
+	// failed commits in a row, under blockMtx
+	commitFailures     int
+	commitFailureLimit int
+	stopNode           func(error)
+
+	// commitDb wraps stateDb to count what committing a block writes
+	commitDb    *countingDatabase
+	commitStats *commitStatsWindow
+
+	// receipts of the executing block, kept in block tx order. SaveReceipts,
+	// the receipts hash and log indexes all rely on this order.
 	receipts etypes.Receipts
-	Signer   etypes.Signer
+	// storage envelopes of receipts, one per receipt in the same order
+	receiptEnvs []*receiptEnvelope
+	// contracts deployed by the executing block
+	creations []*contractCreation
+	Signer    etypes.Signer
+
+	txStatus         *txStatusTracker
+	senders          *senderCache
+	receiptsMigrator *receiptsMigrator
+	httpQuery        *http.Server
+	queryACL         *httpQueryACL
+	txImport         *http.Server
+	historical       *limiter // queries on historical states, each holding its own trie reader
+	checkTxs         *limiter
+	poolDrops        *poolDropStats
+	simulations      *simulationCache
+	warmer           *stateWarmer
+	mirror           *mirror
+	execGuard        *execGuard
+	postExecute      postExecuteHooks
+
+	balancesBatchLimit    int
+	storageKeysLimit      int
+	stateDiffLimit        int
+	lightHeaderRangeLimit int
+	recentBlocksLimit     int
+	gasRangeLimit         int
+	queryMaxResponseBytes int
+	logsRangeLimit        int
+	simulationCallDepth   uint64
+	maxTxDataSize         int
+	checkTxSignature      bool
+	idleCommitSkip        bool
+	receiptsRetention     uint64
+	logDedup              bool
+	logDedupMinSize       int
+	globalMinGasPrice     *big.Int
+	coinbase              common.Address
+	viewCallSender        *common.Address // nil when unsigned view calls are refused
+	simulateBlockQuery    bool
+	gasPriceFloors        map[common.Address]*big.Int
+
+	genesisHash common.Hash
+
+	committedHeight  int64  // atomic, height of the last committed block
+	receiptsPruned   uint64 // atomic, height up to which the receipts are pruned
+	contractCount    uint64 // atomic, number of live contracts
+	configEpochs     configEpochs
+	syncLagThreshold uint64
+	syncingQueries   string
+	catchUpMode      bool
+	txOrder          string
+	nonceGap         string
+	// txs out of nonce order in the last executed block
+	txOrderViolations int
+	// contract creations of the valid txs of the executing block so far, for
+	// the per block limit
+	blockCreations uint64
+	// app message txs the executing block sent, and the gas of the calls
+	// delivering the inbound ones
+	appMessages   [][]byte
+	appMessageGas uint64
+
+	misbehaviorMaxAge     int64
+	misbehaviorBlockLimit uint64
+
+	// heights of the first blocks the execution rules apply to, see activeAt
+	zeroAddressHeight uint64
+	gasLimitHeight    uint64
+	txBoundsHeight    uint64
 }
 
 type LastBlockInfo struct {
@@ -115,11 +206,121 @@ type LastBlockInfo struct {
 }
 
 func NewEVMApp(config *viper.Viper) (*EVMApp, error) {
+	setDefaults(config)
+	if err := checkConfig(config, config.GetString("db_dir")); err != nil {
+		return nil, errors.Wrap(err, "app error")
+	}
+	chainConfig, err := loadChainConfig(config.GetStringMapString("fork_schedule"))
+	if err != nil {
+		log.Error("load chain config error", zap.Error(err))
+		return nil, errors.Wrap(err, "app error")
+	}
+	chainConfig = withLogDataCaps(chainConfig, uint64(config.GetInt64("max_tx_log_data")), uint64(config.GetInt64("max_block_log_data")))
+	chainConfig = withSenderTxsLimit(chainConfig, uint64(config.GetInt64("max_txs_per_sender")))
+	chainConfig = withCreationsLimit(chainConfig, uint64(config.GetInt64("max_creations_per_block")))
+	chainConfig = withAppMessages(chainConfig, config.GetBool("app_messages"))
+	if chainConfig, err = withTxOrderPolicy(chainConfig, config.GetString("tx_order_policy")); err != nil {
+		return nil, errors.Wrap(err, "app error")
+	}
+	if chainConfig, err = withZeroAddressPolicy(chainConfig, config.GetString("zero_address_policy")); err != nil {
+		return nil, errors.Wrap(err, "app error")
+	}
+	if chainConfig, err = withMinAccountBalance(chainConfig, config.GetString("min_account_balance_wei")); err != nil {
+		return nil, errors.Wrap(err, "app error")
+	}
+	if chainConfig, err = withReceiptsHash(chainConfig, config.GetString("receipts_hash")); err != nil {
+		return nil, errors.Wrap(err, "app error")
+	}
+	if chainConfig, err = withTxBounds(chainConfig, config.GetString("max_tx_value"), config.GetString("max_tx_gas_price")); err != nil {
+		return nil, errors.Wrap(err, "app error")
+	}
+	if chainConfig, err = withBlockGas(chainConfig, uint64(config.GetInt64("block_gas_limit")), uint64(config.GetInt64("system_gas_reserve"))); err != nil {
+		return nil, errors.Wrap(err, "app error")
+	}
 	app := &EVMApp{
-		datadir:     config.GetString("db_dir"),
-		Config:      config,
-		chainConfig: params.MainnetChainConfig,
-		Signer:      new(etypes.HomesteadSigner),
+		datadir:               config.GetString("db_dir"),
+		Config:                config,
+		chainConfig:           chainConfig,
+		Signer:                new(etypes.HomesteadSigner),
+		balancesBatchLimit:    config.GetInt("balances_batch_limit"),
+		storageKeysLimit:      config.GetInt("storage_keys_limit"),
+		stateDiffLimit:        config.GetInt("state_diff_limit"),
+		lightHeaderRangeLimit: config.GetInt("light_header_range_limit"),
+		recentBlocksLimit:     config.GetInt("recent_blocks_limit"),
+		gasRangeLimit:         config.GetInt("gas_range_limit"),
+		queryMaxResponseBytes: config.GetInt("query_max_response_bytes"),
+		logsRangeLimit:        config.GetInt("logs_range_limit"),
+		simulationCallDepth:   uint64(config.GetInt64("simulation_call_depth")),
+		simulateBlockQuery:    config.GetBool("simulate_block_query"),
+		maxTxDataSize:         config.GetInt("max_tx_data_size"),
+		checkTxSignature:      config.GetBool("check_tx_signature"),
+		idleCommitSkip:        config.GetBool("idle_commit_skip"),
+		commitStats:           newCommitStatsWindow(config.GetInt("commit_stats_window")),
+		syncLagThreshold:      uint64(config.GetInt64("sync_lag_threshold")),
+		syncingQueries:        config.GetString("syncing_queries"),
+		catchUpMode:           config.GetBool("catch_up_mode"),
+		txOrder:               config.GetString("tx_order"),
+		nonceGap:              config.GetString("exec_nonce_gap"),
+		misbehaviorMaxAge:     config.GetInt64("misbehavior_max_age"),
+		misbehaviorBlockLimit: uint64(config.GetInt64("misbehavior_block_limit")),
+		appMessageGas:         uint64(config.GetInt64("app_message_gas")),
+		zeroAddressHeight:     uint64(config.GetInt64("zero_address_height")),
+		gasLimitHeight:        uint64(config.GetInt64("gas_limit_height")),
+		txBoundsHeight:        uint64(config.GetInt64("tx_bounds_height")),
+		commitFailureLimit:    config.GetInt("commit_failure_limit"),
+		stopNode:              stopNode,
+	}
+	if path := config.GetString("query_acl_file"); path != "" {
+		if app.queryACL, err = newHTTPQueryACL(path); err != nil {
+			return nil, errors.Wrap(err, "app error")
+		}
+	}
+	if app.syncLagThreshold == 0 {
+		app.syncLagThreshold = 1
+	}
+	if !validSyncingQueries(app.syncingQueries) {
+		return nil, fmt.Errorf("app error: invalid syncing_queries %q", app.syncingQueries)
+	}
+	if app.simulationCallDepth == 0 || app.simulationCallDepth > params.CallCreateDepth {
+		return nil, fmt.Errorf("app error: simulation_call_depth must be 1 to %d", params.CallCreateDepth)
+	}
+	if !validTxOrder(app.txOrder) {
+		return nil, fmt.Errorf("app error: invalid tx_order %q", app.txOrder)
+	}
+	if !validNonceGap(app.nonceGap) {
+		return nil, fmt.Errorf("app error: invalid exec_nonce_gap %q", app.nonceGap)
+	}
+	if policy := config.GetString("duplicate_nonce"); !validDuplicateNonce(policy) {
+		return nil, fmt.Errorf("app error: invalid duplicate_nonce %q", policy)
+	}
+	if order := config.GetString("reap_order"); !validReapOrder(order) {
+		return nil, fmt.Errorf("app error: invalid reap_order %q", order)
+	}
+	if tieBreak := config.GetString("reap_tie_break"); !validReapTieBreak(tieBreak) {
+		return nil, fmt.Errorf("app error: invalid reap_tie_break %q", tieBreak)
+	}
+	warm, warmRecent, err := parseWarmupMode(config.GetString("warmup_mode"))
+	if err != nil {
+		return nil, errors.Wrap(err, "app error")
+	}
+	if app.globalMinGasPrice, err = parseGasPrice(config.GetString("min_gas_price")); err != nil {
+		return nil, errors.Wrap(err, "app error: min_gas_price")
+	}
+	if app.gasPriceFloors, err = loadGasPriceFloors(config.GetStringMapString("gas_price_floors")); err != nil {
+		return nil, errors.Wrap(err, "app error")
+	}
+	if coinbase := config.GetString("coinbase"); coinbase != "" {
+		if !common.IsHexAddress(coinbase) {
+			return nil, fmt.Errorf("app error: invalid coinbase %q", coinbase)
+		}
+		app.coinbase = common.HexToAddress(coinbase)
+	}
+	if sender := config.GetString("view_call_sender"); sender != "" {
+		if !common.IsHexAddress(sender) {
+			return nil, fmt.Errorf("app error: invalid view_call_sender %q", sender)
+		}
+		addr := common.HexToAddress(sender)
+		app.viewCallSender = &addr
 	}
 
 	app.AngineHooks = gtypes.Hooks{
@@ -129,54 +330,123 @@ func NewEVMApp(config *viper.Viper) (*EVMApp, error) {
 		OnExecute:  gtypes.NewHook(app.OnExecute),
 	}
 
-	var err error
 	if err = app.BaseApplication.InitBaseApplication(AppName, app.datadir); err != nil {
+		err = diagnoseDatabaseError(filepath.Join(app.datadir, AppName+".db"), err)
 		log.Error("InitBaseApplication error", zap.Error(err))
 		return nil, errors.Wrap(err, "app error")
 	}
 
-	if app.stateDb, err = OpenDatabase(app.datadir, "chaindata", DatabaseCache, DatabaseHandles); err != nil {
+	if config.GetBool("db_recover") {
+		log.Warn("db_recover is set, a corrupted state database will be recovered, possibly dropping data; back the datadir up first")
+	}
+	if app.stateDb, err = openStateBackend(app.datadir, config); err != nil {
 		log.Error("OpenDatabase error", zap.Error(err))
 		return nil, errors.Wrap(err, "app error")
 	}
 
+	app.commitDb = newCountingDatabase(app.stateDb)
+	app.txStatus = newTxStatusTracker(app.stateDb, config.GetInt("tx_status_limit"),
+		time.Duration(config.GetInt("tx_status_retention"))*time.Second)
+	app.senders = newSenderCache(config.GetInt("sender_cache_size"),
+		time.Duration(config.GetInt("sender_cache_idle"))*time.Second)
+	app.receiptsMigrator = newReceiptsMigrator(app.stateDb, config.GetInt("receipts_migration_batch"),
+		config.GetBool("receipts_migration_paused"))
+	app.receiptsRetention = uint64(config.GetInt64("receipts_retention"))
+	app.logDedup = config.GetBool("receipts_dedup_logs")
+	app.logDedupMinSize = config.GetInt("receipts_dedup_min_size")
+	app.loadReceiptsPruned()
+	app.historical = newLimiter(config.GetInt("historical_query_limit"),
+		time.Duration(config.GetInt("historical_query_wait"))*time.Millisecond, errServerBusy)
+	app.simulations = newSimulationCache(config.GetInt("simulation_cache_size"))
+	app.checkTxs = newLimiter(config.GetInt("check_tx_limit"),
+		time.Duration(config.GetInt("check_tx_wait"))*time.Millisecond, ErrCheckTxBusy)
+	app.warmer = newStateWarmer(app.stateDb, warm, warmRecent, config.GetInt("warmup_node_budget"))
+	app.mirror = newMirror(app, time.Duration(config.GetInt("mirror_retry_interval"))*time.Second)
+	app.execGuard = newExecGuard(uint64(config.GetInt64("exec_memory_soft_limit")), uint64(config.GetInt64("exec_memory_hard_limit")), app.datadir)
+	app.poolDrops = newPoolDropStats(config.GetInt("pool_drop_stats_hours"))
 	app.pool = NewEthTxPool(app, config)
 
 	return app, nil
 }
 
-func OpenDatabase(datadir string, name string, cache int, handles int) (ethdb.Database, error) {
-	return ethdb.NewLDBDatabase(filepath.Join(datadir, name), cache, handles)
+// OpenDatabase opens the leveldb database name in datadir, a failure is returned
+// as a *DatabaseError. A corrupted database is only recovered when asked for.
+func OpenDatabase(datadir string, name string, cache int, handles int, recoverCorrupted bool) (ethdb.Database, error) {
+	path := filepath.Join(datadir, name)
+	db, err := ethdb.OpenLDBDatabase(path, cache, handles, recoverCorrupted)
+	if err != nil {
+		return nil, diagnoseDatabaseError(path, err)
+	}
+	return db, nil
 }
 
 func (app *EVMApp) writeGenesis() error {
 	if app.getLastAppHash() != EmptyTrieRoot {
-		return nil
+		return app.checkGenesisRoot()
 	}
 
 	g := core.DefaultGenesis()
 	b := g.ToBlock(app.stateDb)
-	app.SaveLastBlock(LastBlockInfo{Height: 0, AppHash: b.Root().Bytes()})
-	return nil
+	if err := app.stateDb.Put(GenesisRootKey, b.Root().Bytes()); err != nil {
+		return err
+	}
+	if err := app.saveLastBlock(LastBlockInfo{Height: 0, AppHash: b.Root().Bytes()}); err != nil {
+		return err
+	}
+	if err := app.saveReceiptsHashAlgo(); err != nil {
+		return err
+	}
+	if err := app.saveConsensusConfig(); err != nil {
+		return err
+	}
+	return app.saveGenesisHash()
 }
 
 func (app *EVMApp) Start() (err error) {
+	// an unreadable last block must stop the start, not look like a new chain
+	if _, err := app.loadLastBlock(); err != nil {
+		app.Stop()
+		log.Error("fail to load last block", zap.Error(err))
+		return err
+	}
+	if err := app.resumeRollback(); err != nil {
+		app.Stop()
+		log.Error("fail to finish the interrupted rollback", zap.Error(err))
+		return err
+	}
+	if app.Config.GetBool("state_snapshot_load") && app.getLastAppHash() == EmptyTrieRoot {
+		if err := app.loadStateSnapshot(app.stateSnapshotFile()); err != nil {
+			app.Stop()
+			log.Error("load state snapshot err:", zap.Error(err))
+			return err
+		}
+	}
 	if err := app.writeGenesis(); err != nil {
 		app.Stop()
 		log.Error("write genesis err:", zap.Error(err))
 		return err
 	}
-
-	lastBlock := &LastBlockInfo{
-		Height:  0,
-		AppHash: make([]byte, 0),
+	if err := app.loadGenesisHash(); err != nil {
+		app.Stop()
+		log.Error("genesis hash err:", zap.Error(err))
+		return err
+	}
+	if err := app.checkReceiptsHashAlgo(); err != nil {
+		app.Stop()
+		log.Error("receipts hash err:", zap.Error(err))
+		return err
 	}
-	if res, err := app.LoadLastBlock(lastBlock); err == nil && res != nil {
-		lastBlock = res.(*LastBlockInfo)
+	if err := app.checkConsensusConfig(); err != nil {
+		app.Stop()
+		log.Error("consensus config err:", zap.Error(err))
+		return err
 	}
+
+	lastBlock, err := app.loadLastBlock()
 	if err != nil {
+		app.Stop()
 		log.Error("fail to load last block", zap.Error(err))
-		return
+		return err
 	}
 
 	// Load evm state when starting
@@ -184,23 +454,52 @@ func (app *EVMApp) Start() (err error) {
 	if len(lastBlock.AppHash) > 0 {
 		trieRoot = common.BytesToHash(lastBlock.AppHash)
 	}
+	atomic.StoreInt64(&app.committedHeight, lastBlock.Height)
+	app.simulations.reset(uint64(lastBlock.Height))
 	app.pool.Start(lastBlock.Height)
 	if app.state, err = estate.New(trieRoot, estate.NewDatabase(app.stateDb)); err != nil {
 		app.Stop()
 		log.Error("fail to new state", zap.Error(err))
 		return
 	}
+	app.committedHeader, app.committedRoot = app.startHeader(lastBlock.Height), trieRoot
+	if err = app.loadContractCount(uint64(lastBlock.Height), trieRoot); err != nil {
+		app.Stop()
+		log.Error("fail to load contract count", zap.Error(err))
+		return
+	}
+	if err = app.loadConfigEpochs(); err != nil {
+		app.Stop()
+		log.Error("fail to load config epochs", zap.Error(err))
+		return
+	}
+	app.receiptsMigrator.Start()
+	app.warmer.Start(trieRoot, uint64(lastBlock.Height))
+	app.mirror.Start()
+
+	if laddr := app.Config.GetString("http_query_laddr"); laddr != "" {
+		if err = app.startHTTPQuery(laddr); err != nil {
+			app.Stop()
+			log.Error("fail to start http query server", zap.Error(err))
+			return
+		}
+	}
+	if laddr := app.Config.GetString("tx_import_laddr"); laddr != "" {
+		if err = app.startTxImport(laddr, app.Config.GetString("tx_import_token")); err != nil {
+			app.Stop()
+			log.Error("fail to start tx import server", zap.Error(err))
+			return
+		}
+	}
 
 	return nil
 }
 
 func (app *EVMApp) getLastAppHash() common.Hash {
-	lastBlock := &LastBlockInfo{
-		Height:  0,
-		AppHash: make([]byte, 0),
-	}
-	if res, err := app.LoadLastBlock(lastBlock); err == nil && res != nil {
-		lastBlock = res.(*LastBlockInfo)
+	lastBlock, err := app.loadLastBlock()
+	if err != nil {
+		log.Error("fail to load last block", zap.Error(err))
+		return EmptyTrieRoot
 	}
 	if len(lastBlock.AppHash) > 0 {
 		return common.BytesToHash(lastBlock.AppHash)
@@ -213,6 +512,20 @@ func (app *EVMApp) GetTxPool() gtypes.TxPool {
 }
 
 func (app *EVMApp) Stop() {
+	if app.httpQuery != nil {
+		app.httpQuery.Close()
+	}
+	if app.txImport != nil {
+		app.txImport.Close()
+	}
+	app.receiptsMigrator.Stop()
+	app.warmer.Stop()
+	app.mirror.Stop()
+	if app.Config.GetBool("state_snapshot_on_stop") && app.state != nil {
+		if err := app.writeStateSnapshot(app.stateSnapshotFile()); err != nil {
+			log.Error("write state snapshot err:", zap.Error(err))
+		}
+	}
 	app.BaseApplication.Stop()
 	app.stateDb.Close()
 }
@@ -233,16 +546,105 @@ func (app *EVMApp) OnPrevote(height, round int64, block *gtypes.Block) (interfac
 	return nil, nil
 }
 
-func (app *EVMApp) genExecFun(block *gtypes.Block, res *gtypes.ExecuteResult) BeginExecFunc {
+// blockExecution is a block executed on a state, none of it committed: the state
+// and header it ran with, its result, receipts and the contracts it created.
+type blockExecution struct {
+	state           *estate.StateDB
+	header          *etypes.Header
+	res             gtypes.ExecuteResult
+	receipts        etypes.Receipts
+	receiptEnvs     []*receiptEnvelope
+	creations       []*contractCreation
+	blockCreations  uint64
+	blockGas        uint64
+	orderViolations int
+	appMessages     [][]byte
+	// txs deferred for a nonce gap, and while retrying them their positions in
+	// the block txs by the index of the retry pass
+	deferred  []deferredTx
+	positions []int
+}
+
+// executeBlock executes block on state with the fees going to coinbase, state is
+// all it changes: OnExecute keeps the execution for the commit, SimulateBlock
+// throws it away. guard watches the memory the execution takes, nil for none.
+func (app *EVMApp) executeBlock(state *estate.StateDB, block *gtypes.Block, coinbase common.Address, guard *execGuard) (*blockExecution, error) {
+	exec := &blockExecution{state: state, header: BuildEVMHeader(block.Header, EVMHeaderOptions{Coinbase: coinbase})}
+	height := block.Header.Height
+	inbound := app.deliverAppMessages(exec, block)
+	if err := app.checkTxOrderPolicy(block.Data.Txs); err != nil {
+		log.Warn("[evm execute] block rejected", zap.Int64("height", height), zap.Error(err))
+		rejectBlockTxs(block.Data.Txs, &exec.res, err)
+	} else {
+		txs, violations := app.orderBlockTxs(height, block.Data.Txs)
+		exec.orderViolations = violations
+		if guard != nil {
+			guard.begin(height)
+		}
+		beginExec := app.genExecFun(block, exec, guard)
+		exeWithCPUParallelVeirfy(app.Signer, txs, nil, beginExec)
+		app.execDeferred(exec, beginExec)
+		if guard != nil {
+			guard.end()
+		}
+	}
+	app.recordMisbehavior(state, block)
+	app.collectAppMessages(exec, inbound)
+	if err := app.postExecute.run(state, exec.header); err != nil {
+		return nil, err
+	}
+	return exec, nil
+}
+
+func (app *EVMApp) genExecFun(block *gtypes.Block, exec *blockExecution, guard *execGuard) BeginExecFunc {
 	blockHash := common.BytesToHash(block.Hash())
-	app.currentHeader = makeCurrentHeader(block, block.Header)
+	res := &exec.res
+	// log data of the valid txs executed so far, for the per block log data cap
+	var blockLogData uint64
+	// txs executed so far by each sender, for the per sender tx limit
+	senderTxs := make(map[common.Address]uint64)
 
 	return func() (ExecFunc, EndExecFunc) {
-		state := app.currentState
+		state := exec.state
 		stateSnapshot := state.Snapshot()
 		temReceipt := make([]*etypes.Receipt, 0)
+		temEnvs := make([]*receiptEnvelope, 0)
+		var temCreation *contractCreation
+		var temLogData, temCreations, temGas uint64
+		var pos int
 
 		execFunc := func(txIndex int, raw []byte, tx *etypes.Transaction) error {
+			if exec.positions != nil {
+				txIndex = exec.positions[txIndex]
+			}
+			pos = txIndex
+			if activeAt(app.txBoundsHeight, block.Height) {
+				if err := app.checkTxBounds(tx); err != nil {
+					return err
+				}
+			}
+			if activeAt(app.gasLimitHeight, block.Height) {
+				if err := checkGasLimit(tx); err != nil {
+					return err
+				}
+			}
+			if activeAt(app.zeroAddressHeight, block.Height) {
+				if err := checkZeroAddress(tx); err != nil {
+					return err
+				}
+			}
+			if err := app.checkNonceGap(state, tx); err != nil {
+				return err
+			}
+			if err := app.countSenderTx(senderTxs, tx); err != nil {
+				return err
+			}
+			if err := app.checkCreationsLimit(exec.blockCreations+temCreations, tx); err != nil {
+				return err
+			}
+			if err := app.checkBlockGas(exec.blockGas+temGas, tx); err != nil {
+				return err
+			}
 			gp := new(core.GasPool).AddGas(math.MaxBig256.Uint64())
 
 			txBytes, err := rlp.EncodeToBytes(tx)
@@ -252,34 +654,67 @@ func (app *EVMApp) genExecFun(block *gtypes.Block, res *gtypes.ExecuteResult) Be
 			txhash := gtypes.Tx(txBytes).Hash()
 			state.Prepare(common.BytesToHash(txhash), blockHash, txIndex)
 
+			// contract creation addresses are crypto.CreateAddress(sender, tx nonce): the state
+			// transition rejects txs whose nonce differs from the sender's nonce in state, so every
+			// node executing the block derives the same address.
 			bc := NewBlockChain(app.stateDb)
+			vmConfig := evmConfig
+			vmConfig.BlockLogData = blockLogData + temLogData
+			vmConfig.BlockCreations = exec.blockCreations + temCreations
+			var memoryPeak, creations uint64
+			vmConfig.MemoryPeak, vmConfig.Creations = &memoryPeak, &creations
 			receipt, _, err := core.ApplyTransaction(
 				app.chainConfig,
 				bc,
-				nil, // coinbase ,maybe use local account
+				nil, // fees go to the header's coinbase
 				gp,
 				state,
-				app.currentHeader,
+				exec.header,
 				tx,
 				new(uint64),
-				evmConfig)
+				vmConfig)
+			if guard != nil {
+				guard.tx(txIndex, common.BytesToHash(txhash), memoryPeak)
+			}
 
 			if err != nil {
 				return err
 			}
+			app.burnZeroAddressValue(state, block.Height, tx, receipt)
+			if temCreation, err = app.txContractCreation(tx, receipt); err != nil {
+				return err
+			}
+			temLogData += logDataSize(receipt.Logs)
+			temCreations += creations
+			temGas += receipt.GasUsed
 			temReceipt = append(temReceipt, receipt)
+			temEnvs = append(temEnvs, newReceiptEnvelope(receipt, tx.Gas(), tx.GasPrice(), uint64(block.Height), blockHash, txIndex))
 			return nil
 		}
 
 		endFunc := func(raw []byte, err error) bool {
+			if err == ErrNonceGap && app.nonceGap == nonceGapDefer {
+				state.RevertToSnapshot(stateSnapshot)
+				temReceipt, temEnvs, temCreation = nil, nil, nil
+				exec.deferred = append(exec.deferred, deferredTx{pos: pos, raw: raw})
+				return true
+			}
 			if err != nil {
 				log.Warn("[evm execute],apply transaction", zap.Error(err))
 				state.RevertToSnapshot(stateSnapshot)
-				temReceipt = nil
+				temReceipt, temEnvs, temCreation = nil, nil, nil
 				res.InvalidTxs = append(res.InvalidTxs, gtypes.ExecuteInvalidTx{Bytes: raw, Error: err})
 				return true
 			}
-			app.receipts = append(app.receipts, temReceipt...)
+			exec.receipts = append(exec.receipts, temReceipt...)
+			exec.receiptEnvs = append(exec.receiptEnvs, temEnvs...)
+			if temCreation != nil {
+				exec.creations = append(exec.creations, temCreation)
+				temCreation = nil
+			}
+			blockLogData += temLogData
+			exec.blockCreations += temCreations
+			exec.blockGas += temGas
 			res.ValidTxs = append(res.ValidTxs, raw)
 			return true
 		}
@@ -287,26 +722,23 @@ func (app *EVMApp) genExecFun(block *gtypes.Block, res *gtypes.ExecuteResult) Be
 	}
 }
 
-func makeCurrentHeader(block *gtypes.Block, header *gtypes.Header) *etypes.Header {
-	return &etypes.Header{
-		ParentHash: common.BytesToHash(block.Header.LastBlockID.Hash),
-		Difficulty: big.NewInt(0),
-		GasLimit:   math.MaxBig256.Uint64(),
-		Time:       big.NewInt(block.Header.Time.Unix()),
-		Number:     big.NewInt(header.Height),
-	}
-}
-
 func (app *EVMApp) OnExecute(height, round int64, block *gtypes.Block) (interface{}, error) {
-	var (
-		res gtypes.ExecuteResult
-		err error
-	)
-
-	if app.currentState, err = estate.New(app.getLastAppHash(), estate.NewDatabase(app.stateDb)); err != nil {
+	app.blockMtx.Lock()
+	defer app.blockMtx.Unlock()
+	atomic.StoreInt32(&app.blockInFlight, 1)
+	state, err := estate.New(app.getLastAppHash(), estate.NewDatabase(app.commitDb))
+	if err != nil {
 		return nil, errors.Wrap(err, "create StateDB failed")
 	}
-	exeWithCPUParallelVeirfy(app.Signer, block.Data.Txs, nil, app.genExecFun(block, &res))
+	exec, err := app.executeBlock(state, block, app.coinbase, app.execGuard)
+	if err != nil {
+		return nil, err
+	}
+	// a block may be executed again after a failed round, the previous run is dropped
+	app.currentState, app.currentHeader = exec.state, exec.header
+	app.receipts, app.receiptEnvs, app.creations = exec.receipts, exec.receiptEnvs, exec.creations
+	app.blockCreations, app.txOrderViolations = exec.blockCreations, exec.orderViolations
+	app.appMessages = exec.appMessages
 
 	m := make(map[string]int)
 	for _, tx := range block.Data.Txs {
@@ -318,51 +750,161 @@ func (app *EVMApp) OnExecute(height, round int64, block *gtypes.Block) (interfac
 			dups++
 		}
 	}
-	return res, err
+	return exec.res, nil
 }
 
 // OnCommit run in a sync way, we don't need to lock stateDupMtx, but stateMtx is still needed
 func (app *EVMApp) OnCommit(height, round int64, block *gtypes.Block) (interface{}, error) {
-	appHash, err := app.currentState.Commit(true)
-	if err != nil {
-		return nil, err
-	}
+	app.blockMtx.Lock()
+	defer app.blockMtx.Unlock()
+	defer atomic.StoreInt32(&app.blockInFlight, 0)
+	res, err := app.commitBlock(height, block)
+	app.countCommit(height, err)
+	return res, err
+}
 
-	if err := app.currentState.Database().TrieDB().Commit(appHash, false); err != nil {
-		return nil, err
-	}
+// commitBlock commits the block at height OnExecute executed
+func (app *EVMApp) commitBlock(height int64, block *gtypes.Block) (interface{}, error) {
+	prevAppHash := app.getLastAppHash()
+	touched := app.currentState.DirtyAccounts()
+	destroyed := app.currentState.SuicidedAccounts()
+	recreated := app.recreatedContracts(touched)
+	contractDelta := app.contractCountDelta(touched)
+	idle := app.idleCommit(app.receipts, touched, destroyed)
+
+	stats := rtypes.CommitStats{Height: uint64(height), Creations: app.blockCreations}
+	appHash := prevAppHash
+	state := app.state // an idle block leaves the committed state as is
+	if !idle {
+		var err error
+		if appHash, err = app.currentState.Commit(app.chainConfig.MinAccountBalance == nil); err != nil {
+			return nil, err
+		}
+		app.commitDb.reset()
+		start := time.Now()
+		if err := app.currentState.Database().TrieDB().Commit(appHash, false); err != nil {
+			return nil, err
+		}
+		stats.TrieDuration = uint64(time.Since(start))
+		stats.TrieNodes, stats.TrieBytes = app.commitDb.reset()
 
-	app.stateMtx.Lock()
-	if app.state, err = estate.New(appHash, estate.NewDatabase(app.stateDb)); err != nil {
-		app.stateMtx.Unlock()
-		return nil, errors.Wrap(err, "create StateDB failed")
+		if state, err = estate.New(appHash, estate.NewDatabase(app.stateDb)); err != nil {
+			return nil, errors.Wrap(err, "create StateDB failed")
+		}
+	} else {
+		idleCommitsCounter.Inc(1)
 	}
+	// the receipts hash of a block without receipts is nil either way, and a
+	// failed receipts write fails the commit before the block is recorded
+	var rHash []byte
+	if !idle {
+		start := time.Now()
+		var err error
+		if rHash, err = app.SaveReceipts(); err != nil {
+			return nil, err
+		}
+		stats.ReceiptDuration = uint64(time.Since(start))
+		_, stats.ReceiptBytes = app.commitDb.reset()
+	}
+	app.stateMtx.Lock()
+	app.state, app.committedHeader, app.committedRoot = state, app.currentHeader, appHash
 	app.stateMtx.Unlock()
 
-	app.SaveLastBlock(LastBlockInfo{Height: height, AppHash: appHash.Bytes()})
-
-	rHash, err := app.SaveReceipts()
-	if err != nil {
-		log.Error("application save receipts", zap.Error(err), zap.Int64("height", block.Height))
+	if err := app.saveContractCount(uint64(height), contractDelta); err != nil {
+		return nil, err
+	}
+	if err := app.saveConfigEpoch(uint64(height)); err != nil {
+		return nil, err
+	}
+	if err := app.saveLastBlock(LastBlockInfo{Height: height, AppHash: appHash.Bytes()}); err != nil {
+		return nil, err
 	}
+	atomic.StoreInt64(&app.committedHeight, height)
+	app.simulations.reset(uint64(height))
 
-	app.receipts = nil
+	app.recordCommitStats(stats)
+	if err := app.saveTouchedAccounts(uint64(height), touched); err != nil {
+		log.Error("application save touched accounts", zap.Error(err), zap.Int64("height", block.Height))
+	}
+	if err := app.saveDestroyedContracts(uint64(height), destroyed, recreated); err != nil {
+		log.Error("application save destroyed contracts", zap.Error(err), zap.Int64("height", block.Height))
+	}
+	if err := app.saveContractCreators(app.creations); err != nil {
+		log.Error("application save contract creators", zap.Error(err), zap.Int64("height", block.Height))
+	}
+	if err := app.saveTxRoot(block); err != nil {
+		log.Error("application save tx root", zap.Error(err), zap.Int64("height", block.Height))
+	}
+	if err := app.saveBlockSummary(block, appHash); err != nil {
+		log.Error("application save block summary", zap.Error(err), zap.Int64("height", block.Height))
+	}
+	if err := app.saveAppMessages(uint64(height)); err != nil {
+		log.Error("application save app messages", zap.Error(err), zap.Int64("height", block.Height))
+	}
+	var lightHeaderHash []byte
+	if hash, err := app.saveLightHeader(block, prevAppHash, appHash, rHash); err != nil {
+		log.Error("application save light header", zap.Error(err), zap.Int64("height", block.Height))
+	} else {
+		lightHeaderHash = hash.Bytes()
+	}
+	for _, receipt := range app.receipts {
+		app.txStatus.committed(receipt.TxHash, uint64(height))
+	}
+	app.receipts, app.receiptEnvs, app.creations, app.appMessages = nil, nil, nil, nil
+	if !app.catchingUp(height) {
+		app.mirror.committed()
+	}
 	app.pool.updateToState()
-	log.Info("application save to db", zap.String("appHash", fmt.Sprintf("%X", appHash.Bytes())), zap.String("receiptHash", fmt.Sprintf("%X", rHash)))
+	log.Info("application save to db", zap.Bool("idle", idle), zap.String("appHash", fmt.Sprintf("%X", appHash.Bytes())), zap.String("receiptHash", fmt.Sprintf("%X", rHash)),
+		zap.Uint64("trieNodes", stats.TrieNodes), zap.Uint64("trieBytes", stats.TrieBytes), zap.Uint64("receiptBytes", stats.ReceiptBytes), zap.Uint64("creations", stats.Creations),
+		zap.Duration("trieCommit", time.Duration(stats.TrieDuration)), zap.Duration("receiptsCommit", time.Duration(stats.ReceiptDuration)))
 
 	return gtypes.CommitResult{
-		AppHash:      appHash.Bytes(),
-		ReceiptsHash: rHash,
+		AppHash:         appHash.Bytes(),
+		ReceiptsHash:    rHash,
+		LightHeaderHash: lightHeaderHash,
 	}, nil
 }
 
 func (app *EVMApp) CheckTx(bs []byte) error {
+	// the slot is taken before the state lock and released after it, so a full
+	// limiter never waits on the lock holder
+	if err := app.checkTxs.acquire(); err != nil {
+		return err
+	}
+	defer app.checkTxs.release()
+
 	tx := &etypes.Transaction{}
 	err := rlp.DecodeBytes(bs, tx)
 	if err != nil {
 		return err
 	}
-	from, _ := etypes.Sender(app.Signer, tx)
+	if err := app.checkTxBounds(tx); err != nil {
+		return err
+	}
+	// reject oversized payloads before they are broadcast to other nodes
+	if app.maxTxDataSize > 0 && len(tx.Data()) > app.maxTxDataSize {
+		return fmt.Errorf("tx data too large: %d bytes, limit %d", len(tx.Data()), app.maxTxDataSize)
+	}
+	if floor := app.minGasPrice(tx.To()); tx.GasPrice().Cmp(floor) < 0 {
+		app.poolDrops.record(rtypes.PoolDrop_Underpriced)
+		return fmt.Errorf("gas price %v below the minimum %v", tx.GasPrice(), floor)
+	}
+	if err := checkGasLimit(tx); err != nil {
+		return err
+	}
+	if err := app.checkBlockGas(0, tx); err != nil {
+		return err
+	}
+	if err := checkZeroAddress(tx); err != nil {
+		return err
+	}
+	from, err := app.senders.sender(app.Signer, tx)
+	if app.checkTxSignature {
+		if err := verifyTxSignature(tx, from, err); err != nil {
+			return err
+		}
+	}
 
 	app.stateMtx.Lock()
 	defer app.stateMtx.Unlock()
@@ -370,6 +912,7 @@ func (app *EVMApp) CheckTx(bs []byte) error {
 	nonce := tx.Nonce()
 	getNonce := app.state.GetNonce(from)
 	if getNonce > nonce {
+		app.poolDrops.record(rtypes.PoolDrop_StaleNonce)
 		txhash := gtypes.Tx(bs).Hash()
 		return fmt.Errorf("nonce(%d) different with getNonce(%d), transaction already exists %v", nonce, getNonce, hex.EncodeToString(txhash))
 	}
@@ -382,48 +925,77 @@ func (app *EVMApp) CheckTx(bs []byte) error {
 }
 
 func (app *EVMApp) SaveReceipts() ([]byte, error) {
-	savedReceipts := make([][]byte, 0, len(app.receipts))
-	receiptBatch := app.stateDb.NewBatch()
-
-	for _, receipt := range app.receipts {
-		storageReceipt := (*etypes.ReceiptForStorage)(receipt)
-		storageReceiptBytes, err := rlp.EncodeToBytes(storageReceipt)
+	txHashes := make([]common.Hash, 0, len(app.receipts))
+	receiptBatch := app.commitDb.NewBatch()
+	logData := make(logDataRefs)
+
+	for i, receipt := range app.receipts {
+		// the receipts hash stays over the legacy encoding of the inflated
+		// receipts, only the stored format changes
+		envBytes, err := encodeReceiptEnvelope(app.dedupLogData(app.receiptEnvs[i], logData))
 		if err != nil {
 			return nil, fmt.Errorf("wrong rlp encode:%v", err.Error())
 		}
 
-		key := append(ReceiptsPrefix, receipt.TxHash.Bytes()...)
-		if err := receiptBatch.Put(key, storageReceiptBytes); err != nil {
+		if err := receiptBatch.Put(receiptKey(receipt.TxHash), envBytes); err != nil {
 			return nil, fmt.Errorf("batch receipt failed:%v", err.Error())
 		}
-		savedReceipts = append(savedReceipts, storageReceiptBytes)
+		txHashes = append(txHashes, receipt.TxHash)
+	}
+	if len(txHashes) > 0 {
+		index, err := rlp.EncodeToBytes(txHashes)
+		if err != nil {
+			return nil, fmt.Errorf("wrong rlp encode:%v", err.Error())
+		}
+		if err := receiptBatch.Put(blockReceiptsKey(app.currentHeader.Number.Uint64()), index); err != nil {
+			return nil, fmt.Errorf("batch receipts index failed:%v", err.Error())
+		}
+	}
+	pruned, err := app.pruneReceipts(receiptBatch, app.currentHeader.Number.Uint64(), logData)
+	if err != nil {
+		return nil, fmt.Errorf("prune receipts failed:%v", err.Error())
+	}
+	if err := app.writeLogDataRefs(receiptBatch, logData); err != nil {
+		return nil, fmt.Errorf("batch log data failed:%v", err.Error())
 	}
 	if err := receiptBatch.Write(); err != nil {
 		return nil, fmt.Errorf("persist receipts failed:%v", err.Error())
 	}
-	rHash := merkle.SimpleHashFromHashes(savedReceipts)
+	atomic.StoreUint64(&app.receiptsPruned, pruned)
+	rHash, err := receiptsHash(app.chainConfig.ReceiptsHash, app.receipts)
+	if err != nil {
+		return nil, fmt.Errorf("hash receipts failed:%v", err.Error())
+	}
 	return rHash, nil
 }
 
 func (app *EVMApp) Info() (resInfo gtypes.ResultInfo) {
-	lb := &LastBlockInfo{
-		AppHash: make([]byte, 0),
-		Height:  0,
-	}
-	if res, err := app.LoadLastBlock(lb); err == nil {
-		lb = res.(*LastBlockInfo)
+	lb, err := app.loadLastBlock()
+	if err != nil {
+		log.Error("fail to load last block", zap.Error(err))
 	}
 
 	resInfo.LastBlockAppHash = lb.AppHash
 	resInfo.LastBlockHeight = lb.Height
 	resInfo.Version = "alpha 0.2"
 	resInfo.Data = "default app with evm-1.5.9"
+	resInfo.GenesisHash = app.genesisHash.Bytes()
 	return
 }
 
 func (app *EVMApp) Query(query []byte) (res gtypes.Result) {
 	action := query[0]
 	load := query[1:]
+	if msg := app.syncingQuery(action); msg != "" {
+		if app.syncingQueries == syncingQueriesRefuse {
+			return gtypes.NewError(gtypes.CodeType_Syncing, msg)
+		}
+		defer func() {
+			if res.IsOK() {
+				res = res.SetLog(msg)
+			}
+		}()
+	}
 	switch action {
 	case rtypes.QueryType_Contract:
 		res = app.queryContract(load, 0)
@@ -433,8 +1005,77 @@ func (app *EVMApp) Query(query []byte) (res gtypes.Result) {
 		}
 		h := binary.BigEndian.Uint64(load[len(load)-8:])
 		res = app.queryContract(load[:len(load)-8], h)
+	case rtypes.QueryType_ContractDepthCapped:
+		res = app.queryContractDepthCapped(load)
+	case rtypes.QueryType_Call:
+		res = app.queryCall(load)
+	case rtypes.QueryType_ViewCall:
+		res = app.queryViewCall(load)
 	case rtypes.QueryType_Nonce:
 		res = app.queryNonce(load)
+	case rtypes.QueryType_CodeSize:
+		res = app.queryCodeSize(load)
+	case rtypes.QueryType_ContractCount:
+		res = app.queryContractCount()
+	case rtypes.QueryType_Account:
+		res = app.queryAccount(load)
+	case rtypes.QueryType_AccountAtRoot:
+		res = app.queryAccountAtRoot(load)
+	case rtypes.QueryType_ConfigAtHeight:
+		res = app.queryConfigAtHeight(load)
+	case rtypes.QueryType_RawTx:
+		res = app.queryRawTx(load)
+	case rtypes.QueryType_Page:
+		res = app.queryPage(load)
+	case rtypes.QueryType_TxResult:
+		res = app.queryTxResult(load)
+	case rtypes.QueryType_SimulateBlock:
+		res = app.querySimulateBlock(load)
+	case rtypes.QueryType_EVMHeader:
+		res = app.queryEVMHeader(load)
+	case rtypes.QueryType_RecentBlocks:
+		res = app.queryRecentBlocks(load)
+	case rtypes.QueryType_AppMessages:
+		res = app.queryAppMessages(load)
+	case rtypes.QueryType_StorageMulti:
+		res = app.queryStorageMulti(load)
+	case rtypes.QueryType_ReceiptProof:
+		res = app.queryReceiptProof(load)
+	case rtypes.QueryType_StorageDiff:
+		res = app.queryStorageDiff(load)
+	case rtypes.QueryType_PoolDropStats:
+		res = app.queryPoolDropStats(load)
+	case rtypes.QueryType_GasOverRange:
+		res = app.queryGasOverRange(load)
+	case rtypes.QueryType_GenesisHash:
+		res = app.queryGenesisHash()
+	case rtypes.QueryType_TxRoot:
+		res = app.queryTxRoot(load)
+	case rtypes.QueryType_PredictAddress:
+		res = app.queryPredictAddress(load)
+	case rtypes.QueryType_VerifySignature:
+		res = app.queryVerifySignature(load)
+	case rtypes.QueryType_RulesAt:
+		res = app.queryRulesAt(load)
+	case rtypes.QueryType_PendingBySender, rtypes.QueryType_Misbehavior, rtypes.QueryType_BlockTouchedAccounts, rtypes.QueryType_CommitStats,
+		rtypes.QueryType_Logs:
+		res = app.queryList(action, load)
+	case rtypes.QueryType_BalancesBatch:
+		res = app.queryBalancesBatch(load)
+	case rtypes.QueryType_TxStatus:
+		res = app.queryTxStatus(load)
+	case rtypes.QueryType_DecodeTx:
+		res = app.queryDecodeTx(load)
+	case rtypes.QueryType_DecodeInput:
+		res = app.queryDecodeInput(load)
+	case rtypes.QueryType_SyncStatus:
+		res = app.querySyncStatus()
+	case rtypes.QueryType_IsDestroyed:
+		res = app.queryIsDestroyed(load)
+	case rtypes.QueryType_StateDiff:
+		res = app.queryStateDiff(load)
+	case rtypes.QueryType_LightHeader:
+		res = app.queryLightHeader(load)
 	case rtypes.QueryType_Receipt:
 		res = app.queryReceipt(load)
 	case rtypes.QueryType_Existence:
@@ -451,6 +1092,9 @@ func (app *EVMApp) Query(query []byte) (res gtypes.Result) {
 	return res
 }
 
+// queryContractExistence answers 0x01 when the address the rlp encoded tx is sent
+// to holds code whose hash is the tx data, followed by the contract creator when
+// known, and 0x00 otherwise. See rtypes.CodeHashMatches for the hash comparison.
 func (app *EVMApp) queryContractExistence(load []byte) gtypes.Result {
 	tx := new(etypes.Transaction)
 	err := rlp.DecodeBytes(load, tx)
@@ -463,103 +1107,233 @@ func (app *EVMApp) queryContractExistence(load []byte) gtypes.Result {
 	hashBytes := app.state.GetCodeHash(*contractAddr).Bytes()
 	app.stateMtx.Unlock()
 
-	if bytes.Equal(tx.Data(), hashBytes) {
+	// the code hash is compared normalized, so the representations of "no code"
+	// can't make an account without code exist
+	if rtypes.HasCode(hashBytes) && rtypes.CodeHashMatches(tx.Data(), hashBytes) {
+		// the creator follows the existence byte when it's known
+		if creator, ok := app.ContractCreator(*contractAddr); ok {
+			return gtypes.NewResultOK(append([]byte{0x01}, creator.Bytes()...), "contract exists, created by "+creator.Hex())
+		}
 		return gtypes.NewResultOK(append([]byte{}, byte(0x01)), "contract exists")
 	}
 	return gtypes.NewResultOK(append([]byte{}, byte(0x00)), "constract doesn't exist")
 }
 
 func (app *EVMApp) queryContract(load []byte, height uint64) gtypes.Result {
-	tx := new(etypes.Transaction)
-	err := rlp.DecodeBytes(load, tx)
+	txMsg, err := app.queryTxMessage(load)
 	if err != nil {
 		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
 	}
+	return app.simulateQuery(txMsg, height)
+}
 
-	from, err := app.Signer.Sender(tx)
-	if err != nil {
+func (app *EVMApp) simulateResult(res []byte, err error) gtypes.Result {
+	if err == errServerBusy {
+		return gtypes.NewError(gtypes.CodeType_ServerBusy, err.Error())
+	} else if err != nil {
 		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
 	}
-	txMsg := etypes.NewMessage(from, tx.To(), 0, tx.Value(), tx.Gas(), tx.GasPrice(), tx.Data(), false)
+	return gtypes.NewResultOK(res, "")
+}
 
+// simulateContract applies txMsg to a copy of the state at height, 0 for the
+// latest committed state, and returns the evm output. Errors of the message pre-checks,
+// eg. a wrong nonce or missing funds, are returned, evm failures aren't. Calls
+// nest up to simulation_call_depth, unless vmConfig sets its own max depth.
+func (app *EVMApp) simulateContract(txMsg etypes.Message, height uint64, vmConfig vm.Config) ([]byte, error) {
 	bc := NewBlockChain(app.stateDb)
+	if vmConfig.MaxCallDepth == 0 {
+		vmConfig.MaxCallDepth = app.simulationCallDepth
+	}
 
 	var vmEnv *vm.EVM
 
 	if height == 0 {
 
-		envCxt := core.NewEVMContext(txMsg, app.currentHeader, bc, nil)
-
 		app.stateMtx.Lock()
-		vmEnv = vm.NewEVM(envCxt, app.state.Copy(), app.chainConfig, evmConfig)
+		envCxt := core.NewEVMContext(txMsg, app.committedHeader, bc, nil)
+		vmEnv = vm.NewEVM(envCxt, app.state.Copy(), app.chainConfig, vmConfig)
 		app.stateMtx.Unlock()
 	} else {
-		//appHash save in next block AppHash
-		height++
-		blockMeta, err := app.core.GetBlockMeta(int64(height))
+		if err := app.historical.acquire(); err != nil {
+			return nil, err
+		}
+		defer app.historical.release()
+		state, header, err := app.historicalState(height)
 		if err != nil {
-			return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+			return nil, err
 		}
-		ethHeader := makeETHHeader(blockMeta.Header)
-		envCxt := core.NewEVMContext(txMsg, ethHeader, bc, nil)
+		envCxt := core.NewEVMContext(txMsg, BuildEVMHeader(header, EVMHeaderOptions{Coinbase: app.coinbase}), bc, nil)
+		vmEnv = vm.NewEVM(envCxt, state, app.chainConfig, vmConfig)
+	}
 
-		trieRoot := EmptyTrieRoot
-		if len(blockMeta.Header.AppHash) > 0 {
-			trieRoot = common.BytesToHash(blockMeta.Header.AppHash)
-		}
+	gpl := new(core.GasPool).AddGas(math.MaxBig256.Uint64())
+	res, _, _, err := core.ApplyMessage(vmEnv, txMsg, gpl) // we don't care about gasUsed
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (app *EVMApp) queryNonce(addrBytes []byte) gtypes.Result {
+	if len(addrBytes) != 20 {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid address")
+	}
+	nonce, err := app.GetNonce(common.BytesToAddress(addrBytes), 0)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+
+	data, err := rlp.EncodeToBytes(nonce)
+	if err != nil {
+		log.Warn("query error", zap.Error(err))
+	}
+	return gtypes.NewResultOK(data, "")
+}
 
-		state, err := estate.New(trieRoot, estate.NewDatabase(app.stateDb))
+// queryCodeSize returns the rlp encoded code size of the 20 bytes address, on the
+// latest state or, when followed by an 8 bytes big endian height, on the state
+// committed at that height.
+func (app *EVMApp) queryCodeSize(load []byte) gtypes.Result {
+	if len(load) != common.AddressLength && len(load) != common.AddressLength+8 {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid address")
+	}
+	addr := common.BytesToAddress(load[:common.AddressLength])
+
+	var size int
+	if len(load) == common.AddressLength {
+		app.stateMtx.Lock()
+		size = app.state.GetCodeSize(addr)
+		app.stateMtx.Unlock()
+	} else {
+		if err := app.historical.acquire(); err != nil {
+			return gtypes.NewError(gtypes.CodeType_ServerBusy, err.Error())
+		}
+		defer app.historical.release()
+		state, _, err := app.historicalState(binary.BigEndian.Uint64(load[common.AddressLength:]))
 		if err != nil {
 			return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
 		}
-		vmEnv = vm.NewEVM(envCxt, state, app.chainConfig, evmConfig)
+		size = state.GetCodeSize(addr)
 	}
 
-	gpl := new(core.GasPool).AddGas(math.MaxBig256.Uint64())
-	res, _, _, err := core.ApplyMessage(vmEnv, txMsg, gpl) // we don't care about gasUsed
+	data, err := rlp.EncodeToBytes(uint64(size))
 	if err != nil {
-		log.Warn("query apply msg err", zap.Error(err))
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
 	}
-
-	return gtypes.NewResultOK(res, "")
+	return gtypes.NewResultOK(data, "")
 }
 
-func makeETHHeader(header *gtypes.Header) *etypes.Header {
-	return &etypes.Header{
-		ParentHash: common.BytesToHash(header.LastBlockID.Hash),
-		Difficulty: big.NewInt(0),
-		GasLimit:   math.MaxBig256.Uint64(),
-		Time:       big.NewInt(header.Time.Unix()),
-		Number:     big.NewInt(header.Height),
+// listPendingBySender lists the rtypes.PoolTx of the txs of a 20 bytes address
+// in the tx pool, the token is the index of a tx in the list.
+func (app *EVMApp) listPendingBySender(load, token []byte, limit int) ([]listItem, []byte, error) {
+	if len(load) != common.AddressLength {
+		return nil, nil, errors.New("Invalid address")
 	}
+	txs := app.pool.SenderTxs(common.BytesToAddress(load))
+	return indexedList(uint64(len(txs)), token, limit, func(i uint64) interface{} { return txs[i] })
 }
 
-func (app *EVMApp) queryNonce(addrBytes []byte) gtypes.Result {
-	if len(addrBytes) != 20 {
-		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid address")
+// queryBalancesBatch takes a rlp encoded address list and returns the rlp encoded balances in the same order.
+func (app *EVMApp) queryBalancesBatch(load []byte) gtypes.Result {
+	var addrs []common.Address
+	if err := rlp.DecodeBytes(load, &addrs); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	if len(addrs) > app.balancesBatchLimit {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, fmt.Sprintf("too many addresses, limit is %d", app.balancesBatchLimit))
 	}
-	addr := common.BytesToAddress(addrBytes)
 
+	balances := make([]*big.Int, len(addrs))
 	app.stateMtx.Lock()
-	nonce := app.state.GetNonce(addr)
+	for i, addr := range addrs {
+		balances[i] = app.state.GetBalance(addr)
+	}
 	app.stateMtx.Unlock()
 
-	data, err := rlp.EncodeToBytes(nonce)
+	data, err := rlp.EncodeToBytes(balances)
 	if err != nil {
-		log.Warn("query error", zap.Error(err))
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+func (app *EVMApp) queryTxStatus(txHashBytes []byte) gtypes.Result {
+	if len(txHashBytes) != common.HashLength {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid tx hash")
+	}
+	status := app.txStatus.Get(common.BytesToHash(txHashBytes))
+	data, err := rlp.EncodeToBytes(&status)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+func (app *EVMApp) recordCommitStats(stats rtypes.CommitStats) {
+	trieNodesMeter.Mark(int64(stats.TrieNodes))
+	trieBytesMeter.Mark(int64(stats.TrieBytes))
+	trieCommitTimer.Update(time.Duration(stats.TrieDuration))
+	receiptBytesMeter.Mark(int64(stats.ReceiptBytes))
+	receiptCommitTimer.Update(time.Duration(stats.ReceiptDuration))
+	app.commitStats.add(stats)
+}
+
+// queryDecodeTx returns the canonical json form of raw tx bytes
+func (app *EVMApp) queryDecodeTx(raw []byte) gtypes.Result {
+	tx, err := rtypes.DecodeTxJSON(raw)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
 	}
 	return gtypes.NewResultOK(data, "")
 }
 
 func (app *EVMApp) queryReceipt(txHashBytes []byte) gtypes.Result {
-	key := append(ReceiptsPrefix, txHashBytes...)
-	data, err := app.stateDb.Get(key)
+	env, err := app.storedReceipt(common.BytesToHash(txHashBytes))
+	if err != nil {
+		return app.storedReceiptError(txHashBytes, err)
+	}
+	// always answer in the legacy encoding, whichever format the receipt is stored in
+	data, err := rlp.EncodeToBytes(env.Receipt)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+// queryTxResult returns the rlp encoded rtypes.TxResult of the tx with the 32
+// bytes hash, read from its stored receipt.
+func (app *EVMApp) queryTxResult(txHashBytes []byte) gtypes.Result {
+	if len(txHashBytes) != common.HashLength {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid tx hash")
+	}
+	env, err := app.storedReceipt(common.BytesToHash(txHashBytes))
 	if err != nil {
-		return gtypes.NewError(gtypes.CodeType_InternalError, "fail to get receipt for tx:"+string(key))
+		return app.storedReceiptError(txHashBytes, err)
+	}
+	result := rtypes.TxResult{Status: txResultStatus(env.Class), GasUsed: env.Receipt.GasUsed, Height: env.Height}
+	data, err := rlp.EncodeToBytes(&result)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
 	}
 	return gtypes.NewResultOK(data, "")
 }
 
+// storedReceiptError answers the error reading the stored receipt of a tx
+func (app *EVMApp) storedReceiptError(txHashBytes []byte, err error) gtypes.Result {
+	if err == ErrReceiptNotFound {
+		if pruned := atomic.LoadUint64(&app.receiptsPruned); pruned > 0 {
+			return gtypes.NewError(gtypes.CodeType_InternalError, fmt.Sprintf("fail to get receipt for tx:%x, the receipts of blocks up to %d are pruned", txHashBytes, pruned))
+		}
+		return gtypes.NewError(gtypes.CodeType_InternalError, "fail to get receipt for tx:"+string(append(ReceiptsPrefix, txHashBytes...)))
+	}
+	return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+}
+
 func (app *EVMApp) queryTransaction(txHashBytes []byte) gtypes.Result {
 	if len(txHashBytes) == 0 {
 		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Empty query")
@@ -580,6 +1354,25 @@ func (app *EVMApp) queryTransaction(txHashBytes []byte) gtypes.Result {
 	return res
 }
 
+// queryRawTx returns the bytes of the tx with the 32 bytes hash exactly as the
+// block committed them, unlike QueryType_TxRaw which re-encodes the stored tx,
+// so the signature and the hash verify byte for byte. CheckTx never rewrites a
+// tx, so they are the bytes the client sent.
+func (app *EVMApp) queryRawTx(load []byte) gtypes.Result {
+	if len(load) != common.HashLength {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid hash")
+	}
+	data, err := app.core.Query(gtypes.QueryTx, load)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	result, ok := data.(*gtypes.ResultTransaction)
+	if !ok {
+		return gtypes.NewError(gtypes.CodeType_InternalError, "unexpected tx query answer")
+	}
+	return gtypes.NewResultOK(result.RawTransaction, "")
+}
+
 func (app *EVMApp) queryPayLoad(txHashBytes []byte) gtypes.Result {
 	if len(txHashBytes) == 0 {
 		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Empty query")
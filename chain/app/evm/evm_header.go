@@ -0,0 +1,102 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"math/big"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/math"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// EVMHeaderOptions are the fields of the evm header the block header doesn't carry
+type EVMHeaderOptions struct {
+	Coinbase common.Address // collects the fees and read by COINBASE
+}
+
+// BuildEVMHeader returns the eth header the evm runs the block of header with.
+// Execution and every query build it here, so they see the same fields:
+//   - ParentHash is the hash of the previous block, read by BLOCKHASH
+//   - Number is the block height, it also picks the active fork rules
+//   - Time is the block time in seconds, 0 for a header without time
+//   - GasLimit is unbounded, the gas a block takes isn't limited
+//   - Difficulty is 0, there's no randomness for DIFFICULTY to give
+func BuildEVMHeader(header *gtypes.Header, opts EVMHeaderOptions) *etypes.Header {
+	evmHeader := &etypes.Header{
+		ParentHash: common.BytesToHash(header.LastBlockID.Hash),
+		Coinbase:   opts.Coinbase,
+		Difficulty: big.NewInt(0),
+		GasLimit:   math.MaxBig256.Uint64(),
+		Time:       big.NewInt(0),
+		Number:     big.NewInt(header.Height),
+	}
+	if !header.Time.IsZero() {
+		evmHeader.Time.SetInt64(header.Time.Unix())
+	}
+	return evmHeader
+}
+
+// startHeader returns the evm header of the last block, at height, the app
+// starts from. It's built from the block stored by the core, or from the height
+// alone when the core doesn't have it.
+func (app *EVMApp) startHeader(height int64) *etypes.Header {
+	opts := EVMHeaderOptions{Coinbase: app.coinbase}
+	if app.core != nil && height > 0 {
+		meta, err := app.core.GetBlockMeta(height)
+		if err == nil && meta != nil && meta.Header != nil {
+			return BuildEVMHeader(meta.Header, opts)
+		}
+		log.Warn("last block header not found, starting with the height alone", zap.Int64("height", height), zap.Error(err))
+	}
+	return BuildEVMHeader(&gtypes.Header{Height: height}, opts)
+}
+
+// queryEVMHeader takes an 8 bytes big endian height, 0 for the last committed
+// block, and returns the rlp encoded eth header the evm executed the block at
+// that height with, with the node's coinbase. The latest queries run with the
+// header of the last committed block. The queries at a height run on the state
+// the block at that height committed, with the header of the next block.
+func (app *EVMApp) queryEVMHeader(load []byte) gtypes.Result {
+	if len(load) != 8 {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong height")
+	}
+	height := binary.BigEndian.Uint64(load)
+	app.stateMtx.Lock()
+	header := app.committedHeader
+	app.stateMtx.Unlock()
+
+	committed := header.Number.Uint64()
+	if height > committed {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "height above the last committed block")
+	}
+	if height != 0 && height != committed {
+		meta, err := app.core.GetBlockMeta(int64(height))
+		if err != nil {
+			return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+		}
+		header = BuildEVMHeader(meta.Header, EVMHeaderOptions{Coinbase: app.coinbase})
+	}
+	data, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,177 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// headerCore serves the headers of the blocks the app executed
+type headerCore struct {
+	testCore
+	headers map[int64]*gtypes.Header
+}
+
+func (c *headerCore) GetBlockMeta(height int64) (*gtypes.BlockMeta, error) {
+	header, ok := c.headers[height]
+	if !ok {
+		return nil, fmt.Errorf("no block %d", height)
+	}
+	return &gtypes.BlockMeta{Header: header}, nil
+}
+
+// commitHeaderTestBlock executes and commits a block at height, stored in core
+// like the consensus does, and returns the rlp of the evm header it executed with.
+func commitHeaderTestBlock(t *testing.T, app *EVMApp, core *headerCore, height int64) []byte {
+	block := makeTestBlock(height)
+	block.Header.Time = time.Unix(1600000000+10*height, 0)
+	block.Header.LastBlockID.Hash = common.BigToHash(big.NewInt(height - 1)).Bytes()
+	block.Header.AppHash = app.getLastAppHash().Bytes()
+	core.headers[height] = block.Header
+	if _, err := app.OnExecute(height, 0, block); err != nil {
+		t.Fatal(err)
+	}
+	executed, err := rlp.EncodeToBytes(app.currentHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.OnCommit(height, 0, block); err != nil {
+		t.Fatal(err)
+	}
+	// the next block carries the app hash of this one
+	core.headers[height+1] = &gtypes.Header{Height: height + 1, AppHash: app.getLastAppHash().Bytes()}
+	return executed
+}
+
+func queryTestEVMHeader(t *testing.T, app *EVMApp, height uint64) []byte {
+	load := make([]byte, 8)
+	binary.BigEndian.PutUint64(load, height)
+	res := app.Query(append([]byte{rtypes.QueryType_EVMHeader}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	return res.Data
+}
+
+// queryTestTimestamp returns the TIMESTAMP a contract query at height sees, 0
+// for the latest state
+func queryTestTimestamp(t *testing.T, app *EVMApp, height uint64) uint64 {
+	key, _ := testKey(t, testKeyA)
+	// returns TIMESTAMP as the code of the contract
+	code := common.Hex2Bytes("4260005260206000f3")
+	raw := signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), code))
+	query := append([]byte{rtypes.QueryType_Contract}, raw...)
+	if height != 0 {
+		query = append([]byte{rtypes.QueryTypeContractByHeight}, raw...)
+		query = append(query, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(query[len(query)-8:], height)
+	}
+	res := app.Query(query)
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	return new(big.Int).SetBytes(res.Data).Uint64()
+}
+
+func TestQueryEVMHeader(t *testing.T) {
+	conf := viper.New()
+	conf.Set("coinbase", "0x00000000000000000000000000000000000000cb")
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	core := &headerCore{headers: make(map[int64]*gtypes.Header)}
+	app.SetCore(core)
+
+	executed := make(map[uint64][]byte)
+	for height := int64(1); height <= 3; height++ {
+		executed[uint64(height)] = commitHeaderTestBlock(t, app, core, height)
+	}
+	for height, header := range executed {
+		if queried := queryTestEVMHeader(t, app, height); !bytes.Equal(queried, header) {
+			t.Fatalf("header queried at %d differs from the executed one", height)
+		}
+	}
+	if latest := queryTestEVMHeader(t, app, 0); !bytes.Equal(latest, executed[3]) {
+		t.Fatal("latest header differs from the last executed one")
+	}
+	var header etypes.Header
+	if err := rlp.DecodeBytes(executed[3], &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Number.Int64() != 3 || header.Time.Int64() != 1600000030 || header.Coinbase != app.coinbase ||
+		header.ParentHash != common.BigToHash(big.NewInt(2)) {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	load := make([]byte, 8)
+	binary.BigEndian.PutUint64(load, 4)
+	if res := app.Query(append([]byte{rtypes.QueryType_EVMHeader}, load...)); !res.IsErr() {
+		t.Fatal("expected a header above the last committed block to be refused")
+	}
+
+	// the queries on the latest state and on the state block 2 committed both
+	// run with the header of block 3
+	if latest, historical := queryTestTimestamp(t, app, 0), queryTestTimestamp(t, app, 2); latest != 1600000030 || historical != latest {
+		t.Fatalf("expected timestamp 1600000030, got %d latest and %d at height 2", latest, historical)
+	}
+}
+
+func TestEVMHeaderAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	core := &headerCore{headers: make(map[int64]*gtypes.Header)}
+	start := func() *EVMApp {
+		conf := viper.New()
+		conf.Set("db_dir", dir)
+		conf.Set("block_size", 100)
+		app, err := NewEVMApp(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.SetCore(core)
+		if err := app.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return app
+	}
+
+	app := start()
+	executed := commitHeaderTestBlock(t, app, core, 1)
+	app.Stop()
+
+	app = start()
+	defer app.Stop()
+	if latest := queryTestEVMHeader(t, app, 0); !bytes.Equal(latest, executed) {
+		t.Fatal("latest header after a restart differs from the executed one")
+	}
+	if timestamp := queryTestTimestamp(t, app, 0); timestamp != 1600000010 {
+		t.Fatalf("expected timestamp 1600000010 after a restart, got %d", timestamp)
+	}
+}
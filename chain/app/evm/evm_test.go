@@ -0,0 +1,326 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const (
+	testKeyA = "7d73c3dafd3c0215b8526b26f8dbdb93242fc7dcfbdfa1000d93436d577c3b94"
+	testKeyB = "b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291"
+
+	testGas = 1000000
+)
+
+// logContractCode deploys a contract whose runtime code emits one empty LOG0 on every call.
+var logContractCode = common.FromHex("6006600c60003960066000f360006000a000")
+
+func newTestApp(t *testing.T) (*EVMApp, func()) {
+	return newTestAppWithConfig(t, viper.New())
+}
+
+func newTestAppWithConfig(t *testing.T, conf *viper.Viper) (*EVMApp, func()) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Set("db_dir", dir)
+	if !conf.IsSet("block_size") {
+		conf.Set("block_size", 100)
+	}
+	app, err := NewEVMApp(conf)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	if err := app.Start(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return app, func() {
+		app.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func testKey(t *testing.T, hexKey string) (*ecdsa.PrivateKey, common.Address) {
+	key, err := crypto.HexToECDSA(hexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, crypto.PubkeyToAddress(key.PublicKey)
+}
+
+func signTestTx(t *testing.T, key *ecdsa.PrivateKey, tx *etypes.Transaction) []byte {
+	signed, err := etypes.SignTx(tx, EthSigner, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := rlp.EncodeToBytes(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func makeTestBlock(height int64, txs ...[]byte) *gtypes.Block {
+	block := &gtypes.Block{
+		Header: &gtypes.Header{
+			Height: height,
+			Time:   time.Now(),
+			NumTxs: int64(len(txs)),
+		},
+		Data: &gtypes.Data{},
+	}
+	for _, tx := range txs {
+		block.Data.Txs = append(block.Data.Txs, tx)
+	}
+	return block
+}
+
+// execTestBlock executes and commits one block, returning the execute result.
+func execTestBlock(t *testing.T, app *EVMApp, height int64, txs ...[]byte) gtypes.ExecuteResult {
+	block := makeTestBlock(height, txs...)
+	res, err := app.OnExecute(height, 0, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.OnCommit(height, 0, block); err != nil {
+		t.Fatal(err)
+	}
+	return res.(gtypes.ExecuteResult)
+}
+
+// fundTestAccounts credits the accounts in the committed app state, like genesis alloc does.
+func fundTestAccounts(t *testing.T, app *EVMApp, amount *big.Int, addrs ...common.Address) {
+	updateTestState(t, app, func(state *estate.StateDB) {
+		for _, addr := range addrs {
+			state.AddBalance(addr, amount)
+		}
+	})
+}
+
+// updateTestState changes the committed app state behind the tx pool's back.
+func updateTestState(t *testing.T, app *EVMApp, update func(state *estate.StateDB)) {
+	app.stateMtx.Lock()
+	defer app.stateMtx.Unlock()
+	update(app.state)
+	root, err := app.state.Commit(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.state.Database().TrieDB().Commit(root, false); err != nil {
+		t.Fatal(err)
+	}
+	app.committedRoot = root
+	lb, err := app.loadLastBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.saveLastBlock(LastBlockInfo{Height: lb.Height, AppHash: root.Bytes()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func txHash(raw []byte) common.Hash {
+	return common.BytesToHash(gtypes.Tx(raw).Hash())
+}
+
+func TestReceiptsFollowBlockOrder(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	keyA, addrA := testKey(t, testKeyA)
+	keyB, _ := testKey(t, testKeyB)
+
+	deploy := signTestTx(t, keyA, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), logContractCode))
+	execTestBlock(t, app, 1, deploy)
+	contract := crypto.CreateAddress(addrA, 0)
+
+	txs := [][]byte{
+		signTestTx(t, keyA, etypes.NewTransaction(1, contract, big.NewInt(0), testGas, big.NewInt(0), nil)),
+		signTestTx(t, keyB, etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil)),
+		signTestTx(t, keyA, etypes.NewTransaction(2, contract, big.NewInt(0), testGas, big.NewInt(0), nil)),
+	}
+	block := makeTestBlock(2, txs...)
+	if _, err := app.OnExecute(2, 0, block); err != nil {
+		t.Fatal(err)
+	}
+	if len(app.receipts) != len(txs) {
+		t.Fatalf("expected %d receipts, got %d", len(txs), len(app.receipts))
+	}
+	for i, receipt := range app.receipts {
+		if receipt.TxHash != txHash(txs[i]) {
+			t.Errorf("receipt %d out of block order", i)
+		}
+	}
+	logs := append(app.receipts[0].Logs, app.receipts[2].Logs...)
+	if len(logs) != 2 || len(app.receipts[1].Logs) != 0 {
+		t.Fatalf("unexpected logs %v", logs)
+	}
+	for i, l := range logs {
+		if l.Index != uint(i) {
+			t.Errorf("log %d has index %d", i, l.Index)
+		}
+	}
+	if logs[0].TxIndex != 0 || logs[1].TxIndex != 2 {
+		t.Errorf("unexpected log tx indexes %d, %d", logs[0].TxIndex, logs[1].TxIndex)
+	}
+}
+
+func TestQueryBalancesBatch(t *testing.T) {
+	conf := viper.New()
+	conf.Set("balances_batch_limit", 3)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	_, funded := testKey(t, testKeyA)
+	empty := common.HexToAddress("0x0100000000000000000000000000000000000001")
+	fundTestAccounts(t, app, big.NewInt(1000), funded)
+
+	load, _ := rlp.EncodeToBytes([]common.Address{funded, empty, funded})
+	res := app.Query(append([]byte{rtypes.QueryType_BalancesBatch}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var balances []*big.Int
+	if err := rlp.DecodeBytes(res.Data, &balances); err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 3 || balances[0].Int64() != 1000 || balances[1].Sign() != 0 || balances[2].Int64() != 1000 {
+		t.Fatalf("unexpected balances %v", balances)
+	}
+
+	load, _ = rlp.EncodeToBytes([]common.Address{funded, empty, funded, empty})
+	if res := app.Query(append([]byte{rtypes.QueryType_BalancesBatch}, load...)); res.IsOK() {
+		t.Fatal("expected batch over limit to be rejected")
+	}
+}
+
+func TestContractAddressAcrossNodes(t *testing.T) {
+	key, addr := testKey(t, testKeyA)
+	blocks := [][]byte{
+		signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), logContractCode)),
+		signTestTx(t, key, etypes.NewContractCreation(1, big.NewInt(0), testGas, big.NewInt(0), logContractCode)),
+	}
+
+	var addrs [2][]common.Address
+	for node := range addrs {
+		app, clean := newTestApp(t)
+		for i, raw := range blocks {
+			execTestBlock(t, app, int64(i+1), raw)
+			var receipt etypes.ReceiptForStorage
+			if err := rlp.DecodeBytes(queryTestReceipt(t, app, txHash(raw)), &receipt); err != nil {
+				t.Fatal(err)
+			}
+			addrs[node] = append(addrs[node], receipt.ContractAddress)
+		}
+		clean()
+	}
+	for i := range blocks {
+		if expected := crypto.CreateAddress(addr, uint64(i)); addrs[0][i] != expected || addrs[1][i] != expected {
+			t.Fatalf("contract %d deployed at %x and %x, expected %x", i, addrs[0][i], addrs[1][i], expected)
+		}
+	}
+}
+
+func TestQueryDecodeTx(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	raw := signTestTx(t, key, etypes.NewTransaction(3, common.Address{}, big.NewInt(5), testGas, big.NewInt(0), []byte{1}))
+	res := app.Query(append([]byte{rtypes.QueryType_DecodeTx}, raw...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	tx, err := rtypes.ParseTxJSON(res.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *tx.From != addr || tx.Nonce != 3 || tx.Value.ToInt().Int64() != 5 || *tx.Hash != txHash(raw) {
+		t.Fatalf("unexpected decoded tx %s", res.Data)
+	}
+	if encoded, err := rtypes.EncodeTxJSON(tx); err != nil || !bytes.Equal(encoded, raw) {
+		t.Fatalf("re-encoded tx differs, err %v", err)
+	}
+	if res := app.Query([]byte{rtypes.QueryType_DecodeTx, 0x01}); res.IsOK() {
+		t.Fatal("expected invalid tx to be rejected")
+	}
+}
+
+func TestCheckTxDataSize(t *testing.T) {
+	conf := viper.New()
+	conf.Set("max_tx_data_size", 16)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	under := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), make([]byte, 16)))
+	if err := app.CheckTx(under); err != nil {
+		t.Fatal(err)
+	}
+	over := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), make([]byte, 17)))
+	if err := app.CheckTx(over); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected oversized data to be rejected, got %v", err)
+	}
+}
+
+func TestQueryRawTx(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	core := &testCore{txs: make(map[common.Hash][]byte)}
+	app.SetCore(core)
+
+	key, _ := testKey(t, testKeyA)
+	tx := etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(1), testGas, big.NewInt(0), []byte{1, 2})
+	raw := signTestTx(t, key, tx)
+	core.txs[txHash(raw)] = raw
+
+	res := app.Query(append([]byte{rtypes.QueryType_RawTx}, txHash(raw).Bytes()...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	signed := new(etypes.Transaction)
+	if err := rlp.DecodeBytes(raw, signed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res.Data, raw) || txHash(res.Data) != txHash(raw) || crypto.Keccak256Hash(res.Data) != signed.Hash() {
+		t.Fatalf("expected the committed tx bytes %x, got %x", raw, res.Data)
+	}
+	if res := app.Query(append([]byte{rtypes.QueryType_RawTx}, common.HexToHash("0x01").Bytes()...)); res.IsOK() {
+		t.Fatal("expected an unknown tx not to be found")
+	}
+	if res := app.Query([]byte{rtypes.QueryType_RawTx, 0x01}); res.IsOK() {
+		t.Fatal("expected an invalid hash to be rejected")
+	}
+}
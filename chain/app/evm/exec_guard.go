@@ -0,0 +1,163 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/metrics"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+)
+
+var (
+	execHeapPeakGauge   = metrics.NewRegisteredGauge("evm/execute/memory/heap", nil)
+	execEVMPeakGauge    = metrics.NewRegisteredGauge("evm/execute/memory/evm", nil)
+	execGoroutinesGauge = metrics.NewRegisteredGauge("evm/execute/goroutines", nil)
+	execSoftLimitMeter  = metrics.NewRegisteredMeter("evm/execute/memory/softlimit", nil)
+)
+
+// execMemoryStats are the peaks of executing one block
+type execMemoryStats struct {
+	Height       int64
+	Heap         uint64 // peak heap growth over the block start, sampled after each tx
+	EVM          uint64 // peak EVM memory of one tx, all its call frames summed
+	Goroutines   int
+	SoftLimitHit bool
+}
+
+// execGuard watches the memory used executing a block. Over the soft limit the
+// memory freed by the block so far is given back to the OS, once per block, and
+// a warning is logged. Over the hard limit, even after giving memory back, the
+// node halts before the block commits rather than being killed out of memory.
+type execGuard struct {
+	soft    uint64 // bytes, 0 for no limit
+	hard    uint64 // bytes, 0 for no limit
+	datadir string // where the heap profile of a halt is written
+	halt    func(error)
+
+	base     uint64
+	released bool
+	stats    execMemoryStats
+	last     execMemoryStats // stats of the last executed block
+}
+
+func newExecGuard(soft, hard uint64, datadir string) *execGuard {
+	return &execGuard{soft: soft, hard: hard, datadir: datadir, halt: haltNode}
+}
+
+func haltNode(err error) {
+	log.Fatal("halting the node", zap.Error(err))
+}
+
+func heapInUse() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// begin starts watching the execution of the block at height
+func (g *execGuard) begin(height int64) {
+	g.base = heapInUse()
+	g.released = false
+	g.stats = execMemoryStats{Height: height}
+}
+
+func (g *execGuard) heapGrowth() uint64 {
+	if heap := heapInUse(); heap > g.base {
+		return heap - g.base
+	}
+	return 0
+}
+
+// tx samples the memory after executing a tx whose EVM memory peaked at evmPeak
+func (g *execGuard) tx(txIndex int, txHash common.Hash, evmPeak uint64) {
+	heap := g.heapGrowth()
+	if heap > g.stats.Heap {
+		g.stats.Heap = heap
+	}
+	if evmPeak > g.stats.EVM {
+		g.stats.EVM = evmPeak
+	}
+	if n := runtime.NumGoroutine(); n > g.stats.Goroutines {
+		g.stats.Goroutines = n
+	}
+
+	usage := max64(heap, evmPeak)
+	overSoft := g.soft > 0 && usage > g.soft && !g.released
+	if overSoft {
+		g.stats.SoftLimitHit = true
+		execSoftLimitMeter.Mark(1)
+		log.Warn("block execution over the soft memory limit, releasing memory",
+			zap.Int64("height", g.stats.Height), zap.Int("tx", txIndex), zap.String("hash", txHash.Hex()),
+			zap.Uint64("heap", heap), zap.Uint64("evm", evmPeak), zap.Uint64("limit", g.soft))
+	}
+	if overSoft || (g.hard > 0 && usage > g.hard) {
+		g.released = true
+		debug.FreeOSMemory()
+		heap = g.heapGrowth()
+		usage = max64(heap, evmPeak)
+	}
+	if g.hard > 0 && usage > g.hard {
+		g.halt(g.diagnose(txIndex, txHash, heap, evmPeak))
+	}
+}
+
+// diagnose logs the memory state of a block over the hard limit, writing a heap
+// profile to the datadir, and returns the halt error.
+func (g *execGuard) diagnose(txIndex int, txHash common.Hash, heap, evmPeak uint64) error {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	fields := []zapcore.Field{
+		zap.Int64("height", g.stats.Height), zap.Int("tx", txIndex), zap.String("hash", txHash.Hex()),
+		zap.Uint64("heap", heap), zap.Uint64("evm", evmPeak), zap.Uint64("limit", g.hard),
+		zap.Uint64("heapAlloc", ms.HeapAlloc), zap.Uint64("heapSys", ms.HeapSys), zap.Uint64("sys", ms.Sys),
+		zap.Uint32("numGC", ms.NumGC), zap.Int("goroutines", runtime.NumGoroutine()),
+	}
+	profile := filepath.Join(g.datadir, fmt.Sprintf("exec-heap-%d.pprof", g.stats.Height))
+	if f, err := os.Create(profile); err == nil {
+		if err = pprof.WriteHeapProfile(f); err == nil {
+			fields = append(fields, zap.String("profile", profile))
+		}
+		f.Close()
+	}
+	log.Error("block execution over the hard memory limit", fields...)
+	return fmt.Errorf("block %d tx %d (%s) uses %d bytes of memory, over the hard limit of %d",
+		g.stats.Height, txIndex, txHash.Hex(), max64(heap, evmPeak), g.hard)
+}
+
+// end publishes the peaks of the executed block
+func (g *execGuard) end() {
+	g.last = g.stats
+	execHeapPeakGauge.Update(int64(g.stats.Heap))
+	execEVMPeakGauge.Update(int64(g.stats.EVM))
+	execGoroutinesGauge.Update(int64(g.stats.Goroutines))
+	log.Debug("block execution memory", zap.Int64("height", g.stats.Height),
+		zap.Uint64("heap", g.stats.Heap), zap.Uint64("evm", g.stats.EVM), zap.Int("goroutines", g.stats.Goroutines))
+}
+
+func max64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
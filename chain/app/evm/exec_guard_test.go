@@ -0,0 +1,66 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+// memoryHungryCode is init code expanding the memory to 640KB: MSTORE(0xa0000, 1)
+var memoryHungryCode = common.FromHex("6001620a00005200")
+
+func TestExecGuardSoftLimit(t *testing.T) {
+	conf := viper.New()
+	conf.Set("exec_memory_soft_limit", 256*1024)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x01"), big.NewInt(0), testGas, big.NewInt(0), nil)))
+	if stats := app.execGuard.last; stats.Height != 1 || stats.SoftLimitHit || stats.EVM != 0 || stats.Goroutines == 0 {
+		t.Fatalf("unexpected stats of a light block %+v", stats)
+	}
+
+	res := execTestBlock(t, app, 2, signTestTx(t, key, etypes.NewContractCreation(1, big.NewInt(0), testGas, big.NewInt(0), memoryHungryCode)))
+	if len(res.ValidTxs) != 1 {
+		t.Fatalf("expected the tx to run, got %+v", res.InvalidTxs)
+	}
+	if stats := app.execGuard.last; stats.Height != 2 || !stats.SoftLimitHit || stats.EVM < 0xa0020 {
+		t.Fatalf("expected the soft limit hit with the EVM memory peak recorded, got %+v", stats)
+	}
+}
+
+func TestExecGuardHardLimit(t *testing.T) {
+	conf := viper.New()
+	conf.Set("exec_memory_hard_limit", 256*1024)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	var halted error
+	app.execGuard.halt = func(err error) { halted = err }
+
+	key, _ := testKey(t, testKeyA)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), memoryHungryCode)))
+	if halted == nil {
+		t.Fatal("expected the node halted over the hard limit")
+	}
+	if app.execGuard.last.SoftLimitHit {
+		t.Fatal("expected no soft limit hit without a soft limit")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// forkBlocks maps the fork names of fork_schedule to their switch blocks in c
+func forkBlocks(c *params.ChainConfig) map[string]**big.Int {
+	return map[string]**big.Int{
+		"homestead":      &c.HomesteadBlock,
+		"eip150":         &c.EIP150Block,
+		"eip155":         &c.EIP155Block,
+		"eip158":         &c.EIP158Block,
+		"byzantium":      &c.ByzantiumBlock,
+		"constantinople": &c.ConstantinopleBlock,
+	}
+}
+
+// loadChainConfig returns the mainnet chain config with the forks of schedule
+// moved to the scheduled heights. schedule maps block heights to comma separated
+// fork names, eg. {"100" = "eip150,eip158", "5000" = "byzantium"}; forks not in
+// schedule keep their mainnet blocks. The rules of a block are picked by its
+// header Number, so every validator must run the same schedule. Opcode prices
+// aren't affected, params.GasTable always answers the constantinople table.
+func loadChainConfig(schedule map[string]string) (*params.ChainConfig, error) {
+	if len(schedule) == 0 {
+		return params.MainnetChainConfig, nil
+	}
+	config := *params.MainnetChainConfig
+	blocks := forkBlocks(&config)
+	scheduled := make(map[string]bool)
+	for height, forks := range schedule {
+		h, err := strconv.ParseUint(height, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("fork_schedule: invalid height %q", height)
+		}
+		for _, fork := range strings.Split(forks, ",") {
+			fork = strings.ToLower(strings.TrimSpace(fork))
+			block, ok := blocks[fork]
+			if !ok {
+				return nil, fmt.Errorf("fork_schedule: unknown fork %q", fork)
+			}
+			if scheduled[fork] {
+				return nil, fmt.Errorf("fork_schedule: fork %q scheduled twice", fork)
+			}
+			scheduled[fork] = true
+			*block = new(big.Int).SetUint64(h)
+		}
+	}
+	return &config, nil
+}
+
+// chainRules returns the forks active at height in the chain config of the app
+func (app *EVMApp) chainRules(height uint64) *rtypes.ChainRules {
+	rules := app.chainConfig.Rules(new(big.Int).SetUint64(height))
+	return &rtypes.ChainRules{
+		Height:         height,
+		ChainID:        rules.ChainID,
+		Homestead:      rules.IsHomestead,
+		EIP150:         rules.IsEIP150,
+		EIP155:         rules.IsEIP155,
+		EIP158:         rules.IsEIP158,
+		Byzantium:      rules.IsByzantium,
+		Constantinople: rules.IsConstantinople,
+	}
+}
+
+// queryRulesAt returns the rlp encoded rtypes.ChainRules of the 8 bytes big
+// endian height in load. Any height can be asked, the rules of the blocks to
+// come are known from the schedule.
+func (app *EVMApp) queryRulesAt(load []byte) gtypes.Result {
+	if len(load) != 8 {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong height")
+	}
+	data, err := rlp.EncodeToBytes(app.chainRules(binary.BigEndian.Uint64(load)))
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
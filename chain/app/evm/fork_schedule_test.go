@@ -0,0 +1,119 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func testGasUsed(t *testing.T, app *EVMApp, raw []byte) uint64 {
+	receipt := &etypes.ReceiptForStorage{}
+	if err := rlp.DecodeBytes(queryTestReceipt(t, app, txHash(raw)), receipt); err != nil {
+		t.Fatal(err)
+	}
+	return receipt.GasUsed
+}
+
+func TestForkSchedule(t *testing.T) {
+	conf := viper.New()
+	conf.Set("fork_schedule", map[string]string{"3": "homestead"})
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	before := signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), nil))
+	after := signTestTx(t, key, etypes.NewContractCreation(1, big.NewInt(0), testGas, big.NewInt(0), nil))
+	execTestBlock(t, app, 2, before)
+	execTestBlock(t, app, 3, after)
+
+	// homestead raises the intrinsic gas of contract creations
+	diff := params.TxGasContractCreation - params.TxGas
+	if gasBefore, gasAfter := testGasUsed(t, app, before), testGasUsed(t, app, after); gasAfter != gasBefore+diff {
+		t.Fatalf("expected homestead to add %d gas from height 3, used %d then %d", diff, gasBefore, gasAfter)
+	}
+	if params.MainnetChainConfig.HomesteadBlock.Cmp(app.chainConfig.HomesteadBlock) == 0 {
+		t.Fatal("expected the schedule not to touch the mainnet config")
+	}
+}
+
+func TestLoadChainConfig(t *testing.T) {
+	config, err := loadChainConfig(map[string]string{"10": "eip150, EIP158", "20": "byzantium"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.EIP150Block.Uint64() != 10 || config.EIP158Block.Uint64() != 10 || config.ByzantiumBlock.Uint64() != 20 {
+		t.Fatalf("unexpected fork blocks %v", config)
+	}
+	if config.HomesteadBlock.Cmp(params.MainnetChainConfig.HomesteadBlock) != 0 {
+		t.Fatal("expected unscheduled forks to keep mainnet blocks")
+	}
+	if config, err := loadChainConfig(nil); err != nil || config != params.MainnetChainConfig {
+		t.Fatal("expected mainnet config without a schedule")
+	}
+	for _, schedule := range []map[string]string{
+		{"x": "eip150"},
+		{"10": "nofork"},
+		{"10": "eip150", "20": "eip150"},
+	} {
+		if _, err := loadChainConfig(schedule); err == nil {
+			t.Fatalf("expected schedule %v to be rejected", schedule)
+		}
+	}
+}
+
+func TestQueryRulesAt(t *testing.T) {
+	conf := viper.New()
+	conf.Set("fork_schedule", map[string]string{"3": "homestead", "5": "byzantium"})
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	for _, c := range []struct {
+		height               uint64
+		homestead, byzantium bool
+	}{
+		{2, false, false},
+		{3, true, false},
+		{4, true, false},
+		{5, true, true},
+	} {
+		load := make([]byte, 8)
+		binary.BigEndian.PutUint64(load, c.height)
+		res := app.Query(append([]byte{rtypes.QueryType_RulesAt}, load...))
+		if res.IsErr() {
+			t.Fatal(res.Log)
+		}
+		rules := &rtypes.ChainRules{}
+		if err := rlp.DecodeBytes(res.Data, rules); err != nil {
+			t.Fatal(err)
+		}
+		if rules.Height != c.height || rules.Homestead != c.homestead || rules.Byzantium != c.byzantium {
+			t.Fatalf("height %d: unexpected rules %+v", c.height, rules)
+		}
+		if rules.ChainID.Cmp(app.chainConfig.ChainID) != 0 {
+			t.Fatalf("expected chain id %v, got %v", app.chainConfig.ChainID, rules.ChainID)
+		}
+	}
+	if res := app.Query([]byte{rtypes.QueryType_RulesAt, 1}); res.IsOK() {
+		t.Fatal("expected a short height to be rejected")
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// ErrGasLimitExceeded rejects txs asking for more gas than EVMGasLimit, the most
+// gas any tx may use. CheckTx refuses them and, from gas_limit_height, blocks
+// carrying them have them invalidated.
+var ErrGasLimitExceeded = errors.New("gas limit exceeds the evm gas limit")
+
+func checkGasLimit(tx *etypes.Transaction) error {
+	if tx.Gas() > EVMGasLimit {
+		return ErrGasLimitExceeded
+	}
+	return nil
+}
+
+// clampGas caps the gas of a query message to EVMGasLimit, it returns the note
+// of the result log when it did.
+func clampGas(msg etypes.Message) (etypes.Message, string) {
+	if msg.Gas() <= EVMGasLimit {
+		return msg, ""
+	}
+	note := fmt.Sprintf("gas limit %d clamped to %d", msg.Gas(), EVMGasLimit)
+	return etypes.NewMessage(msg.From(), msg.To(), msg.Nonce(), msg.Value(), EVMGasLimit, msg.GasPrice(), msg.Data(), msg.CheckNonce()), note
+}
+
+// simulateQuery answers the evm output of txMsg, simulated on the state at
+// height with its gas clamped to EVMGasLimit. Successful answers are memoized
+// until the next block commits.
+func (app *EVMApp) simulateQuery(txMsg etypes.Message, height uint64) gtypes.Result {
+	key, committed := simulationKey(txMsg, height), uint64(atomic.LoadInt64(&app.committedHeight))
+	if res, ok := app.simulations.get(key, committed); ok {
+		return res
+	}
+	txMsg, note := clampGas(txMsg)
+	res := app.simulateResult(app.simulateContract(txMsg, height, evmConfig))
+	if note != "" && res.IsOK() {
+		res = res.SetLog(note)
+	}
+	if res.IsOK() {
+		app.simulations.put(key, committed, res)
+	}
+	return res
+}
@@ -0,0 +1,96 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func TestGasLimitExceeded(t *testing.T) {
+	conf := viper.New()
+	conf.Set("gas_limit_height", 2)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), callerCode)))
+	contract := crypto.CreateAddress(addr, 0)
+	huge := 10 * EVMGasLimit
+
+	raw := signTestTx(t, key, etypes.NewTransaction(1, contract, big.NewInt(0), huge, big.NewInt(0), nil))
+	if err := app.CheckTx(raw); err != ErrGasLimitExceeded {
+		t.Fatalf("expected CheckTx to fail with %v, got %v", ErrGasLimitExceeded, err)
+	}
+
+	// slipped into a block, the tx is invalidated without touching the state
+	appHash := app.getLastAppHash()
+	res := execTestBlock(t, app, 2, raw)
+	if len(res.ValidTxs) != 0 || len(res.InvalidTxs) != 1 || res.InvalidTxs[0].Error != ErrGasLimitExceeded {
+		t.Fatalf("expected the tx invalidated with %v, got %+v", ErrGasLimitExceeded, res.InvalidTxs)
+	}
+	if app.getLastAppHash() != appHash || app.state.GetNonce(addr) != 1 {
+		t.Fatal("expected the invalidated tx to leave the state untouched")
+	}
+
+	// queries run with the gas clamped to the limit and say so
+	expected := common.LeftPadBytes(addr.Bytes(), 32)
+	res2 := app.Query(append([]byte{rtypes.QueryType_Contract}, raw...))
+	if res2.IsErr() || !bytes.Equal(res2.Data, expected) || !strings.Contains(res2.Log, "clamped") {
+		t.Fatalf("unexpected contract query answer %x %q", res2.Data, res2.Log)
+	}
+	call, err := rlp.EncodeToBytes(&rtypes.CallObject{From: addr, To: &contract, Gas: huge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2 = app.Query(append([]byte{rtypes.QueryType_Call}, call...))
+	if res2.IsErr() || !bytes.Equal(res2.Data, expected) || !strings.Contains(res2.Log, "clamped") {
+		t.Fatalf("unexpected call query answer %x %q", res2.Data, res2.Log)
+	}
+	call, err = rlp.EncodeToBytes(&rtypes.CallObject{From: addr, To: &contract, Gas: testGas})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res2 = app.Query(append([]byte{rtypes.QueryType_Call}, call...)); res2.IsErr() || res2.Log != "" {
+		t.Fatalf("expected no clamp note within the limit, got %q", res2.Log)
+	}
+}
+
+func TestGasLimitHeight(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	// without gas_limit_height the blocks run as they did before the check
+	key, _ := testKey(t, testKeyA)
+	raw := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), 10*EVMGasLimit, big.NewInt(0), nil))
+	if err := app.CheckTx(raw); err != ErrGasLimitExceeded {
+		t.Fatalf("expected CheckTx to fail with %v, got %v", ErrGasLimitExceeded, err)
+	}
+	res := execTestBlock(t, app, 1, raw)
+	for _, invalid := range res.InvalidTxs {
+		if invalid.Error == ErrGasLimitExceeded {
+			t.Fatalf("expected no gas limit check before the activation height, got %+v", res.InvalidTxs)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+)
+
+func parseGasPrice(value string) (*big.Int, error) {
+	price, ok := new(big.Int).SetString(value, 10)
+	if !ok || price.Sign() < 0 {
+		return nil, fmt.Errorf("invalid gas price %q", value)
+	}
+	return price, nil
+}
+
+// loadGasPriceFloors parses gas_price_floors, target contract addresses to their
+// decimal min gas prices.
+func loadGasPriceFloors(floors map[string]string) (map[common.Address]*big.Int, error) {
+	res := make(map[common.Address]*big.Int, len(floors))
+	for addr, value := range floors {
+		if !common.IsHexAddress(addr) {
+			return nil, fmt.Errorf("gas_price_floors: invalid address %q", addr)
+		}
+		price, err := parseGasPrice(value)
+		if err != nil {
+			return nil, fmt.Errorf("gas_price_floors: %v", err)
+		}
+		res[common.HexToAddress(addr)] = price
+	}
+	return res, nil
+}
+
+// minGasPrice returns the min gas price of txs to the target, the floor set for
+// it or else min_gas_price. Contract creations use min_gas_price.
+func (app *EVMApp) minGasPrice(to *common.Address) *big.Int {
+	if to != nil {
+		if floor, ok := app.gasPriceFloors[*to]; ok {
+			return floor
+		}
+	}
+	return app.globalMinGasPrice
+}
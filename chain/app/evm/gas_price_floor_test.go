@@ -0,0 +1,75 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func TestCheckTxGasPriceFloor(t *testing.T) {
+	free, paid := common.HexToAddress("0x1001"), common.HexToAddress("0x1002")
+	conf := viper.New()
+	conf.Set("min_gas_price", "10")
+	conf.Set("gas_price_floors", map[string]string{free.Hex(): "0", paid.Hex(): "100"})
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	fundTestAccounts(t, app, big.NewInt(1e12), addr)
+	check := func(to *common.Address, price int64) error {
+		var tx *etypes.Transaction
+		if to == nil {
+			tx = etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(price), nil)
+		} else {
+			tx = etypes.NewTransaction(0, *to, big.NewInt(0), testGas, big.NewInt(price), nil)
+		}
+		return app.CheckTx(signTestTx(t, key, tx))
+	}
+
+	other := common.HexToAddress("0x1003")
+	for _, c := range []struct {
+		to    *common.Address
+		price int64
+		ok    bool
+	}{
+		{&free, 0, true},
+		{&paid, 99, false},
+		{&paid, 100, true},
+		{&other, 9, false}, // global default
+		{&other, 10, true},
+		{nil, 9, false},
+		{nil, 10, true},
+	} {
+		err := check(c.to, c.price)
+		if c.ok && err != nil {
+			t.Fatalf("to %v, price %d: %v", c.to, c.price, err)
+		}
+		if !c.ok && (err == nil || !strings.Contains(err.Error(), "below the minimum")) {
+			t.Fatalf("to %v, price %d: expected the price to be rejected, got %v", c.to, c.price, err)
+		}
+	}
+
+	conf = viper.New()
+	conf.Set("gas_price_floors", map[string]string{"0x12": "1"})
+	if _, err := NewEVMApp(conf); err == nil {
+		t.Fatal("expected invalid floor address to be rejected")
+	}
+}
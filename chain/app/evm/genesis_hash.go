@@ -0,0 +1,136 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var (
+	// GenesisHashKey stores the genesis hash of the chain, see GenesisHash
+	GenesisHashKey = []byte("genesis-hash")
+	// GenesisRootKey stores the state root of the genesis the state database was
+	// initialized with
+	GenesisRootKey = []byte("genesis-root")
+)
+
+// GenesisHash returns the keccak256 of the canonical json of genesis, its alloc
+// and system contracts, along with the chain config the app runs it with. json
+// encodes maps in key order, so the hash only depends on the content. Nodes of
+// a network must share it, deployment tooling may precompute it for the
+// expected_genesis_hash of the nodes.
+func GenesisHash(genesis *core.Genesis, config *params.ChainConfig) (common.Hash, error) {
+	g := *genesis
+	g.Config = nil
+	data, err := json.Marshal(&struct {
+		Genesis *core.Genesis       `json:"genesis"`
+		Config  *params.ChainConfig `json:"config"`
+	}{&g, config})
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(data), nil
+}
+
+func (app *EVMApp) computeGenesisHash() (common.Hash, error) {
+	genesis := core.DefaultGenesis()
+	return GenesisHash(&genesis, app.chainConfig)
+}
+
+// saveGenesisHash stores the genesis hash of a chain being initialized
+func (app *EVMApp) saveGenesisHash() error {
+	hash, err := app.computeGenesisHash()
+	if err != nil {
+		return err
+	}
+	if err := app.stateDb.Put(GenesisHashKey, hash.Bytes()); err != nil {
+		return err
+	}
+	app.genesisHash = hash
+	return nil
+}
+
+// loadGenesisHash loads the stored genesis hash, backfilling it with the current
+// genesis for datadirs initialized before it was stored, and checks it against
+// expected_genesis_hash when set.
+func (app *EVMApp) loadGenesisHash() error {
+	if value, err := app.stateDb.Get(GenesisHashKey); err == nil && len(value) == common.HashLength {
+		app.genesisHash = common.BytesToHash(value)
+	} else {
+		if err := app.saveGenesisHash(); err != nil {
+			return err
+		}
+		log.Info("backfilled the genesis hash", zap.String("hash", app.genesisHash.Hex()))
+	}
+	expected := app.Config.GetString("expected_genesis_hash")
+	if expected == "" {
+		return nil
+	}
+	if common.HexToHash(expected) != app.genesisHash {
+		return fmt.Errorf("genesis hash %s, expected %s", app.genesisHash.Hex(), expected)
+	}
+	return nil
+}
+
+// genesisRoot is the state root of the app genesis, computed in memory
+func genesisRoot() common.Hash {
+	genesis := core.DefaultGenesis()
+	return genesis.ToBlock(nil).Root()
+}
+
+// checkGenesisRoot checks the state database already initialized was initialized
+// with the genesis of the app, so a datadir of another chain isn't run on. The
+// datadirs initialized before the root was stored have it backfilled when they
+// hold the state of the app genesis; the check is skipped with
+// genesis_root_check off.
+func (app *EVMApp) checkGenesisRoot() error {
+	if !app.Config.GetBool("genesis_root_check") {
+		return nil
+	}
+	expected := genesisRoot()
+	if value, err := app.stateDb.Get(GenesisRootKey); err == nil && len(value) == common.HashLength {
+		if root := common.BytesToHash(value); root != expected {
+			return fmt.Errorf("state database of genesis root %s, the app genesis has %s", root.Hex(), expected.Hex())
+		}
+		return nil
+	}
+	if ok, err := app.stateDb.Has(expected.Bytes()); err != nil || !ok {
+		return fmt.Errorf("state database without the genesis root %s of the app genesis", expected.Hex())
+	}
+	if err := app.stateDb.Put(GenesisRootKey, expected.Bytes()); err != nil {
+		return err
+	}
+	log.Info("backfilled the genesis root", zap.String("root", expected.Hex()))
+	return nil
+}
+
+// queryGenesisHash returns the rlp encoded genesis hash
+func (app *EVMApp) queryGenesisHash() gtypes.Result {
+	data, err := rlp.EncodeToBytes(app.genesisHash)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
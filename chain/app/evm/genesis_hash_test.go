@@ -0,0 +1,179 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func queryTestGenesisHash(t *testing.T, app *EVMApp) common.Hash {
+	res := app.Query([]byte{rtypes.QueryType_GenesisHash})
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var hash common.Hash
+	if err := rlp.DecodeBytes(res.Data, &hash); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func startTestApp(dir string, conf *viper.Viper) (*EVMApp, error) {
+	conf.Set("db_dir", dir)
+	conf.Set("block_size", 100)
+	app, err := NewEVMApp(conf)
+	if err != nil {
+		return nil, err
+	}
+	return app, app.Start()
+}
+
+func TestGenesisHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app, err := startTestApp(dir, viper.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := core.DefaultGenesis()
+	expected, err := GenesisHash(&genesis, app.chainConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash := queryTestGenesisHash(t, app); hash != expected {
+		t.Fatalf("expected genesis hash %x, got %x", expected, hash)
+	}
+	if info := app.Info(); !bytes.Equal(info.GenesisHash, expected.Bytes()) {
+		t.Fatalf("expected the info genesis hash %x, got %x", expected, info.GenesisHash)
+	}
+	scheduled, err := loadChainConfig(map[string]string{"1": "constantinople"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, err := GenesisHash(&genesis, scheduled); err != nil || other == expected {
+		t.Fatalf("expected another chain config to change the genesis hash, got %x %v", other, err)
+	}
+
+	// a datadir initialized before the hash was stored has it backfilled
+	if err := app.stateDb.Delete(GenesisHashKey); err != nil {
+		t.Fatal(err)
+	}
+	app.Stop()
+	if app, err = startTestApp(dir, viper.New()); err != nil {
+		t.Fatal(err)
+	}
+	if hash := queryTestGenesisHash(t, app); hash != expected {
+		t.Fatalf("expected the backfilled genesis hash %x, got %x", expected, hash)
+	}
+	if value, err := app.stateDb.Get(GenesisHashKey); err != nil || !bytes.Equal(value, expected.Bytes()) {
+		t.Fatalf("expected the genesis hash stored, got %x %v", value, err)
+	}
+	app.Stop()
+
+	conf := viper.New()
+	conf.Set("expected_genesis_hash", expected.Hex())
+	if app, err = startTestApp(dir, conf); err != nil {
+		t.Fatalf("expected a matching genesis hash to start, got %v", err)
+	}
+	app.Stop()
+
+	conf = viper.New()
+	conf.Set("expected_genesis_hash", common.HexToHash("0x01").Hex())
+	if _, err = startTestApp(dir, conf); err == nil {
+		t.Fatal("expected a mismatching genesis hash to refuse to start")
+	}
+}
+
+// writeOtherTestGenesis initializes the state database of dir with a genesis
+// other than the app's, the root stored or not
+func writeOtherTestGenesis(t *testing.T, dir string, storeRoot bool) {
+	conf := viper.New()
+	conf.Set("db_dir", dir)
+	app, err := NewEVMApp(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Stop()
+	other := core.DefaultGenesis()
+	other.Alloc[common.HexToAddress("0x01")] = core.GenesisAccount{Balance: big.NewInt(1)}
+	root := other.ToBlock(app.stateDb).Root()
+	if storeRoot {
+		if err := app.stateDb.Put(GenesisRootKey, root.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.saveLastBlock(LastBlockInfo{Height: 0, AppHash: root.Bytes()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenesisRootCheck(t *testing.T) {
+	for _, storeRoot := range []bool{true, false} {
+		dir, err := ioutil.TempDir("", "evm-app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		writeOtherTestGenesis(t, dir, storeRoot)
+		if _, err := startTestApp(dir, viper.New()); err == nil || !strings.Contains(err.Error(), "genesis root") {
+			t.Fatalf("expected the database of another genesis refused, got %v", err)
+		}
+		conf := viper.New()
+		conf.Set("genesis_root_check", false)
+		app, err := startTestApp(dir, conf)
+		if err != nil {
+			t.Fatalf("expected the check skipped, got %v", err)
+		}
+		app.Stop()
+	}
+
+	// a database of the app genesis initialized before the root was stored has
+	// it backfilled
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	app, err := startTestApp(dir, viper.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.stateDb.Delete(GenesisRootKey); err != nil {
+		t.Fatal(err)
+	}
+	app.Stop()
+	if app, err = startTestApp(dir, viper.New()); err != nil {
+		t.Fatalf("expected the database of the app genesis to start, got %v", err)
+	}
+	defer app.Stop()
+	if value, err := app.stateDb.Get(GenesisRootKey); err != nil || common.BytesToHash(value) != genesisRoot() {
+		t.Fatalf("expected the genesis root backfilled, got %x %v", value, err)
+	}
+}
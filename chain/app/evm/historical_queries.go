@@ -0,0 +1,52 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var errServerBusy = errors.New("server busy, too many historical queries")
+
+// historicalState opens the state committed at height with the header of the
+// next block, which carries its app hash. The caller holds a historical slot.
+func (app *EVMApp) historicalState(height uint64) (*estate.StateDB, *gtypes.Header, error) {
+	trieRoot, header, err := app.historicalRoot(height)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := estate.New(trieRoot, estate.NewDatabase(app.stateDb))
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, header, nil
+}
+
+// historicalRoot returns the state root committed at height with the header of
+// the next block, which carries it as its app hash.
+func (app *EVMApp) historicalRoot(height uint64) (common.Hash, *gtypes.Header, error) {
+	blockMeta, err := app.core.GetBlockMeta(int64(height + 1))
+	if err != nil {
+		return common.Hash{}, nil, err
+	}
+	trieRoot := EmptyTrieRoot
+	if len(blockMeta.Header.AppHash) > 0 {
+		trieRoot = common.BytesToHash(blockMeta.Header.AppHash)
+	}
+	return trieRoot, blockMeta.Header, nil
+}
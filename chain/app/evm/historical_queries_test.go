@@ -0,0 +1,82 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// blockingCore holds GetBlockMeta calls until release is closed
+type blockingCore struct {
+	testCore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingCore) GetBlockMeta(height int64) (*gtypes.BlockMeta, error) {
+	c.entered <- struct{}{}
+	<-c.release
+	return c.testCore.GetBlockMeta(height)
+}
+
+func TestHistoricalQueryLimit(t *testing.T) {
+	conf := viper.New()
+	conf.Set("historical_query_limit", 2)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	core := &blockingCore{entered: make(chan struct{}), release: make(chan struct{})}
+	app.SetCore(core)
+	execTestBlock(t, app, 1)
+
+	key, _ := testKey(t, testKeyA)
+	raw := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), nil))
+	query := append([]byte{rtypes.QueryTypeContractByHeight}, raw...)
+	query = append(query, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(query[len(query)-8:], 1)
+
+	results := make(chan gtypes.Result, 2)
+	for i := 0; i < 2; i++ {
+		go func() { results <- app.Query(query) }()
+		<-core.entered
+	}
+	for i := 0; i < 2; i++ {
+		if res := app.Query(query); res.Code != gtypes.CodeType_ServerBusy {
+			t.Fatalf("expected historical query over the limit to be busy, got %+v", res)
+		}
+	}
+	// current state queries don't take a slot
+	if res := app.Query(append([]byte{rtypes.QueryType_Contract}, raw...)); res.IsErr() {
+		t.Fatal(res.Log)
+	}
+
+	close(core.release)
+	for i := 0; i < 2; i++ {
+		if res := <-results; res.Code == gtypes.CodeType_ServerBusy {
+			t.Fatalf("unexpected busy result of a running query %+v", res)
+		}
+	}
+	go func() { <-core.entered }()
+	if res := app.Query(query); res.Code == gtypes.CodeType_ServerBusy {
+		t.Fatalf("expected released slots to be reused, got %+v", res)
+	}
+}
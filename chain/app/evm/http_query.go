@@ -0,0 +1,450 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const (
+	httpQueryMaxBody      = 1 << 20
+	httpQueryMaxHeader    = 1 << 16
+	httpQueryReadTimeout  = 5 * time.Second
+	httpQueryWriteTimeout = 10 * time.Second
+)
+
+// httpQueryEndpoint maps an http endpoint onto a Query action. load builds the
+// action payload from the request and result converts the result data to a json
+// value, the query itself always goes through app.Query. accounts, when set,
+// lists the accounts a payload queries for the query acl, the endpoints without
+// it are closed to the clients limited to addresses unless open marks them as
+// answering no account data. list marks the actions answering a list, they're
+// queried a page at a time from the token parameter.
+type httpQueryEndpoint struct {
+	method   string
+	action   rtypes.QueryType
+	load     func(r *http.Request, body []byte) ([]byte, error)
+	result   func(data []byte) (interface{}, error)
+	accounts func(app *EVMApp, load []byte) ([][]common.Address, error)
+	open     bool
+	list     bool
+}
+
+// httpQueryPage is the json answer of the list endpoints, next is the token of
+// the following page, absent once the list is complete.
+type httpQueryPage struct {
+	Items interface{}   `json:"items"`
+	Next  hexutil.Bytes `json:"next,omitempty"`
+}
+
+// httpQueryEndpoints, all answers are json:
+//
+//	GET  /nonce?address=0x..                  nonce as 0x hex
+//	GET  /balance?address=0x..[&address=0x..] balances as 0x hex, in address order
+//	GET  /receipt?hash=0x..                   receipt of the tx
+//	GET  /txstatus?hash=0x..                  tx status
+//	GET  /commitstats[?token=0x..]            commit stats of the latest blocks, a page
+//	GET  /touched?height=N[&token=0x..]       accounts modified by the block, a page
+//	POST /decodetx                            canonical json of the 0x hex raw tx in the body
+//	GET  /logs?[from=N][&to=N][&address=0x..][&topic=0x..,0x..][&token=0x..]
+//	                                          logs of the blocks from to to included, a page
+//
+// The addresses of /logs are alternatives, any address without them. Each topic
+// parameter is the comma separated alternatives of the topic at its position,
+// an empty one matching any topic; to 0, or absent, is the committed height.
+//
+// The pages are {"items": [..], "next": "0x.."}, the next page is queried with
+// the token next until an answer comes without it.
+//
+// GET /status answers the last block height and app hash, as Info does, whether
+// the app is still catching up with the core and whether the database warmup is
+// done.
+var httpQueryEndpoints = map[string]*httpQueryEndpoint{
+	"/nonce": {
+		method: http.MethodGet,
+		action: rtypes.QueryType_Nonce,
+		load: func(r *http.Request, _ []byte) ([]byte, error) {
+			addr, err := httpQueryAddress(r.URL.Query().Get("address"))
+			if err != nil {
+				return nil, err
+			}
+			return addr[:], nil
+		},
+		result: func(data []byte) (interface{}, error) {
+			var nonce uint64
+			err := rlp.DecodeBytes(data, &nonce)
+			return hexutil.Uint64(nonce), err
+		},
+		accounts: httpQueryLoadAccounts,
+	},
+	"/balance": {
+		method: http.MethodGet,
+		action: rtypes.QueryType_BalancesBatch,
+		load: func(r *http.Request, _ []byte) ([]byte, error) {
+			values := r.URL.Query()["address"]
+			if len(values) == 0 {
+				return nil, fmt.Errorf("missing address")
+			}
+			addrs := make([]common.Address, len(values))
+			for i, value := range values {
+				addr, err := httpQueryAddress(value)
+				if err != nil {
+					return nil, err
+				}
+				addrs[i] = addr
+			}
+			return rlp.EncodeToBytes(addrs)
+		},
+		result: func(data []byte) (interface{}, error) {
+			var balances []*big.Int
+			if err := rlp.DecodeBytes(data, &balances); err != nil {
+				return nil, err
+			}
+			res := make([]*hexutil.Big, len(balances))
+			for i, balance := range balances {
+				res[i] = (*hexutil.Big)(balance)
+			}
+			return res, nil
+		},
+		accounts: httpQueryLoadAccounts,
+	},
+	"/receipt": {
+		method: http.MethodGet,
+		action: rtypes.QueryType_Receipt,
+		load:   httpQueryHashLoad,
+		result: func(data []byte) (interface{}, error) {
+			receipt := &etypes.ReceiptForStorage{}
+			err := rlp.DecodeBytes(data, receipt)
+			return (*etypes.Receipt)(receipt), err
+		},
+		accounts: httpQueryTxAccounts,
+	},
+	"/txstatus": {
+		method: http.MethodGet,
+		action: rtypes.QueryType_TxStatus,
+		load:   httpQueryHashLoad,
+		result: func(data []byte) (interface{}, error) {
+			status := &rtypes.TxStatus{}
+			err := rlp.DecodeBytes(data, status)
+			return status, err
+		},
+		accounts: httpQueryTxAccounts,
+	},
+	"/commitstats": {
+		method: http.MethodGet,
+		action: rtypes.QueryType_CommitStats,
+		load:   func(*http.Request, []byte) ([]byte, error) { return nil, nil },
+		result: func(data []byte) (interface{}, error) {
+			stats := make([]rtypes.CommitStats, 0)
+			page, err := decodePage(data, &stats)
+			if err != nil {
+				return nil, err
+			}
+			return &httpQueryPage{stats, page.Next}, nil
+		},
+		open: true,
+		list: true,
+	},
+	"/touched": {
+		method: http.MethodGet,
+		action: rtypes.QueryType_BlockTouchedAccounts,
+		load: func(r *http.Request, _ []byte) ([]byte, error) {
+			height, err := strconv.ParseUint(r.URL.Query().Get("height"), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid height")
+			}
+			load := make([]byte, 8)
+			binary.BigEndian.PutUint64(load, height)
+			return load, nil
+		},
+		result: func(data []byte) (interface{}, error) {
+			addrs := make([]common.Address, 0)
+			page, err := decodePage(data, &addrs)
+			if err != nil {
+				return nil, err
+			}
+			return &httpQueryPage{addrs, page.Next}, nil
+		},
+		list: true,
+	},
+	"/decodetx": {
+		method: http.MethodPost,
+		action: rtypes.QueryType_DecodeTx,
+		load: func(_ *http.Request, body []byte) ([]byte, error) {
+			var raw hexutil.Bytes
+			if err := raw.UnmarshalText(bytes.TrimSpace(body)); err != nil {
+				return nil, err
+			}
+			return raw, nil
+		},
+		result: func(data []byte) (interface{}, error) {
+			return json.RawMessage(data), nil
+		},
+		accounts: httpQueryRawTxAccounts,
+	},
+	"/logs": {
+		method: http.MethodGet,
+		action: rtypes.QueryType_Logs,
+		load:   httpQueryLogsLoad,
+		result: func(data []byte) (interface{}, error) {
+			records := make([]*rtypes.LogRecord, 0)
+			page, err := decodePage(data, &records)
+			if err != nil {
+				return nil, err
+			}
+			logs := make([]*etypes.Log, len(records))
+			for i, record := range records {
+				logs[i] = &etypes.Log{
+					Address:     record.Address,
+					Topics:      record.Topics,
+					Data:        record.Data,
+					BlockNumber: record.Height,
+					TxHash:      record.TxHash,
+					TxIndex:     uint(record.TxIndex),
+					BlockHash:   record.BlockHash,
+					Index:       uint(record.LogIndex),
+				}
+			}
+			return &httpQueryPage{logs, page.Next}, nil
+		},
+		accounts: httpQueryLogsAccounts,
+		list:     true,
+	},
+}
+
+func httpQueryAddress(value string) (common.Address, error) {
+	var addr common.Address
+	if err := addr.UnmarshalText([]byte(value)); err != nil {
+		return addr, fmt.Errorf("invalid address %q", value)
+	}
+	return addr, nil
+}
+
+func httpQueryHashLoad(r *http.Request, _ []byte) ([]byte, error) {
+	var hash common.Hash
+	if err := hash.UnmarshalText([]byte(r.URL.Query().Get("hash"))); err != nil {
+		return nil, fmt.Errorf("invalid hash")
+	}
+	return hash[:], nil
+}
+
+// httpQueryLogsLoad is the rtypes.LogsQuery of the parameters of /logs
+func httpQueryLogsLoad(r *http.Request, _ []byte) ([]byte, error) {
+	params := r.URL.Query()
+	query := &rtypes.LogsQuery{}
+	for name, block := range map[string]*uint64{"from": &query.FromBlock, "to": &query.ToBlock} {
+		if value := params.Get(name); value != "" {
+			height, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s", name)
+			}
+			*block = height
+		}
+	}
+	for _, value := range params["address"] {
+		addr, err := httpQueryAddress(value)
+		if err != nil {
+			return nil, err
+		}
+		query.Addresses = append(query.Addresses, addr)
+	}
+	for _, value := range params["topic"] {
+		var topics []common.Hash
+		if value != "" {
+			for _, alt := range strings.Split(value, ",") {
+				var topic common.Hash
+				if err := topic.UnmarshalText([]byte(alt)); err != nil {
+					return nil, fmt.Errorf("invalid topic %q", alt)
+				}
+				topics = append(topics, topic)
+			}
+		}
+		query.Topics = append(query.Topics, topics)
+	}
+	return rlp.EncodeToBytes(query)
+}
+
+// httpQueryPageLoad is the QueryType_Page query of the page of the list action
+// answers with load from the token parameter of the request, the first page
+// without it.
+func httpQueryPageLoad(r *http.Request, action rtypes.QueryType, load []byte) ([]byte, error) {
+	var token hexutil.Bytes
+	if value := r.URL.Query().Get("token"); value != "" {
+		if err := token.UnmarshalText([]byte(value)); err != nil {
+			return nil, fmt.Errorf("invalid token")
+		}
+	}
+	query, err := rlp.EncodeToBytes(&rtypes.PageQuery{Query: action, Load: load, Token: token})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{rtypes.QueryType_Page}, query...), nil
+}
+
+// httpQueryError is the json answer of failed queries, code is the Query result code
+type httpQueryError struct {
+	Code gtypes.CodeType `json:"code"`
+	Log  string          `json:"log"`
+}
+
+type httpQueryStatus struct {
+	Height  hexutil.Uint64 `json:"height"`
+	AppHash hexutil.Bytes  `json:"appHash"`
+	Syncing bool           `json:"syncing"`
+	Target  hexutil.Uint64 `json:"target"` // latest block height stored by the core
+	Ready   bool           `json:"ready"`  // the database warmup is done, or off
+	Warmed  hexutil.Uint64 `json:"warmed"` // bytes read by the warmup
+}
+
+func writeHTTPQuery(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(&httpQueryError{gtypes.CodeType_InternalError, err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func writeHTTPQueryError(w http.ResponseWriter, code gtypes.CodeType, msg string) {
+	status := http.StatusInternalServerError
+	switch code {
+	case gtypes.CodeType_BaseInvalidInput:
+		status = http.StatusBadRequest
+	case gtypes.CodeType_Syncing, gtypes.CodeType_ServerBusy:
+		status = http.StatusServiceUnavailable
+	}
+	writeHTTPQuery(w, status, &httpQueryError{code, msg})
+}
+
+func (app *EVMApp) serveHTTPQuery(path string, ep *httpQueryEndpoint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != ep.method {
+			w.Header().Set("Allow", ep.method)
+			writeHTTPQueryError(w, gtypes.CodeType_BaseInvalidInput, "method not allowed")
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, httpQueryMaxBody))
+		if err != nil {
+			writeHTTPQueryError(w, gtypes.CodeType_BaseInvalidInput, err.Error())
+			return
+		}
+		var client *httpQueryClient
+		if app.queryACL != nil {
+			if client, err = app.queryACL.authenticate(r, body); err != nil {
+				writeHTTPQueryDenied(w, err)
+				return
+			}
+		}
+		load, err := ep.load(r, body)
+		if err != nil {
+			writeHTTPQueryError(w, gtypes.CodeType_BaseInvalidInput, err.Error())
+			return
+		}
+		if client != nil {
+			if err := app.authorizeHTTPQuery(client, path, ep, load); err != nil {
+				writeHTTPQueryDenied(w, err)
+				return
+			}
+		}
+		query := append([]byte{ep.action}, load...)
+		if ep.list {
+			if query, err = httpQueryPageLoad(r, ep.action, load); err != nil {
+				writeHTTPQueryError(w, gtypes.CodeType_BaseInvalidInput, err.Error())
+				return
+			}
+		}
+		res := app.Query(query)
+		if res.IsErr() {
+			writeHTTPQueryError(w, res.Code, res.Log)
+			return
+		}
+		value, err := ep.result(res.Data)
+		if err != nil {
+			writeHTTPQueryError(w, gtypes.CodeType_InternalError, err.Error())
+			return
+		}
+		writeHTTPQuery(w, http.StatusOK, value)
+	}
+}
+
+func (app *EVMApp) serveHTTPStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeHTTPQueryError(w, gtypes.CodeType_BaseInvalidInput, "method not allowed")
+		return
+	}
+	info := app.Info()
+	sync := app.syncStatus()
+	writeHTTPQuery(w, http.StatusOK, &httpQueryStatus{
+		Height:  hexutil.Uint64(info.LastBlockHeight),
+		AppHash: info.LastBlockAppHash,
+		Syncing: sync.Syncing,
+		Target:  hexutil.Uint64(sync.Target),
+		Ready:   app.warmer.Ready(),
+		Warmed:  hexutil.Uint64(app.warmer.WarmedBytes()),
+	})
+}
+
+func (app *EVMApp) httpQueryHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", app.serveHTTPStatus)
+	for path, ep := range httpQueryEndpoints {
+		mux.Handle(path, app.serveHTTPQuery(path, ep))
+	}
+	return mux
+}
+
+// startHTTPQuery serves the query endpoints on laddr. Without query_acl_file the
+// endpoints have no authentication, bind them to a trusted interface.
+func (app *EVMApp) startHTTPQuery(laddr string) error {
+	listener, err := net.Listen("tcp", laddr)
+	if err != nil {
+		return err
+	}
+	app.httpQuery = &http.Server{
+		Handler:        app.httpQueryHandler(),
+		ReadTimeout:    httpQueryReadTimeout,
+		WriteTimeout:   httpQueryWriteTimeout,
+		MaxHeaderBytes: httpQueryMaxHeader,
+	}
+	go func(srv *http.Server) {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("http query server stopped", zap.Error(err))
+		}
+	}(app.httpQuery)
+	log.Info("http query server started", zap.String("laddr", listener.Addr().String()))
+	return nil
+}
@@ -0,0 +1,412 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// The headers authenticating an http query under query_acl_file. The signature
+// is the hex HMAC-SHA256, keyed by a secret of the client, of the method, the
+// request uri, the time, the nonce and the body, each followed by a newline.
+const (
+	HTTPQueryClientHeader    = "X-Query-Client"
+	HTTPQueryTimeHeader      = "X-Query-Time"  // unix seconds
+	HTTPQueryNonceHeader     = "X-Query-Nonce" // unique among the requests of the client, up to 64 bytes
+	HTTPQuerySignatureHeader = "X-Query-Signature"
+)
+
+const (
+	httpQueryACLSkew     = 5 * time.Minute // max distance of a request time to the node clock
+	httpQueryACLCheck    = time.Second     // min time between two checks of the policies file
+	httpQueryACLMaxNonce = 64
+	// max nonces of a client remembered, the requests beyond wait for the
+	// oldest to leave the skew window
+	httpQueryACLMaxNonces = 100000
+)
+
+var (
+	errQueryUnauthenticated = errors.New("request not authenticated")
+	errQueryRateLimited     = errors.New("rate limit exceeded")
+)
+
+// writeHTTPQueryDenied answers a request the acl refused
+func writeHTTPQueryDenied(w http.ResponseWriter, err error) {
+	status := http.StatusForbidden
+	switch err {
+	case errQueryUnauthenticated:
+		status = http.StatusUnauthorized
+	case errQueryRateLimited:
+		status = http.StatusTooManyRequests
+	}
+	writeHTTPQuery(w, status, &httpQueryError{gtypes.CodeType_Unauthorized, err.Error()})
+}
+
+// HTTPQueryPolicy is what a client of the http query endpoints may do. The
+// query_acl_file is a json object of the policies by client name.
+type HTTPQueryPolicy struct {
+	// secrets signing the requests of the client, several of them while one
+	// is rotated
+	Secrets []string `json:"secrets"`
+	// endpoints the client may query, eg. "/balance", empty for all of them
+	Actions []string `json:"actions"`
+	// accounts the endpoints about accounts or txs may be queried for, the
+	// sender or the recipient of a tx, empty for all of them. The endpoints
+	// listing other accounts, eg. /touched, are closed to the clients with
+	// addresses.
+	Addresses []common.Address `json:"addresses"`
+	// requests per second, with bursts of as many but at least 1, 0 for no
+	// limit
+	Rate float64 `json:"rate"`
+}
+
+func (p *HTTPQueryPolicy) burst() float64 {
+	return math.Max(p.Rate, 1)
+}
+
+type httpQueryClient struct {
+	policy    HTTPQueryPolicy
+	actions   map[string]bool
+	addresses map[common.Address]bool
+	tokens    float64
+	refilled  time.Time
+}
+
+// httpQueryACL authenticates the requests of the http query endpoints and checks
+// them against the policy of their client. The policies file is read again once
+// it changed, so clients and secrets come and go without a restart; a file
+// failing to load leaves the previous policies in force. Queries made in the
+// process, through Query, don't go through it.
+type httpQueryACL struct {
+	path string
+	now  func() time.Time
+
+	mtx     sync.Mutex
+	clients map[string]*httpQueryClient
+	modTime time.Time
+	size    int64
+	checked time.Time
+	// nonces seen with the unix time their request expires at, a replay
+	// within the skew window is refused and a later one by its time
+	nonces      map[httpQueryNonce]int64
+	nonceCounts map[string]int // nonces remembered by client
+	maxNonces   int
+	pruned      time.Time
+}
+
+type httpQueryNonce struct {
+	client string
+	nonce  string
+}
+
+func newHTTPQueryACL(path string) (*httpQueryACL, error) {
+	acl := &httpQueryACL{path: path, now: time.Now, nonces: make(map[httpQueryNonce]int64), nonceCounts: make(map[string]int), maxNonces: httpQueryACLMaxNonces}
+	if err := acl.load(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// load reads the policies file, keeping the rate limit state of the clients
+// whose rate didn't change.
+func (acl *httpQueryACL) load() error {
+	info, err := os.Stat(acl.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(acl.path)
+	if err != nil {
+		return err
+	}
+	var policies map[string]HTTPQueryPolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return fmt.Errorf("invalid query acl %s: %v", acl.path, err)
+	}
+	clients := make(map[string]*httpQueryClient, len(policies))
+	for name, policy := range policies {
+		if len(policy.Secrets) == 0 {
+			return fmt.Errorf("invalid query acl %s: no secret for client %q", acl.path, name)
+		}
+		client := &httpQueryClient{
+			policy:   policy,
+			tokens:   policy.burst(),
+			refilled: acl.now(),
+		}
+		if len(policy.Actions) > 0 {
+			client.actions = make(map[string]bool)
+			for _, action := range policy.Actions {
+				client.actions[action] = true
+			}
+		}
+		if len(policy.Addresses) > 0 {
+			client.addresses = make(map[common.Address]bool)
+			for _, addr := range policy.Addresses {
+				client.addresses[addr] = true
+			}
+		}
+		if prev, ok := acl.clients[name]; ok && prev.policy.Rate == policy.Rate {
+			client.tokens, client.refilled = prev.tokens, prev.refilled
+		}
+		clients[name] = client
+	}
+	acl.clients, acl.modTime, acl.size = clients, info.ModTime(), info.Size()
+	return nil
+}
+
+// reload loads the policies file again when it changed since the last load,
+// checking it at most once per httpQueryACLCheck. The caller holds acl.mtx.
+func (acl *httpQueryACL) reload() {
+	now := acl.now()
+	if now.Sub(acl.checked) < httpQueryACLCheck {
+		return
+	}
+	acl.checked = now
+	info, err := os.Stat(acl.path)
+	if err != nil {
+		log.Warn("query acl unreadable, previous policies kept", zap.Error(err))
+		return
+	}
+	if info.ModTime().Equal(acl.modTime) && info.Size() == acl.size {
+		return
+	}
+	if err := acl.load(); err != nil {
+		log.Warn("query acl not reloaded, previous policies kept", zap.Error(err))
+		return
+	}
+	log.Info("query acl reloaded", zap.String("path", acl.path), zap.Int("clients", len(acl.clients)))
+}
+
+// authenticate returns the client signing r, counting the request against the
+// rate of the client. A nonce is accepted once.
+func (acl *httpQueryACL) authenticate(r *http.Request, body []byte) (*httpQueryClient, error) {
+	name, nonce := r.Header.Get(HTTPQueryClientHeader), r.Header.Get(HTTPQueryNonceHeader)
+	signature, err := hex.DecodeString(r.Header.Get(HTTPQuerySignatureHeader))
+	if err != nil || name == "" || nonce == "" || len(nonce) > httpQueryACLMaxNonce {
+		return nil, errQueryUnauthenticated
+	}
+	sec, err := strconv.ParseInt(r.Header.Get(HTTPQueryTimeHeader), 10, 64)
+	if err != nil {
+		return nil, errQueryUnauthenticated
+	}
+
+	acl.mtx.Lock()
+	defer acl.mtx.Unlock()
+	acl.reload()
+	now := acl.now()
+	if skew := now.Sub(time.Unix(sec, 0)); skew > httpQueryACLSkew || skew < -httpQueryACLSkew {
+		return nil, errQueryUnauthenticated
+	}
+	client, ok := acl.clients[name]
+	if !ok {
+		return nil, errQueryUnauthenticated
+	}
+	signed := false
+	for _, secret := range client.policy.Secrets {
+		if hmac.Equal(signature, SignHTTPQuery(secret, r.Method, r.URL.RequestURI(), sec, nonce, body)) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return nil, errQueryUnauthenticated
+	}
+	acl.pruneNonces(now, httpQueryACLSkew)
+	seen := httpQueryNonce{name, nonce}
+	if _, ok := acl.nonces[seen]; ok {
+		return nil, errQueryUnauthenticated
+	}
+	if acl.nonceCounts[name] >= acl.maxNonces {
+		if acl.pruneNonces(now, time.Second); acl.nonceCounts[name] >= acl.maxNonces {
+			return nil, errQueryRateLimited
+		}
+	}
+	acl.nonces[seen] = sec + int64(httpQueryACLSkew/time.Second)
+	acl.nonceCounts[name]++
+	if rate := client.policy.Rate; rate > 0 {
+		client.tokens = math.Min(client.policy.burst(), client.tokens+now.Sub(client.refilled).Seconds()*rate)
+		client.refilled = now
+		if client.tokens < 1 {
+			return nil, errQueryRateLimited
+		}
+		client.tokens--
+	}
+	return client, nil
+}
+
+// pruneNonces forgets the nonces of the requests whose time is out of the skew
+// window, at most once per interval. The caller holds acl.mtx.
+func (acl *httpQueryACL) pruneNonces(now time.Time, interval time.Duration) {
+	if now.Sub(acl.pruned) < interval {
+		return
+	}
+	acl.pruned = now
+	for seen, expires := range acl.nonces {
+		if expires < now.Unix() {
+			delete(acl.nonces, seen)
+			if acl.nonceCounts[seen.client]--; acl.nonceCounts[seen.client] <= 0 {
+				delete(acl.nonceCounts, seen.client)
+			}
+		}
+	}
+}
+
+// SignHTTPQuery returns the signature of an http query request, uri is the path
+// with the query string, sec the unix time sent in HTTPQueryTimeHeader and nonce
+// the one sent in HTTPQueryNonceHeader.
+func SignHTTPQuery(secret, method, uri string, sec int64, nonce string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s\n", method, uri, sec, nonce)
+	mac.Write(body)
+	mac.Write([]byte("\n"))
+	return mac.Sum(nil)
+}
+
+// allows tells whether the client may query action about accounts, one of each
+// group of accounts must be in its addresses.
+func (c *httpQueryClient) allows(action string, accounts [][]common.Address) error {
+	if c.actions != nil && !c.actions[action] {
+		return fmt.Errorf("%s not allowed", action)
+	}
+	if c.addresses == nil {
+		return nil
+	}
+	for _, group := range accounts {
+		allowed := false
+		for _, addr := range group {
+			allowed = allowed || c.addresses[addr]
+		}
+		if !allowed {
+			return fmt.Errorf("%s not allowed for %s", action, group[0].Hex())
+		}
+	}
+	return nil
+}
+
+// authorizeHTTPQuery checks the client may query the endpoint at path with load
+func (app *EVMApp) authorizeHTTPQuery(client *httpQueryClient, path string, ep *httpQueryEndpoint, load []byte) error {
+	var accounts [][]common.Address
+	if client.addresses != nil && !ep.open {
+		if ep.accounts == nil {
+			return fmt.Errorf("%s not allowed to a client limited to addresses", path)
+		}
+		var err error
+		if accounts, err = ep.accounts(app, load); err != nil {
+			return err
+		}
+	}
+	return client.allows(path, accounts)
+}
+
+// httpQueryLoadAccounts are the accounts the nonce and balance endpoints are
+// queried for, each a group of its own
+func httpQueryLoadAccounts(app *EVMApp, load []byte) ([][]common.Address, error) {
+	addrs := []common.Address{common.BytesToAddress(load)}
+	if len(load) != common.AddressLength {
+		if err := rlp.DecodeBytes(load, &addrs); err != nil {
+			return nil, err
+		}
+	}
+	accounts := make([][]common.Address, len(addrs))
+	for i, addr := range addrs {
+		accounts[i] = []common.Address{addr}
+	}
+	return accounts, nil
+}
+
+// httpQueryLogsAccounts are the addresses the logs query load filters on, each a
+// group of its own. The logs of any address are closed to the clients limited
+// to addresses.
+func httpQueryLogsAccounts(app *EVMApp, load []byte) ([][]common.Address, error) {
+	query := &rtypes.LogsQuery{}
+	if err := rlp.DecodeBytes(load, query); err != nil {
+		return nil, err
+	}
+	if len(query.Addresses) == 0 {
+		return nil, fmt.Errorf("logs of any address not allowed to a client limited to addresses")
+	}
+	accounts := make([][]common.Address, len(query.Addresses))
+	for i, addr := range query.Addresses {
+		accounts[i] = []common.Address{addr}
+	}
+	return accounts, nil
+}
+
+// httpQueryTxAccounts is the sender and the recipient of the tx whose hash is
+// load, the created contract for a creation. They're the ones the tx status
+// records for the txs the pool accepted, committed or not, and the committed
+// tx's for the others.
+func httpQueryTxAccounts(app *EVMApp, load []byte) ([][]common.Address, error) {
+	if accounts := app.txStatus.accounts(common.BytesToHash(load)); len(accounts) > 0 {
+		return [][]common.Address{accounts}, nil
+	}
+	res := app.Query(append([]byte{rtypes.QueryType_RawTx}, load...))
+	if res.IsErr() {
+		return nil, fmt.Errorf("unknown tx")
+	}
+	tx := &etypes.Transaction{}
+	if err := rlp.DecodeBytes(res.Data, tx); err != nil {
+		return nil, err
+	}
+	from, err := app.senders.sender(app.Signer, tx)
+	if err != nil {
+		return nil, err
+	}
+	if tx.To() != nil {
+		return [][]common.Address{{from, *tx.To()}}, nil
+	}
+	receipt, err := app.GetReceipt(tx.Hash())
+	if err != nil {
+		return nil, err
+	}
+	return [][]common.Address{{from, receipt.ContractAddress}}, nil
+}
+
+// httpQueryRawTxAccounts is the sender and the recipient of the raw tx load, the
+// contract it would create for a creation.
+func httpQueryRawTxAccounts(app *EVMApp, load []byte) ([][]common.Address, error) {
+	tx := &etypes.Transaction{}
+	if err := rlp.DecodeBytes(load, tx); err != nil {
+		return nil, err
+	}
+	from, err := etypes.Sender(app.Signer, tx)
+	if err != nil {
+		return nil, err
+	}
+	if tx.To() != nil {
+		return [][]common.Address{{from, *tx.To()}}, nil
+	}
+	return [][]common.Address{{from, crypto.CreateAddress(from, tx.Nonce())}}, nil
+}
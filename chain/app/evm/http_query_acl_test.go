@@ -0,0 +1,324 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func writeTestQueryACL(t *testing.T, path string, policies map[string]HTTPQueryPolicy) {
+	data, err := json.Marshal(policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var httpTestNonces uint64
+
+// httpTestACLQuery sends a GET of uri signed by client with secret under a new
+// nonce, and returns the status answered
+func httpTestACLQuery(t *testing.T, srv *httptest.Server, client, secret, uri string) int {
+	return httpTestACLQueryNonce(t, srv, client, secret, uri, strconv.FormatUint(atomic.AddUint64(&httpTestNonces, 1), 10))
+}
+
+func httpTestACLQueryNonce(t *testing.T, srv *httptest.Server, client, secret, uri, nonce string) int {
+	return httpTestACLRequest(t, srv, client, secret, http.MethodGet, uri, nonce, nil)
+}
+
+// httpTestACLPost sends a POST of body to uri signed by client with secret under
+// a new nonce, and returns the status answered
+func httpTestACLPost(t *testing.T, srv *httptest.Server, client, secret, uri string, body []byte) int {
+	return httpTestACLRequest(t, srv, client, secret, http.MethodPost, uri, strconv.FormatUint(atomic.AddUint64(&httpTestNonces, 1), 10), body)
+}
+
+func httpTestACLRequest(t *testing.T, srv *httptest.Server, client, secret, method, uri, nonce string, body []byte) int {
+	req, err := http.NewRequest(method, srv.URL+uri, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec := time.Now().Unix()
+	req.Header.Set(HTTPQueryClientHeader, client)
+	req.Header.Set(HTTPQueryTimeHeader, strconv.FormatInt(sec, 10))
+	req.Header.Set(HTTPQueryNonceHeader, nonce)
+	req.Header.Set(HTTPQuerySignatureHeader, hex.EncodeToString(SignHTTPQuery(secret, method, uri, sec, nonce, body)))
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var res httpQueryError
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || res.Code == 0 {
+			t.Fatalf("expected an error answer, got %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// newTestACLApp starts an app with a committed transfer from A to B and its
+// http query endpoints under the acl of path
+func newTestACLApp(t *testing.T, path string) (*EVMApp, *httptest.Server, common.Hash, func()) {
+	conf := viper.New()
+	conf.Set("query_acl_file", path)
+	app, clean := newTestAppWithConfig(t, conf)
+	keyA, addrA := testKey(t, testKeyA)
+	_, addrB := testKey(t, testKeyB)
+	fundTestAccounts(t, app, big.NewInt(1000), addrA)
+	raw := signTestTx(t, keyA, etypes.NewTransaction(0, addrB, big.NewInt(10), testGas, big.NewInt(0), nil))
+	execTestBlock(t, app, 1, raw)
+	hash := txHash(raw)
+	app.SetCore(&testCore{height: 1, txs: map[common.Hash][]byte{hash: raw}})
+	srv := httptest.NewServer(app.httpQueryHandler())
+	return app, srv, hash, func() {
+		srv.Close()
+		clean()
+	}
+}
+
+func TestHTTPQueryACL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "query-acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "acl.json")
+	_, addrA := testKey(t, testKeyA)
+	_, addrB := testKey(t, testKeyB)
+	other := common.HexToAddress("0x1234")
+	writeTestQueryACL(t, path, map[string]HTTPQueryPolicy{
+		"partner-a": {Secrets: []string{"secret-a"}, Actions: []string{"/balance", "/receipt"}, Addresses: []common.Address{addrB}},
+		"partner-b": {Secrets: []string{"secret-b"}, Actions: []string{"/nonce"}, Rate: 1},
+		"operator":  {Secrets: []string{"secret-o"}},
+		"scoped":    {Secrets: []string{"secret-s"}, Addresses: []common.Address{addrB}},
+	})
+	app, srv, hash, clean := newTestACLApp(t, path)
+	defer clean()
+
+	// authentication
+	if status := httpTestACLQuery(t, srv, "operator", "wrong", "/nonce?address="+addrA.Hex()); status != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong signature to be refused, got %d", status)
+	}
+	if status := httpTestACLQuery(t, srv, "nobody", "secret-o", "/nonce?address="+addrA.Hex()); status != http.StatusUnauthorized {
+		t.Fatalf("expected an unknown client to be refused, got %d", status)
+	}
+	var nonce interface{}
+	httpTestQuery(t, srv, http.MethodGet, "/nonce?address="+addrA.Hex(), "", http.StatusUnauthorized, &nonce)
+	if status := httpTestACLQuery(t, srv, "operator", "secret-o", "/nonce?address="+addrA.Hex()); status != http.StatusOK {
+		t.Fatalf("expected a client without restrictions to be answered, got %d", status)
+	}
+
+	// a nonce is accepted once per client, a request without one not at all
+	if status := httpTestACLQueryNonce(t, srv, "operator", "secret-o", "/nonce?address="+addrA.Hex(), ""); status != http.StatusUnauthorized {
+		t.Fatalf("expected a request without nonce to be refused, got %d", status)
+	}
+	if status := httpTestACLQueryNonce(t, srv, "operator", "secret-o", "/nonce?address="+addrA.Hex(), "replayed"); status != http.StatusOK {
+		t.Fatalf("expected a new nonce to be answered, got %d", status)
+	}
+	if status := httpTestACLQueryNonce(t, srv, "operator", "secret-o", "/nonce?address="+addrA.Hex(), "replayed"); status != http.StatusUnauthorized {
+		t.Fatalf("expected a replayed nonce to be refused, got %d", status)
+	}
+	if status := httpTestACLQueryNonce(t, srv, "partner-a", "secret-a", "/balance?address="+addrB.Hex(), "replayed"); status != http.StatusOK {
+		t.Fatalf("expected the nonce of another client to be answered, got %d", status)
+	}
+	// the nonces are forgotten once their requests are out of the skew window
+	app.queryACL.mtx.Lock()
+	app.queryACL.pruneNonces(time.Now().Add(2*httpQueryACLSkew), httpQueryACLSkew)
+	nonces, counts := len(app.queryACL.nonces), len(app.queryACL.nonceCounts)
+	app.queryACL.mtx.Unlock()
+	if nonces != 0 || counts != 0 {
+		t.Fatalf("expected the nonces out of the window forgotten, %d left of %d clients", nonces, counts)
+	}
+
+	// a client keeps up to maxNonces nonces in the window, the requests beyond
+	// wait for the oldest to leave it
+	app.queryACL.mtx.Lock()
+	app.queryACL.maxNonces = 2
+	app.queryACL.mtx.Unlock()
+	for i := 0; i < 2; i++ {
+		if status := httpTestACLQuery(t, srv, "operator", "secret-o", "/nonce?address="+addrA.Hex()); status != http.StatusOK {
+			t.Fatalf("expected a request under the nonces cap answered, got %d", status)
+		}
+	}
+	if status := httpTestACLQuery(t, srv, "operator", "secret-o", "/nonce?address="+addrA.Hex()); status != http.StatusTooManyRequests {
+		t.Fatalf("expected a request over the nonces cap refused, got %d", status)
+	}
+	app.queryACL.mtx.Lock()
+	for seen := range app.queryACL.nonces {
+		app.queryACL.nonces[seen] = time.Now().Add(-time.Minute).Unix()
+	}
+	app.queryACL.pruned = time.Time{}
+	app.queryACL.mtx.Unlock()
+	if status := httpTestACLQuery(t, srv, "operator", "secret-o", "/nonce?address="+addrA.Hex()); status != http.StatusOK {
+		t.Fatalf("expected a request answered once the nonces left the window, got %d", status)
+	}
+	app.queryACL.mtx.Lock()
+	app.queryACL.maxNonces = httpQueryACLMaxNonces
+	app.queryACL.mtx.Unlock()
+
+	// actions
+	if status := httpTestACLQuery(t, srv, "partner-a", "secret-a", "/nonce?address="+addrB.Hex()); status != http.StatusForbidden {
+		t.Fatalf("expected an action out of the policy to be refused, got %d", status)
+	}
+	// addresses, a tx is allowed by its sender or its recipient
+	if status := httpTestACLQuery(t, srv, "partner-a", "secret-a", "/balance?address="+addrB.Hex()); status != http.StatusOK {
+		t.Fatalf("expected the balance of an allowed address, got %d", status)
+	}
+	if status := httpTestACLQuery(t, srv, "partner-a", "secret-a", "/balance?address="+addrB.Hex()+"&address="+other.Hex()); status != http.StatusForbidden {
+		t.Fatalf("expected the balance of another address to be refused, got %d", status)
+	}
+	if status := httpTestACLQuery(t, srv, "partner-a", "secret-a", "/receipt?hash="+hash.Hex()); status != http.StatusOK {
+		t.Fatalf("expected the receipt of a tx to an allowed address, got %d", status)
+	}
+
+	// the endpoints listing other accounts are closed to a client limited to
+	// addresses, the ones without account data open
+	if status := httpTestACLQuery(t, srv, "scoped", "secret-s", "/touched?height=1"); status != http.StatusForbidden {
+		t.Fatalf("expected the touched accounts to be refused, got %d", status)
+	}
+	if status := httpTestACLQuery(t, srv, "operator", "secret-o", "/touched?height=1"); status != http.StatusOK {
+		t.Fatalf("expected the touched accounts to a client without restrictions, got %d", status)
+	}
+	if status := httpTestACLQuery(t, srv, "scoped", "secret-s", "/commitstats"); status != http.StatusOK {
+		t.Fatalf("expected the commit stats, got %d", status)
+	}
+	// the logs of the allowed addresses only
+	if status := httpTestACLQuery(t, srv, "scoped", "secret-s", "/logs?address="+addrB.Hex()); status != http.StatusOK {
+		t.Fatalf("expected the logs of an allowed address, got %d", status)
+	}
+	if status := httpTestACLQuery(t, srv, "scoped", "secret-s", "/logs?address="+addrB.Hex()+"&address="+other.Hex()); status != http.StatusForbidden {
+		t.Fatalf("expected the logs of another address refused, got %d", status)
+	}
+	if status := httpTestACLQuery(t, srv, "scoped", "secret-s", "/logs"); status != http.StatusForbidden {
+		t.Fatalf("expected the logs of any address refused, got %d", status)
+	}
+	if status := httpTestACLQuery(t, srv, "operator", "secret-o", "/logs"); status != http.StatusOK {
+		t.Fatalf("expected the logs of any address to a client without restrictions, got %d", status)
+	}
+	keyA, _ := testKey(t, testKeyA)
+	own := signTestTx(t, keyA, etypes.NewTransaction(1, addrB, big.NewInt(10), testGas, big.NewInt(0), nil))
+	if status := httpTestACLPost(t, srv, "scoped", "secret-s", "/decodetx", []byte(hexutil.Encode(own))); status != http.StatusOK {
+		t.Fatalf("expected a tx to an allowed address decoded, got %d", status)
+	}
+	foreign := signTestTx(t, keyA, etypes.NewTransaction(1, other, big.NewInt(10), testGas, big.NewInt(0), nil))
+	if status := httpTestACLPost(t, srv, "scoped", "secret-s", "/decodetx", []byte(hexutil.Encode(foreign))); status != http.StatusForbidden {
+		t.Fatalf("expected a tx between other addresses refused, got %d", status)
+	}
+
+	// the status of a tx never committed is allowed by the accounts the pool
+	// accepted it with
+	toB := signTestTx(t, keyA, etypes.NewTransaction(1, addrB, big.NewInt(1), testGas, big.NewInt(0), nil))
+	toOther := signTestTx(t, keyA, etypes.NewTransaction(2, other, big.NewInt(1), testGas, big.NewInt(0), nil))
+	for _, raw := range [][]byte{toB, toOther} {
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status := httpTestACLQuery(t, srv, "scoped", "secret-s", "/txstatus?hash="+txHash(toB).Hex()); status != http.StatusOK {
+		t.Fatalf("expected the status of a pending tx to an allowed address, got %d", status)
+	}
+	if status := httpTestACLQuery(t, srv, "scoped", "secret-s", "/txstatus?hash="+txHash(toOther).Hex()); status != http.StatusForbidden {
+		t.Fatalf("expected the status of a pending tx between other addresses refused, got %d", status)
+	}
+
+	// rate
+	if status := httpTestACLQuery(t, srv, "partner-b", "secret-b", "/nonce?address="+other.Hex()); status != http.StatusOK {
+		t.Fatalf("expected the first request to be answered, got %d", status)
+	}
+	if status := httpTestACLQuery(t, srv, "partner-b", "secret-b", "/nonce?address="+other.Hex()); status != http.StatusTooManyRequests {
+		t.Fatalf("expected the request over the rate to be refused, got %d", status)
+	}
+	app.queryACL.now = func() time.Time { return time.Now().Add(time.Second) }
+	if status := httpTestACLQuery(t, srv, "partner-b", "secret-b", "/nonce?address="+other.Hex()); status != http.StatusOK {
+		t.Fatalf("expected a request a second later to be answered, got %d", status)
+	}
+
+	// queries in the process don't go through the acl
+	if res := app.Query(append([]byte{rtypes.QueryType_Nonce}, other.Bytes()...)); res.IsErr() {
+		t.Fatal(res.Log)
+	}
+}
+
+func TestHTTPQueryACLRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "query-acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "acl.json")
+	writeTestQueryACL(t, path, map[string]HTTPQueryPolicy{"partner": {Secrets: []string{"old"}}})
+	app, srv, _, clean := newTestACLApp(t, path)
+	defer clean()
+	uri := "/nonce?address=" + common.HexToAddress("0x1234").Hex()
+
+	// the new secret is added, then the old one removed
+	rotate := func(secrets ...string) {
+		writeTestQueryACL(t, path, map[string]HTTPQueryPolicy{"partner": {Secrets: secrets}})
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+		app.queryACL.mtx.Lock()
+		app.queryACL.checked = time.Time{}
+		app.queryACL.mtx.Unlock()
+	}
+	if status := httpTestACLQuery(t, srv, "partner", "new", uri); status != http.StatusUnauthorized {
+		t.Fatalf("expected the new secret to be unknown yet, got %d", status)
+	}
+	rotate("old", "new")
+	for _, secret := range []string{"old", "new"} {
+		if status := httpTestACLQuery(t, srv, "partner", secret, uri); status != http.StatusOK {
+			t.Fatalf("expected secret %s to be accepted during the rotation, got %d", secret, status)
+		}
+	}
+	rotate("new")
+	if status := httpTestACLQuery(t, srv, "partner", "old", uri); status != http.StatusUnauthorized {
+		t.Fatalf("expected the old secret to be refused after the rotation, got %d", status)
+	}
+	if status := httpTestACLQuery(t, srv, "partner", "new", uri); status != http.StatusOK {
+		t.Fatalf("expected the new secret to be accepted, got %d", status)
+	}
+
+	// a broken file leaves the policies in force
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	app.queryACL.mtx.Lock()
+	app.queryACL.checked = time.Time{}
+	app.queryACL.mtx.Unlock()
+	if status := httpTestACLQuery(t, srv, "partner", "new", uri); status != http.StatusOK {
+		t.Fatalf("expected the previous policies kept, got %d", status)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func httpTestQuery(t *testing.T, srv *httptest.Server, method, path, body string, status int, v interface{}) {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: expected status %d, got %d", method, path, status, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+}
+
+func TestHTTPQuery(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	srv := httptest.NewServer(app.httpQueryHandler())
+	defer srv.Close()
+
+	keyA, addrA := testKey(t, testKeyA)
+	_, addrB := testKey(t, testKeyB)
+	fundTestAccounts(t, app, big.NewInt(1000), addrA)
+	to := common.HexToAddress("0x01")
+	raw := signTestTx(t, keyA, etypes.NewTransaction(0, to, big.NewInt(10), testGas, big.NewInt(0), nil))
+	if err := app.pool.ReceiveTx(raw); err != nil {
+		t.Fatal(err)
+	}
+	execTestBlock(t, app, 1, raw)
+	hash := txHash(raw)
+
+	var status httpQueryStatus
+	httpTestQuery(t, srv, http.MethodGet, "/status", "", http.StatusOK, &status)
+	if status.Height != 1 || len(status.AppHash) != common.HashLength {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	var nonce hexutil.Uint64
+	httpTestQuery(t, srv, http.MethodGet, "/nonce?address="+addrA.Hex(), "", http.StatusOK, &nonce)
+	if nonce != 1 {
+		t.Fatalf("expected nonce 1, got %d", nonce)
+	}
+
+	var balances []*hexutil.Big
+	httpTestQuery(t, srv, http.MethodGet, fmt.Sprintf("/balance?address=%s&address=%s", to.Hex(), addrB.Hex()), "", http.StatusOK, &balances)
+	if len(balances) != 2 || balances[0].ToInt().Int64() != 10 || balances[1].ToInt().Sign() != 0 {
+		t.Fatalf("unexpected balances %v", balances)
+	}
+
+	var receipt etypes.Receipt
+	httpTestQuery(t, srv, http.MethodGet, "/receipt?hash="+hash.Hex(), "", http.StatusOK, &receipt)
+	if receipt.TxHash != hash || receipt.Status != etypes.ReceiptStatusSuccessful {
+		t.Fatalf("unexpected receipt %+v", receipt)
+	}
+
+	var txStatus rtypes.TxStatus
+	httpTestQuery(t, srv, http.MethodGet, "/txstatus?hash="+hash.Hex(), "", http.StatusOK, &txStatus)
+	if txStatus.Status != rtypes.TxStatus_Committed || txStatus.Height != 1 {
+		t.Fatalf("unexpected tx status %+v", txStatus)
+	}
+
+	var stats []rtypes.CommitStats
+	page := &httpQueryPage{Items: &stats}
+	httpTestQuery(t, srv, http.MethodGet, "/commitstats", "", http.StatusOK, page)
+	if len(stats) != 1 || stats[0].Height != 1 || page.Next != nil {
+		t.Fatalf("unexpected commit stats %+v", page)
+	}
+
+	var touched []common.Address
+	page = &httpQueryPage{Items: &touched}
+	httpTestQuery(t, srv, http.MethodGet, "/touched?height=1", "", http.StatusOK, page)
+	all := queryTestTouchedAccounts(t, app, 1)
+	if len(touched) != len(all) || page.Next != nil {
+		t.Fatalf("unexpected touched accounts %+v", page)
+	}
+	// past the response size limit the list goes on from the token of the page
+	app.queryMaxResponseBytes = 1
+	var paged []common.Address
+	for path := "/touched?height=1"; ; path = "/touched?height=1&token=" + page.Next.String() {
+		touched = nil
+		page = &httpQueryPage{Items: &touched}
+		httpTestQuery(t, srv, http.MethodGet, path, "", http.StatusOK, page)
+		if len(touched) != 1 {
+			t.Fatalf("expected a page of one account, got %+v", page)
+		}
+		paged = append(paged, touched...)
+		if page.Next == nil {
+			break
+		}
+	}
+	app.queryMaxResponseBytes = 0
+	if !reflect.DeepEqual(paged, all) {
+		t.Fatalf("expected the pages to get the touched accounts %v, got %v", all, paged)
+	}
+
+	var decoded rtypes.TxJSON
+	httpTestQuery(t, srv, http.MethodPost, "/decodetx", hexutil.Encode(raw)+"\n", http.StatusOK, &decoded)
+	if decoded.Hash == nil || *decoded.Hash != hash || *decoded.From != addrA {
+		t.Fatalf("unexpected decoded tx %+v", decoded)
+	}
+
+	var qerr httpQueryError
+	httpTestQuery(t, srv, http.MethodGet, "/nonce?address=0x12", "", http.StatusBadRequest, &qerr)
+	if qerr.Code == 0 || qerr.Log == "" {
+		t.Fatalf("unexpected error answer %+v", qerr)
+	}
+	httpTestQuery(t, srv, http.MethodPost, "/nonce?address="+addrA.Hex(), "", http.StatusBadRequest, &qerr)
+	httpTestQuery(t, srv, http.MethodPost, "/decodetx", "0x0102", http.StatusBadRequest, &qerr)
+	httpTestQuery(t, srv, http.MethodPost, "/decodetx", strings.Repeat("0", httpQueryMaxBody+1), http.StatusBadRequest, &qerr)
+	httpTestQuery(t, srv, http.MethodGet, "/touched?height=x", "", http.StatusBadRequest, &qerr)
+	httpTestQuery(t, srv, http.MethodGet, "/touched?height=1&token=0xzz", "", http.StatusBadRequest, &qerr)
+	app.balancesBatchLimit = 1
+	httpTestQuery(t, srv, http.MethodGet, fmt.Sprintf("/balance?address=%s&address=%s", to.Hex(), addrB.Hex()), "", http.StatusBadRequest, &qerr)
+	if !strings.Contains(qerr.Log, "too many addresses") {
+		t.Fatalf("expected the batch limit of the query, got %q", qerr.Log)
+	}
+}
+
+func TestHTTPQueryLogs(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	srv := httptest.NewServer(app.httpQueryHandler())
+	defer srv.Close()
+	writeTestLogBlocks(t, app, 10)
+
+	var logs []*etypes.Log
+	page := &httpQueryPage{Items: &logs}
+	httpTestQuery(t, srv, http.MethodGet, "/logs", "", http.StatusOK, page)
+	if len(logs) != 10*2*len(logFixtureAddresses) || page.Next != nil {
+		t.Fatalf("expected every log, got %d %+v", len(logs), page)
+	}
+	all := streamTestLogs(t, app, &LogStreamFilter{From: LogCursor{Height: 3}, ToBlock: 4, Addresses: logFixtureAddresses[1:]})
+	logs = nil
+	path := fmt.Sprintf("/logs?from=3&to=4&address=%s&topic=%s,%s", logFixtureAddresses[1].Hex(), logTestHash(3, 0).Hex(), logTestHash(4, 0).Hex())
+	httpTestQuery(t, srv, http.MethodGet, path, "", http.StatusOK, page)
+	if len(logs) != len(all) {
+		t.Fatalf("expected %d logs, got %d", len(all), len(logs))
+	}
+	for i, l := range logs {
+		if l.BlockNumber != all[i].Height || uint64(l.Index) != all[i].LogIndex || l.TxHash != all[i].TxHash || l.Address != all[i].Address {
+			t.Fatalf("unexpected log %d %+v, expected %+v", i, l, all[i])
+		}
+	}
+	logs = nil
+	httpTestQuery(t, srv, http.MethodGet, "/logs?from=3&to=4&topic=&topic="+logTestHash(3, 0).Hex(), "", http.StatusOK, page)
+	if len(logs) != 0 {
+		t.Fatalf("expected no log with a second topic, got %d", len(logs))
+	}
+
+	// past the response size limit the logs go on from the token of the page
+	app.queryMaxResponseBytes = 1
+	var paged []*etypes.Log
+	for path := "/logs?from=3&to=4"; ; path = "/logs?from=3&to=4&token=" + page.Next.String() {
+		logs = nil
+		page = &httpQueryPage{Items: &logs}
+		httpTestQuery(t, srv, http.MethodGet, path, "", http.StatusOK, page)
+		if len(logs) != 1 {
+			t.Fatalf("expected a page of one log, got %+v", page)
+		}
+		paged = append(paged, logs...)
+		if page.Next == nil {
+			break
+		}
+	}
+	app.queryMaxResponseBytes = 0
+	if len(paged) != 2*2*len(logFixtureAddresses) {
+		t.Fatalf("expected the pages to get the logs of 2 blocks, got %d", len(paged))
+	}
+
+	var qerr httpQueryError
+	httpTestQuery(t, srv, http.MethodGet, "/logs?from=x", "", http.StatusBadRequest, &qerr)
+	httpTestQuery(t, srv, http.MethodGet, "/logs?address=0x12", "", http.StatusBadRequest, &qerr)
+	httpTestQuery(t, srv, http.MethodGet, "/logs?topic=0x12", "", http.StatusBadRequest, &qerr)
+}
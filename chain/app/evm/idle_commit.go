@@ -0,0 +1,30 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/metrics"
+)
+
+var idleCommitsCounter = metrics.NewRegisteredCounter("evm/commit/idle", nil)
+
+// idleCommit tells if the executed block left the state untouched, no receipt
+// and no account changed, evidence records included. Committing such a block
+// yields the previous app hash and no receipts, so OnCommit carries the roots
+// forward instead of committing the trie and writing the receipts.
+func (app *EVMApp) idleCommit(receipts etypes.Receipts, touched, destroyed []common.Address) bool {
+	return app.idleCommitSkip && len(receipts) == 0 && len(touched) == 0 && len(destroyed) == 0
+}
@@ -0,0 +1,78 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func commitTestBlock(t *testing.T, app *EVMApp, block *gtypes.Block) gtypes.CommitResult {
+	if _, err := app.OnExecute(block.Height, 0, block); err != nil {
+		t.Fatal(err)
+	}
+	res, err := app.OnCommit(block.Height, 0, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.(gtypes.CommitResult)
+}
+
+func TestIdleCommit(t *testing.T) {
+	full, cleanFull := newTestApp(t)
+	defer cleanFull()
+	full.idleCommitSkip = false
+	idle, cleanIdle := newTestApp(t)
+	defer cleanIdle()
+
+	key, _ := testKey(t, testKeyA)
+	to := common.HexToAddress("0x1234")
+	transfer := func(nonce uint64) []byte {
+		return signTestTx(t, key, etypes.NewTransaction(nonce, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+	}
+	chain := [][][]byte{
+		nil,
+		{transfer(0)},
+		nil,
+		nil,
+		{transfer(5)}, // wrong nonce, nothing executes
+		{signTestTx(t, key, etypes.NewContractCreation(1, big.NewInt(0), testGas, big.NewInt(0), callerCode))},
+		nil,
+	}
+	skipped := 0
+	for i, txs := range chain {
+		block := makeTestBlock(int64(i+1), txs...)
+		state := idle.state
+		expected, got := commitTestBlock(t, full, block), commitTestBlock(t, idle, block)
+		if idle.state == state {
+			// the state wasn't committed again
+			skipped++
+		}
+		if !bytes.Equal(expected.AppHash, got.AppHash) || !bytes.Equal(expected.ReceiptsHash, got.ReceiptsHash) ||
+			!bytes.Equal(expected.LightHeaderHash, got.LightHeaderHash) {
+			t.Fatalf("block %d: expected the commit %+v, got %+v", block.Height, expected, got)
+		}
+		if full.Info().LastBlockHeight != block.Height || idle.Info().LastBlockHeight != block.Height {
+			t.Fatalf("block %d: expected the height to advance on both nodes", block.Height)
+		}
+	}
+	if skipped != 5 {
+		t.Fatalf("expected 5 idle commits, got %d", skipped)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/go-wire"
+)
+
+// LastBlockKey stores the last committed block, the key BaseApplication
+// stored it under before it was versioned
+var LastBlockKey = []byte("lastblock")
+
+// lastBlockVersion1 prefixes the rlp encoded lastBlockRecord. Legacy records
+// are the go-wire encoded LastBlockInfo, starting with the big endian height,
+// whose first byte is 0 for any height a chain reaches.
+const lastBlockVersion1 byte = 0x01
+
+type lastBlockRecord struct {
+	Height  uint64
+	AppHash []byte
+}
+
+// loadLastBlock returns the last committed block, height 0 without app hash
+// when none is stored yet. A record it can't decode is an error, never a
+// panic nor a silent restart from genesis.
+func (app *EVMApp) loadLastBlock() (LastBlockInfo, error) {
+	data := app.Database.Get(LastBlockKey)
+	if len(data) == 0 {
+		return LastBlockInfo{AppHash: make([]byte, 0)}, nil
+	}
+	return decodeLastBlock(data)
+}
+
+func decodeLastBlock(data []byte) (info LastBlockInfo, err error) {
+	switch data[0] {
+	case lastBlockVersion1:
+		var record lastBlockRecord
+		if err := rlp.DecodeBytes(data[1:], &record); err != nil {
+			return LastBlockInfo{}, fmt.Errorf("decode last block: %v", err)
+		}
+		return LastBlockInfo{Height: int64(record.Height), AppHash: record.AppHash}, nil
+	case 0x00:
+		defer func() {
+			if r := recover(); r != nil {
+				info, err = LastBlockInfo{}, fmt.Errorf("decode legacy last block: %v", r)
+			}
+		}()
+		var legacy LastBlockInfo
+		n, werr := new(int), new(error)
+		wire.ReadBinaryPtr(&legacy, bytes.NewReader(data), len(data), n, werr)
+		if *werr != nil {
+			return LastBlockInfo{}, fmt.Errorf("decode legacy last block: %v", *werr)
+		}
+		if *n != len(data) {
+			return LastBlockInfo{}, fmt.Errorf("decode legacy last block: %d trailing bytes", len(data)-*n)
+		}
+		return legacy, nil
+	default:
+		return LastBlockInfo{}, fmt.Errorf("unknown last block record version %d", data[0])
+	}
+}
+
+// saveLastBlock stores info as the last committed block
+func (app *EVMApp) saveLastBlock(info LastBlockInfo) error {
+	if info.Height < 0 {
+		return fmt.Errorf("invalid last block height %d", info.Height)
+	}
+	data, err := rlp.EncodeToBytes(&lastBlockRecord{Height: uint64(info.Height), AppHash: info.AppHash})
+	if err != nil {
+		return err
+	}
+	app.Database.SetSync(LastBlockKey, append([]byte{lastBlockVersion1}, data...))
+	return nil
+}
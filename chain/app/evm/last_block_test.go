@@ -0,0 +1,83 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+)
+
+func TestLastBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app, err := startTestApp(dir, viper.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	execTestBlock(t, app, 1)
+	current, err := app.loadLastBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Height != 1 || len(current.AppHash) == 0 {
+		t.Fatalf("unexpected last block %+v", current)
+	}
+	if stored := app.Database.Get(LastBlockKey); stored[0] != lastBlockVersion1 {
+		t.Fatalf("expected a versioned record, got %x", stored)
+	}
+	if info := app.Info(); info.LastBlockHeight != 1 || !bytes.Equal(info.LastBlockAppHash, current.AppHash) {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	// the record written by BaseApplication before the version byte
+	app.SaveLastBlock(current)
+	if legacy, err := app.loadLastBlock(); err != nil || legacy.Height != 1 || !bytes.Equal(legacy.AppHash, current.AppHash) {
+		t.Fatalf("expected the legacy record decoded to %+v, got %+v %v", current, legacy, err)
+	}
+	if hash := app.getLastAppHash(); hash != common.BytesToHash(current.AppHash) {
+		t.Fatalf("expected the legacy app hash %x, got %x", current.AppHash, hash)
+	}
+
+	for _, garbage := range [][]byte{
+		{0xff, 0x01, 0x02},
+		append([]byte{lastBlockVersion1}, 0xc5, 0x01),
+		{0x00, 0x00, 0x01},
+		{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0xff},
+	} {
+		app.Database.SetSync(LastBlockKey, garbage)
+		if _, err := app.loadLastBlock(); err == nil {
+			t.Fatalf("expected record %x to fail to decode", garbage)
+		}
+		if hash := app.getLastAppHash(); hash != EmptyTrieRoot {
+			t.Fatalf("expected no app hash from record %x, got %x", garbage, hash)
+		}
+		if info := app.Info(); info.LastBlockHeight != 0 {
+			t.Fatalf("expected no last block from record %x, got %+v", garbage, info)
+		}
+	}
+	app.Stop()
+	if _, err := startTestApp(dir, viper.New()); err == nil {
+		t.Fatal("expected an undecodable last block to refuse to start")
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// LightHeaderPrefix indexes the rlp encoded rtypes.LightHeader of each block by height
+var LightHeaderPrefix = []byte("lightheader-")
+
+func lightHeaderKey(height uint64) []byte {
+	key := make([]byte, len(LightHeaderPrefix)+8)
+	copy(key, LightHeaderPrefix)
+	binary.BigEndian.PutUint64(key[len(LightHeaderPrefix):], height)
+	return key
+}
+
+// blockLogs lists the logs of a block's receipts in order, for their trie root
+type blockLogs []*etypes.Log
+
+func (l blockLogs) Len() int { return len(l) }
+
+func (l blockLogs) GetRlp(i int) []byte {
+	enc, _ := rlp.EncodeToBytes(l[i])
+	return enc
+}
+
+func logsRoot(receipts []*etypes.Receipt) common.Hash {
+	var logs blockLogs
+	for _, receipt := range receipts {
+		logs = append(logs, receipt.Logs...)
+	}
+	return etypes.DeriveSha(logs)
+}
+
+// saveLightHeader stores the light header of block, committed from prevAppHash
+// to appHash, and returns its hash.
+func (app *EVMApp) saveLightHeader(block *gtypes.Block, prevAppHash, appHash common.Hash, receiptsHash []byte) (common.Hash, error) {
+	header := &rtypes.LightHeader{
+		Height:         uint64(block.Height),
+		PrevAppHash:    prevAppHash,
+		BlockHash:      block.Hash(),
+		NewAppHash:     appHash,
+		ReceiptsHash:   receiptsHash,
+		LogsRoot:       logsRoot(app.receipts),
+		ValidatorsHash: block.ValidatorsHash,
+	}
+	data, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := app.stateDb.Put(lightHeaderKey(header.Height), data); err != nil {
+		return common.Hash{}, err
+	}
+	return header.Hash(), nil
+}
+
+// LightHeaders returns the light headers of the blocks in [from, to].
+func (app *EVMApp) LightHeaders(from, to uint64) ([]*rtypes.LightHeader, error) {
+	if from == 0 || from > to {
+		return nil, fmt.Errorf("invalid height range [%d, %d]", from, to)
+	}
+	headers := make([]*rtypes.LightHeader, 0, to-from+1)
+	for height := from; height <= to; height++ {
+		data, err := app.stateDb.Get(lightHeaderKey(height))
+		if err != nil {
+			return nil, fmt.Errorf("no light header of height %d", height)
+		}
+		header := &rtypes.LightHeader{}
+		if err := rlp.DecodeBytes(data, header); err != nil {
+			return nil, err
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+// listLightHeaders lists the rtypes.LightHeader of the blocks in the 16 bytes
+// height range [from, to], the token is the height of a block. Pages hold at most
+// light_header_range_limit headers.
+func (app *EVMApp) listLightHeaders(load, token []byte, limit int) ([]listItem, []byte, error) {
+	if len(load) != 16 {
+		return nil, nil, fmt.Errorf("wrong height range")
+	}
+	from, to := binary.BigEndian.Uint64(load[:8]), binary.BigEndian.Uint64(load[8:])
+	if len(token) > 0 {
+		next, err := parseIndexToken(token)
+		if err != nil || next < from || next > to {
+			return nil, nil, errInvalidPageToken
+		}
+		from = next
+	}
+	if limit > app.lightHeaderRangeLimit {
+		limit = app.lightHeaderRangeLimit
+	}
+	last := to
+	if limit > 0 && from <= to && to-from >= uint64(limit) {
+		last = from + uint64(limit) - 1
+	}
+	headers, err := app.LightHeaders(from, last)
+	if err != nil {
+		return nil, nil, err
+	}
+	items := make([]listItem, len(headers))
+	for i, header := range headers {
+		items[i] = listItem{indexToken(header.Height), header}
+	}
+	if last < to {
+		return items, indexToken(last + 1), nil
+	}
+	return items, nil, nil
+}
+
+// queryLightHeader answers an 8 bytes big endian height with the rlp encoded
+// rtypes.LightHeader of the block, or a 16 bytes height range [from, to] with
+// the rlp encoded []rtypes.LightHeader of up to light_header_range_limit blocks.
+func (app *EVMApp) queryLightHeader(load []byte) gtypes.Result {
+	var from, to uint64
+	switch len(load) {
+	case 8:
+		from = binary.BigEndian.Uint64(load)
+		to = from
+	case 16:
+		from, to = binary.BigEndian.Uint64(load[:8]), binary.BigEndian.Uint64(load[8:])
+		if from <= to && to-from >= uint64(app.lightHeaderRangeLimit) {
+			return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, fmt.Sprintf("too many light headers, limit %d", app.lightHeaderRangeLimit))
+		}
+	default:
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong height")
+	}
+	headers, err := app.LightHeaders(from, to)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	var data []byte
+	if len(load) == 8 {
+		data, err = rlp.EncodeToBytes(headers[0])
+	} else {
+		data, err = rlp.EncodeToBytes(headers)
+	}
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
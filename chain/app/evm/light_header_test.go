@@ -0,0 +1,115 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func TestLightHeaderChain(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	const blocks = 50
+	key, addr := testKey(t, testKeyA)
+	contract := crypto.CreateAddress(addr, 0)
+	commitHashes := make([][]byte, blocks+1)
+	appHashes := make([]common.Hash, blocks+1)
+	appHashes[0] = app.getLastAppHash()
+	for height := int64(1); height <= blocks; height++ {
+		var tx *etypes.Transaction
+		if height == 1 {
+			tx = etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), logContractCode)
+		} else {
+			tx = etypes.NewTransaction(uint64(height-1), contract, big.NewInt(0), testGas, big.NewInt(0), nil)
+		}
+		block := makeTestBlock(height, signTestTx(t, key, tx))
+		block.ValidatorsHash, block.LastCommit = []byte{0x01}, &gtypes.Commit{}
+		if _, err := app.OnExecute(height, 0, block); err != nil {
+			t.Fatal(err)
+		}
+		res, err := app.OnCommit(height, 0, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commitHashes[height] = res.(gtypes.CommitResult).LightHeaderHash
+		appHashes[height] = app.getLastAppHash()
+	}
+
+	load := make([]byte, 16)
+	binary.BigEndian.PutUint64(load, 1)
+	binary.BigEndian.PutUint64(load[8:], blocks)
+	res := app.Query(append([]byte{rtypes.QueryType_LightHeader}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var headers []*rtypes.LightHeader
+	if err := rlp.DecodeBytes(res.Data, &headers); err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != blocks {
+		t.Fatalf("expected %d light headers, got %d", blocks, len(headers))
+	}
+	if err := rtypes.VerifyLightHeaderChain(headers); err != nil {
+		t.Fatal(err)
+	}
+	for i, header := range headers {
+		height := i + 1
+		if header.PrevAppHash != appHashes[height-1] || header.NewAppHash != appHashes[height] {
+			t.Fatalf("unexpected app hashes of light header %d", height)
+		}
+		if hash := header.Hash(); !bytes.Equal(hash.Bytes(), commitHashes[height]) {
+			t.Fatalf("light header %d hash %x differs from the commit result %x", height, hash, commitHashes[height])
+		}
+		if len(header.BlockHash) == 0 || !bytes.Equal(header.ValidatorsHash, []byte{0x01}) {
+			t.Fatalf("expected the block and validators hashes of light header %d", height)
+		}
+		// block 1 deploys the log contract, the later ones each emit a log
+		if (header.LogsRoot == etypes.EmptyRootHash) != (height == 1) {
+			t.Fatalf("unexpected logs root of light header %d", height)
+		}
+	}
+
+	res = app.Query(append([]byte{rtypes.QueryType_LightHeader}, load[8:]...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var single rtypes.LightHeader
+	if err := rlp.DecodeBytes(res.Data, &single); err != nil {
+		t.Fatal(err)
+	}
+	if single.Hash() != headers[blocks-1].Hash() {
+		t.Fatal("expected the single form to answer the same light header")
+	}
+
+	binary.BigEndian.PutUint64(load[8:], blocks+1)
+	if res := app.Query(append([]byte{rtypes.QueryType_LightHeader}, load...)); res.IsOK() {
+		t.Fatal("expected a range past the committed height to be rejected")
+	}
+	app.lightHeaderRangeLimit = 10
+	binary.BigEndian.PutUint64(load[8:], blocks)
+	if res := app.Query(append([]byte{rtypes.QueryType_LightHeader}, load...)); res.IsOK() {
+		t.Fatal("expected a range over the limit to be rejected")
+	}
+}
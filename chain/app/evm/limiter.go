@@ -0,0 +1,74 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrCheckTxBusy rejects the txs checked over check_tx_limit, they may be sent again
+var ErrCheckTxBusy = errors.New("server busy, too many txs being checked")
+
+// limiter bounds the calls running at once, failing the calls over the limit
+// with its busy error. A nil limiter doesn't limit.
+type limiter struct {
+	slots chan struct{}
+	wait  time.Duration
+	busy  error
+}
+
+func newLimiter(limit int, wait time.Duration, busy error) *limiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &limiter{slots: make(chan struct{}, limit), wait: wait, busy: busy}
+}
+
+// acquire takes a slot, waiting up to l.wait for one to be released.
+func (l *limiter) acquire() error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if l.wait <= 0 {
+		return l.busy
+	}
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return l.busy
+	}
+}
+
+func (l *limiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// inUse returns the slots taken and the limit, 0 and 0 for no limit
+func (l *limiter) inUse() (int, int) {
+	if l == nil {
+		return 0, 0
+	}
+	return len(l.slots), cap(l.slots)
+}
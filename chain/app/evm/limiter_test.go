@@ -0,0 +1,59 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func TestCheckTxLimit(t *testing.T) {
+	conf := viper.New()
+	conf.Set("check_tx_limit", 2)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	raw := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), nil))
+
+	// the checks holding a slot wait on the state lock, the others are busy
+	const calls = 10
+	results := make(chan error, calls)
+	app.stateMtx.Lock()
+	for i := 0; i < calls; i++ {
+		go func() { results <- app.CheckTx(raw) }()
+	}
+	for i := 0; i < calls-2; i++ {
+		if err := <-results; err != ErrCheckTxBusy {
+			app.stateMtx.Unlock()
+			t.Fatalf("expected %v, got %v", ErrCheckTxBusy, err)
+		}
+	}
+	app.stateMtx.Unlock()
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("expected the checks holding a slot to pass, got %v", err)
+		}
+	}
+
+	// released slots are taken again
+	if err := app.CheckTx(raw); err != nil {
+		t.Fatal(err)
+	}
+}
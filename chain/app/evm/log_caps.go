@@ -0,0 +1,38 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/params"
+)
+
+// withLogDataCaps returns config with the per tx and per block log data caps set
+func withLogDataCaps(config *params.ChainConfig, perTx, perBlock uint64) *params.ChainConfig {
+	if perTx == 0 && perBlock == 0 {
+		return config
+	}
+	capped := *config
+	capped.MaxTxLogData, capped.MaxBlockLogData = perTx, perBlock
+	return &capped
+}
+
+// logDataSize sums the data bytes of logs, the measure of the log data caps
+func logDataSize(logs []*etypes.Log) uint64 {
+	var size uint64
+	for _, l := range logs {
+		size += uint64(len(l.Data))
+	}
+	return size
+}
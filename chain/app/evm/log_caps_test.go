@@ -0,0 +1,96 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/core/vm"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// logDataCode deploys a contract emitting one LOG0 of as many memory bytes as
+// the uint256 in its calldata
+var logDataCode = common.FromHex("6007600c60003960076000f3" + "6000356000a000")
+
+func TestLogDataCaps(t *testing.T) {
+	keyA, addrA := testKey(t, testKeyA)
+	contract := crypto.CreateAddress(addrA, 0)
+	emit := func(key string, nonce uint64, size int64) []byte {
+		k, _ := testKey(t, key)
+		data := common.LeftPadBytes(big.NewInt(size).Bytes(), 32)
+		return signTestTx(t, k, etypes.NewTransaction(nonce, contract, big.NewInt(0), testGas, big.NewInt(0), data))
+	}
+	deploy := signTestTx(t, keyA, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), logDataCode))
+	txs := [][]byte{
+		emit(testKeyA, 1, 1000),    // within both caps
+		emit(testKeyB, 0, 64*1024), // over the tx cap
+		emit(testKeyA, 2, 1000),    // over the block cap with the first one, left out
+		emit(testKeyB, 1, 0),       // empty log, still fits
+	}
+	expected := []struct {
+		status uint64
+		logs   int
+	}{
+		{etypes.ReceiptStatusSuccessful, 1},
+		{etypes.ReceiptStatusFailed, 0},
+		{},
+		{etypes.ReceiptStatusSuccessful, 1},
+	}
+
+	var appHashes [2]common.Hash
+	var receipts [2][][]byte
+	for node := range appHashes {
+		conf := viper.New()
+		conf.Set("max_tx_log_data", 1024)
+		conf.Set("max_block_log_data", 1536)
+		app, clean := newTestAppWithConfig(t, conf)
+		execTestBlock(t, app, 1, deploy)
+		res := execTestBlock(t, app, 2, txs...)
+		if len(res.InvalidTxs) != 1 || !bytes.Equal(res.InvalidTxs[0].Bytes, txs[2]) || res.InvalidTxs[0].Error != vm.ErrBlockLogDataLimit {
+			t.Fatalf("expected the tx over the block cap invalidated with %v, got %+v", vm.ErrBlockLogDataLimit, res.InvalidTxs)
+		}
+		for i, raw := range txs {
+			if i == 2 {
+				continue
+			}
+			data := queryTestReceipt(t, app, txHash(raw))
+			var receipt etypes.ReceiptForStorage
+			if err := rlp.DecodeBytes(data, &receipt); err != nil {
+				t.Fatal(err)
+			}
+			if receipt.Status != expected[i].status || len(receipt.Logs) != expected[i].logs {
+				t.Fatalf("node %d tx %d: status %d with %d logs, expected %+v", node, i, receipt.Status, len(receipt.Logs), expected[i])
+			}
+			receipts[node] = append(receipts[node], data)
+		}
+		appHashes[node] = app.getLastAppHash()
+		clean()
+	}
+	if appHashes[0] != appHashes[1] {
+		t.Fatalf("nodes diverged: %x and %x", appHashes[0], appHashes[1])
+	}
+	for i := range receipts[0] {
+		if !bytes.Equal(receipts[0][i], receipts[1][i]) {
+			t.Fatalf("receipt %d differs between nodes", i)
+		}
+	}
+}
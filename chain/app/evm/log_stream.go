@@ -0,0 +1,233 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// LogCursor is the position of a log in the chain, see rtypes.LogRecord. Logs
+// are streamed from the first one at or after a cursor.
+type LogCursor struct {
+	Height   uint64
+	TxIndex  uint64
+	LogIndex uint64
+}
+
+// NextLogCursor is the cursor resuming a stream after record
+func NextLogCursor(record *rtypes.LogRecord) LogCursor {
+	return LogCursor{Height: record.Height, TxIndex: record.TxIndex, LogIndex: record.LogIndex + 1}
+}
+
+func (c LogCursor) before(other LogCursor) bool {
+	if c.Height != other.Height {
+		return c.Height < other.Height
+	}
+	if c.TxIndex != other.TxIndex {
+		return c.TxIndex < other.TxIndex
+	}
+	return c.LogIndex < other.LogIndex
+}
+
+// LogStreamFilter selects the logs StreamLogs streams
+type LogStreamFilter struct {
+	From      LogCursor // first position streamed, height 0 for the first block
+	ToBlock   uint64    // last block streamed, 0, or past the committed height, for the committed height
+	Addresses []common.Address
+	Topics    [][]common.Hash
+}
+
+// errLogsPageFull stops the scan of a page of QueryType_Logs
+var errLogsPageFull = errors.New("logs page full")
+
+// StreamLogs calls fn with the logs matching filter, in chain order, with the
+// receipts of one block in memory at a time and no cap on the range, for an
+// indexer to fetch the logs from genesis. It returns the error of fn, which
+// ends the stream, or ctx.Err() once ctx is done. A stream stopped at a record
+// resumes from NextLogCursor of the last record it handled, without gaps or
+// duplicates. Like GetLogs, a range starting at a pruned block fails with a
+// *LogsPrunedError, so does a stream the pruning caught up with.
+func (app *EVMApp) StreamLogs(ctx context.Context, filter *LogStreamFilter, fn func(*rtypes.LogRecord) error) error {
+	if err := app.checkRead(rtypes.QueryType_Receipt); err != nil {
+		return err
+	}
+	from, to := filter.From, app.logsRangeEnd(filter.ToBlock)
+	if from.Height == 0 {
+		from = LogCursor{Height: 1}
+	}
+	if from.Height > to {
+		return fmt.Errorf("invalid block range %d-%d", from.Height, to)
+	}
+	if err := app.logsPruned(from.Height); err != nil {
+		return err
+	}
+	match := &LogFilter{Addresses: filter.Addresses, Topics: filter.Topics}
+	call := func(record *rtypes.LogRecord) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(record)
+	}
+	for height := from.Height; height <= to; height++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := app.scanBlockLogs(height, from, match, call); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logsRangeEnd is the last block of a range of logs ending at toBlock
+func (app *EVMApp) logsRangeEnd(toBlock uint64) uint64 {
+	committed := uint64(atomic.LoadInt64(&app.committedHeight))
+	if toBlock == 0 || toBlock > committed {
+		return committed
+	}
+	return toBlock
+}
+
+// scanBlockLogs calls fn with the logs of the block at height matching filter,
+// from the position from on. Blocks committed before the per-block receipts
+// index existed have no entry in it and no logs.
+func (app *EVMApp) scanBlockLogs(height uint64, from LogCursor, filter *LogFilter, fn func(*rtypes.LogRecord) error) error {
+	index, err := app.stateDb.Get(blockReceiptsKey(height))
+	if err != nil || len(index) == 0 {
+		// no receipts, or not indexed
+		return nil
+	}
+	var txHashes []common.Hash
+	if err := rlp.DecodeBytes(index, &txHashes); err != nil {
+		return fmt.Errorf("decode receipts index of block %d: %v", height, err)
+	}
+	var logIndex uint64
+	for txIndex, hash := range txHashes {
+		env, err := app.storedReceipt(hash)
+		if err == ErrReceiptNotFound {
+			if pruned := app.logsPruned(height); pruned != nil {
+				return pruned
+			}
+		}
+		if err != nil {
+			return err
+		}
+		for _, l := range env.Receipt.Logs {
+			cursor := LogCursor{Height: height, TxIndex: uint64(txIndex), LogIndex: logIndex}
+			logIndex++
+			if cursor.before(from) || !filter.match(l) {
+				continue
+			}
+			err := fn(&rtypes.LogRecord{
+				Height:    height,
+				TxIndex:   cursor.TxIndex,
+				LogIndex:  cursor.LogIndex,
+				TxHash:    hash,
+				BlockHash: env.BlockHash,
+				Address:   l.Address,
+				Topics:    l.Topics,
+				Data:      l.Data,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// logCursorToken is the page token of cursor, its 3 fields 8 bytes big endian
+func logCursorToken(cursor LogCursor) []byte {
+	token := make([]byte, 24)
+	binary.BigEndian.PutUint64(token, cursor.Height)
+	binary.BigEndian.PutUint64(token[8:], cursor.TxIndex)
+	binary.BigEndian.PutUint64(token[16:], cursor.LogIndex)
+	return token
+}
+
+func parseLogCursorToken(token []byte) (LogCursor, error) {
+	if len(token) != 24 {
+		return LogCursor{}, errInvalidPageToken
+	}
+	return LogCursor{
+		Height:   binary.BigEndian.Uint64(token),
+		TxIndex:  binary.BigEndian.Uint64(token[8:]),
+		LogIndex: binary.BigEndian.Uint64(token[16:]),
+	}, nil
+}
+
+// listLogs lists the rtypes.LogRecord of the logs the rlp encoded rtypes.LogsQuery
+// selects, the token is the cursor of a log. A page scans at most
+// logs_range_limit blocks, so a page may hold no logs and still be followed by
+// another one; the list ends once its last block is scanned.
+func (app *EVMApp) listLogs(load, token []byte, limit int) ([]listItem, []byte, error) {
+	var query rtypes.LogsQuery
+	if err := rlp.DecodeBytes(load, &query); err != nil {
+		return nil, nil, err
+	}
+	from, to := LogCursor{Height: query.FromBlock}, app.logsRangeEnd(query.ToBlock)
+	if from.Height == 0 {
+		from.Height = 1
+	}
+	if len(token) > 0 {
+		cursor, err := parseLogCursorToken(token)
+		if err != nil || cursor.before(from) || cursor.Height > to {
+			return nil, nil, errInvalidPageToken
+		}
+		from = cursor
+	}
+	if from.Height > to {
+		return nil, nil, fmt.Errorf("invalid block range %d-%d", from.Height, to)
+	}
+	if err := app.logsPruned(from.Height); err != nil {
+		return nil, nil, err
+	}
+	last := to
+	if to-from.Height >= uint64(app.logsRangeLimit) {
+		last = from.Height + uint64(app.logsRangeLimit) - 1
+	}
+
+	match := &LogFilter{Addresses: query.Addresses, Topics: query.Topics}
+	items := make([]listItem, 0)
+	var next []byte
+	for height := from.Height; height <= last; height++ {
+		err := app.scanBlockLogs(height, from, match, func(record *rtypes.LogRecord) error {
+			token := logCursorToken(LogCursor{record.Height, record.TxIndex, record.LogIndex})
+			if len(items) == limit {
+				next = token
+				return errLogsPageFull
+			}
+			items = append(items, listItem{token, record})
+			return nil
+		})
+		if err == errLogsPageFull {
+			return items, next, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if last < to {
+		return items, logCursorToken(LogCursor{Height: last + 1}), nil
+	}
+	return items, nil, nil
+}
@@ -0,0 +1,236 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// The log fixture has 2 receipts per block, and each receipt one log of each of
+// logFixtureAddresses, the topic the block height
+var logFixtureAddresses = []common.Address{common.HexToAddress("0x01"), common.HexToAddress("0x02")}
+
+// logTestHash is the hash of the tx at index in the log fixture, and the topic
+// of the logs of its block with index 0
+func logTestHash(height uint64, index int) common.Hash {
+	var hash common.Hash
+	binary.BigEndian.PutUint64(hash[16:], height)
+	binary.BigEndian.PutUint64(hash[24:], uint64(index)+1)
+	return hash
+}
+
+// writeTestLogBlocks stores the receipts of the log fixture for the blocks 1 to
+// n straight into the receipt store, as committing them would.
+func writeTestLogBlocks(tb testing.TB, app *EVMApp, n uint64) {
+	batch := app.stateDb.NewBatch()
+	for height := uint64(1); height <= n; height++ {
+		var txHashes []common.Hash
+		for txIndex := 0; txIndex < 2; txIndex++ {
+			hash := logTestHash(height, txIndex)
+			receipt := &etypes.Receipt{Status: etypes.ReceiptStatusSuccessful, TxHash: hash}
+			for _, addr := range logFixtureAddresses {
+				receipt.Logs = append(receipt.Logs, &etypes.Log{Address: addr, Topics: []common.Hash{logTestHash(height, 0)}, Data: []byte{byte(txIndex)}})
+			}
+			data, err := encodeReceiptEnvelope(newReceiptEnvelope(receipt, 0, nil, height, common.Hash{}, txIndex))
+			if err != nil {
+				tb.Fatal(err)
+			}
+			if err := batch.Put(receiptKey(hash), data); err != nil {
+				tb.Fatal(err)
+			}
+			txHashes = append(txHashes, hash)
+		}
+		index, err := rlp.EncodeToBytes(txHashes)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if err := batch.Put(blockReceiptsKey(height), index); err != nil {
+			tb.Fatal(err)
+		}
+		if batch.ValueSize() > 1<<20 {
+			if err := batch.Write(); err != nil {
+				tb.Fatal(err)
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		tb.Fatal(err)
+	}
+	atomic.StoreInt64(&app.committedHeight, int64(n))
+}
+
+func streamTestLogs(t *testing.T, app *EVMApp, filter *LogStreamFilter) []*rtypes.LogRecord {
+	var records []*rtypes.LogRecord
+	err := app.StreamLogs(context.Background(), filter, func(record *rtypes.LogRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func sameTestLogs(t *testing.T, got, expected []*rtypes.LogRecord) {
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d logs, got %d", len(expected), len(got))
+	}
+	for i := range got {
+		if NextLogCursor(got[i]) != NextLogCursor(expected[i]) || got[i].TxHash != expected[i].TxHash || got[i].Address != expected[i].Address {
+			t.Fatalf("unexpected log %d %+v, expected %+v", i, got[i], expected[i])
+		}
+	}
+}
+
+func TestStreamLogs(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	writeTestLogBlocks(t, app, 50)
+
+	all := streamTestLogs(t, app, &LogStreamFilter{})
+	if len(all) != 50*2*len(logFixtureAddresses) {
+		t.Fatalf("expected every log, got %d", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if !NextLogCursor(all[i-1]).before(NextLogCursor(all[i])) {
+			t.Fatalf("expected the logs in chain order, got %+v after %+v", all[i], all[i-1])
+		}
+	}
+	if records := streamTestLogs(t, app, &LogStreamFilter{From: LogCursor{Height: 10}, ToBlock: 12, Addresses: logFixtureAddresses[1:]}); len(records) != 3*2 {
+		t.Fatalf("expected the logs of one address in blocks 10 to 12, got %d", len(records))
+	}
+
+	// cancelled in the middle of a block, the stream resumes after the last log
+	// handled
+	for _, stop := range []int{1, 7, 101, len(all) - 1} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var records []*rtypes.LogRecord
+		err := app.StreamLogs(ctx, &LogStreamFilter{}, func(record *rtypes.LogRecord) error {
+			records = append(records, record)
+			if len(records) == stop {
+				cancel()
+			}
+			return nil
+		})
+		cancel()
+		if err != context.Canceled || len(records) != stop {
+			t.Fatalf("expected the stream cancelled after %d logs, got %d %v", stop, len(records), err)
+		}
+		rest := streamTestLogs(t, app, &LogStreamFilter{From: NextLogCursor(records[len(records)-1])})
+		sameTestLogs(t, append(records, rest...), all)
+	}
+
+	atomic.StoreUint64(&app.receiptsPruned, 5)
+	err := app.StreamLogs(context.Background(), &LogStreamFilter{}, func(*rtypes.LogRecord) error { return nil })
+	if _, ok := err.(*LogsPrunedError); !ok {
+		t.Fatalf("expected a *LogsPrunedError, got %v", err)
+	}
+}
+
+func TestQueryLogsPages(t *testing.T) {
+	conf := viper.New()
+	conf.Set("logs_range_limit", 4)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	writeTestLogBlocks(t, app, 30)
+	all := streamTestLogs(t, app, &LogStreamFilter{From: LogCursor{Height: 3}, ToBlock: 25})
+
+	load, err := rlp.EncodeToBytes(&rtypes.LogsQuery{FromBlock: 3, ToBlock: 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []*rtypes.LogRecord
+	var token []byte
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("expected the pages to end")
+		}
+		data, err := rlp.EncodeToBytes(&rtypes.PageQuery{Query: rtypes.QueryType_Logs, Load: load, Token: token, Limit: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := app.Query(append([]byte{rtypes.QueryType_Page}, data...))
+		if res.IsErr() {
+			t.Fatal(res.Log)
+		}
+		var page rtypes.Page
+		if err := rlp.DecodeBytes(res.Data, &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range page.Items {
+			record := &rtypes.LogRecord{}
+			if err := rlp.DecodeBytes(item, record); err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, record)
+		}
+		if len(page.Next) == 0 {
+			break
+		}
+		token = page.Next
+	}
+	sameTestLogs(t, records, all)
+
+	res := app.Query(append([]byte{rtypes.QueryType_Logs}, load...))
+	if res.IsErr() || res.Log == "" {
+		t.Fatalf("expected the first page and the token of the next one, got %q", res.Log)
+	}
+}
+
+// BenchmarkStreamLogs streams the logs of a 10k blocks fixture, the records of
+// the stream are decoded from the receipt store one block at a time
+func BenchmarkStreamLogs(b *testing.B) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := viper.New()
+	conf.Set("db_dir", dir)
+	conf.Set("block_size", 100)
+	app, err := NewEVMApp(conf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := app.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer app.Stop()
+	writeTestLogBlocks(b, app, 10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var n int
+		err := app.StreamLogs(context.Background(), &LogStreamFilter{}, func(*rtypes.LogRecord) error {
+			n++
+			return nil
+		})
+		if err != nil || n != 10000*2*len(logFixtureAddresses) {
+			b.Fatalf("expected every log streamed, got %d %v", n, err)
+		}
+	}
+}
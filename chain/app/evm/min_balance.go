@@ -0,0 +1,35 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/params"
+)
+
+// withMinAccountBalance returns config with the min account balance set from decimal wei
+func withMinAccountBalance(config *params.ChainConfig, value string) (*params.ChainConfig, error) {
+	floor, ok := new(big.Int).SetString(value, 10)
+	if !ok || floor.Sign() < 0 {
+		return nil, fmt.Errorf("invalid min_account_balance_wei %q", value)
+	}
+	if floor.Sign() == 0 {
+		return config, nil
+	}
+	floored := *config
+	floored.MinAccountBalance = floor
+	return &floored, nil
+}
@@ -0,0 +1,116 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// sweepCode deploys a contract sending its whole balance to 0x01 when called,
+// reverting when the transfer fails
+var sweepCode = common.FromHex("6018600c60003960186000f3" +
+	"6000600060006000" + "3031" + "6001" + "5a" + "f1" + "6016" + "57" + "60006000fd" + "5b00")
+
+func newMinBalanceTestApp(t *testing.T, min string) (*EVMApp, func()) {
+	conf := viper.New()
+	conf.Set("min_account_balance_wei", min)
+	return newTestAppWithConfig(t, conf)
+}
+
+func TestMinAccountBalance(t *testing.T) {
+	key, addr := testKey(t, testKeyA)
+	to, untouched := common.HexToAddress("0x01"), common.HexToAddress("0x05")
+	contract := crypto.CreateAddress(addr, 1)
+	blocks := [][][]byte{
+		{
+			// leaves 9 wei, below the min
+			signTestTx(t, key, etypes.NewTransaction(0, to, big.NewInt(991), testGas, big.NewInt(0), nil)),
+			// leaves exactly the min after funding the contract
+			signTestTx(t, key, etypes.NewContractCreation(1, big.NewInt(100), testGas, big.NewInt(0), sweepCode)),
+		},
+		{
+			signTestTx(t, key, etypes.NewTransaction(2, to, big.NewInt(890), testGas, big.NewInt(0), nil)),
+			// the contract can't sweep itself below the min
+			signTestTx(t, key, etypes.NewTransaction(3, contract, big.NewInt(0), testGas, big.NewInt(0), nil)),
+			// an empty account touched by a 0 value transfer
+			signTestTx(t, key, etypes.NewTransaction(4, untouched, big.NewInt(0), testGas, big.NewInt(0), nil)),
+		},
+	}
+
+	var appHashes []common.Hash
+	for node := 0; node < 2; node++ {
+		app, clean := newMinBalanceTestApp(t, "10")
+		defer clean()
+		fundTestAccounts(t, app, big.NewInt(1000), addr)
+		for i, txs := range blocks {
+			res := execTestBlock(t, app, int64(i+1), txs...)
+			if len(res.InvalidTxs) != 0 {
+				t.Fatalf("unexpected invalid txs %+v", res.InvalidTxs)
+			}
+		}
+		for _, c := range []struct {
+			tx     []byte
+			failed bool
+		}{
+			{blocks[0][0], true},
+			{blocks[0][1], false},
+			{blocks[1][0], false},
+			{blocks[1][1], true},
+			{blocks[1][2], false},
+		} {
+			receipt := &etypes.ReceiptForStorage{}
+			if err := rlp.DecodeBytes(queryTestReceipt(t, app, txHash(c.tx)), receipt); err != nil {
+				t.Fatal(err)
+			}
+			if failed := receipt.Status == etypes.ReceiptStatusFailed; failed != c.failed {
+				t.Fatalf("tx %x: expected failed %v", txHash(c.tx), c.failed)
+			}
+		}
+		if balance := app.state.GetBalance(addr); balance.Cmp(big.NewInt(10)) != 0 {
+			t.Fatalf("expected the sender kept at the min, got %v", balance)
+		}
+		if balance := app.state.GetBalance(contract); balance.Cmp(big.NewInt(100)) != 0 {
+			t.Fatalf("expected the sweep reverted, contract balance %v", balance)
+		}
+		if balance := app.state.GetBalance(to); balance.Cmp(big.NewInt(890)) != 0 {
+			t.Fatalf("expected only the transfer keeping the min, got %v", balance)
+		}
+		if !app.state.Exist(untouched) {
+			t.Fatal("expected the empty account kept")
+		}
+		appHashes = append(appHashes, app.getLastAppHash())
+	}
+	if appHashes[0] != appHashes[1] {
+		t.Fatalf("nodes disagree on the app hash: %x %x", appHashes[0], appHashes[1])
+	}
+
+	// without a min the sweep goes through and the empty account is deleted
+	app, clean := newMinBalanceTestApp(t, "0")
+	defer clean()
+	fundTestAccounts(t, app, big.NewInt(1000), addr)
+	for i, txs := range blocks {
+		execTestBlock(t, app, int64(i+1), txs...)
+	}
+	if app.state.GetBalance(contract).Sign() != 0 || app.state.Exist(untouched) {
+		t.Fatal("expected the sweep and the empty account deletion without a min")
+	}
+}
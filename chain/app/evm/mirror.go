@@ -0,0 +1,373 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/eth/accounts/abi"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+)
+
+// MirrorCursorPrefix stores the last height delivered to each mirror sink, by name
+var MirrorCursorPrefix = []byte("mirrorcursor-")
+
+func mirrorCursorKey(name string) []byte {
+	return append(append([]byte{}, MirrorCursorPrefix...), name...)
+}
+
+const erc20TransferABI = `[{"anonymous":false,"name":"Transfer","type":"event","inputs":[
+	{"indexed":true,"name":"from","type":"address"},
+	{"indexed":true,"name":"to","type":"address"},
+	{"indexed":false,"name":"value","type":"uint256"}]}]`
+
+var erc20Transfer abi.Event
+
+func init() {
+	parsed, err := abi.JSON(strings.NewReader(erc20TransferABI))
+	if err != nil {
+		panic(err)
+	}
+	erc20Transfer = parsed.Events["Transfer"]
+}
+
+// MirrorBalance is the balance change of an account in a block
+type MirrorBalance struct {
+	Address common.Address
+	From    *big.Int
+	To      *big.Int
+}
+
+// MirrorTransfer is an ERC20 Transfer event emitted in a block
+type MirrorTransfer struct {
+	Token    common.Address
+	From     common.Address
+	To       common.Address
+	Value    *big.Int
+	TxHash   common.Hash
+	LogIndex uint
+}
+
+// MirrorCreation is a contract created in a block
+type MirrorCreation struct {
+	Address common.Address
+	TxHash  common.Hash
+}
+
+// MirrorBlock holds the records of one committed block, in block order. Blocks
+// are delivered even when they have no record, so sinks can follow the height.
+type MirrorBlock struct {
+	Height    uint64
+	Balances  []MirrorBalance // sorted by hashed address
+	Transfers []MirrorTransfer
+	Creations []MirrorCreation
+}
+
+// MirrorSink receives the records of committed blocks, in height order. A block
+// is delivered again until MirrorBlock returns nil, so sinks must tolerate a
+// block delivered more than once.
+type MirrorSink interface {
+	MirrorBlock(block *MirrorBlock) error
+}
+
+// RegisterMirrorSink mirrors the blocks committed after the last one delivered
+// to the sink of this name into sink, starting from the first block for a new
+// name. Delivery runs aside commits, a failing or slow sink catches up later.
+func (app *EVMApp) RegisterMirrorSink(name string, sink MirrorSink) {
+	app.mirror.register(name, sink)
+}
+
+// MirrorBlockRecords builds the mirror records of the block at height from its
+// light header, receipts and state.
+func (app *EVMApp) MirrorBlockRecords(height uint64) (*MirrorBlock, error) {
+	if err := app.checkReceiptsPruned(height); err != nil {
+		return nil, err
+	}
+	headers, err := app.LightHeaders(height, height)
+	if err != nil {
+		return nil, err
+	}
+	header := headers[0]
+	block := &MirrorBlock{Height: height}
+	if err := app.mirrorBalances(block, header.PrevAppHash, header.NewAppHash); err != nil {
+		return nil, err
+	}
+	if err := app.mirrorReceipts(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (app *EVMApp) mirrorBalances(block *MirrorBlock, prevRoot, root common.Hash) error {
+	diff, err := app.DiffStates(prevRoot, root, common.Hash{}, 0)
+	if err != nil {
+		return err
+	}
+	prevState, err := estate.New(prevRoot, estate.NewDatabase(app.stateDb))
+	if err != nil {
+		return err
+	}
+	state, err := estate.New(root, estate.NewDatabase(app.stateDb))
+	if err != nil {
+		return err
+	}
+	for _, account := range diff.Accounts {
+		if account.Address == nil {
+			log.Warn("mirror skips an account without address preimage", zap.String("key", account.Key.Hex()))
+			continue
+		}
+		from, to := prevState.GetBalance(*account.Address), state.GetBalance(*account.Address)
+		if from.Cmp(to) != 0 {
+			block.Balances = append(block.Balances, MirrorBalance{Address: *account.Address, From: from, To: to})
+		}
+	}
+	return nil
+}
+
+func (app *EVMApp) mirrorReceipts(block *MirrorBlock) error {
+	index, err := app.stateDb.Get(blockReceiptsKey(block.Height))
+	if err != nil || len(index) == 0 {
+		return nil
+	}
+	var txHashes []common.Hash
+	if err := rlp.DecodeBytes(index, &txHashes); err != nil {
+		return fmt.Errorf("decode receipts index of block %d: %v", block.Height, err)
+	}
+	var logIndex uint
+	for _, hash := range txHashes {
+		data, err := app.stateDb.Get(receiptKey(hash))
+		if err != nil {
+			return fmt.Errorf("get receipt %x: %v", hash, err)
+		}
+		env, err := app.decodeReceipt(data)
+		if err != nil {
+			return fmt.Errorf("decode receipt %x: %v", hash, err)
+		}
+		receipt := env.Receipt
+		if receipt.Status == etypes.ReceiptStatusSuccessful && receipt.ContractAddress != (common.Address{}) {
+			block.Creations = append(block.Creations, MirrorCreation{Address: receipt.ContractAddress, TxHash: hash})
+		}
+		for _, l := range receipt.Logs {
+			if transfer, ok := decodeERC20Transfer(l); ok {
+				transfer.TxHash, transfer.LogIndex = hash, logIndex
+				block.Transfers = append(block.Transfers, *transfer)
+			}
+			logIndex++
+		}
+	}
+	return nil
+}
+
+// decodeERC20Transfer decodes l as a Transfer(address,address,uint256) event
+func decodeERC20Transfer(l *etypes.Log) (*MirrorTransfer, bool) {
+	if len(l.Topics) != 3 || l.Topics[0] != erc20Transfer.Id() {
+		return nil, false
+	}
+	values, err := erc20Transfer.Inputs.NonIndexed().UnpackValues(l.Data)
+	if err != nil || len(values) != 1 {
+		return nil, false
+	}
+	value, ok := values[0].(*big.Int)
+	if !ok {
+		return nil, false
+	}
+	return &MirrorTransfer{
+		Token: l.Address,
+		From:  common.BytesToAddress(l.Topics[1].Bytes()),
+		To:    common.BytesToAddress(l.Topics[2].Bytes()),
+		Value: value,
+	}, true
+}
+
+// mirror delivers the records of committed blocks to the registered sinks in
+// the background, each from its own persisted cursor.
+type mirror struct {
+	app   *EVMApp
+	retry time.Duration
+
+	mtx   sync.Mutex
+	sinks map[string]MirrorSink
+
+	notify   chan struct{}
+	quit     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func newMirror(app *EVMApp, retry time.Duration) *mirror {
+	if retry <= 0 {
+		retry = time.Second
+	}
+	return &mirror{
+		app:    app,
+		retry:  retry,
+		sinks:  make(map[string]MirrorSink),
+		notify: make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+	}
+}
+
+func (m *mirror) register(name string, sink MirrorSink) {
+	m.mtx.Lock()
+	m.sinks[name] = sink
+	m.mtx.Unlock()
+	m.committed()
+}
+
+// Start runs the deliveries, it must be called once the state database is open.
+func (m *mirror) Start() {
+	m.wg.Add(1)
+	go m.run()
+}
+
+// Stop waits for the running delivery, it must be called before closing the database.
+func (m *mirror) Stop() {
+	m.stopOnce.Do(func() { close(m.quit) })
+	m.wg.Wait()
+}
+
+// committed wakes the deliveries up after a commit, without waiting for them.
+func (m *mirror) committed() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *mirror) run() {
+	defer m.wg.Done()
+	retry := time.NewTimer(m.retry)
+	defer retry.Stop()
+	for {
+		select {
+		case <-m.quit:
+			return
+		case <-m.notify:
+		case <-retry.C:
+		}
+		m.deliverAll()
+		if !retry.Stop() {
+			select {
+			case <-retry.C:
+			default:
+			}
+		}
+		retry.Reset(m.retry)
+	}
+}
+
+func (m *mirror) deliverAll() {
+	// the blocks replayed while catching up are delivered after the last one
+	committed := atomic.LoadInt64(&m.app.committedHeight)
+	if m.app.catchingUp(committed) {
+		return
+	}
+	m.mtx.Lock()
+	sinks := make(map[string]MirrorSink, len(m.sinks))
+	for name, sink := range m.sinks {
+		sinks[name] = sink
+	}
+	m.mtx.Unlock()
+
+	height := uint64(committed)
+	for name, sink := range sinks {
+		if err := m.deliver(name, sink, height); err != nil {
+			log.Warn("mirror delivery stopped, retrying later", zap.String("sink", name), zap.Error(err))
+		}
+	}
+}
+
+// deliver sends the blocks after the sink's cursor up to height, the cursor is
+// saved after each delivered block.
+func (m *mirror) deliver(name string, sink MirrorSink, height uint64) error {
+	cursor := m.cursor(name)
+	for next := cursor + 1; next <= height; next++ {
+		select {
+		case <-m.quit:
+			return nil
+		default:
+		}
+		block, err := m.app.MirrorBlockRecords(next)
+		if err != nil {
+			return fmt.Errorf("block %d: %v", next, err)
+		}
+		if err := sink.MirrorBlock(block); err != nil {
+			return fmt.Errorf("block %d: %v", next, err)
+		}
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, next)
+		if err := m.app.stateDb.Put(mirrorCursorKey(name), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cursor returns the last height delivered to the sink of this name
+func (m *mirror) cursor(name string) uint64 {
+	value, err := m.app.stateDb.Get(mirrorCursorKey(name))
+	if err != nil || len(value) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(value)
+}
+
+// MemoryMirrorSink keeps the mirrored blocks in memory, a reference sink for
+// tests and tools. Redelivered blocks replace the earlier delivery.
+type MemoryMirrorSink struct {
+	mtx    sync.Mutex
+	blocks map[uint64]*MirrorBlock
+	err    error
+}
+
+func NewMemoryMirrorSink() *MemoryMirrorSink {
+	return &MemoryMirrorSink{blocks: make(map[uint64]*MirrorBlock)}
+}
+
+func (s *MemoryMirrorSink) MirrorBlock(block *MirrorBlock) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.blocks[block.Height] = block
+	return nil
+}
+
+// SetError makes the sink refuse deliveries with err, or accept them again with nil.
+func (s *MemoryMirrorSink) SetError(err error) {
+	s.mtx.Lock()
+	s.err = err
+	s.mtx.Unlock()
+}
+
+// Block returns the delivered records of the block at height, if any.
+func (s *MemoryMirrorSink) Block(height uint64) (*MirrorBlock, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	block, ok := s.blocks[height]
+	return block, ok
+}
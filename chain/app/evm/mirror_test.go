@@ -0,0 +1,131 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+)
+
+// transferCode deploys a contract emitting Transfer(caller, 0x02, 42) when called
+func transferCode() []byte {
+	sig := crypto.Keccak256([]byte("Transfer(address,address,uint256)"))
+	runtime := common.FromHex("602a600052" + "73" + common.HexToAddress("0x02").Hex()[2:] + "33" + "7f" + common.Bytes2Hex(sig) + "60206000a300")
+	return append(common.FromHex("6042600c60003960426000f3"), runtime...)
+}
+
+func waitMirrorBlock(t *testing.T, sink *MemoryMirrorSink, height uint64) *MirrorBlock {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if block, ok := sink.Block(height); ok {
+			return block
+		}
+	}
+	t.Fatalf("block %d not mirrored", height)
+	return nil
+}
+
+func checkMirrorBlock(t *testing.T, got, expected *MirrorBlock) {
+	if len(got.Balances) != len(expected.Balances) || len(got.Transfers) != len(expected.Transfers) || len(got.Creations) != len(expected.Creations) {
+		t.Fatalf("block %d: unexpected records %+v", expected.Height, got)
+	}
+	for i, b := range expected.Balances {
+		g := got.Balances[i]
+		if g.Address != b.Address || g.From.Cmp(b.From) != 0 || g.To.Cmp(b.To) != 0 {
+			t.Fatalf("block %d: unexpected balance record %+v, expected %+v", expected.Height, g, b)
+		}
+	}
+	for i, tr := range expected.Transfers {
+		g := got.Transfers[i]
+		if g.Token != tr.Token || g.From != tr.From || g.To != tr.To || g.Value.Cmp(tr.Value) != 0 || g.TxHash != tr.TxHash || g.LogIndex != tr.LogIndex {
+			t.Fatalf("block %d: unexpected transfer record %+v, expected %+v", expected.Height, g, tr)
+		}
+	}
+	for i, c := range expected.Creations {
+		if got.Creations[i] != c {
+			t.Fatalf("block %d: unexpected creation record %+v, expected %+v", expected.Height, got.Creations[i], c)
+		}
+	}
+}
+
+func sortedMirrorBalances(balances ...MirrorBalance) []MirrorBalance {
+	sort.Slice(balances, func(i, j int) bool {
+		return bytes.Compare(crypto.Keccak256(balances[i].Address[:]), crypto.Keccak256(balances[j].Address[:])) < 0
+	})
+	return balances
+}
+
+func TestMirrorSink(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	sink := NewMemoryMirrorSink()
+	app.RegisterMirrorSink("memory", sink)
+
+	key, addr := testKey(t, testKeyA)
+	to := common.HexToAddress("0x01")
+	funds := big.NewInt(1000)
+	fundTestAccounts(t, app, funds, addr)
+	contract := crypto.CreateAddress(addr, 0)
+
+	deploy := signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), transferCode()))
+	pay := signTestTx(t, key, etypes.NewTransaction(1, to, big.NewInt(100), testGas, big.NewInt(0), nil))
+	execTestBlock(t, app, 1, deploy, pay)
+	checkMirrorBlock(t, waitMirrorBlock(t, sink, 1), &MirrorBlock{
+		Height: 1,
+		Balances: sortedMirrorBalances(
+			MirrorBalance{Address: addr, From: funds, To: big.NewInt(900)},
+			MirrorBalance{Address: to, From: big.NewInt(0), To: big.NewInt(100)},
+		),
+		Creations: []MirrorCreation{{Address: contract, TxHash: txHash(deploy)}},
+	})
+
+	call := signTestTx(t, key, etypes.NewTransaction(2, contract, big.NewInt(0), testGas, big.NewInt(0), nil))
+	execTestBlock(t, app, 2, call)
+	checkMirrorBlock(t, waitMirrorBlock(t, sink, 2), &MirrorBlock{
+		Height: 2,
+		Transfers: []MirrorTransfer{{
+			Token: contract, From: addr, To: common.HexToAddress("0x02"), Value: big.NewInt(42), TxHash: txHash(call),
+		}},
+	})
+
+	// commits go on while the sink is down, it catches up once back
+	sink.SetError(errors.New("sink down"))
+	execTestBlock(t, app, 3, signTestTx(t, key, etypes.NewTransaction(3, to, big.NewInt(5), testGas, big.NewInt(0), nil)))
+	execTestBlock(t, app, 4)
+	if _, ok := sink.Block(3); ok {
+		t.Fatal("expected no delivery to a failing sink")
+	}
+	sink.SetError(nil)
+	app.mirror.committed()
+	checkMirrorBlock(t, waitMirrorBlock(t, sink, 4), &MirrorBlock{Height: 4})
+	checkMirrorBlock(t, waitMirrorBlock(t, sink, 3), &MirrorBlock{
+		Height: 3,
+		Balances: sortedMirrorBalances(
+			MirrorBalance{Address: addr, From: big.NewInt(900), To: big.NewInt(895)},
+			MirrorBalance{Address: to, From: big.NewInt(100), To: big.NewInt(105)},
+		),
+	})
+	for deadline := time.Now().Add(5 * time.Second); app.mirror.cursor("memory") != 4; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the cursor at 4, got %d", app.mirror.cursor("memory"))
+		}
+	}
+}
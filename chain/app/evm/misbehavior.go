@@ -0,0 +1,188 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+	"math/big"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	ghash "github.com/dappledger/AnnChain/gemmill/go-hash"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// MisbehaviorAddress is the system contract holding the validator misbehavior
+// evidence recorded from blocks. Its storage, all words big endian:
+//
+//	slot 0                   number of records n
+//	slot keccak256(i) + 0    offender validator address of record i < n
+//	slot keccak256(i) + 1    height of the conflicting votes
+//	slot keccak256(i) + 2    height of the block recording the evidence
+//	slot keccak256(i) + 3    evidence hash
+//	slot keccak256(offender, height, round)  1 once recorded
+//
+// Addresses and hashes are right aligned, their length follows the core hasher.
+var MisbehaviorAddress = common.BytesToAddress([]byte("misbehavior"))
+
+var (
+	errEvidenceFuture       = errors.New("evidence of votes above the block height")
+	errEvidenceTooOld       = errors.New("evidence older than misbehavior_max_age")
+	errEvidenceRecorded     = errors.New("evidence already recorded")
+	errEvidenceNoCore       = errors.New("no validator sets to check the evidence against")
+	errOffenderNotValidator = errors.New("evidence offender is not a validator at the height of the votes")
+)
+
+func misbehaviorSlot(i uint64, field int64) common.Hash {
+	base := new(big.Int).SetBytes(crypto.Keccak256(common.BigToHash(new(big.Int).SetUint64(i)).Bytes()))
+	return common.BigToHash(base.Add(base, big.NewInt(field)))
+}
+
+func misbehaviorSeenSlot(ev *gtypes.DuplicateVoteEvidence) common.Hash {
+	vote := ev.VoteA
+	return crypto.Keccak256Hash(
+		common.BytesToHash(ev.Address()).Bytes(),
+		common.BigToHash(big.NewInt(vote.Height)).Bytes(),
+		common.BigToHash(big.NewInt(vote.Round)).Bytes(),
+	)
+}
+
+// checkEvidence checks ev may be recorded by the block at height of chainID: its
+// votes conflict, aren't above the block nor older than misbehavior_max_age
+// blocks, and no evidence of the same offender, height and round is recorded in
+// state. It reads nothing but the block and the state, so every node executing
+// the block decides alike.
+func (app *EVMApp) checkEvidence(state *estate.StateDB, ev *gtypes.DuplicateVoteEvidence, chainID string, height int64) error {
+	if err := ev.Verify(chainID); err != nil {
+		return err
+	}
+	voteHeight := ev.Height()
+	if voteHeight > height {
+		return errEvidenceFuture
+	}
+	if app.misbehaviorMaxAge > 0 && voteHeight+app.misbehaviorMaxAge < height {
+		return errEvidenceTooOld
+	}
+	if state.GetState(MisbehaviorAddress, misbehaviorSeenSlot(ev)) != (common.Hash{}) {
+		return errEvidenceRecorded
+	}
+	return nil
+}
+
+// sameMisbehavior tells whether the txs a and b are evidence of the same
+// offender, height and round, of which a block records one
+func sameMisbehavior(a, b []byte) bool {
+	if !gtypes.IsEvidenceTx(a) || !gtypes.IsEvidenceTx(b) {
+		return false
+	}
+	evA, errA := gtypes.DecodeEvidenceTx(a)
+	evB, errB := gtypes.DecodeEvidenceTx(b)
+	return errA == nil && errB == nil && misbehaviorSeenSlot(evA) == misbehaviorSeenSlot(evB)
+}
+
+// checkEvidenceOffender refuses ev unless its offender was a validator at the
+// height of the votes. The validator sets are recorded by the core of the node
+// from the height it runs the recording from, so only the pool checks it.
+func (app *EVMApp) checkEvidenceOffender(ev *gtypes.DuplicateVoteEvidence) error {
+	if app.core == nil {
+		return errEvidenceNoCore
+	}
+	validators, err := app.core.ValidatorsAt(ev.Height())
+	if err != nil {
+		return err
+	}
+	if !validators.HasAddress(ev.Address()) {
+		return errOffenderNotValidator
+	}
+	return nil
+}
+
+// checkEvidenceTx refuses an evidence tx of an offender out of the validators or
+// the next block wouldn't record, before it enters the pool
+func (app *EVMApp) checkEvidenceTx(tx []byte) error {
+	ev, err := gtypes.DecodeEvidenceTx(tx)
+	if err != nil {
+		return err
+	}
+	app.stateMtx.Lock()
+	err = app.checkEvidence(app.state, ev, app.Config.GetString("chain_id"), atomic.LoadInt64(&app.committedHeight)+1)
+	app.stateMtx.Unlock()
+	if err != nil {
+		return err
+	}
+	return app.checkEvidenceOffender(ev)
+}
+
+// recordMisbehavior writes the valid evidence txs of block into the misbehavior
+// contract, up to misbehavior_block_limit records. Evidence failing
+// checkEvidence is skipped.
+func (app *EVMApp) recordMisbehavior(state *estate.StateDB, block *gtypes.Block) {
+	recorded := uint64(0)
+	for _, tx := range block.Data.ExTxs {
+		if !gtypes.IsEvidenceTx(tx) {
+			continue
+		}
+		if app.misbehaviorBlockLimit > 0 && recorded >= app.misbehaviorBlockLimit {
+			log.Warn("[evm execute] evidence beyond misbehavior_block_limit", zap.Int64("height", block.Height))
+			break
+		}
+		ev, err := gtypes.DecodeEvidenceTx(tx)
+		if err == nil {
+			err = app.checkEvidence(state, ev, block.ChainID, block.Height)
+		}
+		if err != nil {
+			log.Warn("[evm execute] evidence not recorded", zap.Error(err), zap.Int64("height", block.Height))
+			continue
+		}
+		height := ev.Height()
+		seen := misbehaviorSeenSlot(ev)
+		// a nonce keeps the contract from being removed as an empty account
+		if state.GetNonce(MisbehaviorAddress) == 0 {
+			state.SetNonce(MisbehaviorAddress, 1)
+		}
+		n := state.GetState(MisbehaviorAddress, common.Hash{}).Big().Uint64()
+		state.SetState(MisbehaviorAddress, misbehaviorSlot(n, 0), common.BytesToHash(ev.Address()))
+		state.SetState(MisbehaviorAddress, misbehaviorSlot(n, 1), common.BigToHash(big.NewInt(height)))
+		state.SetState(MisbehaviorAddress, misbehaviorSlot(n, 2), common.BigToHash(big.NewInt(block.Height)))
+		state.SetState(MisbehaviorAddress, misbehaviorSlot(n, 3), common.BytesToHash(ev.Hash()))
+		state.SetState(MisbehaviorAddress, seen, common.BigToHash(big.NewInt(1)))
+		state.SetState(MisbehaviorAddress, common.Hash{}, common.BigToHash(new(big.Int).SetUint64(n+1)))
+		recorded++
+	}
+}
+
+// listMisbehavior lists the rtypes.Misbehavior recorded in the latest state, in
+// record order, the token is the index of a record.
+func (app *EVMApp) listMisbehavior(load, token []byte, limit int) ([]listItem, []byte, error) {
+	hashLen := len(ghash.DoHash(nil))
+	app.stateMtx.Lock()
+	defer app.stateMtx.Unlock()
+	n := app.state.GetState(MisbehaviorAddress, common.Hash{}).Big().Uint64()
+	return indexedList(n, token, limit, func(i uint64) interface{} {
+		offender := app.state.GetState(MisbehaviorAddress, misbehaviorSlot(i, 0))
+		evHash := app.state.GetState(MisbehaviorAddress, misbehaviorSlot(i, 3))
+		return rtypes.Misbehavior{
+			Offender:       offender[common.HashLength-hashLen:],
+			Height:         app.state.GetState(MisbehaviorAddress, misbehaviorSlot(i, 1)).Big().Uint64(),
+			RecordedHeight: app.state.GetState(MisbehaviorAddress, misbehaviorSlot(i, 2)).Big().Uint64(),
+			EvidenceHash:   evHash[common.HashLength-hashLen:],
+		}
+	})
+}
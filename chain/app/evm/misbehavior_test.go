@@ -0,0 +1,164 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/gemmill/go-crypto"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func signTestVote(priv crypto.PrivKeyEd25519, height int64, blockHash string) *gtypes.Vote {
+	vote := &gtypes.Vote{
+		ValidatorAddress: priv.PubKey().Address(),
+		Height:           height,
+		Type:             gtypes.VoteTypePrevote,
+		BlockID:          gtypes.BlockID{Hash: []byte(blockHash)},
+	}
+	vote.Signature = priv.Sign(gtypes.SignBytes("", vote))
+	return vote
+}
+
+// testValidators is the validator set of the keys, of power 1 each
+func testValidators(privs ...crypto.PrivKeyEd25519) *gtypes.ValidatorSet {
+	validators := make([]*gtypes.Validator, len(privs))
+	for i, priv := range privs {
+		validators[i] = gtypes.NewValidator(priv.PubKey(), 1, false)
+	}
+	return gtypes.NewValidatorSet(validators)
+}
+
+// execTestEvidenceBlock executes and commits one block carrying the evidence txs
+func execTestEvidenceBlock(t *testing.T, app *EVMApp, height int64, evidence ...[]byte) []byte {
+	block := makeTestBlock(height)
+	for _, tx := range evidence {
+		block.Data.ExTxs = append(block.Data.ExTxs, tx)
+	}
+	if _, err := app.OnExecute(height, 0, block); err != nil {
+		t.Fatal(err)
+	}
+	res, err := app.OnCommit(height, 0, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.(gtypes.CommitResult).AppHash
+}
+
+func queryTestMisbehavior(t *testing.T, app *EVMApp) []rtypes.Misbehavior {
+	res := app.Query([]byte{rtypes.QueryType_Misbehavior})
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var records []rtypes.Misbehavior
+	if _, err := decodePage(res.Data, &records); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestRecordMisbehavior(t *testing.T) {
+	priv := crypto.GenPrivKeyEd25519()
+	voteA, voteB := signTestVote(priv, 2, "a"), signTestVote(priv, 2, "b")
+	ev := gtypes.NewDuplicateVoteEvidence(priv.PubKey(), voteA, voteB)
+	swapped := gtypes.TagEvidenceTx(&gtypes.DuplicateVoteEvidence{PubKey: priv.PubKey(), VoteA: voteB, VoteB: voteA})
+	forged := signTestVote(crypto.GenPrivKeyEd25519(), 2, "c")
+	forged.ValidatorAddress = voteA.ValidatorAddress
+	old := gtypes.NewDuplicateVoteEvidence(priv.PubKey(), signTestVote(priv, 1, "a"), signTestVote(priv, 1, "b"))
+
+	conf := viper.New()
+	conf.Set("misbehavior_max_age", 2)
+	var hashes [2][]byte
+	var records [2][]rtypes.Misbehavior
+	for node := range hashes {
+		app, clean := newTestAppWithConfig(t, conf)
+		// the execution doesn't depend on the validator sets the core recorded
+		if node == 0 {
+			app.SetCore(&testCore{validators: testValidators(priv)})
+		}
+		execTestEvidenceBlock(t, app, 1)
+		execTestEvidenceBlock(t, app, 2)
+		execTestEvidenceBlock(t, app, 3, gtypes.TagEvidenceTx(ev), swapped,
+			gtypes.TagEvidenceTx(&gtypes.DuplicateVoteEvidence{PubKey: priv.PubKey(), VoteA: voteA, VoteB: forged}))
+		hashes[node] = execTestEvidenceBlock(t, app, 4, gtypes.TagEvidenceTx(ev), gtypes.TagEvidenceTx(old))
+		records[node] = queryTestMisbehavior(t, app)
+		clean()
+	}
+
+	expected := []rtypes.Misbehavior{{Offender: priv.PubKey().Address(), Height: 2, RecordedHeight: 3, EvidenceHash: ev.Hash()}}
+	if !reflect.DeepEqual(records[0], expected) {
+		t.Fatalf("expected one record of the evidence, got %+v", records[0])
+	}
+	if !bytes.Equal(hashes[0], hashes[1]) || !reflect.DeepEqual(records[0], records[1]) {
+		t.Fatal("expected both nodes to record the same evidence")
+	}
+}
+
+func TestMisbehaviorChecks(t *testing.T) {
+	validator, other, outsider := crypto.GenPrivKeyEd25519(), crypto.GenPrivKeyEd25519(), crypto.GenPrivKeyEd25519()
+	evidence := func(priv crypto.PrivKeyEd25519, voteType byte) []byte {
+		voteA, voteB := signTestVote(priv, 1, "a"), signTestVote(priv, 1, "b")
+		for _, vote := range []*gtypes.Vote{voteA, voteB} {
+			vote.Type = voteType
+			vote.Signature = priv.Sign(gtypes.SignBytes("", vote))
+		}
+		return gtypes.TagEvidenceTx(gtypes.NewDuplicateVoteEvidence(priv.PubKey(), voteA, voteB))
+	}
+	byValidator, byOther, byOutsider := evidence(validator, gtypes.VoteTypePrevote), evidence(other, gtypes.VoteTypePrevote), evidence(outsider, gtypes.VoteTypePrevote)
+	// the same offender, height and round as byValidator
+	precommits := evidence(validator, gtypes.VoteTypePrecommit)
+
+	conf := viper.New()
+	conf.Set("misbehavior_block_limit", 1)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	app.SetCore(&testCore{validators: testValidators(validator, other)})
+
+	// the pool refuses the evidence of a key out of the validators, and the
+	// evidence of a misbehavior it holds already
+	if err := app.pool.ReceiveTx(byOutsider); err != errOffenderNotValidator {
+		t.Fatalf("expected the evidence of a non validator refused, got %v", err)
+	}
+	for _, tx := range [][]byte{byValidator, byOther} {
+		if err := app.pool.ReceiveTx(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.pool.ReceiveTx(precommits); err != errTxExist {
+		t.Fatalf("expected the evidence of the same round refused, got %v", err)
+	}
+	if txs := app.pool.reapAdminOP(10); len(txs) != 1 {
+		t.Fatalf("expected the evidence reaped up to misbehavior_block_limit, got %d", len(txs))
+	}
+
+	// on execution, the evidence beyond the block limit or of a round recorded
+	// already isn't recorded
+	execTestEvidenceBlock(t, app, 1, byValidator, byOther)
+	if records := queryTestMisbehavior(t, app); len(records) != 1 || !bytes.Equal(records[0].Offender, validator.PubKey().Address()) {
+		t.Fatalf("expected the validator's evidence recorded, got %+v", records)
+	}
+	execTestEvidenceBlock(t, app, 2, precommits, byOther)
+	records := queryTestMisbehavior(t, app)
+	if len(records) != 2 || !bytes.Equal(records[1].Offender, other.PubKey().Address()) || records[1].RecordedHeight != 2 {
+		t.Fatalf("expected the evidence beyond the limit recorded in the next block, got %+v", records)
+	}
+	if err := app.pool.ReceiveTx(precommits); err != errEvidenceRecorded {
+		t.Fatalf("expected the evidence of a recorded round refused, got %v", err)
+	}
+}
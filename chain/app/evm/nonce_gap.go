@@ -0,0 +1,89 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// exec_nonce_gap values, how execution handles a tx whose nonce is above the
+// next nonce of its sender in the executing state. CheckTx only sees the pool's
+// view of the nonces, and a proposer may put any tx in a block, so gapped txs
+// do reach execution. Every mode changes the block results, so every validator
+// must run the same exec_nonce_gap.
+const (
+	nonceGapState  = "state"  // left to the state transition, failing the tx with nonce too high
+	nonceGapReject = "reject" // failed with ErrNonceGap before the per block limits count the tx
+	nonceGapDefer  = "defer"  // retried after the rest of the block, failed with ErrNonceGap if still gapped
+)
+
+// ErrNonceGap fails a tx whose nonce is above the next nonce of its sender
+var ErrNonceGap = errors.New("tx nonce above the sender's next nonce")
+
+func validNonceGap(mode string) bool {
+	return mode == nonceGapState || mode == nonceGapReject || mode == nonceGapDefer
+}
+
+// deferredTx is a tx of a block deferred for a nonce gap
+type deferredTx struct {
+	pos int // position of the tx in the executed block txs
+	raw gtypes.Tx
+}
+
+// checkNonceGap returns ErrNonceGap when the nonce of tx is above the next nonce
+// of its sender in state. Txs with a nonce below it are left to the state
+// transition, which fails them with nonce too low.
+func (app *EVMApp) checkNonceGap(state *estate.StateDB, tx *etypes.Transaction) error {
+	if app.nonceGap == nonceGapState {
+		return nil
+	}
+	from, err := app.senders.sender(app.Signer, tx)
+	if err != nil {
+		return err
+	}
+	if tx.Nonce() > state.GetNonce(from) {
+		return ErrNonceGap
+	}
+	return nil
+}
+
+// execDeferred retries the txs deferred for a nonce gap once the rest of the
+// block executed, pass after pass while a pass executes some of them: a gap only
+// closes when the missing nonces of the sender execute. The txs left gapped are
+// invalid with ErrNonceGap. Deferred txs keep their block position in their
+// receipts. With tx_order reorder the txs of a sender already run in nonce order,
+// so only gaps no tx of the block fills are deferred, and they all fail.
+func (app *EVMApp) execDeferred(exec *blockExecution, beginExec BeginExecFunc) {
+	for len(exec.deferred) > 0 {
+		pass := exec.deferred
+		exec.deferred = nil
+		txs := make(gtypes.Txs, len(pass))
+		exec.positions = make([]int, len(pass))
+		for i, d := range pass {
+			txs[i], exec.positions[i] = d.raw, d.pos
+		}
+		exeWithCPUParallelVeirfy(app.Signer, txs, nil, beginExec)
+		if len(exec.deferred) == len(pass) {
+			for _, d := range exec.deferred {
+				exec.res.InvalidTxs = append(exec.res.InvalidTxs, gtypes.ExecuteInvalidTx{Bytes: d.raw, Error: ErrNonceGap})
+			}
+			exec.deferred = nil
+		}
+	}
+	exec.positions = nil
+}
@@ -0,0 +1,113 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func TestExecNonceGap(t *testing.T) {
+	key, from := testKey(t, testKeyA)
+	to := common.HexToAddress("0x01")
+	nonceTx := func(nonce uint64) []byte {
+		return signTestTx(t, key, etypes.NewTransaction(nonce, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+	}
+	// nonce 1 reaches execution before nonce 0, nonce 5 is never filled
+	tx1, tx0, tx5 := nonceTx(1), nonceTx(0), nonceTx(5)
+
+	for _, c := range []struct {
+		mode    string
+		valid   [][]byte
+		gapErr  error
+		invalid int
+		nonce   uint64
+	}{
+		{mode: nonceGapState, valid: [][]byte{tx0}, gapErr: core.ErrNonceTooHigh, invalid: 2, nonce: 1},
+		{mode: nonceGapReject, valid: [][]byte{tx0}, gapErr: ErrNonceGap, invalid: 2, nonce: 1},
+		{mode: nonceGapDefer, valid: [][]byte{tx0, tx1}, gapErr: ErrNonceGap, invalid: 1, nonce: 2},
+	} {
+		conf := viper.New()
+		conf.Set("exec_nonce_gap", c.mode)
+		app, clean := newTestAppWithConfig(t, conf)
+
+		res := execTestBlock(t, app, 1, tx1, tx0, tx5)
+		if len(res.ValidTxs) != len(c.valid) || len(res.InvalidTxs) != c.invalid {
+			t.Fatalf("%s: expected %d valid %d invalid txs, got %d valid %d invalid", c.mode, len(c.valid), c.invalid, len(res.ValidTxs), len(res.InvalidTxs))
+		}
+		for i, raw := range c.valid {
+			if txHash(res.ValidTxs[i]) != txHash(raw) {
+				t.Fatalf("%s: unexpected valid tx %d", c.mode, i)
+			}
+		}
+		for _, invalid := range res.InvalidTxs {
+			if invalid.Error != c.gapErr {
+				t.Fatalf("%s: expected error %v, got %v", c.mode, c.gapErr, invalid.Error)
+			}
+		}
+		if nonce := app.state.GetNonce(from); nonce != c.nonce {
+			t.Fatalf("%s: expected sender nonce %d, got %d", c.mode, c.nonce, nonce)
+		}
+		clean()
+	}
+}
+
+func TestExecNonceGapDeferredPosition(t *testing.T) {
+	conf := viper.New()
+	conf.Set("exec_nonce_gap", nonceGapDefer)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	to := common.HexToAddress("0x01")
+	tx1 := signTestTx(t, key, etypes.NewTransaction(1, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+	tx0 := signTestTx(t, key, etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+
+	exec, err := app.executeBlock(app.state.Copy(), makeTestBlock(1, tx1, tx0), common.Address{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exec.receiptEnvs) != 2 {
+		t.Fatalf("expected 2 receipts, got %d", len(exec.receiptEnvs))
+	}
+	// the deferred tx keeps its position in the block
+	if exec.receiptEnvs[0].TxIndex != 1 || exec.receiptEnvs[1].TxIndex != 0 {
+		t.Fatalf("expected tx indexes 1 and 0, got %d and %d", exec.receiptEnvs[0].TxIndex, exec.receiptEnvs[1].TxIndex)
+	}
+}
+
+func TestExecNonceGapSenderLimit(t *testing.T) {
+	key, _ := testKey(t, testKeyA)
+	to := common.HexToAddress("0x01")
+	tx1 := signTestTx(t, key, etypes.NewTransaction(1, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+	tx0 := signTestTx(t, key, etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+
+	for mode, valid := range map[string]int{nonceGapState: 0, nonceGapReject: 1} {
+		conf := viper.New()
+		conf.Set("max_txs_per_sender", 1)
+		conf.Set("exec_nonce_gap", mode)
+		app, clean := newTestAppWithConfig(t, conf)
+		// failing in the state transition the gapped tx takes the sender's only slot
+		if res := execTestBlock(t, app, 1, tx1, tx0); len(res.ValidTxs) != valid {
+			t.Fatalf("%s: expected %d valid txs, got %d", mode, valid, len(res.ValidTxs))
+		}
+		clean()
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var errPoolDropStatsDisabled = errors.New("pool drop stats disabled, see pool_drop_stats_hours")
+
+// poolDropBucket is the drops of one minute, the minute never changes once the
+// bucket is in its slot
+type poolDropBucket struct {
+	minute int64 // unix minutes
+	counts [rtypes.PoolDropReasons]uint64
+}
+
+// poolDropStats keeps the drops of the tx pool by reason for the last hours, a
+// ring of per minute buckets. Drops are recorded on the CheckTx path without a
+// lock: the bucket of the current minute is counted into atomically, and the
+// first drop of a minute swaps a new bucket into the slot of the minute the ring
+// last held there. A nil poolDropStats records nothing.
+type poolDropStats struct {
+	slots []unsafe.Pointer // *poolDropBucket of the minute m at m % len(slots)
+	now   func() time.Time
+
+	// subscribers of the stats published each minute
+	mtx  sync.Mutex
+	subs map[chan *rtypes.PoolDropStats]struct{}
+}
+
+func newPoolDropStats(hours int) *poolDropStats {
+	if hours <= 0 {
+		return nil
+	}
+	return &poolDropStats{
+		slots: make([]unsafe.Pointer, hours*60),
+		now:   time.Now,
+		subs:  make(map[chan *rtypes.PoolDropStats]struct{}),
+	}
+}
+
+func (s *poolDropStats) record(reason rtypes.PoolDropReason) {
+	if s == nil {
+		return
+	}
+	minute := s.now().Unix() / 60
+	slot := &s.slots[minute%int64(len(s.slots))]
+	for {
+		p := atomic.LoadPointer(slot)
+		if b := (*poolDropBucket)(p); b != nil && b.minute >= minute {
+			// a drop late for a minute rolled over is lost
+			if b.minute == minute {
+				atomic.AddUint64(&b.counts[reason], 1)
+			}
+			return
+		}
+		fresh := &poolDropBucket{minute: minute}
+		fresh.counts[reason] = 1
+		if atomic.CompareAndSwapPointer(slot, p, unsafe.Pointer(fresh)) {
+			return
+		}
+	}
+}
+
+// recordErr records the drop of a tx the pool failed to take with err, the
+// errors of no drop reason aren't recorded
+func (s *poolDropStats) recordErr(err error) {
+	switch err {
+	case ErrReplaceUnderpriced:
+		s.record(rtypes.PoolDrop_Underpriced)
+	case errTxPoolWaitingQueueIsFull:
+		s.record(rtypes.PoolDrop_PoolFull)
+	}
+}
+
+// buckets returns the buckets of the minutes from first to last with drops
+func (s *poolDropStats) buckets(first, last int64) []rtypes.PoolDropBucket {
+	if oldest := last - int64(len(s.slots)) + 1; first < oldest {
+		first = oldest
+	}
+	buckets := make([]rtypes.PoolDropBucket, 0)
+	for minute := first; minute <= last; minute++ {
+		b := (*poolDropBucket)(atomic.LoadPointer(&s.slots[minute%int64(len(s.slots))]))
+		if b == nil || b.minute != minute {
+			continue
+		}
+		bucket := rtypes.PoolDropBucket{Minute: uint64(minute * 60), Counts: make([]uint64, len(b.counts))}
+		for i := range b.counts {
+			bucket.Counts[i] = atomic.LoadUint64(&b.counts[i])
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
+
+// subscribe returns a channel receiving the stats published each minute and the
+// func ending the subscription. A subscriber not keeping up misses the stats
+// published while its buffer is full.
+func (s *poolDropStats) subscribe(buffer int) (<-chan *rtypes.PoolDropStats, func()) {
+	ch := make(chan *rtypes.PoolDropStats, buffer)
+	s.mtx.Lock()
+	s.subs[ch] = struct{}{}
+	s.mtx.Unlock()
+	return ch, func() {
+		s.mtx.Lock()
+		delete(s.subs, ch)
+		s.mtx.Unlock()
+	}
+}
+
+// publish sends the subscribers the bucket of the minute just closed, if it had
+// drops, with the current watermarks
+func (s *poolDropStats) publish(watermarks rtypes.PoolWatermarks) {
+	if s == nil {
+		return
+	}
+	now := s.now()
+	minute := now.Unix()/60 - 1
+	stats := &rtypes.PoolDropStats{Buckets: s.buckets(minute, minute), Watermarks: watermarks, Time: uint64(now.Unix())}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- stats:
+		default:
+		}
+	}
+}
+
+// SubscribePoolDropStats returns a channel receiving the pool drops of each
+// minute once it closes, along with the pool watermarks, and the func ending the
+// subscription. The stats are published by the pool every minute, with no
+// bucket for a minute without drops; a subscriber whose buffer is full misses
+// them.
+func (app *EVMApp) SubscribePoolDropStats(buffer int) (<-chan *rtypes.PoolDropStats, func(), error) {
+	if app.poolDrops == nil {
+		return nil, nil, errPoolDropStatsDisabled
+	}
+	ch, cancel := app.poolDrops.subscribe(buffer)
+	return ch, cancel, nil
+}
+
+// watermarks is the fill of the pool queues and of the CheckTx slots
+func (tp *ethTxPool) watermarks() rtypes.PoolWatermarks {
+	tp.Lock()
+	var pending, waiting int
+	for _, txs := range tp.pending {
+		pending += txs.Len()
+	}
+	for _, txs := range tp.waiting {
+		waiting += txs.Len()
+	}
+	tp.Unlock()
+	checkTxs, checkTxLimit := tp.app.checkTxs.inUse()
+	return rtypes.PoolWatermarks{
+		Pending:      uint64(pending),
+		PendingLimit: uint64(tp.pendingLimit),
+		Waiting:      uint64(waiting),
+		WaitingLimit: uint64(tp.waitingLimit),
+		CheckTxs:     uint64(checkTxs),
+		CheckTxLimit: uint64(checkTxLimit),
+	}
+}
+
+// queryPoolDropStats takes the number of minutes as 8 bytes big endian, 0 or
+// empty for all the minutes kept, and returns the rlp encoded rtypes.PoolDropStats of
+// the last minutes, the current one included.
+func (app *EVMApp) queryPoolDropStats(load []byte) gtypes.Result {
+	if app.poolDrops == nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, errPoolDropStatsDisabled.Error())
+	}
+	minutes := int64(len(app.poolDrops.slots))
+	if len(load) == 8 {
+		if n := binary.BigEndian.Uint64(load); n > 0 && n < uint64(minutes) {
+			minutes = int64(n)
+		}
+	} else if len(load) != 0 {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid minutes")
+	}
+	now := app.poolDrops.now()
+	last := now.Unix() / 60
+	stats := &rtypes.PoolDropStats{
+		Buckets:    app.poolDrops.buckets(last-minutes+1, last),
+		Watermarks: app.pool.watermarks(),
+		Time:       uint64(now.Unix()),
+	}
+	data, err := rlp.EncodeToBytes(stats)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
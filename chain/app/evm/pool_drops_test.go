@@ -0,0 +1,170 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"math/big"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func queryTestPoolDropStats(t *testing.T, app *EVMApp, minutes uint64) *rtypes.PoolDropStats {
+	load := make([]byte, 8)
+	binary.BigEndian.PutUint64(load, minutes)
+	res := app.Query(append([]byte{rtypes.QueryType_PoolDropStats}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	stats := &rtypes.PoolDropStats{}
+	if err := rlp.DecodeBytes(res.Data, stats); err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestPoolDropStats(t *testing.T) {
+	conf := viper.New()
+	conf.Set("block_size", 1) // waiting queue holds 10 txs
+	conf.Set("min_gas_price", "10")
+	conf.Set("pool_drop_stats_hours", 1)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	start := time.Unix(1600000000, 0).Truncate(time.Minute)
+	clock := start
+	app.poolDrops.now = func() time.Time { return clock }
+
+	keyA, _ := testKey(t, testKeyA)
+	keyB, addrB := testKey(t, testKeyB)
+	fundTestAccounts(t, app, new(big.Int).Mul(big.NewInt(10*testGas), big.NewInt(10)), addrB)
+	tx := func(key *ecdsa.PrivateKey, nonce uint64, data byte) []byte {
+		return signTestTx(t, key, etypes.NewTransaction(nonce, common.HexToAddress("0x01"), big.NewInt(0), testGas, big.NewInt(10), []byte{data}))
+	}
+	// underpriced
+	if err := app.CheckTx(signTestTx(t, keyA, etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(1), nil))); err == nil {
+		t.Fatal("expected the underpriced tx refused")
+	}
+	// the waiting queue filled by a nonce gap, then full for another sender
+	for nonce := uint64(2); nonce <= 11; nonce++ {
+		if err := app.pool.ReceiveTx(tx(keyA, nonce, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.pool.ReceiveTx(tx(keyB, 5, 0)); err != errTxPoolWaitingQueueIsFull {
+		t.Fatalf("expected the waiting queue full, got %v", err)
+	}
+	// a lower nonce replaces the highest waiting one
+	if err := app.pool.ReceiveTx(tx(keyA, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	// the 10 waiting txs expire
+	app.pool.Lock()
+	app.pool.waitingLifeTime = 0
+	app.pool.evictStaleWaiting()
+	app.pool.Unlock()
+	// a nonce already committed
+	execTestBlock(t, app, 1, tx(keyB, 0, 0))
+	if err := app.pool.ReceiveTx(tx(keyB, 0, 1)); err == nil {
+		t.Fatal("expected the stale nonce refused")
+	}
+
+	stats := queryTestPoolDropStats(t, app, 0)
+	expected := []rtypes.PoolDropBucket{{Minute: uint64(start.Unix()), Counts: []uint64{1, 1, 10, 1, 1}}}
+	if !reflect.DeepEqual(stats.Buckets, expected) {
+		t.Fatalf("expected buckets %+v, got %+v", expected, stats.Buckets)
+	}
+	if w := stats.Watermarks; w.WaitingLimit != 10 || w.PendingLimit != 10 || w.Waiting != 0 {
+		t.Fatalf("unexpected watermarks %+v", w)
+	}
+
+	// the next minute has its own bucket, the minutes without drops none
+	clock = start.Add(3 * time.Minute)
+	app.poolDrops.record(rtypes.PoolDrop_Underpriced)
+	stats = queryTestPoolDropStats(t, app, 0)
+	if len(stats.Buckets) != 2 || stats.Buckets[1].Minute != uint64(clock.Unix()) || stats.Buckets[1].Counts[rtypes.PoolDrop_Underpriced] != 1 {
+		t.Fatalf("expected a second bucket, got %+v", stats.Buckets)
+	}
+	if stats = queryTestPoolDropStats(t, app, 1); len(stats.Buckets) != 1 || stats.Buckets[0].Minute != uint64(clock.Unix()) {
+		t.Fatalf("expected the bucket of the last minute, got %+v", stats.Buckets)
+	}
+
+	// an hour later the first minute rolled over, its slot takes the new minute
+	clock = start.Add(time.Hour)
+	stats = queryTestPoolDropStats(t, app, 0)
+	if len(stats.Buckets) != 1 || stats.Buckets[0].Minute != uint64(start.Add(3*time.Minute).Unix()) {
+		t.Fatalf("expected the first minute rolled over, got %+v", stats.Buckets)
+	}
+	app.poolDrops.record(rtypes.PoolDrop_Replaced)
+	stats = queryTestPoolDropStats(t, app, 0)
+	last := stats.Buckets[len(stats.Buckets)-1]
+	if len(stats.Buckets) != 2 || last.Minute != uint64(clock.Unix()) || !reflect.DeepEqual(last.Counts, []uint64{0, 0, 0, 1, 0}) {
+		t.Fatalf("expected the slot reused for the new minute, got %+v", stats.Buckets)
+	}
+}
+
+func TestPoolDropStatsPublish(t *testing.T) {
+	drops := newPoolDropStats(1)
+	clock := time.Unix(1600000000, 0).Truncate(time.Minute)
+	drops.now = func() time.Time { return clock }
+	events, cancel := drops.subscribe(1)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				drops.record(rtypes.PoolDrop_PoolFull)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the stats of the minute just closed are published, a full buffer drops
+	// the next ones
+	minute := clock
+	clock = clock.Add(time.Minute)
+	watermarks := rtypes.PoolWatermarks{Pending: 3, PendingLimit: 10}
+	drops.publish(watermarks)
+	drops.publish(watermarks)
+	stats := <-events
+	if len(stats.Buckets) != 1 || stats.Buckets[0].Minute != uint64(minute.Unix()) || stats.Buckets[0].Counts[rtypes.PoolDrop_PoolFull] != 8000 {
+		t.Fatalf("expected the 8000 drops of the closed minute, got %+v", stats.Buckets)
+	}
+	if stats.Watermarks != watermarks || stats.Time != uint64(clock.Unix()) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	select {
+	case stats := <-events:
+		t.Fatalf("expected the stats published on a full buffer dropped, got %+v", stats)
+	default:
+	}
+
+	clock = clock.Add(time.Minute)
+	drops.publish(watermarks)
+	if stats := <-events; len(stats.Buckets) != 0 {
+		t.Fatalf("expected no bucket for a minute without drops, got %+v", stats.Buckets)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+const poolExportVersion = 1
+
+// poolExport is the rlp encoded export of the tx pool: the raw eth txs, each
+// sender's pending txs then its waiting ones, in nonce order.
+type poolExport struct {
+	Version uint
+	Txs     [][]byte
+}
+
+// PoolImportDrop is a tx of a pool export dropped on import
+type PoolImportDrop struct {
+	Hash   common.Hash
+	Reason string
+}
+
+// PoolImportReport sums up a pool import
+type PoolImportReport struct {
+	Imported int
+	Dropped  []PoolImportDrop
+}
+
+// ExportPool writes the pending and waiting eth txs of the tx pool to w, for
+// ImportPool to reload them on another node. The admin and evidence txs stay.
+func (app *EVMApp) ExportPool(w io.Writer) (int, error) {
+	tp := app.pool
+	tp.Lock()
+	senders := make(map[common.Address]struct{})
+	for addr := range tp.pending {
+		senders[addr] = struct{}{}
+	}
+	for addr := range tp.waiting {
+		senders[addr] = struct{}{}
+	}
+	addrs := make([]common.Address, 0, len(senders))
+	for addr := range senders {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+
+	export := poolExport{Version: poolExportVersion}
+	for _, addr := range addrs {
+		for _, queue := range []*txSortedMap{tp.pending[addr], tp.waiting[addr]} {
+			if queue == nil {
+				continue
+			}
+			for _, tx := range queue.Flatten() {
+				if raw, ok := tp.all[tx.Hash()]; ok {
+					export.Txs = append(export.Txs, raw)
+				}
+			}
+		}
+	}
+	tp.Unlock()
+
+	if err := rlp.Encode(w, &export); err != nil {
+		return 0, err
+	}
+	return len(export.Txs), nil
+}
+
+// ImportPool reads a pool export from r and adds its txs to the tx pool. Each
+// tx is checked again like a newly received one, the txs failing the checks
+// are dropped and listed in the report.
+func (app *EVMApp) ImportPool(r io.Reader) (*PoolImportReport, error) {
+	var export poolExport
+	if err := rlp.Decode(r, &export); err != nil {
+		return nil, err
+	}
+	if export.Version != poolExportVersion {
+		return nil, fmt.Errorf("unknown pool export version %d", export.Version)
+	}
+	report := &PoolImportReport{}
+	for _, raw := range export.Txs {
+		err := app.CheckTx(raw)
+		if err == nil {
+			err = app.pool.ReceiveTx(raw)
+		}
+		if err != nil {
+			var hash common.Hash
+			tx := new(etypes.Transaction)
+			if rlp.DecodeBytes(raw, tx) == nil {
+				hash = tx.Hash()
+			}
+			report.Dropped = append(report.Dropped, PoolImportDrop{Hash: hash, Reason: err.Error()})
+			continue
+		}
+		report.Imported++
+	}
+	return report, nil
+}
@@ -0,0 +1,73 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func TestExportImportPool(t *testing.T) {
+	from, cleanFrom := newTestApp(t)
+	defer cleanFrom()
+	to, cleanTo := newTestApp(t)
+	defer cleanTo()
+
+	keyA, addrA := testKey(t, testKeyA)
+	keyB, _ := testKey(t, testKeyB)
+	recipient := common.HexToAddress("0x1234")
+	transfer := func(key, nonce uint64) []byte {
+		k := keyA
+		if key == 1 {
+			k = keyB
+		}
+		return signTestTx(t, k, etypes.NewTransaction(nonce, recipient, big.NewInt(0), testGas, big.NewInt(0), nil))
+	}
+	stale := transfer(1, 0)
+	for _, raw := range [][]byte{transfer(0, 0), transfer(0, 1), transfer(0, 3), stale} {
+		if err := from.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := from.ExportPool(&buf)
+	if err != nil || n != 4 {
+		t.Fatalf("expected 4 txs exported, got %d %v", n, err)
+	}
+
+	// B's nonce 0 is taken on the importing node
+	execTestBlock(t, to, 1, signTestTx(t, keyB, etypes.NewTransaction(0, addrA, big.NewInt(0), testGas, big.NewInt(0), nil)))
+	report, err := to.ImportPool(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 3 || len(report.Dropped) != 1 || report.Dropped[0].Hash != txHash(stale) {
+		t.Fatalf("unexpected import report %+v", report)
+	}
+	expected := from.pool.SenderTxs(addrA)
+	txs := to.pool.SenderTxs(addrA)
+	if len(txs) != len(expected) {
+		t.Fatalf("expected %d txs of A restored, got %+v", len(expected), txs)
+	}
+	for i, tx := range txs {
+		if tx != expected[i] {
+			t.Fatalf("expected %+v restored, got %+v", expected, txs)
+		}
+	}
+}
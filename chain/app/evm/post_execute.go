@@ -0,0 +1,89 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"sync"
+
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+// PostExecuteHook changes the state of a block after its txs ran, before it's
+// committed, eg. to distribute block rewards. The changes are part of the app
+// hash, so hooks must be deterministic and registered the same, in the same
+// order, on all validators. header must not be modified.
+type PostExecuteHook interface {
+	PostExecute(state *estate.StateDB, header *etypes.Header) error
+}
+
+// PostExecuteFunc is a function used as a PostExecuteHook
+type PostExecuteFunc func(state *estate.StateDB, header *etypes.Header) error
+
+func (f PostExecuteFunc) PostExecute(state *estate.StateDB, header *etypes.Header) error {
+	return f(state, header)
+}
+
+// RegisterPostExecuteHook runs hook on every block executed from now on, after
+// the hooks registered before it. Registering a name again replaces its hook in
+// place, a nil hook removes it.
+func (app *EVMApp) RegisterPostExecuteHook(name string, hook PostExecuteHook) {
+	app.postExecute.register(name, hook)
+}
+
+type namedPostExecuteHook struct {
+	name string
+	hook PostExecuteHook
+}
+
+// postExecuteHooks are the registered hooks, in registration order
+type postExecuteHooks struct {
+	mtx   sync.Mutex
+	hooks []namedPostExecuteHook
+}
+
+func (h *postExecuteHooks) register(name string, hook PostExecuteHook) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	// a new slice each time, run iterates the previous one unlocked
+	hooks := make([]namedPostExecuteHook, 0, len(h.hooks)+1)
+	replaced := false
+	for _, named := range h.hooks {
+		if named.name == name {
+			replaced, named.hook = true, hook
+		}
+		if named.hook != nil {
+			hooks = append(hooks, named)
+		}
+	}
+	if !replaced && hook != nil {
+		hooks = append(hooks, namedPostExecuteHook{name, hook})
+	}
+	h.hooks = hooks
+}
+
+// run runs the hooks in order on state. A failing hook fails the block, as the
+// validators running it would otherwise disagree on the state.
+func (h *postExecuteHooks) run(state *estate.StateDB, header *etypes.Header) error {
+	h.mtx.Lock()
+	hooks := h.hooks
+	h.mtx.Unlock()
+	for _, named := range hooks {
+		if err := named.hook.PostExecute(state, header); err != nil {
+			return fmt.Errorf("post execute hook %s: %v", named.name, err)
+		}
+	}
+	return nil
+}
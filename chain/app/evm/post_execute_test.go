@@ -0,0 +1,68 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func TestPostExecuteHooks(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	rewards := common.HexToAddress("0x1234")
+	var order []string
+	app.RegisterPostExecuteHook("mint", PostExecuteFunc(func(state *estate.StateDB, header *etypes.Header) error {
+		order = append(order, "mint")
+		state.AddBalance(rewards, big.NewInt(100))
+		return nil
+	}))
+	// ordered after mint, the balance minted by this block is seen
+	app.RegisterPostExecuteHook("double", PostExecuteFunc(func(state *estate.StateDB, header *etypes.Header) error {
+		order = append(order, "double")
+		state.AddBalance(rewards, state.GetBalance(rewards))
+		return nil
+	}))
+
+	prevHash := app.getLastAppHash()
+	res := commitTestBlock(t, app, makeTestBlock(1))
+	if len(order) != 2 || order[0] != "mint" || order[1] != "double" {
+		t.Fatalf("unexpected hooks order %v", order)
+	}
+	if balance := app.state.GetBalance(rewards); balance.Int64() != 200 {
+		t.Fatalf("expected the minted balance committed, got %v", balance)
+	}
+	if common.BytesToHash(res.AppHash) == prevHash {
+		t.Fatal("expected the hooks to change the app hash")
+	}
+
+	// removed hooks no longer run, a failing one fails the block
+	app.RegisterPostExecuteHook("double", nil)
+	app.RegisterPostExecuteHook("fail", PostExecuteFunc(func(*estate.StateDB, *etypes.Header) error {
+		return errors.New("no rewards")
+	}))
+	order = nil
+	if _, err := app.OnExecute(2, 0, makeTestBlock(2)); err == nil {
+		t.Fatal("expected a failing hook to fail the block")
+	}
+	if len(order) != 1 || order[0] != "mint" {
+		t.Fatalf("unexpected hooks order %v", order)
+	}
+}
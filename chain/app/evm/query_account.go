@@ -0,0 +1,191 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/eth/trie"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// queryAccount takes a rlp encoded rtypes.AccountQuery and returns the rlp
+// encoded rtypes.AccountState of the account on the targeted state.
+func (app *EVMApp) queryAccount(load []byte) gtypes.Result {
+	var query rtypes.AccountQuery
+	if err := rlp.DecodeBytes(load, &query); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	var account rtypes.AccountState
+	err := app.readTarget(query.Target, query.Height, query.Address, func(state *estate.StateDB, height uint64) {
+		account = rtypes.AccountState{
+			Height:   height,
+			Balance:  new(big.Int).Set(state.GetBalance(query.Address)),
+			Nonce:    state.GetNonce(query.Address),
+			CodeSize: uint64(state.GetCodeSize(query.Address)),
+		}
+	})
+	if err == errServerBusy {
+		return gtypes.NewError(gtypes.CodeType_ServerBusy, err.Error())
+	} else if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(&account)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+// queryStorageMulti takes a rlp encoded rtypes.StorageQuery and returns the rlp
+// encoded rtypes.StorageValues of its keys, all read on the same targeted state.
+func (app *EVMApp) queryStorageMulti(load []byte) gtypes.Result {
+	var query rtypes.StorageQuery
+	if err := rlp.DecodeBytes(load, &query); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	if len(query.Keys) > app.storageKeysLimit {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, fmt.Sprintf("too many keys, limit is %d", app.storageKeysLimit))
+	}
+	values := rtypes.StorageValues{Values: make([]common.Hash, len(query.Keys))}
+	err := app.readTarget(query.Target, query.Height, query.Address, func(state *estate.StateDB, height uint64) {
+		values.Height = height
+		for i, key := range query.Keys {
+			values.Values[i] = state.GetState(query.Address, key)
+		}
+	})
+	if err == errServerBusy {
+		return gtypes.NewError(gtypes.CodeType_ServerBusy, err.Error())
+	} else if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(&values)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+// queryAccountAtRoot takes a 20 bytes address and returns the rlp encoded
+// rtypes.AccountAtRoot of the account on the latest committed state. The account,
+// its proof, the app hash and the height are all read under the state lock, so
+// they always belong to the same block.
+func (app *EVMApp) queryAccountAtRoot(load []byte) gtypes.Result {
+	if len(load) != common.AddressLength {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid address")
+	}
+	addr := common.BytesToAddress(load)
+
+	app.stateMtx.Lock()
+	account := rtypes.AccountAtRoot{
+		Height:  app.committedHeader.Number.Uint64(),
+		AppHash: app.committedRoot,
+		Nonce:   app.state.GetNonce(addr),
+		Balance: new(big.Int).Set(app.state.GetBalance(addr)),
+	}
+	proof, err := app.state.GetProof(addr)
+	app.stateMtx.Unlock()
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	account.Proof = proof
+
+	data, err := rlp.EncodeToBytes(&account)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+// VerifyAccountAtRoot checks the nonce and balance of account against its proof
+// and app hash, for a client to trust the answer of a single node as much as the
+// app hash it got from consensus.
+func VerifyAccountAtRoot(addr common.Address, account *rtypes.AccountAtRoot) error {
+	proofDb := ethdb.NewMemDatabase()
+	for _, node := range account.Proof {
+		if err := proofDb.Put(crypto.Keccak256(node), node); err != nil {
+			return err
+		}
+	}
+	value, _, err := trie.VerifyProof(account.AppHash, crypto.Keccak256(addr.Bytes()), proofDb)
+	if err != nil {
+		return err
+	}
+	proven := estate.Account{Balance: new(big.Int)}
+	if value != nil {
+		if err := rlp.DecodeBytes(value, &proven); err != nil {
+			return err
+		}
+	}
+	if account.Balance == nil || proven.Nonce != account.Nonce || proven.Balance.Cmp(account.Balance) != 0 {
+		return errors.New("account does not match its proof")
+	}
+	return nil
+}
+
+// readTarget runs read on the state of target along with the height of the
+// block committing it. The latest state is read under the state lock, together
+// with its header, so a block being executed or committed never shows through.
+// The pending state of addr is a copy of the latest state with the pool txs of
+// addr applied in nonce order, up to the first failing one, so a nonce gap ends
+// them.
+func (app *EVMApp) readTarget(target rtypes.QueryTarget, height uint64, addr common.Address, read func(*estate.StateDB, uint64)) error {
+	switch target {
+	case rtypes.QueryTarget_Latest:
+		app.stateMtx.Lock()
+		read(app.state, app.committedHeader.Number.Uint64())
+		app.stateMtx.Unlock()
+		return nil
+	case rtypes.QueryTarget_Pending:
+		app.stateMtx.Lock()
+		state, header := app.state.Copy(), *app.committedHeader
+		app.stateMtx.Unlock()
+		committed := header.Number.Uint64()
+		header.Number = new(big.Int).SetUint64(committed + 1)
+		app.applyPending(state, &header, app.pool.senderTxs(addr))
+		read(state, committed)
+		return nil
+	case rtypes.QueryTarget_Height:
+		if height == 0 {
+			return fmt.Errorf("invalid height 0")
+		}
+		return app.readState(height, func(state *estate.StateDB) { read(state, height) })
+	}
+	return fmt.Errorf("invalid query target %d", target)
+}
+
+// applyPending applies txs to state in order, as the next block would, and stops
+// at the first tx failing.
+func (app *EVMApp) applyPending(state *estate.StateDB, header *etypes.Header, txs etypes.Transactions) {
+	bc := NewBlockChain(app.stateDb)
+	for _, tx := range txs {
+		gp := new(core.GasPool).AddGas(math.MaxUint64)
+		snapshot := state.Snapshot()
+		if _, _, err := core.ApplyTransaction(app.chainConfig, bc, nil, gp, state, header, tx, new(uint64), evmConfig); err != nil {
+			state.RevertToSnapshot(snapshot)
+			return
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func queryTestAccount(t *testing.T, app *EVMApp, query rtypes.AccountQuery) rtypes.AccountState {
+	load, err := rlp.EncodeToBytes(&query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := app.Query(append([]byte{rtypes.QueryType_Account}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var account rtypes.AccountState
+	if err := rlp.DecodeBytes(res.Data, &account); err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func TestQueryAccountLatestDuringExecution(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	_, to := testKey(t, testKeyB)
+	fundTestAccounts(t, app, big.NewInt(1000), addr)
+	const transfers = 50
+	txs := make([][]byte, transfers)
+	for i := range txs {
+		txs[i] = signTestTx(t, key, etypes.NewTransaction(uint64(i), to, big.NewInt(1), testGas, big.NewInt(0), nil))
+	}
+
+	// the latest reads only ever see the balance before or after the block
+	seen := make(map[uint64]map[int64]bool)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			account := queryTestAccount(t, app, rtypes.AccountQuery{Address: to})
+			if seen[account.Height] == nil {
+				seen[account.Height] = make(map[int64]bool)
+			}
+			seen[account.Height][account.Balance.Int64()] = true
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+	execTestBlock(t, app, 1, txs...)
+	close(done)
+	wg.Wait()
+
+	for height, balances := range seen {
+		for balance := range balances {
+			if (height != 0 || balance != 0) && (height != 1 || balance != transfers) {
+				t.Fatalf("unexpected balance %d read at height %d", balance, height)
+			}
+		}
+	}
+	if account := queryTestAccount(t, app, rtypes.AccountQuery{Address: to}); account.Height != 1 || account.Balance.Int64() != transfers {
+		t.Fatalf("unexpected latest account %+v", account)
+	}
+}
+
+func TestQueryAccountPending(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	_, to := testKey(t, testKeyB)
+	fundTestAccounts(t, app, big.NewInt(1000), addr)
+	execTestBlock(t, app, 1)
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		if err := app.pool.ReceiveTx(signTestTx(t, key, etypes.NewTransaction(nonce, to, big.NewInt(10), testGas, big.NewInt(0), nil))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a nonce gap keeps the tx waiting, out of the pending state
+	if err := app.pool.ReceiveTx(signTestTx(t, key, etypes.NewTransaction(5, to, big.NewInt(10), testGas, big.NewInt(0), nil))); err != nil {
+		t.Fatal(err)
+	}
+
+	latest := queryTestAccount(t, app, rtypes.AccountQuery{Target: rtypes.QueryTarget_Latest, Address: addr})
+	if latest.Height != 1 || latest.Nonce != 0 || latest.Balance.Int64() != 1000 {
+		t.Fatalf("unexpected latest account %+v", latest)
+	}
+	pending := queryTestAccount(t, app, rtypes.AccountQuery{Target: rtypes.QueryTarget_Pending, Address: addr})
+	if pending.Height != 1 || pending.Nonce != 3 || pending.Balance.Int64() != 970 {
+		t.Fatalf("unexpected pending account %+v", pending)
+	}
+	// pending txs of other accounts aren't applied
+	if other := queryTestAccount(t, app, rtypes.AccountQuery{Target: rtypes.QueryTarget_Pending, Address: to}); other.Balance.Sign() != 0 {
+		t.Fatalf("unexpected pending balance of the receiver %v", other.Balance)
+	}
+
+	for _, query := range []rtypes.AccountQuery{
+		{Target: rtypes.QueryTarget_Height, Address: addr},
+		{Target: 3, Address: addr},
+	} {
+		load, err := rlp.EncodeToBytes(&query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := app.Query(append([]byte{rtypes.QueryType_Account}, load...)); res.IsOK() {
+			t.Fatalf("expected %+v to be rejected", query)
+		}
+	}
+	if res := app.Query(append([]byte{rtypes.QueryType_Account}, common.HexToAddress("0x1234").Bytes()...)); res.IsOK() {
+		t.Fatal("expected an invalid query to be rejected")
+	}
+}
+
+func queryTestAccountAtRoot(t *testing.T, app *EVMApp, addr common.Address) rtypes.AccountAtRoot {
+	res := app.Query(append([]byte{rtypes.QueryType_AccountAtRoot}, addr.Bytes()...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var account rtypes.AccountAtRoot
+	if err := rlp.DecodeBytes(res.Data, &account); err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+func TestQueryAccountAtRoot(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	_, to := testKey(t, testKeyB)
+	fundTestAccounts(t, app, big.NewInt(1000), addr)
+
+	// the reads racing the commits each match the root committed at their height
+	roots := map[uint64]common.Hash{0: app.getLastAppHash()}
+	var reads []rtypes.AccountAtRoot
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			reads = append(reads, queryTestAccountAtRoot(t, app, addr))
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+	const blocks = 5
+	for height := int64(1); height <= blocks; height++ {
+		tx := signTestTx(t, key, etypes.NewTransaction(uint64(height-1), to, big.NewInt(10), testGas, big.NewInt(0), nil))
+		res := commitTestBlock(t, app, makeTestBlock(height, tx))
+		roots[uint64(height)] = common.BytesToHash(res.AppHash)
+	}
+	close(done)
+	wg.Wait()
+
+	for _, account := range reads {
+		if account.AppHash != roots[account.Height] {
+			t.Fatalf("read at height %d with app hash %x, committed %x", account.Height, account.AppHash, roots[account.Height])
+		}
+		if err := VerifyAccountAtRoot(addr, &account); err != nil {
+			t.Fatalf("read at height %d: %v", account.Height, err)
+		}
+		if account.Nonce != account.Height || account.Balance.Int64() != 1000-10*int64(account.Height) {
+			t.Fatalf("unexpected account %+v", account)
+		}
+	}
+
+	account := queryTestAccountAtRoot(t, app, addr)
+	if account.Height != blocks || account.AppHash != common.BytesToHash(app.Info().LastBlockAppHash) {
+		t.Fatalf("expected the last committed root %x, got %x at height %d", app.Info().LastBlockAppHash, account.AppHash, account.Height)
+	}
+	account.Balance.Add(account.Balance, big.NewInt(1))
+	if err := VerifyAccountAtRoot(addr, &account); err == nil {
+		t.Fatal("expected a balance off its proof to fail verification")
+	}
+	// an absent account is proven empty
+	absent := queryTestAccountAtRoot(t, app, common.HexToAddress("0x1234"))
+	if err := VerifyAccountAtRoot(common.HexToAddress("0x1234"), &absent); err != nil || absent.Nonce != 0 || absent.Balance.Sign() != 0 {
+		t.Fatalf("unexpected absent account %+v %v", absent, err)
+	}
+	if res := app.Query(append([]byte{rtypes.QueryType_AccountAtRoot}, 0x01)); res.IsOK() {
+		t.Fatal("expected an invalid address to be rejected")
+	}
+}
+
+func queryTestStorage(t *testing.T, app *EVMApp, query rtypes.StorageQuery) rtypes.StorageValues {
+	load, err := rlp.EncodeToBytes(&query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := app.Query(append([]byte{rtypes.QueryType_StorageMulti}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var values rtypes.StorageValues
+	if err := rlp.DecodeBytes(res.Data, &values); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestQueryStorageMulti(t *testing.T) {
+	conf := viper.New()
+	conf.Set("storage_keys_limit", 4)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	core := &appHashCore{appHashes: map[int64]common.Hash{0: app.getLastAppHash()}}
+	app.SetCore(core)
+
+	// block 1 is a transfer, block 2 deploys a contract storing i at slot i
+	key, addr := testKey(t, testKeyA)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), nil)))
+	core.appHashes[1] = app.getLastAppHash()
+	execTestBlock(t, app, 2, signTestTx(t, key, etypes.NewContractCreation(1, big.NewInt(0), testGas, big.NewInt(0), storageHeavyCode)))
+	core.appHashes[2] = app.getLastAppHash()
+	contract := crypto.CreateAddress(addr, 1)
+
+	keys := []common.Hash{common.BigToHash(big.NewInt(3)), common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(32)), common.BigToHash(big.NewInt(33))}
+	expected := []common.Hash{keys[0], keys[1], keys[2], {}}
+	for _, target := range []rtypes.QueryTarget{rtypes.QueryTarget_Latest, rtypes.QueryTarget_Height} {
+		values := queryTestStorage(t, app, rtypes.StorageQuery{Target: target, Height: 2, Address: contract, Keys: keys})
+		if values.Height != 2 || len(values.Values) != len(keys) {
+			t.Fatalf("unexpected values %+v", values)
+		}
+		for i, value := range values.Values {
+			if value != expected[i] {
+				t.Fatalf("expected slot %x to hold %x, got %x", keys[i], expected[i], value)
+			}
+		}
+	}
+	before := queryTestStorage(t, app, rtypes.StorageQuery{Target: rtypes.QueryTarget_Height, Height: 1, Address: contract, Keys: keys})
+	for _, value := range before.Values {
+		if value != (common.Hash{}) {
+			t.Fatal("expected no storage before the deploy")
+		}
+	}
+
+	load, err := rlp.EncodeToBytes(&rtypes.StorageQuery{Address: contract, Keys: append(keys, keys[0])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := app.Query(append([]byte{rtypes.QueryType_StorageMulti}, load...)); res.IsOK() {
+		t.Fatal("expected more keys than storage_keys_limit to be rejected")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"math/big"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// querySender recovers the sender of a query tx, a malformed signature is an
+// error rather than a panic.
+func (app *EVMApp) querySender(tx *etypes.Transaction) (from common.Address, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid signature: %v", r)
+		}
+	}()
+	v, r, s := tx.RawSignatureValues()
+	if v == nil || r == nil || s == nil || r.Sign() == 0 || s.Sign() == 0 {
+		return from, fmt.Errorf("invalid signature: query tx not signed")
+	}
+	if from, err = app.Signer.Sender(tx); err != nil {
+		return from, fmt.Errorf("invalid signature: %v", err)
+	}
+	return from, nil
+}
+
+// queryTxMessage decodes the rlp encoded signed tx of a contract query into a
+// message. The tx is only used by this query, so the sender recovered from it
+// isn't cached anywhere. The message doesn't check the tx nonce, signed queries
+// run at the sender's nonce in the queried state.
+func (app *EVMApp) queryTxMessage(load []byte) (etypes.Message, error) {
+	tx := new(etypes.Transaction)
+	if err := rlp.DecodeBytes(load, tx); err != nil {
+		return etypes.Message{}, err
+	}
+	from, err := app.querySender(tx)
+	if err != nil {
+		return etypes.Message{}, err
+	}
+	return etypes.NewMessage(from, tx.To(), 0, tx.Value(), tx.Gas(), tx.GasPrice(), tx.Data(), false), nil
+}
+
+// callMessage makes the message of an unsigned call object.
+func callMessage(call *rtypes.CallObject) etypes.Message {
+	value, gasPrice := call.Value, call.GasPrice
+	if value == nil {
+		value = new(big.Int)
+	}
+	if gasPrice == nil {
+		gasPrice = new(big.Int)
+	}
+	gas := call.Gas
+	if gas == 0 {
+		gas = EVMGasLimit
+	}
+	return etypes.NewMessage(call.From, call.To, call.Nonce, value, gas, gasPrice, call.Data, call.CheckNonce)
+}
+
+// queryCall runs the rlp encoded rtypes.CallObject like a contract query, without
+// a signature, and returns the evm output.
+func (app *EVMApp) queryCall(load []byte) gtypes.Result {
+	call := &rtypes.CallObject{}
+	if err := rlp.DecodeBytes(load, call); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	return app.simulateQuery(callMessage(call), call.Height)
+}
+
+// queryViewCall runs the rlp encoded unsigned tx of a view call from the
+// view_call_sender, skipping the signature recovery of contract queries. Like
+// every query it runs on a copy of the latest state, nothing is committed.
+func (app *EVMApp) queryViewCall(load []byte) gtypes.Result {
+	if app.viewCallSender == nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "view calls disabled, no view_call_sender")
+	}
+	tx := new(etypes.Transaction)
+	if err := rlp.DecodeBytes(load, tx); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	if tx.Value().Sign() != 0 {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "view calls carry no value")
+	}
+	txMsg := etypes.NewMessage(*app.viewCallSender, tx.To(), 0, tx.Value(), tx.Gas(), tx.GasPrice(), tx.Data(), false)
+	return app.simulateQuery(txMsg, 0)
+}
@@ -0,0 +1,141 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// callerCode deploys a contract returning its caller as a word
+var callerCode = common.FromHex("6009600c60003960096000f3" + "33600052" + "60206000f3")
+
+func TestQueryContractMalformedSignature(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	execTestBlock(t, app, 1)
+
+	to := common.HexToAddress("0x1234")
+	unsigned, err := rlp.EncodeToBytes(etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := bytes.Repeat([]byte{0xff}, 65)
+	sig[64] = 0
+	garbage, err := etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(0), nil).WithSignature(app.Signer, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := rlp.EncodeToBytes(garbage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, load := range [][]byte{unsigned, forged, {0xc1, 0xff}} {
+		if res := app.Query(append([]byte{rtypes.QueryType_Contract}, load...)); res.IsOK() {
+			t.Fatalf("expected query %x to be rejected", load)
+		}
+	}
+}
+
+func TestQueryCall(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	contract := crypto.CreateAddress(addr, 0)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), callerCode)))
+
+	signed := app.Query(append([]byte{rtypes.QueryType_Contract},
+		signTestTx(t, key, etypes.NewTransaction(1, contract, big.NewInt(0), testGas, big.NewInt(0), nil))...))
+	if signed.IsErr() {
+		t.Fatal(signed.Log)
+	}
+	if !bytes.Equal(signed.Data, common.LeftPadBytes(addr.Bytes(), 32)) {
+		t.Fatalf("unexpected contract output %x", signed.Data)
+	}
+
+	queryCall := func(call *rtypes.CallObject) ([]byte, bool) {
+		load, err := rlp.EncodeToBytes(call)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := app.Query(append([]byte{rtypes.QueryType_Call}, load...))
+		return res.Data, res.IsOK()
+	}
+	if data, ok := queryCall(&rtypes.CallObject{From: addr, To: &contract}); !ok || !bytes.Equal(data, signed.Data) {
+		t.Fatalf("expected the call output %x to match the signed query %x", data, signed.Data)
+	}
+
+	// the nonce is only checked when asked for
+	if _, ok := queryCall(&rtypes.CallObject{From: addr, To: &contract, Nonce: 5}); !ok {
+		t.Fatal("expected the nonce to be ignored")
+	}
+	if _, ok := queryCall(&rtypes.CallObject{From: addr, To: &contract, Nonce: 5, CheckNonce: true}); ok {
+		t.Fatal("expected a wrong nonce to be rejected")
+	}
+	if _, ok := queryCall(&rtypes.CallObject{From: addr, To: &contract, Nonce: 1, CheckNonce: true}); !ok {
+		t.Fatal("expected the account nonce to be accepted")
+	}
+}
+
+func TestQueryViewCall(t *testing.T) {
+	sender := common.HexToAddress("0x1234")
+	conf := viper.New()
+	conf.Set("view_call_sender", sender.Hex())
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	contract := crypto.CreateAddress(addr, 0)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), callerCode)))
+	root := app.state.IntermediateRoot(false)
+
+	load, err := rlp.EncodeToBytes(etypes.NewTransaction(0, contract, big.NewInt(0), testGas, big.NewInt(0), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := app.Query(append([]byte{rtypes.QueryType_ViewCall}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	if !bytes.Equal(res.Data, common.LeftPadBytes(sender.Bytes(), 32)) {
+		t.Fatalf("expected the view call to run from %x, got %x", sender, res.Data)
+	}
+	if app.state.IntermediateRoot(false) != root || app.state.GetNonce(sender) != 0 {
+		t.Fatal("expected the view call to leave the state untouched")
+	}
+
+	valued, err := rlp.EncodeToBytes(etypes.NewTransaction(0, contract, big.NewInt(1), testGas, big.NewInt(0), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := app.Query(append([]byte{rtypes.QueryType_ViewCall}, valued...)); res.IsOK() {
+		t.Fatal("expected a view call carrying value to be rejected")
+	}
+
+	app.viewCallSender = nil
+	if res := app.Query(append([]byte{rtypes.QueryType_ViewCall}, load...)); res.IsOK() {
+		t.Fatal("expected view calls to be refused without view_call_sender")
+	}
+}
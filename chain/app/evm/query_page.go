@@ -0,0 +1,184 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// listQuery is a query answering a list, served in pages by QueryType_Page. list
+// returns up to limit items of the list load asks for, from token, empty for the
+// start of the list, and the token of the item following them, nil once the list
+// ends.
+type listQuery struct {
+	maxPage int // max items of a page
+	list    func(app *EVMApp, load, token []byte, limit int) ([]listItem, []byte, error)
+}
+
+// listItem is an item of a list along with the token resuming the list at it
+type listItem struct {
+	token []byte
+	value interface{}
+}
+
+// listQueries are the queries answering lists. Queried directly they answer their
+// first page.
+var listQueries = map[rtypes.QueryType]listQuery{
+	rtypes.QueryType_PendingBySender:      {1000, (*EVMApp).listPendingBySender},
+	rtypes.QueryType_Misbehavior:          {1000, (*EVMApp).listMisbehavior},
+	rtypes.QueryType_BlockTouchedAccounts: {10000, (*EVMApp).listTouchedAccounts},
+	rtypes.QueryType_CommitStats:          {1000, (*EVMApp).listCommitStats},
+	rtypes.QueryType_LightHeader:          {1000, (*EVMApp).listLightHeaders},
+	rtypes.QueryType_Logs:                 {1000, (*EVMApp).listLogs},
+}
+
+var errInvalidPageToken = errors.New("invalid page token")
+
+// queryPage answers the rlp encoded rtypes.PageQuery with the rlp encoded
+// rtypes.Page of the list query it names.
+func (app *EVMApp) queryPage(load []byte) gtypes.Result {
+	var query rtypes.PageQuery
+	if err := rlp.DecodeBytes(load, &query); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	lq, ok := listQueries[query.Query]
+	if !ok {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, fmt.Sprintf("query %d doesn't answer a list", query.Query))
+	}
+	limit := lq.maxPage
+	if query.Limit > 0 && query.Limit < uint64(limit) {
+		limit = int(query.Limit)
+	}
+	page, err := app.listPage(lq, query.Load, query.Token, limit)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(page)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, pageLog(page))
+}
+
+// queryList answers the list query action queried directly with its first page,
+// encoded as the rtypes.Page QueryType_Page answers. Its Next is the token to
+// resume the list at with QueryType_Page.
+func (app *EVMApp) queryList(action rtypes.QueryType, load []byte) gtypes.Result {
+	lq := listQueries[action]
+	page, err := app.listPage(lq, load, nil, lq.maxPage)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(page)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, pageLog(page))
+}
+
+// decodePage decodes the rlp encoded rtypes.Page data, and its items into the
+// slice items points to.
+func decodePage(data []byte, items interface{}) (*rtypes.Page, error) {
+	var page rtypes.Page
+	if err := rlp.DecodeBytes(data, &page); err != nil {
+		return nil, err
+	}
+	raw := make([]rlp.RawValue, len(page.Items))
+	for i, item := range page.Items {
+		raw[i] = item
+	}
+	list, err := rlp.EncodeToBytes(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &page, rlp.DecodeBytes(list, items)
+}
+
+// listPage returns the page of up to limit items of lq from token. The items of a
+// page take at most query_max_response_bytes, those past it are left to the next
+// page, but a page always holds its first item so following the tokens always
+// gets to the end of the list.
+func (app *EVMApp) listPage(lq listQuery, load, token []byte, limit int) (*rtypes.Page, error) {
+	items, next, err := lq.list(app, load, token, limit)
+	if err != nil {
+		return nil, err
+	}
+	page := &rtypes.Page{Items: make([][]byte, 0, len(items))}
+	size := 0
+	for _, item := range items {
+		data, err := rlp.EncodeToBytes(item.value)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Items) > 0 && app.queryMaxResponseBytes > 0 && size+len(data) > app.queryMaxResponseBytes {
+			page.Next, page.Truncated = item.token, true
+			return page, nil
+		}
+		page.Items = append(page.Items, data)
+		size += len(data)
+	}
+	page.Next = next
+	return page, nil
+}
+
+func pageLog(page *rtypes.Page) string {
+	if len(page.Next) == 0 {
+		return ""
+	}
+	if page.Truncated {
+		return fmt.Sprintf("truncated to query_max_response_bytes, use token %x", page.Next)
+	}
+	return fmt.Sprintf("truncated, use token %x", page.Next)
+}
+
+// indexToken is the token of the item at index i of a list, or at height i
+func indexToken(i uint64) []byte {
+	token := make([]byte, 8)
+	binary.BigEndian.PutUint64(token, i)
+	return token
+}
+
+func parseIndexToken(token []byte) (uint64, error) {
+	switch len(token) {
+	case 0:
+		return 0, nil
+	case 8:
+		return binary.BigEndian.Uint64(token), nil
+	}
+	return 0, errInvalidPageToken
+}
+
+// indexedList lists the items of a list of n items read by index, from the
+// index token.
+func indexedList(n uint64, token []byte, limit int, item func(i uint64) interface{}) ([]listItem, []byte, error) {
+	from, err := parseIndexToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	items := make([]listItem, 0)
+	i := from
+	for ; i < n && len(items) < limit; i++ {
+		items = append(items, listItem{indexToken(i), item(i)})
+	}
+	if i < n {
+		return items, indexToken(i), nil
+	}
+	return items, nil, nil
+}
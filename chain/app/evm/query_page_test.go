@@ -0,0 +1,199 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// queryTestPages follows the pages of a list query from token to the end of the
+// list, checking each page keeps to the response size limit, and returns the items.
+func queryTestPages(t *testing.T, app *EVMApp, query rtypes.QueryType, load, token []byte, limit uint64) [][]byte {
+	var items [][]byte
+	for pages := 0; ; pages++ {
+		if pages > 1000 {
+			t.Fatalf("query %d: no end to the pages", query)
+		}
+		q, err := rlp.EncodeToBytes(&rtypes.PageQuery{Query: query, Load: load, Token: token, Limit: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := app.Query(append([]byte{rtypes.QueryType_Page}, q...))
+		if res.IsErr() {
+			t.Fatalf("query %d: %s", query, res.Log)
+		}
+		var page rtypes.Page
+		if err := rlp.DecodeBytes(res.Data, &page); err != nil {
+			t.Fatal(err)
+		}
+		size := 0
+		for _, item := range page.Items {
+			size += len(item)
+		}
+		if len(page.Items) == 0 && len(page.Next) > 0 {
+			t.Fatalf("query %d: empty page going on", query)
+		}
+		if len(page.Items) > 1 && size > app.queryMaxResponseBytes {
+			t.Fatalf("query %d: page of %d bytes over the limit of %d", query, size, app.queryMaxResponseBytes)
+		}
+		if limit > 0 && uint64(len(page.Items)) > limit {
+			t.Fatalf("query %d: page of %d items over the limit of %d", query, len(page.Items), limit)
+		}
+		if page.Truncated && !strings.Contains(res.Log, "truncated") {
+			t.Fatalf("query %d: truncated page without the marker, log %q", query, res.Log)
+		}
+		items = append(items, page.Items...)
+		if len(page.Next) == 0 {
+			return items
+		}
+		token = page.Next
+	}
+}
+
+// queryTestFullList returns the items of a list query queried directly without a
+// response size limit.
+func queryTestFullList(t *testing.T, app *EVMApp, query rtypes.QueryType, load []byte) [][]byte {
+	maxBytes := app.queryMaxResponseBytes
+	app.queryMaxResponseBytes = 0
+	defer func() { app.queryMaxResponseBytes = maxBytes }()
+	res := app.Query(append([]byte{query}, load...))
+	if res.IsErr() || res.Log != "" {
+		t.Fatalf("query %d: unexpected answer %s", query, res.Log)
+	}
+	var page rtypes.Page
+	if err := rlp.DecodeBytes(res.Data, &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Next) > 0 {
+		t.Fatalf("query %d: expected the whole list, got a token", query)
+	}
+	return page.Items
+}
+
+func TestListQueryPages(t *testing.T) {
+	conf := viper.New()
+	conf.Set("light_header_range_limit", 5)
+	conf.Set("query_max_response_bytes", 200)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	for height := int64(1); height <= 12; height++ {
+		execTestBlock(t, app, height)
+	}
+	key, sender := testKey(t, testKeyA)
+	for nonce := uint64(0); nonce < 30; nonce++ {
+		raw := signTestTx(t, key, etypes.NewTransaction(nonce, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.pool.updateToState()
+	app.state.SetState(MisbehaviorAddress, common.Hash{}, common.BigToHash(big.NewInt(40)))
+	for i := uint64(0); i < 40; i++ {
+		app.state.SetState(MisbehaviorAddress, misbehaviorSlot(i, 1), common.BigToHash(new(big.Int).SetUint64(i+1)))
+	}
+	addrs := make([]common.Address, 100)
+	for i := range addrs {
+		addrs[i] = common.BigToAddress(big.NewInt(int64(i*7919 + 1)))
+	}
+	if err := app.saveTouchedAccounts(20, addrs); err != nil {
+		t.Fatal(err)
+	}
+	for height := uint64(100); height < 140; height++ {
+		app.commitStats.add(rtypes.CommitStats{Height: height, TrieNodes: height})
+	}
+
+	height := make([]byte, 8)
+	binary.BigEndian.PutUint64(height, 20)
+	headers := make([]byte, 16)
+	binary.BigEndian.PutUint64(headers[:8], 1)
+	binary.BigEndian.PutUint64(headers[8:], 12)
+	for _, c := range []struct {
+		query rtypes.QueryType
+		load  []byte
+		items int
+	}{
+		{rtypes.QueryType_PendingBySender, sender.Bytes(), 30},
+		{rtypes.QueryType_Misbehavior, nil, 40},
+		{rtypes.QueryType_BlockTouchedAccounts, height, 100},
+		{rtypes.QueryType_CommitStats, nil, 12 + 40},
+		{rtypes.QueryType_LightHeader, headers, 12},
+	} {
+		var full [][]byte
+		if c.query == rtypes.QueryType_LightHeader {
+			// the plain range query is limited to light_header_range_limit headers
+			full = queryTestPages(t, app, c.query, c.load, nil, 0)
+		} else {
+			full = queryTestFullList(t, app, c.query, c.load)
+		}
+		if len(full) != c.items {
+			t.Fatalf("query %d: expected %d items, got %d", c.query, c.items, len(full))
+		}
+		// following the tokens gets the whole list, whatever the page size
+		for _, limit := range []uint64{0, 1, 3} {
+			if items := queryTestPages(t, app, c.query, c.load, nil, limit); !reflect.DeepEqual(items, full) {
+				t.Fatalf("query %d, limit %d: pages differ from the list, %d items out of %d", c.query, limit, len(items), len(full))
+			}
+		}
+	}
+
+	// queried directly, a list over the limit answers its first page with the
+	// token to go on from
+	res := app.Query([]byte{rtypes.QueryType_Misbehavior})
+	var records []rtypes.Misbehavior
+	page, err := decodePage(res.Data, &records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || len(records) == 40 || !page.Truncated || !strings.Contains(res.Log, "truncated") {
+		t.Fatalf("expected a truncated list, got %d records, log %q", len(records), res.Log)
+	}
+	if rest := queryTestPages(t, app, rtypes.QueryType_Misbehavior, nil, page.Next, 0); len(records)+len(rest) != 40 {
+		t.Fatalf("expected the token to resume the list, got %d and %d records", len(records), len(rest))
+	}
+	// single item queries are unaffected
+	binary.BigEndian.PutUint64(height, 12)
+	if res := app.Query(append([]byte{rtypes.QueryType_LightHeader}, height...)); res.IsErr() || res.Log != "" {
+		t.Fatalf("unexpected single light header answer %s", res.Log)
+	}
+
+	for _, q := range []rtypes.PageQuery{
+		{Query: rtypes.QueryType_Nonce, Load: sender.Bytes()},
+		{Query: rtypes.QueryType_Misbehavior, Token: []byte{1}},
+		{Query: rtypes.QueryType_BlockTouchedAccounts, Load: height, Token: []byte{1}},
+		{Query: rtypes.QueryType_LightHeader, Load: headers, Token: make([]byte, 8)},
+	} {
+		load, err := rlp.EncodeToBytes(&q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := app.Query(append([]byte{rtypes.QueryType_Page}, load...)); res.IsOK() {
+			t.Fatalf("expected page query %+v to be rejected", q)
+		}
+	}
+	if res := app.Query([]byte{rtypes.QueryType_Page, 0x01}); res.IsOK() {
+		t.Fatal("expected an invalid page query to be rejected")
+	}
+}
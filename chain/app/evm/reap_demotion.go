@@ -0,0 +1,72 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"sync/atomic"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/metrics"
+)
+
+const (
+	errReapUnderpriced  = "gas price below the minimum"
+	errReapUnexecutable = "not executable on the committed state"
+
+	// the hold back of an account's demoted txs doubles up to this many times
+	reapHoldMaxDoublings = 4
+)
+
+var reapDemotedMeter = metrics.NewRegisteredMeter("evm/txpool/reap/demoted", nil)
+
+// reapDemoted is the chain of an account's txs from the first one failing when
+// reaping, they go back to the waiting queue together.
+type reapDemoted struct {
+	txs    etypes.Transactions
+	reason string
+}
+
+// reapHold keeps the demoted txs of an account from being promoted, so a chain
+// of txs failing the reap checks isn't checked again on every proposal. It's
+// local to the proposer, blocks still execute every tx they carry.
+type reapHold struct {
+	until     int64 // committed height the txs are held back until
+	doublings uint
+}
+
+// holdBack holds the txs of addr back after a demotion and returns the height
+// they are held back until. Each demotion before the account's txs leave the
+// pool doubles the hold.
+func (tp *ethTxPool) holdBack(addr common.Address) int64 {
+	height := atomic.LoadInt64(&tp.app.committedHeight)
+	if tp.demoteBackoff <= 0 {
+		return height
+	}
+	hold, ok := tp.holds[addr]
+	if !ok {
+		hold = &reapHold{}
+		tp.holds[addr] = hold
+	} else if hold.doublings < reapHoldMaxDoublings {
+		hold.doublings++
+	}
+	hold.until = height + tp.demoteBackoff<<hold.doublings
+	return hold.until
+}
+
+// heldBack reports whether the waiting txs of addr mustn't be promoted yet.
+func (tp *ethTxPool) heldBack(addr common.Address) bool {
+	hold, ok := tp.holds[addr]
+	return ok && atomic.LoadInt64(&tp.app.committedHeight) < hold.until
+}
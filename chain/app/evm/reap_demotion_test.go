@@ -0,0 +1,98 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+// TestReapHoldsBackDustChain reproduces an account sending one tx at a good price
+// followed by a chain of dust priced txs: the chain is demoted once and not
+// checked again by the proposals of the hold back.
+func TestReapHoldsBackDustChain(t *testing.T) {
+	const backoff = 3
+	conf := viper.New()
+	conf.Set("min_gas_price", "10")
+	conf.Set("reap_demote_backoff", backoff)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	fundTestAccounts(t, app, new(big.Int).Mul(big.NewInt(100), big.NewInt(testGas)), addr)
+	var first []byte
+	dust := make(map[common.Hash]bool)
+	// the later nonces first, so the whole chain is promoted to pending together
+	for nonce := uint64(20); ; nonce-- {
+		price := big.NewInt(1)
+		if nonce == 0 {
+			price = big.NewInt(10)
+		}
+		raw := signTestTx(t, key, etypes.NewTransaction(nonce, common.Address{}, big.NewInt(0), testGas, price, nil))
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+		if nonce == 0 {
+			first = raw
+			break
+		}
+		dust[txHash(raw)] = true
+	}
+	app.pool.updateToState()
+
+	txs := app.pool.Reap(-1)
+	if len(txs) != 1 || txHash(txs[0]) != txHash(first) {
+		t.Fatalf("expected only the well priced tx, got %d txs", len(txs))
+	}
+	for hash := range dust {
+		status := queryTestTxStatus(t, app, hash)
+		if status.Status != rtypes.TxStatus_Demoted || status.Reason != errReapUnderpriced || status.Height != backoff {
+			t.Fatalf("unexpected status of a dust tx %+v", status)
+		}
+	}
+
+	// the proposals of the hold back don't see the dust chain
+	execTestBlock(t, app, 1, first)
+	for height := int64(2); height <= backoff; height++ {
+		if app.pool.pending[addr] != nil {
+			t.Fatalf("expected the dust chain held back at height %d", height-1)
+		}
+		if txs := app.pool.Reap(-1); len(txs) != 0 {
+			t.Fatalf("expected nothing to reap, got %d txs", len(txs))
+		}
+		execTestBlock(t, app, height)
+	}
+
+	// once promoted again, the chain is demoted for twice as long
+	if app.pool.pending[addr] == nil || app.pool.pending[addr].Len() != len(dust) {
+		t.Fatal("expected the dust chain promoted after the hold back")
+	}
+	if txs := app.pool.Reap(-1); len(txs) != 0 {
+		t.Fatalf("expected nothing to reap, got %d txs", len(txs))
+	}
+	for hash := range dust {
+		if status := queryTestTxStatus(t, app, hash); status.Height != backoff+2*backoff {
+			t.Fatalf("expected the hold back doubled, held until %d", status.Height)
+		}
+	}
+	if app.pool.Size() != len(dust) {
+		t.Fatalf("demoted txs should stay in pool, size %d", app.pool.Size())
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"container/heap"
+	"time"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// reap_order values, the order the pool reaps pending txs for a proposal in
+const (
+	reapOrderNonce = "nonce" // sender after sender, each sender's txs in nonce order
+	reapOrderPrice = "price" // highest gas price first, each sender's txs still in nonce order
+)
+
+// reap_tie_break values, the order of txs of the same gas price under reap_order
+// price. The hash settles any tie left, so the order of a pool's txs never
+// depends on how the pool happens to walk its senders.
+const (
+	reapTieBreakArrival = "arrival" // earliest received by the pool first, then lowest hash
+	reapTieBreakHash    = "hash"    // lowest hash first, the same order on every node holding the txs
+)
+
+func validReapOrder(order string) bool {
+	return order == reapOrderNonce || order == reapOrderPrice
+}
+
+func validReapTieBreak(tieBreak string) bool {
+	return tieBreak == reapTieBreakArrival || tieBreak == reapTieBreakHash
+}
+
+// reapTx is a pending tx reaped under reap_order price
+type reapTx struct {
+	tx      *etypes.Transaction
+	raw     types.Tx
+	sender  common.Address
+	arrival int64
+}
+
+// reapBefore tells whether a is reaped before b: the higher gas price first,
+// then the tie break
+func reapBefore(a, b *reapTx, tieBreak string) bool {
+	if c := a.tx.GasPrice().Cmp(b.tx.GasPrice()); c != 0 {
+		return c > 0
+	}
+	if tieBreak == reapTieBreakArrival && a.arrival != b.arrival {
+		return a.arrival < b.arrival
+	}
+	ha, hb := a.tx.Hash(), b.tx.Hash()
+	return bytes.Compare(ha[:], hb[:]) < 0
+}
+
+// reapHeads is a heap of the txs of each sender left to reap, the next tx of a
+// sender being the only one competing with the other senders
+type reapHeads struct {
+	senders  [][]*reapTx
+	tieBreak string
+}
+
+func (h *reapHeads) Len() int { return len(h.senders) }
+func (h *reapHeads) Less(i, j int) bool {
+	return reapBefore(h.senders[i][0], h.senders[j][0], h.tieBreak)
+}
+func (h *reapHeads) Swap(i, j int) { h.senders[i], h.senders[j] = h.senders[j], h.senders[i] }
+
+func (h *reapHeads) Push(x interface{}) {
+	h.senders = append(h.senders, x.([]*reapTx))
+}
+
+func (h *reapHeads) Pop() interface{} {
+	old := h.senders
+	n := len(old)
+	x := old[n-1]
+	h.senders = old[0 : n-1]
+	return x
+}
+
+// sortByPrice merges the nonce ordered txs of each sender into up to maxTxs
+// txs, the best next tx of a sender first by reapBefore. A tx refused by fits is
+// skipped along with the later txs of its sender, which can't run before it,
+// and the merge goes on with the other senders.
+func sortByPrice(bySender map[common.Address][]*reapTx, maxTxs int, tieBreak string, fits func(rtx *reapTx) bool) []*reapTx {
+	heads := &reapHeads{tieBreak: tieBreak}
+	for _, txs := range bySender {
+		if len(txs) > 0 {
+			heads.senders = append(heads.senders, txs)
+		}
+	}
+	heap.Init(heads)
+	var sorted []*reapTx
+	for heads.Len() > 0 && len(sorted) < maxTxs {
+		txs := heads.senders[0]
+		if !fits(txs[0]) {
+			heap.Pop(heads)
+			continue
+		}
+		sorted = append(sorted, txs[0])
+		if len(txs) > 1 {
+			heads.senders[0] = txs[1:]
+			heap.Fix(heads, 0)
+		} else {
+			heap.Pop(heads)
+		}
+	}
+	return sorted
+}
+
+// received records tx entering the pool, its arrival breaking gas price ties.
+// Arrivals strictly increase, so txs received within the clock resolution keep
+// the order they were received in.
+func (tp *ethTxPool) received(hash common.Hash, raw types.Tx) {
+	arrival := time.Now().UnixNano()
+	if arrival <= tp.lastArrival {
+		arrival = tp.lastArrival + 1
+	}
+	tp.all[hash], tp.arrivals[hash], tp.lastArrival = raw, arrival, arrival
+}
+
+// forget removes the tx of hash from the pool lookups
+func (tp *ethTxPool) forget(hash common.Hash) {
+	delete(tp.all, hash)
+	delete(tp.arrivals, hash)
+}
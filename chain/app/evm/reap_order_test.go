@@ -0,0 +1,147 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+// reapOrderTestTxs signs, with fixed keys so their hashes are the same on every
+// run, a tx of gas price 1 for each of 6 senders, then for a 7th sender a tx of
+// gas price 5 at nonce 1 and one of gas price 1 at nonce 0
+func reapOrderTestTxs(t *testing.T) [][]byte {
+	to := common.HexToAddress("0x1234")
+	var txs [][]byte
+	for i := 1; i <= 6; i++ {
+		key, _ := testKey(t, fmt.Sprintf("%064x", i))
+		txs = append(txs, signTestTx(t, key, etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(1), nil)))
+	}
+	key, _ := testKey(t, fmt.Sprintf("%064x", 7))
+	return append(txs,
+		signTestTx(t, key, etypes.NewTransaction(1, to, big.NewInt(0), testGas, big.NewInt(5), nil)),
+		signTestTx(t, key, etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(1), nil)))
+}
+
+func reapTestHashes(t *testing.T, tieBreak string, received [][]byte) []common.Hash {
+	conf := viper.New()
+	conf.Set("reap_order", reapOrderPrice)
+	conf.Set("reap_tie_break", tieBreak)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	for _, raw := range received {
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.pool.updateToState()
+	var hashes []common.Hash
+	for _, raw := range app.pool.Reap(-1) {
+		hashes = append(hashes, txHash(raw))
+	}
+	return hashes
+}
+
+func TestReapOrderTieBreak(t *testing.T) {
+	txs := reapOrderTestTxs(t)
+	high, low := txHash(txs[6]), txHash(txs[7])
+	// the nonce 1 tx first, so both txs of the 7th sender are promoted to
+	// pending together
+	shuffled := [][]byte{txs[6], txs[7], txs[5], txs[3], txs[1], txs[4], txs[0], txs[2]}
+	// the gas price 5 tx waits for the tx before it of its sender, which ties
+	// with the other gas price 1 txs
+	withHigh := func(equal []common.Hash) []common.Hash {
+		var order []common.Hash
+		for _, hash := range equal {
+			if hash != high {
+				order = append(order, hash)
+			}
+			if hash == low {
+				order = append(order, high)
+			}
+		}
+		return order
+	}
+	check := func(tieBreak string, received [][]byte, expected []common.Hash) {
+		hashes := reapTestHashes(t, tieBreak, received)
+		if len(hashes) != len(expected) {
+			t.Fatalf("%s: expected %d txs reaped, got %d", tieBreak, len(expected), len(hashes))
+		}
+		for i := range hashes {
+			if hashes[i] != expected[i] {
+				t.Fatalf("%s: unexpected tx %d reaped %x, expected %x", tieBreak, i, hashes[i], expected[i])
+			}
+		}
+	}
+
+	// by hash, the order is the same whatever the order the txs were received in
+	var byHash []common.Hash
+	for _, raw := range txs {
+		byHash = append(byHash, txHash(raw))
+	}
+	sort.Slice(byHash, func(i, j int) bool { return bytes.Compare(byHash[i][:], byHash[j][:]) < 0 })
+	for _, received := range [][][]byte{txs, shuffled, txs} {
+		check(reapTieBreakHash, received, withHigh(byHash))
+	}
+
+	// by arrival, the txs of the same gas price are reaped in the order received
+	for _, received := range [][][]byte{txs, shuffled} {
+		var byArrival []common.Hash
+		for _, raw := range received {
+			byArrival = append(byArrival, txHash(raw))
+		}
+		check(reapTieBreakArrival, received, withHigh(byArrival))
+	}
+}
+
+func reapTestFits(*reapTx) bool { return true }
+
+func TestReapOrderByPrice(t *testing.T) {
+	a := &reapTx{tx: etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(2), nil), arrival: 2}
+	b := &reapTx{tx: etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(1), nil), arrival: 1}
+	c := &reapTx{tx: etypes.NewTransaction(1, common.Address{}, big.NewInt(0), testGas, big.NewInt(3), nil), arrival: 3}
+	bySender := map[common.Address][]*reapTx{{1}: {a}, {2}: {b, c}}
+	// c pays the most but comes after b of its sender
+	sorted := sortByPrice(bySender, 10, reapTieBreakArrival, reapTestFits)
+	if len(sorted) != 3 || sorted[0] != a || sorted[1] != b || sorted[2] != c {
+		t.Fatal("expected the txs by gas price in the nonce order of their sender")
+	}
+	if sorted := sortByPrice(bySender, 1, reapTieBreakArrival, reapTestFits); len(sorted) != 1 || sorted[0] != a {
+		t.Fatal("expected the reaped txs capped")
+	}
+
+	// a tx over the gas budget is skipped with the later txs of its sender, the
+	// merge goes on with the other senders up to maxTxs
+	tx := func(nonce uint64, gas uint64, price int64) *reapTx {
+		return &reapTx{tx: etypes.NewTransaction(nonce, common.Address{}, big.NewInt(0), gas, big.NewInt(price), nil)}
+	}
+	x0, x1 := tx(0, 100000, 5), tx(1, 21000, 5)
+	y0, y1, z0 := tx(0, 21000, 3), tx(1, 21000, 2), tx(0, 21000, 1)
+	bySender = map[common.Address][]*reapTx{{1}: {x0, x1}, {2}: {y0, y1}, {3}: {z0}}
+	for _, maxTxs := range []int{2, 10} {
+		budget := &reapGasBudget{limit: 50000}
+		fits := func(rtx *reapTx) bool { return budget.take(rtx.tx) }
+		if sorted := sortByPrice(bySender, maxTxs, reapTieBreakArrival, fits); len(sorted) != 2 || sorted[0] != y0 || sorted[1] != y1 {
+			t.Fatalf("max %d: expected the txs fitting the gas budget, got %d txs", maxTxs, len(sorted))
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+type reapCheck int
+
+const (
+	reapOK reapCheck = iota
+	reapStale
+	reapUnexecutable
+)
+
+// reapValidator re-checks reap candidates against the committed state plus the
+// effects of the txs reaped before them, looking at nonce and balance only. It's a
+// proposer local optimization to keep dead txs out of blocks, validators still
+// execute every tx with the full rules.
+type reapValidator struct {
+	state    *estate.StateDB
+	nonces   map[common.Address]uint64
+	balances map[common.Address]*big.Int
+}
+
+// newReapValidator must be used under app.stateMtx
+func newReapValidator(state *estate.StateDB) *reapValidator {
+	return &reapValidator{
+		state:    state,
+		nonces:   make(map[common.Address]uint64),
+		balances: make(map[common.Address]*big.Int),
+	}
+}
+
+func (v *reapValidator) balance(addr common.Address) *big.Int {
+	balance, ok := v.balances[addr]
+	if !ok {
+		balance = new(big.Int).Set(v.state.GetBalance(addr))
+		v.balances[addr] = balance
+	}
+	return balance
+}
+
+// check checks tx of sender from as the next tx of the block, applying its effects if it passes.
+func (v *reapValidator) check(from common.Address, tx *etypes.Transaction) reapCheck {
+	nonce, ok := v.nonces[from]
+	if !ok {
+		nonce = v.state.GetNonce(from)
+	}
+	if tx.Nonce() < nonce {
+		return reapStale
+	}
+	cost := tx.Cost()
+	balance := v.balance(from)
+	if tx.Nonce() > nonce || balance.Cmp(cost) < 0 {
+		return reapUnexecutable
+	}
+
+	balance.Sub(balance, cost)
+	if to := tx.To(); to != nil && tx.Value().Sign() > 0 {
+		recipient := v.balance(*to)
+		recipient.Add(recipient, tx.Value())
+	}
+	v.nonces[from] = nonce + 1
+	return reapOK
+}
@@ -0,0 +1,146 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+)
+
+func newReapTestApp(t *testing.T) (*EVMApp, func()) {
+	conf := viper.New()
+	conf.Set("reap_prevalidate", true)
+	return newTestAppWithConfig(t, conf)
+}
+
+func TestReapSkipsUnaffordableTxs(t *testing.T) {
+	app, clean := newReapTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	fundTestAccounts(t, app, big.NewInt(100), addr)
+	to := common.HexToAddress("0x0100000000000000000000000000000000000001")
+	first := signTestTx(t, key, etypes.NewTransaction(0, to, big.NewInt(60), testGas, big.NewInt(0), nil))
+	second := signTestTx(t, key, etypes.NewTransaction(1, to, big.NewInt(60), testGas, big.NewInt(0), nil))
+	// the later nonce first, so both are promoted to pending together
+	for _, raw := range [][]byte{second, first} {
+		if err := app.CheckTx(raw); err != nil {
+			t.Fatal(err)
+		}
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.pool.updateToState()
+
+	txs := app.pool.Reap(-1)
+	if len(txs) != 1 || txHash(txs[0]) != txHash(first) {
+		t.Fatalf("expected only the affordable tx, got %d txs", len(txs))
+	}
+	if status := queryTestTxStatus(t, app, txHash(second)); status.Status != rtypes.TxStatus_Demoted {
+		t.Fatalf("expected unaffordable tx demoted to waiting, got %v", status.Status)
+	}
+	if app.pool.Size() != 2 {
+		t.Fatalf("demoted tx should stay in pool, size %d", app.pool.Size())
+	}
+}
+
+func TestReapDropsStaleTxs(t *testing.T) {
+	app, clean := newReapTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	stale := signTestTx(t, key, etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+	next := signTestTx(t, key, etypes.NewTransaction(1, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+	for _, raw := range [][]byte{next, stale} {
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.pool.updateToState()
+	// nonce 0 consumed elsewhere, the pool has not caught up yet
+	updateTestState(t, app, func(state *estate.StateDB) { state.SetNonce(addr, 1) })
+
+	txs := app.pool.Reap(-1)
+	if len(txs) != 1 || txHash(txs[0]) != txHash(next) {
+		t.Fatalf("expected only the next tx, got %d txs", len(txs))
+	}
+	status := queryTestTxStatus(t, app, txHash(stale))
+	if status.Status != rtypes.TxStatus_Evicted || status.Reason != errNonceTooLow {
+		t.Fatalf("expected stale tx evicted, got %v (%s)", status.Status, status.Reason)
+	}
+	if app.pool.Size() != 1 {
+		t.Fatalf("stale tx should be dropped, size %d", app.pool.Size())
+	}
+}
+
+// BenchmarkReap and BenchmarkReapPrevalidate reap 5000 candidates from 50 accounts,
+// their difference is the cost of the reap validator.
+func BenchmarkReap(b *testing.B)            { benchmarkReap(b, false) }
+func BenchmarkReapPrevalidate(b *testing.B) { benchmarkReap(b, true) }
+
+func benchmarkReap(b *testing.B, prevalidate bool) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := viper.New()
+	conf.Set("db_dir", dir)
+	conf.Set("block_size", 500)
+	conf.Set("reap_prevalidate", prevalidate)
+	app, err := NewEVMApp(conf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := app.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer app.Stop()
+
+	for i := 0; i < 50; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			b.Fatal(err)
+		}
+		for nonce := uint64(100); nonce > 0; nonce-- {
+			tx, err := etypes.SignTx(etypes.NewTransaction(nonce-1, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil), EthSigner, key)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := app.pool.CheckAndAdd(tx, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	app.pool.updateToState()
+	if n := len(app.pool.Reap(-1)); n != 5000 {
+		b.Fatalf("expected 5000 candidates, got %d", n)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		app.pool.Reap(-1)
+	}
+}
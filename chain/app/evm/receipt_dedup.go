@@ -0,0 +1,176 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+)
+
+// LogDataPrefix stores the log data shared by stored receipts, keyed by its
+// keccak256 hash, so the same data emitted by many txs is stored once. The data
+// is deleted along with the last receipt referencing it, see LogDataRefsPrefix.
+var LogDataPrefix = []byte("logdata-")
+
+// LogDataRefsPrefix stores the number of stored receipt logs referencing the
+// log data of the same hash, a big endian uint64
+var LogDataRefsPrefix = []byte("logdatarefs-")
+
+func logDataKey(hash common.Hash) []byte {
+	return append(append([]byte{}, LogDataPrefix...), hash.Bytes()...)
+}
+
+func logDataRefsKey(hash common.Hash) []byte {
+	return append(append([]byte{}, LogDataRefsPrefix...), hash.Bytes()...)
+}
+
+// logDataRefs gathers the references to the shared log data a batch adds and
+// drops, by data hash
+type logDataRefs map[common.Hash]*logDataRef
+
+type logDataRef struct {
+	data  []byte // set by the references added
+	delta int64
+}
+
+func (refs logDataRefs) add(hash common.Hash, data []byte, delta int64) {
+	ref := refs[hash]
+	if ref == nil {
+		ref = &logDataRef{}
+		refs[hash] = ref
+	}
+	if data != nil {
+		ref.data = data
+	}
+	ref.delta += delta
+}
+
+// dedupLogData returns env with the data of its logs of at least
+// app.logDedupMinSize bytes replaced by its hash, and adds the references to
+// that data to refs. env is left untouched: its receipt is the one the receipts
+// hash is computed over.
+func (app *EVMApp) dedupLogData(env *receiptEnvelope, refs logDataRefs) *receiptEnvelope {
+	if !app.logDedup {
+		return env
+	}
+	var deduped *receiptEnvelope
+	for i, l := range env.Receipt.Logs {
+		if len(l.Data) < app.logDedupMinSize {
+			continue
+		}
+		if deduped == nil {
+			envCopy, receipt := *env, *env.Receipt
+			receipt.Logs = append([]*etypes.Log{}, receipt.Logs...)
+			envCopy.Receipt = &receipt
+			deduped = &envCopy
+		}
+		hash := crypto.Keccak256Hash(l.Data)
+		refs.add(hash, l.Data, 1)
+		ref := *l
+		ref.Data = hash.Bytes()
+		deduped.Receipt.Logs[i] = &ref
+		deduped.LogRefs = append(deduped.LogRefs, uint64(i))
+	}
+	if deduped == nil {
+		return env
+	}
+	return deduped
+}
+
+// releaseLogData drops from refs the references of the stored receipt data,
+// being deleted, to the shared log data.
+func releaseLogData(data []byte, refs logDataRefs) error {
+	env, err := decodeStoredReceipt(data)
+	if err != nil {
+		return err
+	}
+	for _, i := range env.LogRefs {
+		if i >= uint64(len(env.Receipt.Logs)) {
+			return fmt.Errorf("log data reference %d out of %d logs", i, len(env.Receipt.Logs))
+		}
+		refs.add(common.BytesToHash(env.Receipt.Logs[i].Data), nil, -1)
+	}
+	return nil
+}
+
+// writeLogDataRefs puts to batch the reference counts refs change, along with
+// the data gaining its first reference, and deletes the data losing its last.
+func (app *EVMApp) writeLogDataRefs(batch ethdb.Batch, refs logDataRefs) error {
+	for hash, ref := range refs {
+		if ref.delta == 0 {
+			continue
+		}
+		var count int64
+		if value, err := app.stateDb.Get(logDataRefsKey(hash)); err == nil && len(value) == 8 {
+			count = int64(binary.BigEndian.Uint64(value))
+		}
+		if count+ref.delta <= 0 {
+			if err := batch.Delete(logDataKey(hash)); err != nil {
+				return err
+			}
+			if err := batch.Delete(logDataRefsKey(hash)); err != nil {
+				return err
+			}
+			continue
+		}
+		if count == 0 {
+			if ref.data == nil {
+				return fmt.Errorf("no log data %x to reference", hash)
+			}
+			if err := batch.Put(logDataKey(hash), ref.data); err != nil {
+				return err
+			}
+		}
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(count+ref.delta))
+		if err := batch.Put(logDataRefsKey(hash), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inflateLogData puts the stored data back in the logs of env referencing it.
+func (app *EVMApp) inflateLogData(env *receiptEnvelope) error {
+	for _, i := range env.LogRefs {
+		if i >= uint64(len(env.Receipt.Logs)) {
+			return fmt.Errorf("log data reference %d out of %d logs", i, len(env.Receipt.Logs))
+		}
+		l := env.Receipt.Logs[i]
+		data, err := app.stateDb.Get(logDataKey(common.BytesToHash(l.Data)))
+		if err != nil {
+			return fmt.Errorf("get log data %x: %v", l.Data, err)
+		}
+		l.Data = data
+	}
+	env.LogRefs = nil
+	return nil
+}
+
+// decodeReceipt reads a stored receipt with its logs data inflated.
+func (app *EVMApp) decodeReceipt(data []byte) (*receiptEnvelope, error) {
+	env, err := decodeStoredReceipt(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.inflateLogData(env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
@@ -0,0 +1,150 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// echoLogCode deploys a contract emitting its call data as a LOG0 on every call.
+var echoLogCode = common.FromHex("600b600c600039600b6000f3" + "366000600037" + "366000a000")
+
+// execAirdropBlock executes an airdrop-like block on a new app: drops calls
+// emitting the same data, and one emitting a few bytes.
+func execAirdropBlock(t *testing.T, conf *viper.Viper, drops int, data []byte) (*EVMApp, func(), []common.Hash, gtypes.CommitResult) {
+	app, clean := newTestAppWithConfig(t, conf)
+	key, _ := testKey(t, testKeyA)
+	deploy := signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), echoLogCode))
+	execTestBlock(t, app, 1, deploy)
+	receipt, err := app.GetReceipt(txHash(deploy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var txs [][]byte
+	var hashes []common.Hash
+	for i := 0; i <= drops; i++ {
+		input := data
+		if i == drops {
+			input = []byte{1, 2, 3}
+		}
+		tx := signTestTx(t, key, etypes.NewTransaction(uint64(i+1), receipt.ContractAddress, big.NewInt(0), testGas, big.NewInt(0), input))
+		txs, hashes = append(txs, tx), append(hashes, txHash(tx))
+	}
+	return app, clean, hashes, commitTestBlock(t, app, makeTestBlock(2, txs...))
+}
+
+func TestReceiptsDedupLogData(t *testing.T) {
+	const drops = 50
+	airdrop := bytes.Repeat([]byte("airdrop claimed "), 64)
+
+	plain, cleanPlain, hashes, plainRes := execAirdropBlock(t, viper.New(), drops, airdrop)
+	defer cleanPlain()
+	conf := viper.New()
+	conf.Set("receipts_dedup_logs", true)
+	deduped, cleanDeduped, _, dedupedRes := execAirdropBlock(t, conf, drops, airdrop)
+	defer cleanDeduped()
+
+	// the receipts hash is over the inflated receipts, whatever is stored
+	if !bytes.Equal(plainRes.ReceiptsHash, dedupedRes.ReceiptsHash) {
+		t.Fatalf("expected the same receipts hash, got %x and %x", plainRes.ReceiptsHash, dedupedRes.ReceiptsHash)
+	}
+	plainStats, dedupedStats := plain.commitStats.list(), deduped.commitStats.list()
+	plainBytes, dedupedBytes := plainStats[len(plainStats)-1].ReceiptBytes, dedupedStats[len(dedupedStats)-1].ReceiptBytes
+	if dedupedBytes*2 > plainBytes {
+		t.Fatalf("expected the receipts to take half the space, got %d bytes instead of %d", dedupedBytes, plainBytes)
+	}
+	t.Logf("airdrop block receipts: %d bytes, %d deduplicated", plainBytes, dedupedBytes)
+
+	// the receipts read back are the ones stored in full
+	for _, hash := range hashes {
+		expected, err := plain.GetReceipt(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		receipt, err := deduped.GetReceipt(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedData, _ := rlp.EncodeToBytes((*etypes.ReceiptForStorage)(expected))
+		data, _ := rlp.EncodeToBytes((*etypes.ReceiptForStorage)(receipt))
+		if !bytes.Equal(expectedData, data) {
+			t.Fatalf("receipt %x changed by the round trip", hash)
+		}
+		res := deduped.Query(append([]byte{rtypes.QueryType_Receipt}, hash.Bytes()...))
+		if res.IsErr() || !bytes.Equal(res.Data, expectedData) {
+			t.Fatalf("unexpected receipt query of %x: %s", hash, res.Log)
+		}
+	}
+	logs, err := deduped.GetLogs(&LogFilter{FromBlock: 2, ToBlock: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != drops+1 || !bytes.Equal(logs[0].Data, airdrop) || !bytes.Equal(logs[drops].Data, []byte{1, 2, 3}) {
+		t.Fatalf("unexpected logs %+v", logs)
+	}
+}
+
+func TestReceiptsDedupPrune(t *testing.T) {
+	const drops = 5
+	airdrop := bytes.Repeat([]byte("airdrop claimed "), 64)
+	hash := crypto.Keccak256Hash(airdrop)
+
+	conf := viper.New()
+	conf.Set("receipts_dedup_logs", true)
+	conf.Set("receipts_retention", 1)
+	app, clean, _, _ := execAirdropBlock(t, conf, drops, airdrop)
+	defer clean()
+	refs := func() uint64 {
+		value, err := app.stateDb.Get(logDataRefsKey(hash))
+		if err != nil {
+			return 0
+		}
+		return binary.BigEndian.Uint64(value)
+	}
+	if refs() != drops {
+		t.Fatalf("expected %d references to the log data, got %d", drops, refs())
+	}
+
+	// block 3 references the data again as the airdrop block is pruned
+	key, addr := testKey(t, testKeyA)
+	echo := crypto.CreateAddress(addr, 0)
+	again := signTestTx(t, key, etypes.NewTransaction(drops+2, echo, big.NewInt(0), testGas, big.NewInt(0), airdrop))
+	commitTestBlock(t, app, makeTestBlock(3, again))
+	if refs() != 1 {
+		t.Fatalf("expected the reference of block 3 left, got %d", refs())
+	}
+	receipt, err := app.GetReceipt(txHash(again))
+	if err != nil || len(receipt.Logs) != 1 || !bytes.Equal(receipt.Logs[0].Data, airdrop) {
+		t.Fatalf("expected the log data of block 3 kept, got %+v %v", receipt, err)
+	}
+
+	// the data goes with its last reference
+	transfer := signTestTx(t, key, etypes.NewTransaction(drops+3, common.HexToAddress("0x01"), big.NewInt(0), testGas, big.NewInt(0), nil))
+	commitTestBlock(t, app, makeTestBlock(4, transfer))
+	if ok, _ := app.stateDb.Has(logDataKey(hash)); ok || refs() != 0 {
+		t.Fatalf("expected the log data deleted with its last reference, got %d references", refs())
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/eth/trie"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// ErrReceiptProofUnsupported refuses receipt proofs on a chain hashing its
+// receipts with the simple hash, which isn't a trie
+var ErrReceiptProofUnsupported = errors.New("receipt proofs need receipts_hash derive-sha")
+
+// receiptProofList collects the trie nodes of a proof in path order
+type receiptProofList [][]byte
+
+func (l *receiptProofList) Put(key []byte, value []byte) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// ReceiptProof returns the receipt of the committed tx of hash with its proof in
+// the receipts trie of its block. The trie is built again from the stored
+// receipts of the block, so the receipts of a pruned block can't be proven.
+func (app *EVMApp) ReceiptProof(hash common.Hash) (*rtypes.ReceiptProof, error) {
+	if app.chainConfig.ReceiptsHash != params.ReceiptsHashDeriveSha {
+		return nil, ErrReceiptProofUnsupported
+	}
+	env, err := app.storedReceipt(hash)
+	if err != nil {
+		return nil, err
+	}
+	index, err := app.stateDb.Get(blockReceiptsKey(env.Height))
+	if err != nil {
+		return nil, fmt.Errorf("no receipts index of block %d", env.Height)
+	}
+	var txHashes []common.Hash
+	if err := rlp.DecodeBytes(index, &txHashes); err != nil {
+		return nil, fmt.Errorf("decode receipts index of block %d: %v", env.Height, err)
+	}
+
+	proof := &rtypes.ReceiptProof{Height: env.Height, TxHash: hash}
+	receipts := new(trie.Trie)
+	found := false
+	for i, txHash := range txHashes {
+		receipt := env.Receipt
+		if txHash != hash {
+			other, err := app.storedReceipt(txHash)
+			if err != nil {
+				return nil, fmt.Errorf("get receipt %x: %v", txHash, err)
+			}
+			receipt = other.Receipt
+		}
+		value, err := rlp.EncodeToBytes((*etypes.Receipt)(receipt))
+		if err != nil {
+			return nil, err
+		}
+		if txHash == hash {
+			proof.Index, proof.Receipt, found = uint64(i), value, true
+		}
+		key, _ := rlp.EncodeToBytes(uint(i))
+		receipts.Update(key, value)
+	}
+	if !found {
+		return nil, fmt.Errorf("receipt %x not indexed in block %d", hash, env.Height)
+	}
+	proof.ReceiptsRoot = receipts.Hash()
+	key, _ := rlp.EncodeToBytes(uint(proof.Index))
+	var nodes receiptProofList
+	if err := receipts.Prove(key, 0, &nodes); err != nil {
+		return nil, err
+	}
+	proof.Proof = nodes
+	return proof, nil
+}
+
+// VerifyReceiptProof checks the receipt of proof against its proof and receipts
+// root and returns it. The root must be checked too, against the receipts hash
+// in the header of the block after proof.Height.
+func VerifyReceiptProof(proof *rtypes.ReceiptProof) (*etypes.Receipt, error) {
+	proofDb := ethdb.NewMemDatabase()
+	for _, node := range proof.Proof {
+		if err := proofDb.Put(crypto.Keccak256(node), node); err != nil {
+			return nil, err
+		}
+	}
+	key, _ := rlp.EncodeToBytes(uint(proof.Index))
+	value, _, err := trie.VerifyProof(proof.ReceiptsRoot, key, proofDb)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil || !bytes.Equal(value, proof.Receipt) {
+		return nil, errors.New("receipt does not match its proof")
+	}
+	receipt := new(etypes.Receipt)
+	if err := rlp.DecodeBytes(value, receipt); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// queryReceiptProof takes a 32 bytes tx hash and returns the rlp encoded
+// rtypes.ReceiptProof of its receipt.
+func (app *EVMApp) queryReceiptProof(load []byte) gtypes.Result {
+	if len(load) != common.HashLength {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid tx hash")
+	}
+	proof, err := app.ReceiptProof(common.BytesToHash(load))
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(proof)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
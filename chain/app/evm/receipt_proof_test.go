@@ -0,0 +1,105 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func queryTestReceiptProof(t *testing.T, app *EVMApp, hash common.Hash) *rtypes.ReceiptProof {
+	res := app.Query(append([]byte{rtypes.QueryType_ReceiptProof}, hash.Bytes()...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	proof := &rtypes.ReceiptProof{}
+	if err := rlp.DecodeBytes(res.Data, proof); err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func TestReceiptProof(t *testing.T) {
+	conf := viper.New()
+	conf.Set("receipts_hash", "derive-sha")
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	fundTestAccounts(t, app, big.NewInt(1000), addr)
+	var txs [][]byte
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		txs = append(txs, signTestTx(t, key, etypes.NewTransaction(nonce, common.HexToAddress("0x1234"), big.NewInt(1), testGas, big.NewInt(0), nil)))
+	}
+	// the creation of a contract storing 32 slots uses more gas than the transfers
+	txs = append(txs, signTestTx(t, key, etypes.NewContractCreation(3, big.NewInt(0), testGas, big.NewInt(0), storageHeavyCode)))
+	committed := commitTestBlock(t, app, makeTestBlock(1, txs...))
+
+	for i, raw := range txs {
+		proof := queryTestReceiptProof(t, app, txHash(raw))
+		if proof.Height != 1 || proof.Index != uint64(i) || proof.TxHash != txHash(raw) {
+			t.Fatalf("unexpected proof of tx %d: %+v", i, proof)
+		}
+		if !bytes.Equal(proof.ReceiptsRoot.Bytes(), committed.ReceiptsHash) {
+			t.Fatalf("expected the committed receipts hash %x, got %x", committed.ReceiptsHash, proof.ReceiptsRoot)
+		}
+		receipt, err := VerifyReceiptProof(proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := app.GetReceipt(txHash(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if receipt.CumulativeGasUsed != stored.CumulativeGasUsed || receipt.Status != stored.Status || receipt.Bloom != stored.Bloom {
+			t.Fatalf("expected the stored receipt of tx %d proven, got %+v", i, receipt)
+		}
+	}
+
+	// a proof doesn't hold for another receipt, nor at another position
+	proof := queryTestReceiptProof(t, app, txHash(txs[1]))
+	forged := *proof
+	forged.Receipt = queryTestReceiptProof(t, app, txHash(txs[3])).Receipt
+	if _, err := VerifyReceiptProof(&forged); err == nil {
+		t.Fatal("expected another receipt to fail the proof")
+	}
+	forged = *proof
+	forged.Index = 3
+	if _, err := VerifyReceiptProof(&forged); err == nil {
+		t.Fatal("expected another position to fail the proof")
+	}
+
+	if res := app.Query(append([]byte{rtypes.QueryType_ReceiptProof}, common.HexToHash("0x01").Bytes()...)); res.IsOK() {
+		t.Fatal("expected an unknown tx to be refused")
+	}
+}
+
+func TestReceiptProofSimpleHash(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	key, _ := testKey(t, testKeyA)
+	raw := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), nil))
+	commitTestBlock(t, app, makeTestBlock(1, raw))
+	if _, err := app.ReceiptProof(txHash(raw)); err != ErrReceiptProofUnsupported {
+		t.Fatalf("expected %v, got %v", ErrReceiptProofUnsupported, err)
+	}
+}
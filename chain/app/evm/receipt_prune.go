@@ -0,0 +1,106 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// ReceiptsPrunedKey stores the height up to which the receipts are pruned
+var ReceiptsPrunedKey = []byte("pruned-receipts-height")
+
+// ErrReceiptsPruned answers the lookups of the receipts of pruned blocks
+var ErrReceiptsPruned = errors.New("receipts pruned")
+
+// receiptsPruneBatch is the max number of blocks pruned along one commit, so a
+// node starting to prune a long history catches up over several blocks.
+const receiptsPruneBatch = 100
+
+func (app *EVMApp) loadReceiptsPruned() {
+	value, err := app.stateDb.Get(ReceiptsPrunedKey)
+	if err != nil || len(value) != 8 {
+		return
+	}
+	atomic.StoreUint64(&app.receiptsPruned, binary.BigEndian.Uint64(value))
+}
+
+// checkReceiptsPruned returns ErrReceiptsPruned if the receipts of the block at
+// height are pruned.
+func (app *EVMApp) checkReceiptsPruned(height uint64) error {
+	if height <= atomic.LoadUint64(&app.receiptsPruned) {
+		return ErrReceiptsPruned
+	}
+	return nil
+}
+
+// pruneReceipts deletes in batch the receipts of the blocks past the retention
+// at height, along with their receipts index entries, and returns the new pruned
+// height to publish once batch is written. Blocks committed before the receipts
+// index existed can't be told apart and keep their receipts.
+func (app *EVMApp) pruneReceipts(batch ethdb.Batch, height uint64, refs logDataRefs) (uint64, error) {
+	pruned := atomic.LoadUint64(&app.receiptsPruned)
+	if app.receiptsRetention == 0 || height <= app.receiptsRetention {
+		return pruned, nil
+	}
+	target := height - app.receiptsRetention
+	if target <= pruned {
+		return pruned, nil
+	}
+	if target > pruned+receiptsPruneBatch {
+		target = pruned + receiptsPruneBatch
+	}
+	for h := pruned + 1; h <= target; h++ {
+		if err := app.deleteBlockReceipts(batch, h, refs); err != nil {
+			return pruned, err
+		}
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, target)
+	if err := batch.Put(ReceiptsPrunedKey, value); err != nil {
+		return pruned, err
+	}
+	return target, nil
+}
+
+// deleteBlockReceipts deletes in batch the receipts of the block at height along
+// with its receipts index entry, if the block has one, and drops from refs their
+// references to the shared log data.
+func (app *EVMApp) deleteBlockReceipts(batch ethdb.Batch, height uint64, refs logDataRefs) error {
+	index, err := app.stateDb.Get(blockReceiptsKey(height))
+	if err != nil || len(index) == 0 {
+		return nil
+	}
+	var txHashes []common.Hash
+	if err := rlp.DecodeBytes(index, &txHashes); err != nil {
+		return fmt.Errorf("decode receipts index of block %d: %v", height, err)
+	}
+	for _, hash := range txHashes {
+		if data, err := app.stateDb.Get(receiptKey(hash)); err == nil {
+			if err := releaseLogData(data, refs); err != nil {
+				return fmt.Errorf("receipt %x: %v", hash, err)
+			}
+		}
+		if err := batch.Delete(receiptKey(hash)); err != nil {
+			return err
+		}
+	}
+	return batch.Delete(blockReceiptsKey(height))
+}
@@ -0,0 +1,104 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+)
+
+func TestPruneReceipts(t *testing.T) {
+	conf := viper.New()
+	conf.Set("receipts_retention", 2)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	var hashes []common.Hash
+	for h := uint64(1); h <= 5; h++ {
+		raw := signTestTx(t, key, etypes.NewTransaction(h-1, common.HexToAddress("0x01"), big.NewInt(0), testGas, big.NewInt(0), nil))
+		execTestBlock(t, app, int64(h), raw)
+		hashes = append(hashes, txHash(raw))
+	}
+
+	// blocks 1 to 3 are past the retention at height 5
+	for i, hash := range hashes {
+		height := uint64(i + 1)
+		_, receiptErr := app.stateDb.Get(receiptKey(hash))
+		_, indexErr := app.stateDb.Get(blockReceiptsKey(height))
+		if pruned := height <= 3; pruned != (receiptErr != nil) || pruned != (indexErr != nil) {
+			t.Fatalf("block %d: expected pruned %v, got receipt error %v, index error %v", height, pruned, receiptErr, indexErr)
+		}
+	}
+
+	res := app.Query(append([]byte{rtypes.QueryType_Receipt}, hashes[0].Bytes()...))
+	if res.IsOK() || !strings.Contains(res.Log, "pruned") {
+		t.Fatalf("expected a pruned receipt error, got %q", res.Log)
+	}
+	if res := app.Query(append([]byte{rtypes.QueryType_Receipt}, hashes[4].Bytes()...)); res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	if err := app.StreamReceipts(ioutil.Discard, 1, 5); err != ErrReceiptsPruned {
+		t.Fatalf("expected %v streaming pruned blocks, got %v", ErrReceiptsPruned, err)
+	}
+	if err := app.StreamReceipts(ioutil.Discard, 4, 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.MirrorBlockRecords(3); err != ErrReceiptsPruned {
+		t.Fatalf("expected %v mirroring a pruned block, got %v", ErrReceiptsPruned, err)
+	}
+}
+
+func TestGetLogsPruned(t *testing.T) {
+	conf := viper.New()
+	conf.Set("receipts_retention", 2)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	logger := crypto.CreateAddress(addr, 0)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), logContractCode)))
+	for h := uint64(2); h <= 5; h++ {
+		to := logger
+		if h == 4 {
+			// a block with receipts but no logs
+			to = common.HexToAddress("0x01")
+		}
+		execTestBlock(t, app, int64(h), signTestTx(t, key, etypes.NewTransaction(h-1, to, big.NewInt(0), testGas, big.NewInt(0), nil)))
+	}
+
+	// blocks 1 to 3 are past the retention at height 5
+	for _, from := range []uint64{1, 3} {
+		_, err := app.GetLogs(&LogFilter{FromBlock: from, ToBlock: 5})
+		if pruned, ok := err.(*LogsPrunedError); !ok || pruned.FromBlock != from || pruned.PrunedTo != 3 {
+			t.Fatalf("expected the logs from block %d to be pruned up to 3, got %v", from, err)
+		}
+	}
+	logs, err := app.GetLogs(&LogFilter{FromBlock: 4, ToBlock: 5})
+	if err != nil || len(logs) != 1 || logs[0].BlockNumber != 5 {
+		t.Fatalf("unexpected logs %+v %v", logs, err)
+	}
+	if logs, err := app.GetLogs(&LogFilter{FromBlock: 4, ToBlock: 4}); err != nil || len(logs) != 0 {
+		t.Fatalf("expected no logs in block 4, got %+v %v", logs, err)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/metrics"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+)
+
+// Stored receipts come in two formats, told apart by the first byte:
+//
+//	legacy: rlp(ReceiptForStorage), always an rlp list, so the first byte is >= 0xc0
+//	v1:     receiptVersion1 || rlp(receiptEnvelope)
+const receiptVersion1 byte = 0x01
+
+const (
+	receiptClassSuccess byte = iota
+	receiptClassFailed
+	receiptClassOutOfGas
+	receiptClassReverted
+)
+
+const receiptsMigrationInterval = time.Second
+
+var (
+	ReceiptsMigrationKey = []byte("migration-receipts-cursor")
+	// BlockReceiptsPrefix indexes the tx hashes of a block's receipts, in block order, by height
+	BlockReceiptsPrefix = []byte("blockreceipts-")
+
+	receiptsMigratedCounter   = metrics.NewRegisteredCounter("evm/receipts/migration/migrated", nil)
+	receiptsMigrationGauge    = metrics.NewRegisteredGauge("evm/receipts/migration/done", nil)
+	receiptsMigrationErrMeter = metrics.NewRegisteredMeter("evm/receipts/migration/errors", nil)
+)
+
+// receiptEnvelope enriches the stored receipt with its status class, fee and
+// position in the chain. Fee, GasPrice and block refs are zero for receipts
+// migrated from the legacy format, which didn't record them.
+type receiptEnvelope struct {
+	Receipt   *etypes.ReceiptForStorage
+	Class     byte
+	GasPrice  *big.Int
+	Fee       *big.Int
+	Height    uint64
+	BlockHash common.Hash
+	TxIndex   uint64
+	// indexes of the logs whose data is stored apart, see LogDataPrefix. As a
+	// tail, it's left out of the encoding when empty, like in envelopes
+	// written before it existed.
+	LogRefs []uint64 `rlp:"tail"`
+}
+
+// newReceiptEnvelope wraps the receipt of a tx with gas limit gas, 0 when the tx
+// isn't known.
+func newReceiptEnvelope(receipt *etypes.Receipt, gas uint64, gasPrice *big.Int, height uint64, blockHash common.Hash, txIndex int) *receiptEnvelope {
+	env := &receiptEnvelope{
+		Receipt:   (*etypes.ReceiptForStorage)(receipt),
+		Class:     receiptClass(receipt, gas),
+		GasPrice:  new(big.Int),
+		Fee:       new(big.Int),
+		Height:    height,
+		BlockHash: blockHash,
+		TxIndex:   uint64(txIndex),
+	}
+	if gasPrice != nil {
+		env.GasPrice.Set(gasPrice)
+		env.Fee.Mul(gasPrice, new(big.Int).SetUint64(receipt.GasUsed))
+	}
+	return env
+}
+
+// receiptClass classes the status of a receipt. Failures of a tx whose gas limit
+// is known are classed by the gas they used, see rtypes.TxResult_Reverted.
+func receiptClass(receipt *etypes.Receipt, gas uint64) byte {
+	switch {
+	case receipt.Status == etypes.ReceiptStatusSuccessful:
+		return receiptClassSuccess
+	case receipt.Status == etypes.ReceiptStatusFailedEVMOutOfGas:
+		return receiptClassOutOfGas
+	case gas == 0:
+		return receiptClassFailed
+	case receipt.GasUsed < gas:
+		return receiptClassReverted
+	default:
+		return receiptClassOutOfGas
+	}
+}
+
+// txResultStatus is the rtypes.TxResultStatus of a receipt class
+func txResultStatus(class byte) rtypes.TxResultStatus {
+	switch class {
+	case receiptClassSuccess:
+		return rtypes.TxResult_Success
+	case receiptClassReverted:
+		return rtypes.TxResult_Reverted
+	case receiptClassOutOfGas:
+		return rtypes.TxResult_OutOfGas
+	}
+	return rtypes.TxResult_Failed
+}
+
+func receiptKey(hash common.Hash) []byte {
+	return append(append([]byte{}, ReceiptsPrefix...), hash.Bytes()...)
+}
+
+func blockReceiptsKey(height uint64) []byte {
+	key := make([]byte, len(BlockReceiptsPrefix)+8)
+	copy(key, BlockReceiptsPrefix)
+	binary.BigEndian.PutUint64(key[len(BlockReceiptsPrefix):], height)
+	return key
+}
+
+func encodeReceiptEnvelope(env *receiptEnvelope) ([]byte, error) {
+	data, err := rlp.EncodeToBytes(env)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{receiptVersion1}, data...), nil
+}
+
+// decodeStoredReceipt reads a receipt in either format.
+func decodeStoredReceipt(data []byte) (*receiptEnvelope, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty receipt")
+	}
+	switch {
+	case data[0] == receiptVersion1:
+		env := &receiptEnvelope{}
+		if err := rlp.DecodeBytes(data[1:], env); err != nil {
+			return nil, err
+		}
+		return env, nil
+	case data[0] >= 0xc0:
+		receipt := &etypes.ReceiptForStorage{}
+		if err := rlp.DecodeBytes(data, receipt); err != nil {
+			return nil, err
+		}
+		return newReceiptEnvelope((*etypes.Receipt)(receipt), 0, nil, 0, common.Hash{}, 0), nil
+	default:
+		return nil, fmt.Errorf("unknown receipt version %d", data[0])
+	}
+}
+
+type receiptsMigrationCursor struct {
+	Last []byte // last migrated key
+	Done bool
+}
+
+// receiptsMigrator rewrites legacy receipts into the v1 format in the background,
+// batchSize keys at a time. Progress is saved as a cursor after each batch, so an
+// interrupted migration resumes where it stopped; rewriting is idempotent, v1
+// entries are skipped.
+type receiptsMigrator struct {
+	db        ethdb.Database
+	batchSize int
+	paused    int32
+
+	quit     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func newReceiptsMigrator(db ethdb.Database, batchSize int, paused bool) *receiptsMigrator {
+	m := &receiptsMigrator{
+		db:        db,
+		batchSize: batchSize,
+		quit:      make(chan struct{}),
+	}
+	m.SetPaused(paused)
+	return m
+}
+
+func (m *receiptsMigrator) Start() {
+	if _, ok := m.db.(prefixIteratee); !ok || m.batchSize <= 0 {
+		return
+	}
+	m.wg.Add(1)
+	go m.loop()
+}
+
+// Stop waits for the running batch, it must be called before closing db.
+func (m *receiptsMigrator) Stop() {
+	m.stopOnce.Do(func() { close(m.quit) })
+	m.wg.Wait()
+}
+
+func (m *receiptsMigrator) SetPaused(paused bool) {
+	if paused {
+		atomic.StoreInt32(&m.paused, 1)
+	} else {
+		atomic.StoreInt32(&m.paused, 0)
+	}
+}
+
+func (m *receiptsMigrator) loop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(receiptsMigrationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.quit:
+			return
+		case <-ticker.C:
+			if atomic.LoadInt32(&m.paused) == 1 {
+				continue
+			}
+			done, err := m.migrateBatch()
+			if err != nil {
+				receiptsMigrationErrMeter.Mark(1)
+				log.Warn("migrate receipts", zap.Error(err))
+				continue
+			}
+			if done {
+				log.Info("receipts migration done")
+				return
+			}
+		}
+	}
+}
+
+func (m *receiptsMigrator) loadCursor() (*receiptsMigrationCursor, error) {
+	cursor := &receiptsMigrationCursor{}
+	data, err := m.db.Get(ReceiptsMigrationKey)
+	if err != nil || len(data) == 0 {
+		// not started yet
+		return cursor, nil
+	}
+	if err := rlp.DecodeBytes(data, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// migrateBatch migrates the next batch of receipts, reporting whether all are done.
+func (m *receiptsMigrator) migrateBatch() (bool, error) {
+	cursor, err := m.loadCursor()
+	if err != nil {
+		return false, err
+	}
+	if cursor.Done {
+		receiptsMigrationGauge.Update(1)
+		return true, nil
+	}
+
+	it := m.db.(prefixIteratee).NewIteratorWithPrefix(ReceiptsPrefix)
+	defer it.Release()
+	var ok bool
+	if len(cursor.Last) == 0 {
+		ok = it.First()
+	} else if ok = it.Seek(cursor.Last); ok && bytes.Equal(it.Key(), cursor.Last) {
+		ok = it.Next()
+	}
+
+	batch := m.db.NewBatch()
+	migrated := 0
+	for n := 0; ok && n < m.batchSize; ok = it.Next() {
+		n++
+		key := append([]byte{}, it.Key()...)
+		cursor.Last = key
+		value := it.Value()
+		if len(key) != len(ReceiptsPrefix)+common.HashLength || len(value) == 0 || value[0] == receiptVersion1 {
+			continue
+		}
+		env, err := decodeStoredReceipt(value)
+		if err != nil {
+			return false, fmt.Errorf("decode receipt %x: %v", key, err)
+		}
+		data, err := encodeReceiptEnvelope(env)
+		if err != nil {
+			return false, err
+		}
+		if err := batch.Put(key, data); err != nil {
+			return false, err
+		}
+		migrated++
+	}
+	if err := it.Error(); err != nil {
+		return false, err
+	}
+
+	cursor.Done = !ok
+	data, err := rlp.EncodeToBytes(cursor)
+	if err != nil {
+		return false, err
+	}
+	if err := batch.Put(ReceiptsMigrationKey, data); err != nil {
+		return false, err
+	}
+	if err := batch.Write(); err != nil {
+		return false, err
+	}
+	receiptsMigratedCounter.Inc(int64(migrated))
+	if cursor.Done {
+		receiptsMigrationGauge.Update(1)
+	}
+	return cursor.Done, nil
+}
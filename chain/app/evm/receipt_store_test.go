@@ -0,0 +1,214 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func queryTestReceipt(t *testing.T, app *EVMApp, hash common.Hash) []byte {
+	res := app.Query(append([]byte{rtypes.QueryType_Receipt}, hash.Bytes()...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	return res.Data
+}
+
+func TestReceiptsMigration(t *testing.T) {
+	conf := viper.New()
+	conf.Set("receipts_migration_paused", true)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	var hashes []common.Hash
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		raw := signTestTx(t, key, etypes.NewTransaction(nonce, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+		execTestBlock(t, app, int64(nonce+1), raw)
+		hashes = append(hashes, txHash(raw))
+	}
+	// receipts written before the envelope format
+	for i := byte(1); i <= 4; i++ {
+		receipt := etypes.NewReceipt(nil, i%2 == 0, uint64(i)*21000)
+		receipt.TxHash = common.BytesToHash([]byte{0xee, i})
+		receipt.GasUsed = uint64(i) * 21000
+		legacy, err := rlp.EncodeToBytes((*etypes.ReceiptForStorage)(receipt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := app.stateDb.Put(receiptKey(receipt.TxHash), legacy); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, receipt.TxHash)
+	}
+
+	expected := make(map[common.Hash][]byte)
+	legacyCount := 0
+	for _, hash := range hashes {
+		data, err := app.stateDb.Get(receiptKey(hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		env, err := decodeStoredReceipt(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data[0] != receiptVersion1 {
+			legacyCount++
+		}
+		expected[hash], _ = rlp.EncodeToBytes(env.Receipt)
+		if got := queryTestReceipt(t, app, hash); !bytes.Equal(got, expected[hash]) {
+			t.Fatalf("receipt %x differs before migration", hash)
+		}
+	}
+	if legacyCount != 4 {
+		t.Fatalf("expected 4 legacy receipts in fixture, got %d", legacyCount)
+	}
+
+	countLegacy := func() int {
+		n := 0
+		for _, hash := range hashes {
+			data, _ := app.stateDb.Get(receiptKey(hash))
+			if data[0] != receiptVersion1 {
+				n++
+			}
+		}
+		return n
+	}
+
+	migrator := newReceiptsMigrator(app.stateDb, 3, false)
+	if done, err := migrator.migrateBatch(); err != nil || done {
+		t.Fatalf("expected partial migration, done %v err %v", done, err)
+	}
+	if n := countLegacy(); n == 0 || n == 4 {
+		t.Fatalf("expected part of legacy receipts migrated, %d left", n)
+	}
+	for _, hash := range hashes {
+		if got := queryTestReceipt(t, app, hash); !bytes.Equal(got, expected[hash]) {
+			t.Fatalf("receipt %x differs during migration", hash)
+		}
+	}
+
+	// a new migrator resumes from the saved cursor, as after a crash
+	migrator = newReceiptsMigrator(app.stateDb, 3, false)
+	for i := 0; ; i++ {
+		done, err := migrator.migrateBatch()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done {
+			break
+		}
+		if i > len(hashes) {
+			t.Fatal("migration never finished")
+		}
+	}
+	if n := countLegacy(); n != 0 {
+		t.Fatalf("%d legacy receipts left", n)
+	}
+	for _, hash := range hashes {
+		if got := queryTestReceipt(t, app, hash); !bytes.Equal(got, expected[hash]) {
+			t.Fatalf("receipt %x differs after migration", hash)
+		}
+	}
+
+	data, _ := app.stateDb.Get(receiptKey(hashes[0]))
+	env, err := decodeStoredReceipt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Height != 1 || env.Class != receiptClassSuccess || env.Fee.Sign() != 0 {
+		t.Fatalf("unexpected envelope %+v", env)
+	}
+	data, _ = app.stateDb.Get(receiptKey(common.BytesToHash([]byte{0xee, 2})))
+	if env, err = decodeStoredReceipt(data); err != nil {
+		t.Fatal(err)
+	}
+	if env.Class != receiptClassFailed || env.Receipt.GasUsed != 42000 {
+		t.Fatalf("unexpected migrated envelope %+v", env)
+	}
+}
+
+func queryTestTxResult(t *testing.T, app *EVMApp, hash common.Hash) rtypes.TxResult {
+	res := app.Query(append([]byte{rtypes.QueryType_TxResult}, hash.Bytes()...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var result rtypes.TxResult
+	if err := rlp.DecodeBytes(res.Data, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestQueryTxResult(t *testing.T) {
+	// REVERT needs byzantium
+	conf := viper.New()
+	conf.Set("fork_schedule", map[string]string{"1": "byzantium"})
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	// runtime codes reverting at once and looping until out of gas
+	revertCode := common.FromHex("6005600c60003960056000f360006000fd")
+	loopCode := common.FromHex("6004600c60003960046000f35b600056")
+	execTestBlock(t, app, 1,
+		signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), revertCode)),
+		signTestTx(t, key, etypes.NewContractCreation(1, big.NewInt(0), testGas, big.NewInt(0), loopCode)),
+	)
+	call := func(nonce uint64, to common.Address) []byte {
+		return signTestTx(t, key, etypes.NewTransaction(nonce, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+	}
+	success := call(2, common.HexToAddress("0x1234"))
+	reverted := call(3, crypto.CreateAddress(addr, 0))
+	outOfGas := call(4, crypto.CreateAddress(addr, 1))
+	execTestBlock(t, app, 2, success, reverted, outOfGas)
+
+	for _, c := range []struct {
+		raw    []byte
+		status rtypes.TxResultStatus
+	}{
+		{success, rtypes.TxResult_Success},
+		{reverted, rtypes.TxResult_Reverted},
+		{outOfGas, rtypes.TxResult_OutOfGas},
+	} {
+		result := queryTestTxResult(t, app, txHash(c.raw))
+		receipt, err := app.GetReceipt(txHash(c.raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != c.status || result.Height != 2 || result.GasUsed != receipt.GasUsed {
+			t.Fatalf("expected status %d at height 2 using %d gas, got %+v", c.status, receipt.GasUsed, result)
+		}
+	}
+	if result := queryTestTxResult(t, app, txHash(outOfGas)); result.GasUsed != testGas {
+		t.Fatalf("expected the out of gas tx to use all its gas, got %d", result.GasUsed)
+	}
+	if res := app.Query(append([]byte{rtypes.QueryType_TxResult}, common.HexToHash("0x01").Bytes()...)); res.IsOK() {
+		t.Fatal("expected an unknown tx not to be found")
+	}
+	if res := app.Query([]byte{rtypes.QueryType_TxResult, 0x01}); res.IsOK() {
+		t.Fatal("expected an invalid hash to be rejected")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// A receipts stream is one version byte followed by records, each a uvarint
+// length and rlp(StreamedReceipt).
+const (
+	ReceiptStreamVersion byte = 1
+
+	maxStreamedReceiptSize = 32 * 1024 * 1024
+)
+
+// StreamedReceipt is one record of a receipts stream
+type StreamedReceipt struct {
+	Height  uint64
+	TxIndex uint64
+	Receipt *etypes.ReceiptForStorage
+}
+
+// StreamReceipts writes the receipts of blocks in [fromHeight, toHeight] to w, in
+// block and tx order, one block in memory at a time. Blocks committed before the
+// per-block receipts index existed have no entry in it and are skipped. Ranges
+// starting at a pruned block fail with ErrReceiptsPruned.
+func (app *EVMApp) StreamReceipts(w io.Writer, fromHeight, toHeight uint64) error {
+	if fromHeight > toHeight {
+		return fmt.Errorf("invalid height range [%d, %d]", fromHeight, toHeight)
+	}
+	if err := app.checkReceiptsPruned(fromHeight); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := bw.WriteByte(ReceiptStreamVersion); err != nil {
+		return err
+	}
+
+	// toHeight may be the max uint64, so the loop ends by comparison instead of by overflow
+	for height := fromHeight; ; height++ {
+		if err := app.streamBlockReceipts(bw, height); err != nil {
+			return err
+		}
+		if height == toHeight {
+			break
+		}
+	}
+	return bw.Flush()
+}
+
+func (app *EVMApp) streamBlockReceipts(w io.Writer, height uint64) error {
+	index, err := app.stateDb.Get(blockReceiptsKey(height))
+	if err != nil || len(index) == 0 {
+		// no receipts, or not indexed
+		return nil
+	}
+	var txHashes []common.Hash
+	if err := rlp.DecodeBytes(index, &txHashes); err != nil {
+		return fmt.Errorf("decode receipts index of block %d: %v", height, err)
+	}
+
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, hash := range txHashes {
+		data, err := app.stateDb.Get(receiptKey(hash))
+		if err != nil {
+			return fmt.Errorf("get receipt %x: %v", hash, err)
+		}
+		env, err := app.decodeReceipt(data)
+		if err != nil {
+			return fmt.Errorf("decode receipt %x: %v", hash, err)
+		}
+		record, err := rlp.EncodeToBytes(&StreamedReceipt{Height: height, TxIndex: env.TxIndex, Receipt: env.Receipt})
+		if err != nil {
+			return err
+		}
+		n := binary.PutUvarint(lenBuf, uint64(len(record)))
+		if _, err := w.Write(lenBuf[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReceiptStreamReader reads back a stream written by StreamReceipts
+type ReceiptStreamReader struct {
+	r       *bufio.Reader
+	started bool
+}
+
+func NewReceiptStreamReader(r io.Reader) *ReceiptStreamReader {
+	return &ReceiptStreamReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next receipt, io.EOF after the last one.
+func (sr *ReceiptStreamReader) Next() (*StreamedReceipt, error) {
+	if !sr.started {
+		version, err := sr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if version != ReceiptStreamVersion {
+			return nil, fmt.Errorf("unsupported receipts stream version %d", version)
+		}
+		sr.started = true
+	}
+
+	size, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxStreamedReceiptSize {
+		return nil, fmt.Errorf("receipt record too large: %d", size)
+	}
+	record := make([]byte, size)
+	if _, err := io.ReadFull(sr.r, record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	receipt := &StreamedReceipt{}
+	if err := rlp.DecodeBytes(record, receipt); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
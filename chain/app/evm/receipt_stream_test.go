@@ -0,0 +1,105 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"testing"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func readTestReceiptStream(t *testing.T, data []byte) []*StreamedReceipt {
+	var receipts []*StreamedReceipt
+	reader := NewReceiptStreamReader(bytes.NewReader(data))
+	for {
+		receipt, err := reader.Next()
+		if err == io.EOF {
+			return receipts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		receipts = append(receipts, receipt)
+	}
+}
+
+func TestStreamReceipts(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	keyA, _ := testKey(t, testKeyA)
+	keyB, _ := testKey(t, testKeyB)
+	blocks := [][][]byte{
+		{signTestTx(t, keyA, etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))},
+		{
+			signTestTx(t, keyB, etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil)),
+			signTestTx(t, keyA, etypes.NewContractCreation(1, big.NewInt(0), testGas, big.NewInt(0), logContractCode)),
+		},
+		{},
+		{signTestTx(t, keyB, etypes.NewTransaction(1, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))},
+	}
+	type expectedReceipt struct {
+		height uint64
+		raw    []byte
+	}
+	var expected []expectedReceipt
+	for i, txs := range blocks {
+		height := int64(i + 1)
+		execTestBlock(t, app, height, txs...)
+		for _, raw := range txs {
+			expected = append(expected, expectedReceipt{uint64(height), raw})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := app.StreamReceipts(&buf, 1, 4); err != nil {
+		t.Fatal(err)
+	}
+	receipts := readTestReceiptStream(t, buf.Bytes())
+	if len(receipts) != len(expected) {
+		t.Fatalf("expected %d receipts, got %d", len(expected), len(receipts))
+	}
+	for i, receipt := range receipts {
+		if receipt.Height != expected[i].height || receipt.Receipt.TxHash != txHash(expected[i].raw) {
+			t.Fatalf("receipt %d: got tx %x at %d", i, receipt.Receipt.TxHash, receipt.Height)
+		}
+		stored, _ := rlp.EncodeToBytes(receipt.Receipt)
+		if !bytes.Equal(stored, queryTestReceipt(t, app, receipt.Receipt.TxHash)) {
+			t.Fatalf("receipt %d differs from the stored one", i)
+		}
+	}
+	if receipts[2].TxIndex != 1 || len(receipts[2].Receipt.Logs) != 0 || receipts[2].Receipt.ContractAddress == (common.Address{}) {
+		t.Fatalf("unexpected contract creation receipt %+v", receipts[2])
+	}
+
+	buf.Reset()
+	if err := app.StreamReceipts(&buf, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if receipts := readTestReceiptStream(t, buf.Bytes()); len(receipts) != 2 || receipts[0].Height != 2 || receipts[1].Height != 2 {
+		t.Fatalf("unexpected receipts of blocks 2-3: %+v", receipts)
+	}
+
+	if err := app.StreamReceipts(&buf, 3, 2); err == nil {
+		t.Fatal("expected invalid range to be rejected")
+	}
+	if _, err := NewReceiptStreamReader(bytes.NewReader([]byte{ReceiptStreamVersion + 1})).Next(); err == nil || err == io.EOF {
+		t.Fatal("expected unknown stream version to be rejected")
+	}
+}
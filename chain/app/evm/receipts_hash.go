@@ -0,0 +1,105 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-merkle"
+)
+
+// ReceiptsHashKey stores the receipts hash algorithm the chain was initialized
+// with, one byte. Datadirs initialized before it was stored use the simple hash.
+var ReceiptsHashKey = []byte("receipts-hash-algo")
+
+var receiptsHashAlgos = map[string]params.ReceiptsHashAlgo{
+	"simple":     params.ReceiptsHashSimple,
+	"derive-sha": params.ReceiptsHashDeriveSha,
+}
+
+// withReceiptsHash returns config with the receipts hash algorithm of name set
+func withReceiptsHash(config *params.ChainConfig, name string) (*params.ChainConfig, error) {
+	algo, ok := receiptsHashAlgos[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid receipts_hash %q", name)
+	}
+	if algo == params.ReceiptsHashSimple {
+		return config, nil
+	}
+	withAlgo := *config
+	withAlgo.ReceiptsHash = algo
+	return &withAlgo, nil
+}
+
+// receiptsHash returns the hash of the receipts of a block with algo, nil when
+// there are none.
+func receiptsHash(algo params.ReceiptsHashAlgo, receipts etypes.Receipts) ([]byte, error) {
+	if len(receipts) == 0 {
+		return nil, nil
+	}
+	switch algo {
+	case params.ReceiptsHashSimple:
+		encoded := make([][]byte, 0, len(receipts))
+		for _, receipt := range receipts {
+			data, err := rlp.EncodeToBytes((*etypes.ReceiptForStorage)(receipt))
+			if err != nil {
+				return nil, err
+			}
+			encoded = append(encoded, data)
+		}
+		return merkle.SimpleHashFromHashes(encoded), nil
+	case params.ReceiptsHashDeriveSha:
+		return etypes.DeriveSha(receipts).Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown receipts hash algorithm %d", algo)
+}
+
+// saveReceiptsHashAlgo stores the receipts hash algorithm of a chain being
+// initialized.
+func (app *EVMApp) saveReceiptsHashAlgo() error {
+	return app.stateDb.Put(ReceiptsHashKey, []byte{byte(app.chainConfig.ReceiptsHash)})
+}
+
+// checkReceiptsHashAlgo refuses a receipts_hash other than the one the chain
+// was initialized with, which would fork the node off at the first receipt.
+func (app *EVMApp) checkReceiptsHashAlgo() error {
+	stored := params.ReceiptsHashSimple
+	if value, err := app.stateDb.Get(ReceiptsHashKey); err == nil && len(value) == 1 {
+		stored = params.ReceiptsHashAlgo(value[0])
+	} else {
+		if err := app.stateDb.Put(ReceiptsHashKey, []byte{byte(stored)}); err != nil {
+			return err
+		}
+		log.Info("backfilled the receipts hash algorithm", zap.Uint8("algo", uint8(stored)))
+	}
+	if stored != app.chainConfig.ReceiptsHash {
+		return fmt.Errorf("receipts_hash %q, the chain uses %q", receiptsHashName(app.chainConfig.ReceiptsHash), receiptsHashName(stored))
+	}
+	return nil
+}
+
+func receiptsHashName(algo params.ReceiptsHashAlgo) string {
+	for name, a := range receiptsHashAlgos {
+		if a == algo {
+			return name
+		}
+	}
+	return fmt.Sprintf("unknown(%d)", algo)
+}
@@ -0,0 +1,115 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/params"
+)
+
+func TestReceiptsHashAlgos(t *testing.T) {
+	receipts := etypes.Receipts{
+		{Status: etypes.ReceiptStatusSuccessful, CumulativeGasUsed: 21000, TxHash: common.HexToHash("0x01"), GasUsed: 21000},
+		{Status: etypes.ReceiptStatusFailed, CumulativeGasUsed: 42000, TxHash: common.HexToHash("0x02"), GasUsed: 21000,
+			Logs: []*etypes.Log{{Address: common.HexToAddress("0x1234"), Topics: []common.Hash{common.HexToHash("0x03")}, Data: []byte{1}}}},
+	}
+	for i := range receipts {
+		receipts[i].Bloom = etypes.CreateBloom(receipts[i : i+1])
+	}
+
+	hashes := make(map[params.ReceiptsHashAlgo][]byte)
+	for _, algo := range receiptsHashAlgos {
+		hash, err := receiptsHash(algo, receipts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, err := receiptsHash(algo, receipts); err != nil || !bytes.Equal(hash, again) {
+			t.Fatalf("algorithm %d: expected a deterministic hash %x, got %x %v", algo, hash, again, err)
+		}
+		if empty, err := receiptsHash(algo, nil); err != nil || empty != nil {
+			t.Fatalf("algorithm %d: expected no hash of no receipts, got %x %v", algo, empty, err)
+		}
+		hashes[algo] = hash
+	}
+	if bytes.Equal(hashes[params.ReceiptsHashSimple], hashes[params.ReceiptsHashDeriveSha]) {
+		t.Fatalf("expected distinct hashes, got %x", hashes[params.ReceiptsHashSimple])
+	}
+	if expected := etypes.DeriveSha(receipts); !bytes.Equal(hashes[params.ReceiptsHashDeriveSha], expected.Bytes()) {
+		t.Fatalf("expected the receipts trie root %x, got %x", expected, hashes[params.ReceiptsHashDeriveSha])
+	}
+	if _, err := receiptsHash(params.ReceiptsHashAlgo(9), receipts); err == nil {
+		t.Fatal("expected an unknown algorithm to fail")
+	}
+}
+
+func TestReceiptsHashConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := viper.New()
+	conf.Set("receipts_hash", "derive-sha")
+	app, err := startTestApp(dir, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := testKey(t, testKeyA)
+	tx := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), nil))
+	res := commitTestBlock(t, app, makeTestBlock(1, tx))
+	receipt, err := app.GetReceipt(txHash(tx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := etypes.DeriveSha(etypes.Receipts{receipt}); !bytes.Equal(res.ReceiptsHash, expected.Bytes()) {
+		t.Fatalf("expected the receipts trie root %x, got %x", expected, res.ReceiptsHash)
+	}
+
+	// the algorithm is part of the chain config, so of the genesis hash
+	genesis := core.DefaultGenesis()
+	simple := *app.chainConfig
+	simple.ReceiptsHash = params.ReceiptsHashSimple
+	if hash, err := GenesisHash(&genesis, &simple); err != nil || hash == app.genesisHash {
+		t.Fatalf("expected the algorithm to change the genesis hash, got %x %v", hash, err)
+	}
+	app.Stop()
+
+	// the chain keeps the algorithm it was initialized with
+	if _, err := startTestApp(dir, viper.New()); err == nil {
+		t.Fatal("expected another receipts hash algorithm to refuse to start")
+	}
+	conf = viper.New()
+	conf.Set("receipts_hash", "derive-sha")
+	if app, err = startTestApp(dir, conf); err != nil {
+		t.Fatal(err)
+	}
+	app.Stop()
+
+	conf = viper.New()
+	conf.Set("receipts_hash", "sha3")
+	if _, err := NewEVMApp(conf); err == nil {
+		t.Fatal("expected an invalid algorithm to be rejected")
+	}
+}
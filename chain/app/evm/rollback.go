@@ -0,0 +1,171 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+)
+
+// RollbackKey stages a rollback, as the 8 bytes big endian height it goes back
+// to followed by the app hash committed there. It's written before anything is
+// changed and deleted once the rollback is done, so a rollback interrupted by a
+// crash is finished on the next start.
+var RollbackKey = []byte("rollback-target")
+
+var errBlockInFlight = errors.New("a block is being executed or committed")
+
+// rollbackIndexes are the keys of the indexes of the block at a height, dropped
+// by a rollback going back below it
+var rollbackIndexes = []func(height uint64) []byte{
+	txRootKey,
+	lightHeaderKey,
+	blockSummaryKey,
+	touchedAccountsKey,
+	appMessagesKey,
+}
+
+// RollbackToHeight rewinds the app to the committed block at height, for the
+// blocks above it to be executed again, eg. after a bug corrupted the indexes.
+// It's an operator operation, see the rollback command, meant for a stopped
+// node: it refuses to run while a block is executed or committed, and the
+// consensus of a running node is left unaware of it.
+//
+// The state tries are left intact, so the blocks executed again commit the same
+// app hashes. The receipts and the indexes keyed by height of the blocks above
+// height are deleted, the config epochs starting above it dropped and the pool
+// flushed. The indexes keyed by address or tx hash, the destroyed contracts,
+// contract creators and tx statuses, keep their entries until the blocks are
+// committed again.
+func (app *EVMApp) RollbackToHeight(height uint64) error {
+	app.blockMtx.Lock()
+	defer app.blockMtx.Unlock()
+	if atomic.LoadInt32(&app.blockInFlight) != 0 {
+		return errBlockInFlight
+	}
+	last, err := app.loadLastBlock()
+	if err != nil {
+		return err
+	}
+	if height == 0 || height >= uint64(last.Height) {
+		return fmt.Errorf("can't roll back to height %d, last block %d", height, last.Height)
+	}
+	root, err := app.rollbackRoot(height)
+	if err != nil {
+		return err
+	}
+	value := make([]byte, 8+common.HashLength)
+	binary.BigEndian.PutUint64(value, height)
+	copy(value[8:], root[:])
+	if err := app.stateDb.Put(RollbackKey, value); err != nil {
+		return err
+	}
+	log.Warn("rolling back", zap.Int64("from", last.Height), zap.Uint64("to", height), zap.String("appHash", root.Hex()))
+	return app.finishRollback(uint64(last.Height), height, root)
+}
+
+// rollbackRoot returns the app hash committed at height, checking the state is
+// still there to go back to.
+func (app *EVMApp) rollbackRoot(height uint64) (common.Hash, error) {
+	headers, err := app.LightHeaders(height, height)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	root := headers[0].NewAppHash
+	if _, err := estate.New(root, estate.NewDatabase(app.stateDb)); err != nil {
+		return common.Hash{}, fmt.Errorf("no state of height %d: %v", height, err)
+	}
+	return root, nil
+}
+
+// resumeRollback finishes the rollback a crash interrupted, on start
+func (app *EVMApp) resumeRollback() error {
+	value, err := app.stateDb.Get(RollbackKey)
+	if err != nil || len(value) != 8+common.HashLength {
+		return nil
+	}
+	last, err := app.loadLastBlock()
+	if err != nil {
+		return err
+	}
+	height, root := binary.BigEndian.Uint64(value), common.BytesToHash(value[8:])
+	log.Warn("finishing an interrupted rollback", zap.Int64("from", last.Height), zap.Uint64("to", height))
+	return app.finishRollback(uint64(last.Height), height, root)
+}
+
+// finishRollback rolls the blocks in (height, last] back. Each step may run
+// again, so the last block is only moved to height once the indexes are gone.
+func (app *EVMApp) finishRollback(last, height uint64, root common.Hash) error {
+	batch := app.stateDb.NewBatch()
+	refs := make(logDataRefs)
+	for h := height + 1; h <= last; h++ {
+		if err := app.deleteBlockReceipts(batch, h, refs); err != nil {
+			return err
+		}
+		for _, key := range rollbackIndexes {
+			if err := batch.Delete(key(h)); err != nil {
+				return err
+			}
+		}
+	}
+	if atomic.LoadUint64(&app.receiptsPruned) > height {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, height)
+		if err := batch.Put(ReceiptsPrunedKey, value); err != nil {
+			return err
+		}
+	}
+	if err := app.writeLogDataRefs(batch, refs); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if atomic.LoadUint64(&app.receiptsPruned) > height {
+		atomic.StoreUint64(&app.receiptsPruned, height)
+	}
+	if err := app.truncateConfigEpochs(height); err != nil {
+		return err
+	}
+	if err := app.saveLastBlock(LastBlockInfo{Height: int64(height), AppHash: root.Bytes()}); err != nil {
+		return err
+	}
+
+	// a started app moves its latest state back too
+	if app.state != nil {
+		state, err := estate.New(root, estate.NewDatabase(app.stateDb))
+		if err != nil {
+			return err
+		}
+		app.stateMtx.Lock()
+		app.state, app.committedHeader, app.committedRoot = state, app.startHeader(int64(height)), root
+		app.stateMtx.Unlock()
+		atomic.StoreInt64(&app.committedHeight, int64(height))
+		app.simulations.reset(height)
+		if err := app.loadContractCount(height, root); err != nil {
+			return err
+		}
+		app.pool.Flush()
+		app.pool.setHeight(int64(height))
+	}
+	return app.stateDb.Delete(RollbackKey)
+}
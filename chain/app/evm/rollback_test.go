@@ -0,0 +1,147 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// commitRollbackTestChain commits blocks 1 to n, each with a transfer, and
+// returns the blocks and the app hash committed at each height.
+func commitRollbackTestChain(t *testing.T, app *EVMApp, n int64) ([]*gtypes.Block, map[int64]common.Hash) {
+	key, _ := testKey(t, testKeyA)
+	blocks := make([]*gtypes.Block, n+1)
+	appHashes := make(map[int64]common.Hash)
+	for height := int64(1); height <= n; height++ {
+		to := common.BigToAddress(big.NewInt(height))
+		tx := signTestTx(t, key, etypes.NewTransaction(uint64(height-1), to, big.NewInt(0), testGas, big.NewInt(0), nil))
+		blocks[height] = makeTestBlock(height, tx)
+		commitTestBlock(t, app, blocks[height])
+		appHashes[height] = app.getLastAppHash()
+	}
+	return blocks, appHashes
+}
+
+func TestRollbackToHeight(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	blocks, appHashes := commitRollbackTestChain(t, app, 15)
+	rolledBack := txHash(blocks[10].Data.Txs[0])
+
+	key, from := testKey(t, testKeyA)
+	if err := app.pool.ReceiveTx(signTestTx(t, key, etypes.NewTransaction(15, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.RollbackToHeight(15); err == nil {
+		t.Fatal("expected a rollback to the last block to be refused")
+	}
+	if err := app.RollbackToHeight(5); err != nil {
+		t.Fatal(err)
+	}
+	if app.getLastAppHash() != appHashes[5] || app.committedRoot != appHashes[5] || app.committedHeader.Number.Int64() != 5 {
+		t.Fatalf("expected the app at height 5, root %x", appHashes[5])
+	}
+	if nonce := app.state.GetNonce(from); nonce != 5 {
+		t.Fatalf("expected the sender nonce of height 5, got %d", nonce)
+	}
+	if app.pool.Size() != 0 {
+		t.Fatal("expected the pool to be flushed")
+	}
+	if _, err := app.stateDb.Get(receiptKey(rolledBack)); err == nil {
+		t.Fatal("expected the receipts above height 5 to be deleted")
+	}
+	if _, err := app.LightHeaders(6, 6); err == nil {
+		t.Fatal("expected the light headers above height 5 to be deleted")
+	}
+	if summaries, err := app.RecentBlocks(1); err != nil || summaries[0].Height != 5 {
+		t.Fatalf("expected the block summary of height 5 to be the latest, got %v %v", summaries, err)
+	}
+
+	// executing the blocks again commits the same app hashes
+	for height := int64(6); height <= 15; height++ {
+		commitTestBlock(t, app, blocks[height])
+		if app.getLastAppHash() != appHashes[height] {
+			t.Fatalf("app hash of height %d differs after the rollback", height)
+		}
+	}
+	if _, err := app.stateDb.Get(receiptKey(rolledBack)); err != nil {
+		t.Fatal("expected the receipts to be saved again")
+	}
+}
+
+func TestRollbackBlockInFlight(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	commitRollbackTestChain(t, app, 2)
+
+	block := makeTestBlock(3)
+	if _, err := app.OnExecute(3, 0, block); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.RollbackToHeight(1); err != errBlockInFlight {
+		t.Fatalf("expected a rollback during a block to be refused, got %v", err)
+	}
+	if _, err := app.OnCommit(3, 0, block); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.RollbackToHeight(1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollbackResumedOnStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evm-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	app, err := startTestApp(dir, viper.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, appHashes := commitRollbackTestChain(t, app, 4)
+
+	// a crash right after staging the rollback
+	value := make([]byte, 8+common.HashLength)
+	binary.BigEndian.PutUint64(value, 2)
+	copy(value[8:], appHashes[2].Bytes())
+	if err := app.stateDb.Put(RollbackKey, value); err != nil {
+		t.Fatal(err)
+	}
+	app.Stop()
+
+	if app, err = startTestApp(dir, viper.New()); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Stop()
+	if app.getLastAppHash() != appHashes[2] || app.committedHeader.Number.Int64() != 2 {
+		t.Fatal("expected the interrupted rollback to be finished on start")
+	}
+	if has, _ := app.stateDb.Has(RollbackKey); has {
+		t.Fatal("expected the rollback to be unstaged")
+	}
+	if _, err := app.LightHeaders(3, 3); err == nil {
+		t.Fatal("expected the light headers above height 2 to be deleted")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+type senderEntry struct {
+	hash common.Hash
+	from common.Address
+	used time.Time
+}
+
+// senderCache keeps the recovered senders of txs by tx hash, so CheckTx and the
+// tx pool, which decode the same raw tx separately, recover its sender once.
+// Entries are bounded by count, least recently used evicted first, and dropped
+// once idle longer than idle. It's only a cache: txs in the pool keep their
+// sender on the tx itself and per account pool state lives in the pool, so
+// eviction never loses anything pool txs need.
+type senderCache struct {
+	mtx     sync.Mutex
+	entries map[common.Hash]*list.Element
+	order   *list.List // *senderEntry, least recently used at front
+	limit   int
+	idle    time.Duration
+}
+
+func newSenderCache(limit int, idle time.Duration) *senderCache {
+	return &senderCache{
+		entries: make(map[common.Hash]*list.Element),
+		order:   list.New(),
+		limit:   limit,
+		idle:    idle,
+	}
+}
+
+// sender returns the sender of tx, recovering and caching it on a miss.
+func (c *senderCache) sender(signer etypes.Signer, tx *etypes.Transaction) (common.Address, error) {
+	hash := tx.Hash()
+	now := time.Now()
+
+	c.mtx.Lock()
+	if elem, ok := c.entries[hash]; ok {
+		entry := elem.Value.(*senderEntry)
+		entry.used = now
+		c.order.MoveToBack(elem)
+		c.mtx.Unlock()
+		return entry.from, nil
+	}
+	c.mtx.Unlock()
+
+	// recover outside the lock, it's the expensive part
+	from, err := etypes.Sender(signer, tx)
+	if err != nil {
+		return from, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.entries[hash]; !ok && c.limit > 0 {
+		c.entries[hash] = c.order.PushBack(&senderEntry{hash: hash, from: from, used: now})
+		for c.order.Len() > c.limit {
+			c.remove(c.order.Front())
+		}
+	}
+	return from, nil
+}
+
+// prune drops the entries not used within idle.
+func (c *senderCache) prune(now time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for elem := c.order.Front(); elem != nil; elem = c.order.Front() {
+		if now.Sub(elem.Value.(*senderEntry).used) <= c.idle {
+			return
+		}
+		c.remove(elem)
+	}
+}
+
+func (c *senderCache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*senderEntry).hash)
+	c.order.Remove(elem)
+}
+
+func (c *senderCache) len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,102 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// oneOffSenderTxs signs one tx from each of n fresh accounts
+func oneOffSenderTxs(t *testing.T, n int) ([][]byte, []common.Address) {
+	raws := make([][]byte, n)
+	addrs := make([]common.Address, n)
+	for i := range raws {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raws[i] = signTestTx(t, key, etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+		addrs[i] = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	return raws, addrs
+}
+
+func TestSenderCacheBounded(t *testing.T) {
+	cache := newSenderCache(50, time.Minute)
+	raws, addrs := oneOffSenderTxs(t, 200)
+	for i, raw := range raws {
+		tx := &etypes.Transaction{}
+		if err := rlp.DecodeBytes(raw, tx); err != nil {
+			t.Fatal(err)
+		}
+		from, err := cache.sender(etypes.HomesteadSigner{}, tx)
+		if err != nil || from != addrs[i] {
+			t.Fatalf("tx %d: got sender %x, %v", i, from, err)
+		}
+		if cache.len() > 50 {
+			t.Fatalf("cache grew to %d entries", cache.len())
+		}
+	}
+
+	cache.prune(time.Now())
+	if cache.len() != 50 {
+		t.Fatalf("expected recently used entries to stay, got %d", cache.len())
+	}
+	cache.prune(time.Now().Add(2 * time.Minute))
+	if cache.len() != 0 {
+		t.Fatalf("expected idle entries to be pruned, got %d", cache.len())
+	}
+}
+
+func TestSenderCacheKeepsPoolTxs(t *testing.T) {
+	conf := viper.New()
+	conf.Set("sender_cache_size", 10)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	pooled := signTestTx(t, key, etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+	if err := app.pool.ReceiveTx(pooled); err != nil {
+		t.Fatal(err)
+	}
+
+	// many one-off senders push the pooled tx's sender out of the cache
+	raws, _ := oneOffSenderTxs(t, 100)
+	for _, raw := range raws {
+		if err := app.CheckTx(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := app.senders.len(); n > 10 {
+		t.Fatalf("sender cache grew to %d entries", n)
+	}
+
+	reaped := app.pool.Reap(10)
+	if len(reaped) != 1 || txHash(reaped[0]) != txHash(pooled) {
+		t.Fatalf("expected the pooled tx to be reaped, got %d txs", len(reaped))
+	}
+	execTestBlock(t, app, 1, reaped[0])
+	if nonce := app.pool.safeGetNonce(addr); nonce != 1 {
+		t.Fatalf("expected the pooled tx to execute, sender nonce %d", nonce)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/params"
+)
+
+var errTooManySenderTxs = errors.New("too many txs from the sender in the block")
+
+// withSenderTxsLimit returns config with the per sender tx limit of a block set
+func withSenderTxsLimit(config *params.ChainConfig, limit uint64) *params.ChainConfig {
+	if limit == 0 {
+		return config
+	}
+	limited := *config
+	limited.MaxTxsPerSender = limit
+	return &limited
+}
+
+// countSenderTx counts tx in the txs of its sender executed so far in the block,
+// failing the txs beyond the per sender limit in block order.
+func (app *EVMApp) countSenderTx(senderTxs map[common.Address]uint64, tx *etypes.Transaction) error {
+	limit := app.chainConfig.MaxTxsPerSender
+	if limit == 0 {
+		return nil
+	}
+	from, err := etypes.Sender(app.Signer, tx)
+	if err != nil {
+		return err
+	}
+	if senderTxs[from] >= limit {
+		return errTooManySenderTxs
+	}
+	senderTxs[from]++
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+const testSenderTxsLimit = 3
+
+func newSenderLimitTestApp(t *testing.T) (*EVMApp, func()) {
+	conf := viper.New()
+	conf.Set("max_txs_per_sender", testSenderTxsLimit)
+	return newTestAppWithConfig(t, conf)
+}
+
+func TestSenderTxsLimitAcrossNodes(t *testing.T) {
+	keyA, _ := testKey(t, testKeyA)
+	keyB, _ := testKey(t, testKeyB)
+	to := common.HexToAddress("0x01")
+	var txs [][]byte
+	for nonce := uint64(0); nonce < 2*testSenderTxsLimit; nonce++ {
+		txs = append(txs, signTestTx(t, keyA, etypes.NewTransaction(nonce, to, big.NewInt(0), testGas, big.NewInt(0), nil)))
+	}
+	other := signTestTx(t, keyB, etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+	txs = append(txs, other)
+
+	var roots [2]common.Hash
+	for node := range roots {
+		app, clean := newSenderLimitTestApp(t)
+		res := execTestBlock(t, app, 1, txs...)
+		if len(res.ValidTxs) != testSenderTxsLimit+1 || len(res.InvalidTxs) != testSenderTxsLimit {
+			t.Fatalf("expected %d valid txs, got %d valid %d invalid", testSenderTxsLimit+1, len(res.ValidTxs), len(res.InvalidTxs))
+		}
+		for i, raw := range res.ValidTxs[:testSenderTxsLimit] {
+			if txHash(raw) != txHash(txs[i]) {
+				t.Fatalf("expected the first %d txs of the sender to be valid", testSenderTxsLimit)
+			}
+		}
+		if txHash(res.ValidTxs[testSenderTxsLimit]) != txHash(other) {
+			t.Fatal("expected the tx of the other sender to be valid")
+		}
+		for _, invalid := range res.InvalidTxs {
+			if invalid.Error != errTooManySenderTxs {
+				t.Fatalf("unexpected error %v", invalid.Error)
+			}
+		}
+		roots[node] = app.getLastAppHash()
+		clean()
+	}
+	if roots[0] != roots[1] {
+		t.Fatalf("state roots differ, %x and %x", roots[0], roots[1])
+	}
+}
+
+func TestReapSenderTxsLimit(t *testing.T) {
+	app, clean := newSenderLimitTestApp(t)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	// the later nonces first, so all are promoted to pending together
+	for nonce := uint64(2 * testSenderTxsLimit); nonce > 0; nonce-- {
+		raw := signTestTx(t, key, etypes.NewTransaction(nonce-1, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.pool.updateToState()
+	if txs := app.pool.Reap(-1); len(txs) != testSenderTxsLimit {
+		t.Fatalf("expected %d txs reaped, got %d", testSenderTxsLimit, len(txs))
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+
+	"github.com/dappledger/AnnChain/eth/ethdb"
+)
+
+const chainDataName = "chaindata"
+
+type dbShard struct {
+	prefix []byte
+	db     ethdb.Database
+}
+
+// shardedDatabase routes keys to different databases by key prefix, so that
+// receipts and indexes don't compete with trie nodes for compaction. Keys
+// without a configured prefix, trie nodes included, go to the main database.
+type shardedDatabase struct {
+	shards []dbShard // longest prefix first
+	main   ethdb.Database
+	dbs    []ethdb.Database // every opened instance, closed once each
+}
+
+// openStateDatabase opens the main chaindata database, plus one database per
+// distinct directory name in shards, which maps key prefix to directory name.
+func openStateDatabase(datadir string, shards map[string]string, recoverCorrupted bool) (ethdb.Database, error) {
+	main, err := OpenDatabase(datadir, chainDataName, DatabaseCache, DatabaseHandles, recoverCorrupted)
+	if err != nil {
+		return nil, err
+	}
+	if len(shards) == 0 {
+		return main, nil
+	}
+
+	sdb := &shardedDatabase{main: main, dbs: []ethdb.Database{main}}
+	opened := map[string]ethdb.Database{chainDataName: main}
+	for prefix, name := range shards {
+		db, ok := opened[name]
+		if !ok {
+			if db, err = OpenDatabase(datadir, name, DatabaseCache, DatabaseHandles, recoverCorrupted); err != nil {
+				sdb.Close()
+				return nil, err
+			}
+			opened[name] = db
+			sdb.dbs = append(sdb.dbs, db)
+		}
+		sdb.shards = append(sdb.shards, dbShard{prefix: []byte(prefix), db: db})
+	}
+	sort.Slice(sdb.shards, func(i, j int) bool { return len(sdb.shards[i].prefix) > len(sdb.shards[j].prefix) })
+	return sdb, nil
+}
+
+func (sdb *shardedDatabase) route(key []byte) ethdb.Database {
+	for _, shard := range sdb.shards {
+		if bytes.HasPrefix(key, shard.prefix) {
+			return shard.db
+		}
+	}
+	return sdb.main
+}
+
+func (sdb *shardedDatabase) Put(key []byte, value []byte) error {
+	return sdb.route(key).Put(key, value)
+}
+
+func (sdb *shardedDatabase) Get(key []byte) ([]byte, error) {
+	return sdb.route(key).Get(key)
+}
+
+func (sdb *shardedDatabase) Has(key []byte) (bool, error) {
+	return sdb.route(key).Has(key)
+}
+
+func (sdb *shardedDatabase) Delete(key []byte) error {
+	return sdb.route(key).Delete(key)
+}
+
+func (sdb *shardedDatabase) Close() {
+	for _, db := range sdb.dbs {
+		db.Close()
+	}
+}
+
+// NewIteratorWithPrefix iterates the shard owning prefix, prefixes spanning several shards are not supported.
+func (sdb *shardedDatabase) NewIteratorWithPrefix(prefix []byte) iterator.Iterator {
+	return sdb.route(prefix).(prefixIteratee).NewIteratorWithPrefix(prefix)
+}
+
+func (sdb *shardedDatabase) NewBatch() ethdb.Batch {
+	return &shardedBatch{sdb: sdb, batches: make(map[ethdb.Database]ethdb.Batch)}
+}
+
+type shardedBatch struct {
+	sdb     *shardedDatabase
+	batches map[ethdb.Database]ethdb.Batch
+}
+
+func (b *shardedBatch) batch(key []byte) ethdb.Batch {
+	db := b.sdb.route(key)
+	batch, ok := b.batches[db]
+	if !ok {
+		batch = db.NewBatch()
+		b.batches[db] = batch
+	}
+	return batch
+}
+
+func (b *shardedBatch) Put(key, value []byte) error {
+	return b.batch(key).Put(key, value)
+}
+
+func (b *shardedBatch) Delete(key []byte) error {
+	return b.batch(key).Delete(key)
+}
+
+func (b *shardedBatch) ValueSize() int {
+	size := 0
+	for _, batch := range b.batches {
+		size += batch.ValueSize()
+	}
+	return size
+}
+
+// Write writes shard by shard, a failure leaves the shards written before it in place.
+func (b *shardedBatch) Write() error {
+	for _, batch := range b.batches {
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *shardedBatch) Reset() {
+	for _, batch := range b.batches {
+		batch.Reset()
+	}
+}
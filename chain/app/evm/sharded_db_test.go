@@ -0,0 +1,97 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+)
+
+func TestShardedDatabase(t *testing.T) {
+	conf := viper.New()
+	conf.Set("db_shards", map[string]string{
+		string(ReceiptsPrefix): "receipts",
+		string(TxStatusPrefix): "index",
+	})
+	app, _ := newTestAppWithConfig(t, conf)
+	defer os.RemoveAll(app.datadir)
+
+	sdb, ok := app.stateDb.(*shardedDatabase)
+	if !ok {
+		t.Fatalf("expected sharded database, got %T", app.stateDb)
+	}
+	shardOf := func(prefix []byte) ethdb.Database {
+		for _, shard := range sdb.shards {
+			if string(shard.prefix) == string(prefix) {
+				return shard.db
+			}
+		}
+		t.Fatalf("no shard for %s", prefix)
+		return nil
+	}
+	receiptsDb, indexDb := shardOf(ReceiptsPrefix), shardOf(TxStatusPrefix)
+
+	key, _ := testKey(t, testKeyA)
+	raw := signTestTx(t, key, etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+	if err := app.pool.ReceiveTx(raw); err != nil {
+		t.Fatal(err)
+	}
+	execTestBlock(t, app, 1, raw)
+
+	expected := []struct {
+		key []byte
+		db  ethdb.Database
+	}{
+		{append(append([]byte{}, ReceiptsPrefix...), txHash(raw).Bytes()...), receiptsDb},
+		{txStatusKey(txHash(raw)), indexDb},
+		{app.getLastAppHash().Bytes(), sdb.main},
+	}
+	for _, e := range expected {
+		for _, db := range sdb.dbs {
+			has, err := db.Has(e.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if has != (db == e.db) {
+				t.Fatalf("key %x in wrong shard", e.key)
+			}
+		}
+	}
+
+	if res := app.Query(append([]byte{rtypes.QueryType_Receipt}, txHash(raw).Bytes()...)); res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	if status := queryTestTxStatus(t, app, txHash(raw)); status.Status != rtypes.TxStatus_Committed {
+		t.Fatalf("expected committed, got %v", status.Status)
+	}
+
+	// shards must be released by Stop, leveldb refuses to open a locked directory
+	app.Stop()
+	for _, name := range []string{chainDataName, "receipts", "index"} {
+		db, err := ethdb.NewLDBDatabase(filepath.Join(app.datadir, name), 16, 16)
+		if err != nil {
+			t.Fatalf("shard %s not closed: %v", name, err)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// BlockOverrides are the header fields a simulated block runs with, zero values
+// for the defaults
+type BlockOverrides struct {
+	Time     time.Time       // zero for now
+	Coinbase *common.Address // nil for the coinbase of the node
+}
+
+// SimulateBlock executes txs as the block following the committed block at
+// parentHeight, 0 for the latest, and returns what committing it would result
+// in. The block runs through the execution of OnExecute on a copy of the parent
+// state that is thrown away: the committed state, the tx pool and the stored
+// receipts are left untouched. Simulations share the historical query limit.
+func (app *EVMApp) SimulateBlock(parentHeight uint64, txs [][]byte, overrides BlockOverrides) (*rtypes.SimulatedBlock, error) {
+	if err := app.checkRead(rtypes.QueryType_SimulateBlock); err != nil {
+		return nil, err
+	}
+	if err := app.historical.acquire(); err != nil {
+		return nil, err
+	}
+	defer app.historical.release()
+	state, parentHeight, err := app.simulationParent(parentHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &gtypes.Block{
+		Header: &gtypes.Header{Height: int64(parentHeight + 1), Time: overrides.Time, NumTxs: int64(len(txs))},
+		Data:   &gtypes.Data{},
+	}
+	if block.Header.Time.IsZero() {
+		block.Header.Time = time.Now()
+	}
+	for _, tx := range txs {
+		block.Data.Txs = append(block.Data.Txs, tx)
+	}
+	coinbase := app.coinbase
+	if overrides.Coinbase != nil {
+		coinbase = *overrides.Coinbase
+	}
+	deleteEmpty := app.chainConfig.MinAccountBalance == nil
+	parentRoot := state.IntermediateRoot(deleteEmpty)
+	exec, err := app.executeBlock(state, block, coinbase, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// the roots OnCommit would commit
+	sim := &rtypes.SimulatedBlock{
+		Height:     parentHeight + 1,
+		AppHash:    parentRoot,
+		Receipts:   make([]rtypes.SimulatedReceipt, len(exec.receipts)),
+		InvalidTxs: make([]rtypes.SimulatedInvalidTx, len(exec.res.InvalidTxs)),
+	}
+	if !app.idleCommit(exec.receipts, state.DirtyAccounts(), state.SuicidedAccounts()) {
+		sim.AppHash = state.IntermediateRoot(deleteEmpty)
+		if sim.ReceiptsHash, err = receiptsHash(app.chainConfig.ReceiptsHash, exec.receipts); err != nil {
+			return nil, err
+		}
+	}
+	for i, receipt := range exec.receipts {
+		sim.GasUsed += receipt.GasUsed
+		sim.Receipts[i] = rtypes.SimulatedReceipt{
+			TxHash:          receipt.TxHash,
+			Status:          txResultStatus(exec.receiptEnvs[i].Class),
+			GasUsed:         receipt.GasUsed,
+			ContractAddress: receipt.ContractAddress,
+			Logs:            uint64(len(receipt.Logs)),
+		}
+	}
+	for i, invalid := range exec.res.InvalidTxs {
+		sim.InvalidTxs[i] = rtypes.SimulatedInvalidTx{TxHash: common.BytesToHash(gtypes.Tx(invalid.Bytes).Hash()), Error: invalid.Error.Error()}
+	}
+	return sim, nil
+}
+
+// simulationParent returns a copy of the state committed at height, 0 for the
+// latest, along with its height.
+func (app *EVMApp) simulationParent(height uint64) (*estate.StateDB, uint64, error) {
+	latest := uint64(atomic.LoadInt64(&app.committedHeight))
+	switch {
+	case height == 0 || height == latest:
+		app.stateMtx.Lock()
+		defer app.stateMtx.Unlock()
+		return app.state.Copy(), app.committedHeader.Number.Uint64(), nil
+	case height > latest:
+		return nil, 0, fmt.Errorf("height %d not committed yet, latest is %d", height, latest)
+	}
+	state, _, err := app.historicalState(height)
+	return state, height, err
+}
+
+// querySimulateBlock answers the rlp encoded rtypes.SimulateBlockQuery with the
+// rlp encoded rtypes.SimulatedBlock. It's an admin query, answered only with
+// simulate_block_query set.
+func (app *EVMApp) querySimulateBlock(load []byte) gtypes.Result {
+	if !app.simulateBlockQuery {
+		return gtypes.NewError(gtypes.CodeType_Unauthorized, "block simulation disabled, no simulate_block_query")
+	}
+	var query rtypes.SimulateBlockQuery
+	if err := rlp.DecodeBytes(load, &query); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	var overrides BlockOverrides
+	if query.Time > 0 {
+		overrides.Time = time.Unix(int64(query.Time), 0)
+	}
+	overrides.Coinbase = query.Coinbase
+	sim, err := app.SimulateBlock(query.ParentHeight, query.Txs, overrides)
+	if err == errServerBusy {
+		return gtypes.NewError(gtypes.CodeType_ServerBusy, err.Error())
+	} else if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(sim)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,141 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func TestSimulateBlock(t *testing.T) {
+	conf := viper.New()
+	conf.Set("simulate_block_query", true)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	keyA, addrA := testKey(t, testKeyA)
+	keyB, addrB := testKey(t, testKeyB)
+	fundTestAccounts(t, app, big.NewInt(1000), addrA)
+	execTestBlock(t, app, 1, signTestTx(t, keyA, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), logContractCode)))
+	contract := crypto.CreateAddress(addrA, 0)
+
+	txs := [][]byte{
+		signTestTx(t, keyA, etypes.NewTransaction(1, addrB, big.NewInt(10), testGas, big.NewInt(0), nil)),
+		signTestTx(t, keyA, etypes.NewTransaction(2, contract, big.NewInt(0), testGas, big.NewInt(0), nil)),
+		signTestTx(t, keyB, etypes.NewTransaction(5, addrA, big.NewInt(0), testGas, big.NewInt(0), nil)), // nonce too high
+		signTestTx(t, keyA, etypes.NewContractCreation(3, big.NewInt(0), testGas, big.NewInt(0), logContractCode)),
+	}
+	committedRoot, poolSize := app.committedRoot, app.pool.Size()
+	sim, err := app.SimulateBlock(0, txs, BlockOverrides{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no side effects
+	if app.committedRoot != committedRoot || app.state.GetNonce(addrA) != 1 || app.state.GetBalance(addrB).Sign() != 0 || app.pool.Size() != poolSize {
+		t.Fatal("expected the simulation to leave the committed state and the pool untouched")
+	}
+	if _, err := app.GetReceipt(txHash(txs[0])); err != ErrReceiptNotFound {
+		t.Fatalf("expected no receipt stored by the simulation, got %v", err)
+	}
+
+	// the same block executed for real commits the simulated roots
+	block := makeTestBlock(2, txs...)
+	res, err := app.OnExecute(2, 0, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := commitTestBlock(t, app, block)
+	if sim.Height != 2 || !bytes.Equal(sim.AppHash.Bytes(), commit.AppHash) || !bytes.Equal(sim.ReceiptsHash, commit.ReceiptsHash) {
+		t.Fatalf("expected the roots %x %x of block 2, got %+v", commit.AppHash, commit.ReceiptsHash, sim)
+	}
+	invalid := res.(gtypes.ExecuteResult).InvalidTxs
+	if len(sim.InvalidTxs) != 1 || len(invalid) != 1 || sim.InvalidTxs[0].TxHash != txHash(txs[2]) || sim.InvalidTxs[0].Error != invalid[0].Error.Error() {
+		t.Fatalf("expected the same invalid txs, got %+v", sim.InvalidTxs)
+	}
+	var gasUsed uint64
+	for i, raw := range [][]byte{txs[0], txs[1], txs[3]} {
+		receipt, err := app.GetReceipt(txHash(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gasUsed += receipt.GasUsed
+		summary := sim.Receipts[i]
+		if summary.TxHash != txHash(raw) || summary.Status != rtypes.TxResult_Success || summary.GasUsed != receipt.GasUsed ||
+			summary.ContractAddress != receipt.ContractAddress || summary.Logs != uint64(len(receipt.Logs)) {
+			t.Fatalf("unexpected receipt summary %+v of %+v", summary, receipt)
+		}
+	}
+	if len(sim.Receipts) != 3 || sim.GasUsed != gasUsed {
+		t.Fatalf("expected 3 receipts using %d gas, got %+v", gasUsed, sim)
+	}
+
+	// an idle block carries the app hash forward
+	if sim, err = app.SimulateBlock(2, nil, BlockOverrides{Time: time.Unix(1, 0)}); err != nil || sim.Height != 3 || !bytes.Equal(sim.AppHash.Bytes(), commit.AppHash) || sim.ReceiptsHash != nil {
+		t.Fatalf("expected an empty block to keep the app hash, got %+v %v", sim, err)
+	}
+	if _, err := app.SimulateBlock(5, nil, BlockOverrides{}); err == nil {
+		t.Fatal("expected a parent not committed yet to be rejected")
+	}
+}
+
+func TestQuerySimulateBlock(t *testing.T) {
+	key, addr := testKey(t, testKeyA)
+	coinbase := common.HexToAddress("0xc0")
+	query, err := rlp.EncodeToBytes(&rtypes.SimulateBlockQuery{
+		Txs:      [][]byte{signTestTx(t, key, etypes.NewTransaction(0, addr, big.NewInt(0), testGas, big.NewInt(1), nil))},
+		Coinbase: &coinbase,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, clean := newTestApp(t)
+	defer clean()
+	if res := app.Query(append([]byte{rtypes.QueryType_SimulateBlock}, query...)); res.IsOK() {
+		t.Fatal("expected the block simulation to be disabled by default")
+	}
+
+	conf := viper.New()
+	conf.Set("simulate_block_query", true)
+	app, clean = newTestAppWithConfig(t, conf)
+	defer clean()
+	fundTestAccounts(t, app, big.NewInt(testGas), addr)
+	res := app.Query(append([]byte{rtypes.QueryType_SimulateBlock}, query...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var sim rtypes.SimulatedBlock
+	if err := rlp.DecodeBytes(res.Data, &sim); err != nil {
+		t.Fatal(err)
+	}
+	if len(sim.Receipts) != 1 || sim.Receipts[0].Status != rtypes.TxResult_Success || sim.GasUsed != 21000 {
+		t.Fatalf("unexpected simulated block %+v", sim)
+	}
+	// the fees went to the coinbase override, on the simulated state only
+	if app.state.GetBalance(coinbase).Sign() != 0 || sim.AppHash == app.committedRoot {
+		t.Fatalf("unexpected simulated block %+v", sim)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/metrics"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var (
+	simulationHitMeter  = metrics.NewRegisteredMeter("evm/query/simulation/cache/hit", nil)
+	simulationMissMeter = metrics.NewRegisteredMeter("evm/query/simulation/cache/miss", nil)
+	simulationSizeGauge = metrics.NewRegisteredGauge("evm/query/simulation/cache/bytes", nil)
+)
+
+// simulationEntryOverhead is the bytes counted for an entry besides its result
+const simulationEntryOverhead = 128
+
+type simulationEntry struct {
+	key    common.Hash
+	result gtypes.Result
+	size   int
+}
+
+// simulationCache memoizes the results of the simulation queries answered at
+// the committed height, so the calls polled many times between two blocks run
+// the evm once. Entries are bounded by their bytes, least recently used evicted
+// first, and all dropped when a block commits. A nil cache doesn't cache.
+type simulationCache struct {
+	mtx     sync.Mutex
+	entries map[common.Hash]*list.Element
+	order   *list.List // *simulationEntry, least recently used at front
+	budget  int
+	used    int
+	height  uint64 // committed height of the entries
+
+	hits, misses uint64
+}
+
+func newSimulationCache(budget int) *simulationCache {
+	if budget <= 0 {
+		return nil
+	}
+	return &simulationCache{entries: make(map[common.Hash]*list.Element), order: list.New(), budget: budget}
+}
+
+// simulationKey hashes the simulated message with the height of the queried
+// state, 0 for the latest one.
+func simulationKey(msg etypes.Message, height uint64) common.Hash {
+	var to []byte
+	if msg.To() != nil {
+		to = msg.To().Bytes()
+	}
+	data, _ := rlp.EncodeToBytes([]interface{}{
+		msg.From(), to, msg.Nonce(), msg.Value(), msg.Gas(), msg.GasPrice(), msg.Data(), msg.CheckNonce(), height,
+	})
+	return crypto.Keccak256Hash(data)
+}
+
+// get returns the result of key answered at the committed height
+func (c *simulationCache) get(key common.Hash, height uint64) (gtypes.Result, bool) {
+	if c == nil {
+		return gtypes.Result{}, false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if elem, ok := c.entries[key]; ok && height == c.height {
+		c.order.MoveToBack(elem)
+		c.hits++
+		simulationHitMeter.Mark(1)
+		return elem.Value.(*simulationEntry).result, true
+	}
+	c.misses++
+	simulationMissMeter.Mark(1)
+	return gtypes.Result{}, false
+}
+
+// put caches the result of key answered at the committed height, unless a block
+// committed meanwhile.
+func (c *simulationCache) put(key common.Hash, height uint64, result gtypes.Result) {
+	if c == nil {
+		return
+	}
+	size := len(result.Data) + len(result.Log) + simulationEntryOverhead
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if height != c.height || size > c.budget {
+		return
+	}
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.order.PushBack(&simulationEntry{key: key, result: result, size: size})
+	c.used += size
+	for c.used > c.budget {
+		entry := c.order.Remove(c.order.Front()).(*simulationEntry)
+		delete(c.entries, entry.key)
+		c.used -= entry.size
+	}
+	simulationSizeGauge.Update(int64(c.used))
+}
+
+// reset drops the entries once the block at height commits
+func (c *simulationCache) reset(height uint64) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.entries = make(map[common.Hash]*list.Element)
+	c.order.Init()
+	c.used = 0
+	c.height = height
+	simulationSizeGauge.Update(0)
+}
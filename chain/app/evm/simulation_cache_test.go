@@ -0,0 +1,84 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// storageCode stores its 32 bytes call data in slot 0, and returns slot 0 when
+// called without data
+var storageCode = common.FromHex("6018600c60003960186000f3" + "3615600c57600035600055005b60005460005260206000f3")
+
+func TestSimulationCache(t *testing.T) {
+	conf := viper.New()
+	conf.Set("simulation_cache_size", 1<<20)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), storageCode)))
+	contract := crypto.CreateAddress(addr, 0)
+	call, err := rlp.EncodeToBytes(&rtypes.CallObject{From: addr, To: &contract})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := append([]byte{rtypes.QueryType_Call}, call...)
+
+	for i := 0; i < 100; i++ {
+		if res := app.Query(query); res.IsErr() || !bytes.Equal(res.Data, make([]byte, 32)) {
+			t.Fatalf("unexpected answer %x %q", res.Data, res.Log)
+		}
+	}
+	if app.simulations.misses != 1 || app.simulations.hits != 99 {
+		t.Fatalf("expected one evm run, got %d misses %d hits", app.simulations.misses, app.simulations.hits)
+	}
+
+	stored := common.LeftPadBytes([]byte{7}, 32)
+	execTestBlock(t, app, 2, signTestTx(t, key, etypes.NewTransaction(1, contract, big.NewInt(0), testGas, big.NewInt(0), stored)))
+	if res := app.Query(query); res.IsErr() || !bytes.Equal(res.Data, stored) {
+		t.Fatalf("expected the answer of the new state, got %x %q", res.Data, res.Log)
+	}
+	if app.simulations.misses != 2 {
+		t.Fatalf("expected the commit to invalidate the cache, got %d misses", app.simulations.misses)
+	}
+}
+
+func TestSimulationCacheBudget(t *testing.T) {
+	cache := newSimulationCache(3 * simulationEntryOverhead)
+	keys := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03")}
+	for _, key := range keys {
+		cache.put(key, 0, gtypes.NewResultOK(key.Bytes(), ""))
+	}
+	if _, ok := cache.get(keys[0], 0); ok {
+		t.Fatal("expected the least recently used entry evicted over the budget")
+	}
+	if _, ok := cache.get(keys[2], 0); !ok {
+		t.Fatal("expected the last entry cached")
+	}
+	if _, ok := cache.get(keys[2], 1); ok {
+		t.Fatal("expected no entry of another committed height")
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"fmt"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/eth/trie"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// DiffStates returns the accounts added, removed or changed from the committed
+// state rootA to rootB, starting at the hashed address start, up to limit
+// accounts or all of them when limit is 0. Subtries both states share are
+// skipped, so the walk follows the size of the diff rather than the size of the
+// states.
+func (app *EVMApp) DiffStates(rootA, rootB, start common.Hash, limit int) (*rtypes.StateDiff, error) {
+	triedb := estate.NewDatabase(app.stateDb).TrieDB()
+	trA, err := trie.New(rootA, triedb)
+	if err != nil {
+		return nil, err
+	}
+	trB, err := trie.New(rootB, triedb)
+	if err != nil {
+		return nil, err
+	}
+	// the address preimages are kept by the database, whatever the root
+	preimages, err := trie.NewSecure(common.Hash{}, triedb, 0)
+	if err != nil {
+		return nil, err
+	}
+	diff := &rtypes.StateDiff{Accounts: make([]rtypes.AccountDiff, 0)}
+	err = diffTries(trA, trB, start[:], func(key, valueA, valueB []byte) (bool, error) {
+		account, err := diffAccount(valueA, valueB)
+		if err != nil {
+			return false, err
+		}
+		account.Key = common.BytesToHash(key)
+		if limit > 0 && len(diff.Accounts) >= limit {
+			diff.More, diff.Next = true, account.Key
+			return false, nil
+		}
+		if preimage := preimages.GetKey(key); len(preimage) == common.AddressLength {
+			addr := common.BytesToAddress(preimage)
+			account.Address = &addr
+		}
+		diff.Accounts = append(diff.Accounts, *account)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// diffTries calls fn with the keys whose values differ from trA to trB, in key
+// order from start, a nil value for a key missing from a trie, until fn returns
+// false. Subtries both tries share are skipped.
+func diffTries(trA, trB *trie.Trie, start []byte, fn func(key, valueA, valueB []byte) (bool, error)) error {
+	// leaves of A missing from B, and of B missing from A, both in key order
+	onlyA, _ := trie.NewDifferenceIterator(trB.NodeIterator(start), trA.NodeIterator(start))
+	onlyB, _ := trie.NewDifferenceIterator(trA.NodeIterator(start), trB.NodeIterator(start))
+	itA, itB := trie.NewIterator(onlyA), trie.NewIterator(onlyB)
+	okA, okB := itA.Next(), itB.Next()
+
+	for okA || okB {
+		var key, valueA, valueB []byte
+		var err error
+		switch {
+		case okA && okB && bytes.Equal(itA.Key, itB.Key):
+			key, valueA, valueB = common.CopyBytes(itA.Key), itA.Value, itB.Value
+			okA, okB = itA.Next(), itB.Next()
+		case okA && (!okB || bytes.Compare(itA.Key, itB.Key) < 0):
+			key, valueA = common.CopyBytes(itA.Key), itA.Value
+			// a leaf only moved in the trie of B is still there
+			if valueB, err = trB.TryGet(key); err != nil {
+				return err
+			}
+			okA = itA.Next()
+		default:
+			key, valueB = common.CopyBytes(itB.Key), itB.Value
+			if valueA, err = trA.TryGet(key); err != nil {
+				return err
+			}
+			okB = itB.Next()
+		}
+		if bytes.Equal(valueA, valueB) {
+			continue
+		}
+		more, err := fn(key, valueA, valueB)
+		if err != nil || !more {
+			return err
+		}
+	}
+	if itA.Err != nil {
+		return itA.Err
+	}
+	return itB.Err
+}
+
+// diffAccount compares the rlp encoded accounts of the same key, nil meaning no
+// account, and returns nil when they don't differ.
+func diffAccount(valueA, valueB []byte) (*rtypes.AccountDiff, error) {
+	switch {
+	case bytes.Equal(valueA, valueB):
+		return nil, nil
+	case len(valueA) == 0:
+		return &rtypes.AccountDiff{Kind: rtypes.StateDiff_Added}, nil
+	case len(valueB) == 0:
+		return &rtypes.AccountDiff{Kind: rtypes.StateDiff_Removed}, nil
+	}
+	var a, b estate.Account
+	if err := rlp.DecodeBytes(valueA, &a); err != nil {
+		return nil, err
+	}
+	if err := rlp.DecodeBytes(valueB, &b); err != nil {
+		return nil, err
+	}
+	account := &rtypes.AccountDiff{Kind: rtypes.StateDiff_Changed}
+	if a.Nonce != b.Nonce {
+		account.Fields = append(account.Fields, "nonce")
+	}
+	if a.Balance.Cmp(b.Balance) != 0 {
+		account.Fields = append(account.Fields, "balance")
+	}
+	if a.Root != b.Root {
+		account.Fields = append(account.Fields, "storage")
+	}
+	if !bytes.Equal(a.CodeHash, b.CodeHash) {
+		account.Fields = append(account.Fields, "code")
+	}
+	return account, nil
+}
+
+// queryStateDiff answers the rlp encoded rtypes.StateDiffQuery with the rlp
+// encoded rtypes.StateDiff, pages hold at most state_diff_limit accounts.
+func (app *EVMApp) queryStateDiff(load []byte) gtypes.Result {
+	var query rtypes.StateDiffQuery
+	if err := rlp.DecodeBytes(load, &query); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	limit := app.stateDiffLimit
+	if query.Limit > 0 && (limit <= 0 || query.Limit < uint64(limit)) {
+		limit = int(query.Limit)
+	}
+	diff, err := app.DiffStates(query.RootA, query.RootB, query.Start, limit)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(diff)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+// DiffStorage returns the storage slots of the contract addr changed from the
+// state committed at heightA to the one at heightB, starting at the hashed slot
+// start, up to limit slots or all of them when limit is 0. Like DiffStates it
+// skips the subtries both storages share. A contract missing from a state has
+// an empty storage there.
+func (app *EVMApp) DiffStorage(addr common.Address, heightA, heightB uint64, start common.Hash, limit int) (*rtypes.StorageDiff, error) {
+	if err := app.historical.acquire(); err != nil {
+		return nil, err
+	}
+	defer app.historical.release()
+	triedb := estate.NewDatabase(app.stateDb).TrieDB()
+	trA, err := app.storageTrieAt(triedb, addr, heightA)
+	if err != nil {
+		return nil, err
+	}
+	trB, err := app.storageTrieAt(triedb, addr, heightB)
+	if err != nil {
+		return nil, err
+	}
+	// the slot preimages are kept by the database, whatever the root
+	preimages, err := trie.NewSecure(common.Hash{}, triedb, 0)
+	if err != nil {
+		return nil, err
+	}
+	diff := &rtypes.StorageDiff{Slots: make([]rtypes.SlotDiff, 0)}
+	err = diffTries(trA, trB, start[:], func(key, valueA, valueB []byte) (bool, error) {
+		slot := rtypes.SlotDiff{KeyHash: common.BytesToHash(key)}
+		var err error
+		if slot.Old, err = storageValue(valueA); err != nil {
+			return false, err
+		}
+		if slot.New, err = storageValue(valueB); err != nil {
+			return false, err
+		}
+		if limit > 0 && len(diff.Slots) >= limit {
+			diff.More, diff.Next = true, slot.KeyHash
+			return false, nil
+		}
+		if preimage := preimages.GetKey(key); len(preimage) == common.HashLength {
+			slotKey := common.BytesToHash(preimage)
+			slot.Key = &slotKey
+		}
+		diff.Slots = append(diff.Slots, slot)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// storageTrieAt opens the storage trie of addr in the state committed at
+// height, an empty trie when the account doesn't exist there. The last
+// committed state has no next block carrying its app hash yet.
+func (app *EVMApp) storageTrieAt(triedb *trie.Database, addr common.Address, height uint64) (*trie.Trie, error) {
+	app.stateMtx.Lock()
+	committed, root := app.committedHeader.Number.Uint64(), app.committedRoot
+	app.stateMtx.Unlock()
+	if height > committed {
+		return nil, fmt.Errorf("height %d not committed", height)
+	}
+	if height < committed {
+		var err error
+		if root, _, err = app.historicalRoot(height); err != nil {
+			return nil, err
+		}
+	}
+	accounts, err := trie.New(root, triedb)
+	if err != nil {
+		return nil, err
+	}
+	value, err := accounts.TryGet(crypto.Keccak256(addr[:]))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return trie.New(common.Hash{}, triedb)
+	}
+	var account estate.Account
+	if err := rlp.DecodeBytes(value, &account); err != nil {
+		return nil, err
+	}
+	return trie.New(account.Root, triedb)
+}
+
+// storageValue decodes a storage trie value, the rlp of the value with its
+// leading zeros trimmed, nil for a slot not set
+func storageValue(value []byte) (common.Hash, error) {
+	if len(value) == 0 {
+		return common.Hash{}, nil
+	}
+	_, content, _, err := rlp.Split(value)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(content), nil
+}
+
+// queryStorageDiff answers the rlp encoded rtypes.StorageDiffQuery with the rlp
+// encoded rtypes.StorageDiff, pages hold at most state_diff_limit slots.
+func (app *EVMApp) queryStorageDiff(load []byte) gtypes.Result {
+	var query rtypes.StorageDiffQuery
+	if err := rlp.DecodeBytes(load, &query); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	limit := app.stateDiffLimit
+	if query.Limit > 0 && (limit <= 0 || query.Limit < uint64(limit)) {
+		limit = int(query.Limit)
+	}
+	diff, err := app.DiffStorage(query.Address, query.HeightA, query.HeightB, query.Start, limit)
+	if err == errServerBusy {
+		return gtypes.NewError(gtypes.CodeType_ServerBusy, err.Error())
+	} else if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(diff)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,213 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func queryTestStateDiff(t *testing.T, app *EVMApp, query rtypes.StateDiffQuery) *rtypes.StateDiff {
+	load, err := rlp.EncodeToBytes(&query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := app.Query(append([]byte{rtypes.QueryType_StateDiff}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	diff := &rtypes.StateDiff{}
+	if err := rlp.DecodeBytes(res.Data, diff); err != nil {
+		t.Fatal(err)
+	}
+	return diff
+}
+
+func TestDiffStates(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	to := common.HexToAddress("0x01")
+	fundTestAccounts(t, app, big.NewInt(1), to)
+	root1 := app.getLastAppHash()
+
+	fundTestAccounts(t, app, big.NewInt(1), to)
+	root2 := app.getLastAppHash()
+	diff := queryTestStateDiff(t, app, rtypes.StateDiffQuery{RootA: root1, RootB: root2})
+	if len(diff.Accounts) != 1 || diff.More {
+		t.Fatalf("expected one changed account, got %+v", diff)
+	}
+	if account := diff.Accounts[0]; account.Kind != rtypes.StateDiff_Changed || account.Address == nil || *account.Address != to ||
+		len(account.Fields) != 1 || account.Fields[0] != "balance" {
+		t.Fatalf("unexpected account diff %+v", account)
+	}
+	if diff := queryTestStateDiff(t, app, rtypes.StateDiffQuery{RootA: root2, RootB: root2}); len(diff.Accounts) != 0 {
+		t.Fatalf("expected no diff of a root with itself, got %+v", diff)
+	}
+
+	added := make(map[common.Address]bool)
+	for i := 0; i < 5; i++ {
+		added[common.BigToAddress(big.NewInt(int64(i+100)))] = true
+	}
+	updateTestState(t, app, func(state *estate.StateDB) {
+		for addr := range added {
+			state.AddBalance(addr, big.NewInt(1))
+		}
+		state.Suicide(to)
+	})
+	root3 := app.getLastAppHash()
+
+	// pages of 2 accounts cover the diff in key order
+	var accounts []rtypes.AccountDiff
+	query := rtypes.StateDiffQuery{RootA: root2, RootB: root3, Limit: 2}
+	for {
+		diff := queryTestStateDiff(t, app, query)
+		if len(diff.Accounts) > 2 {
+			t.Fatalf("expected at most 2 accounts by page, got %d", len(diff.Accounts))
+		}
+		accounts = append(accounts, diff.Accounts...)
+		if !diff.More {
+			break
+		}
+		query.Start = diff.Next
+	}
+	if len(accounts) != 6 {
+		t.Fatalf("expected 6 accounts, got %d", len(accounts))
+	}
+	for i, account := range accounts {
+		if i > 0 && bytes.Compare(accounts[i-1].Key[:], account.Key[:]) >= 0 {
+			t.Fatal("expected the accounts in key order")
+		}
+		switch {
+		case account.Address == nil:
+			t.Fatalf("expected the address of %x", account.Key)
+		case *account.Address == to && account.Kind != rtypes.StateDiff_Removed:
+			t.Fatalf("expected %x removed, got %d", to, account.Kind)
+		case *account.Address != to && (!added[*account.Address] || account.Kind != rtypes.StateDiff_Added):
+			t.Fatalf("unexpected account diff %+v", account)
+		}
+	}
+
+	// the reverse diff swaps added and removed accounts
+	for _, account := range queryTestStateDiff(t, app, rtypes.StateDiffQuery{RootA: root3, RootB: root2}).Accounts {
+		if (*account.Address == to) != (account.Kind == rtypes.StateDiff_Added) {
+			t.Fatalf("unexpected reverse account diff %+v", account)
+		}
+	}
+
+	load, _ := rlp.EncodeToBytes(&rtypes.StateDiffQuery{RootA: common.HexToHash("0x1234"), RootB: root3})
+	if res := app.Query(append([]byte{rtypes.QueryType_StateDiff}, load...)); res.IsOK() {
+		t.Fatal("expected an unknown root to be rejected")
+	}
+}
+
+// slotChangerCode is init code setting the slots 3, 5 and 9 to their number and
+// deploying code setting slot 3 to 7, clearing slot 5 and setting slot 40 to 1
+var slotChangerCode = common.FromHex("600360035560056005556009600955" + "6010601b600039" + "60106000f3" +
+	"6007600355" + "6000600555" + "6001602855" + "00")
+
+func queryTestStorageDiff(t *testing.T, app *EVMApp, query rtypes.StorageDiffQuery) *rtypes.StorageDiff {
+	load, err := rlp.EncodeToBytes(&query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := app.Query(append([]byte{rtypes.QueryType_StorageDiff}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	diff := &rtypes.StorageDiff{}
+	if err := rlp.DecodeBytes(res.Data, diff); err != nil {
+		t.Fatal(err)
+	}
+	return diff
+}
+
+// checkTestStorageDiff checks slots hold the changes, by slot number, of
+// expected, in hashed slot order
+func checkTestStorageDiff(t *testing.T, slots []rtypes.SlotDiff, expected map[int64][2]int64) {
+	if len(slots) != len(expected) {
+		t.Fatalf("expected %d changed slots, got %+v", len(expected), slots)
+	}
+	for i, slot := range slots {
+		if i > 0 && bytes.Compare(slots[i-1].KeyHash[:], slot.KeyHash[:]) >= 0 {
+			t.Fatal("expected the slots in key order")
+		}
+		if slot.Key == nil || crypto.Keccak256Hash(slot.Key[:]) != slot.KeyHash {
+			t.Fatalf("expected the key of slot %x", slot.KeyHash)
+		}
+		change, ok := expected[slot.Key.Big().Int64()]
+		if !ok || slot.Old != common.BigToHash(big.NewInt(change[0])) || slot.New != common.BigToHash(big.NewInt(change[1])) {
+			t.Fatalf("unexpected slot diff %+v", slot)
+		}
+	}
+}
+
+func TestDiffStorage(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	core := &appHashCore{appHashes: map[int64]common.Hash{0: app.getLastAppHash()}}
+	app.SetCore(core)
+
+	key, addr := testKey(t, testKeyA)
+	contract := crypto.CreateAddress(addr, 0)
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), slotChangerCode)))
+	core.appHashes[1] = app.getLastAppHash()
+	execTestBlock(t, app, 2, signTestTx(t, key, etypes.NewTransaction(1, contract, big.NewInt(0), testGas, big.NewInt(0), nil)))
+	core.appHashes[2] = app.getLastAppHash()
+	// block 3 leaves the storage of the contract as is
+	execTestBlock(t, app, 3, signTestTx(t, key, etypes.NewTransaction(2, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), nil)))
+
+	changes := map[int64][2]int64{3: {3, 7}, 5: {5, 0}, 40: {0, 1}}
+	diff := queryTestStorageDiff(t, app, rtypes.StorageDiffQuery{Address: contract, HeightA: 1, HeightB: 3})
+	if diff.More {
+		t.Fatal("expected the whole diff in one page")
+	}
+	checkTestStorageDiff(t, diff.Slots, changes)
+	// before the deploy, the contract has no storage
+	checkTestStorageDiff(t, queryTestStorageDiff(t, app, rtypes.StorageDiffQuery{Address: contract, HeightA: 0, HeightB: 1}).Slots,
+		map[int64][2]int64{3: {0, 3}, 5: {0, 5}, 9: {0, 9}})
+	if diff := queryTestStorageDiff(t, app, rtypes.StorageDiffQuery{Address: contract, HeightA: 2, HeightB: 3}); len(diff.Slots) != 0 {
+		t.Fatalf("expected no change in block 3, got %+v", diff)
+	}
+
+	// pages of 2 slots cover the reverse diff in key order
+	var slots []rtypes.SlotDiff
+	query := rtypes.StorageDiffQuery{Address: contract, HeightA: 3, HeightB: 1, Limit: 2}
+	for {
+		page := queryTestStorageDiff(t, app, query)
+		slots = append(slots, page.Slots...)
+		if !page.More {
+			break
+		}
+		query.Start = page.Next
+	}
+	reverse := make(map[int64][2]int64)
+	for slot, change := range changes {
+		reverse[slot] = [2]int64{change[1], change[0]}
+	}
+	checkTestStorageDiff(t, slots, reverse)
+
+	load, _ := rlp.EncodeToBytes(&rtypes.StorageDiffQuery{Address: contract, HeightA: 1, HeightB: 4})
+	if res := app.Query(append([]byte{rtypes.QueryType_StorageDiff}, load...)); res.IsOK() {
+		t.Fatal("expected a height not committed to be rejected")
+	}
+}
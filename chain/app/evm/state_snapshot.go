@@ -0,0 +1,233 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// A state snapshot is one version byte, a record of rlp(StateSnapshotHeader)
+// and records of rlp(StateSnapshotEntry), each record a uvarint length and its
+// data. The entries are the trie nodes and contract codes of the state at the
+// header's app hash, keyed by hash as in the state database.
+const (
+	StateSnapshotVersion byte = 1
+
+	maxStateSnapshotRecordSize = 32 * 1024 * 1024
+)
+
+// StateSnapshotHeader is the committed block a state snapshot was taken at
+type StateSnapshotHeader struct {
+	Height  uint64
+	AppHash common.Hash
+}
+
+// StateSnapshotEntry is one trie node or contract code of a state snapshot
+type StateSnapshotEntry struct {
+	Key   common.Hash
+	Value []byte
+}
+
+// stateSnapshotFile is where snapshots are written on stop and loaded from on start
+func (app *EVMApp) stateSnapshotFile() string {
+	if file := app.Config.GetString("state_snapshot_file"); file != "" {
+		return file
+	}
+	return filepath.Join(app.datadir, "state.snapshot")
+}
+
+// ExportStateSnapshot writes the state of the last committed block to w.
+func (app *EVMApp) ExportStateSnapshot(w io.Writer) error {
+	lastBlock, err := app.loadLastBlock()
+	if err != nil {
+		return err
+	}
+	if len(lastBlock.AppHash) == 0 {
+		return fmt.Errorf("no committed state")
+	}
+	root := common.BytesToHash(lastBlock.AppHash)
+	state, err := estate.New(root, estate.NewDatabase(app.stateDb))
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := bw.WriteByte(StateSnapshotVersion); err != nil {
+		return err
+	}
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	writeRecord := func(v interface{}) error {
+		record, err := rlp.EncodeToBytes(v)
+		if err != nil {
+			return err
+		}
+		n := binary.PutUvarint(lenBuf, uint64(len(record)))
+		if _, err := bw.Write(lenBuf[:n]); err != nil {
+			return err
+		}
+		_, err = bw.Write(record)
+		return err
+	}
+	if err := writeRecord(&StateSnapshotHeader{Height: uint64(lastBlock.Height), AppHash: root}); err != nil {
+		return err
+	}
+
+	it := estate.NewNodeIterator(state)
+	for it.Next() {
+		// embedded nodes have no hash, they're stored within their parent
+		if it.Hash == (common.Hash{}) {
+			continue
+		}
+		value, err := app.stateDb.Get(it.Hash.Bytes())
+		if err != nil {
+			return fmt.Errorf("get state node %x: %v", it.Hash, err)
+		}
+		if err := writeRecord(&StateSnapshotEntry{Key: it.Hash, Value: value}); err != nil {
+			return err
+		}
+	}
+	if it.Error != nil {
+		return it.Error
+	}
+	return bw.Flush()
+}
+
+// ImportStateSnapshot loads a snapshot written by ExportStateSnapshot into an
+// empty state database, the last committed block becomes the snapshot's. Only
+// the state is imported, the receipts and other indexes of earlier blocks aren't.
+func (app *EVMApp) ImportStateSnapshot(r io.Reader) (*StateSnapshotHeader, error) {
+	if app.getLastAppHash() != EmptyTrieRoot {
+		return nil, fmt.Errorf("state database not empty")
+	}
+	br := bufio.NewReader(r)
+	version, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != StateSnapshotVersion {
+		return nil, fmt.Errorf("unsupported state snapshot version %d", version)
+	}
+	readRecord := func(v interface{}) error {
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return err
+		}
+		if size > maxStateSnapshotRecordSize {
+			return fmt.Errorf("state snapshot record too large: %d", size)
+		}
+		record := make([]byte, size)
+		if _, err := io.ReadFull(br, record); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		return rlp.DecodeBytes(record, v)
+	}
+	header := &StateSnapshotHeader{}
+	if err := readRecord(header); err != nil {
+		return nil, fmt.Errorf("read state snapshot header: %v", err)
+	}
+
+	batch := app.stateDb.NewBatch()
+	for {
+		entry := &StateSnapshotEntry{}
+		if err := readRecord(entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("read state snapshot entry: %v", err)
+		}
+		if err := batch.Put(entry.Key.Bytes(), entry.Value); err != nil {
+			return nil, err
+		}
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return nil, err
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+
+	// the last block is only saved once the whole state is known to be there
+	state, err := estate.New(header.AppHash, estate.NewDatabase(app.stateDb))
+	if err != nil {
+		return nil, err
+	}
+	it := estate.NewNodeIterator(state)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		return nil, fmt.Errorf("incomplete state snapshot: %v", it.Error)
+	}
+	// the state of the genesis isn't in the snapshot, the chain of a snapshot is
+	// taken for the one of the app genesis
+	if err := app.stateDb.Put(GenesisRootKey, genesisRoot().Bytes()); err != nil {
+		return nil, err
+	}
+	if err := app.saveLastBlock(LastBlockInfo{Height: int64(header.Height), AppHash: header.AppHash.Bytes()}); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// writeStateSnapshot exports the state to file through a temp file renamed
+// over it once complete, so an interrupted stop leaves the previous snapshot.
+func (app *EVMApp) writeStateSnapshot(file string) error {
+	tmp, err := os.Create(file + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := app.ExportStateSnapshot(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// loadStateSnapshot imports the snapshot in file, if any.
+func (app *EVMApp) loadStateSnapshot(file string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = app.ImportStateSnapshot(f)
+	return err
+}
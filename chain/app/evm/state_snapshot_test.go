@@ -0,0 +1,82 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+)
+
+func TestStateSnapshotOnStop(t *testing.T) {
+	snapshotDir, err := ioutil.TempDir("", "evm-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(snapshotDir)
+	file := filepath.Join(snapshotDir, "state.snapshot")
+
+	conf := viper.New()
+	conf.Set("state_snapshot_on_stop", true)
+	conf.Set("state_snapshot_file", file)
+	app, _ := newTestAppWithConfig(t, conf)
+	defer os.RemoveAll(conf.GetString("db_dir"))
+
+	key, addr := testKey(t, testKeyA)
+	to := common.HexToAddress("0x01")
+	fundTestAccounts(t, app, big.NewInt(1000), to)
+	execTestBlock(t, app, 1,
+		signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), logContractCode)),
+		signTestTx(t, key, etypes.NewTransaction(1, to, big.NewInt(0), testGas, big.NewInt(0), nil)))
+	contract := crypto.CreateAddress(addr, 0)
+	execTestBlock(t, app, 2, signTestTx(t, key, etypes.NewTransaction(2, contract, big.NewInt(0), testGas, big.NewInt(0), nil)))
+	appHash := app.getLastAppHash()
+	code := app.state.GetCode(contract)
+	app.Stop()
+	if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("expected the temp snapshot renamed")
+	}
+
+	conf = viper.New()
+	conf.Set("state_snapshot_load", true)
+	conf.Set("state_snapshot_file", file)
+	restarted, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	if restarted.getLastAppHash() != appHash {
+		t.Fatalf("expected app hash %x after loading the snapshot, got %x", appHash, restarted.getLastAppHash())
+	}
+	if height := restarted.committedHeight; height != 2 {
+		t.Fatalf("expected height 2 after loading the snapshot, got %d", height)
+	}
+	if restarted.state.GetBalance(to).Cmp(big.NewInt(1000)) != 0 || restarted.state.GetNonce(addr) != 3 {
+		t.Fatal("unexpected accounts after loading the snapshot")
+	}
+	if string(restarted.state.GetCode(contract)) != string(code) || len(code) == 0 {
+		t.Fatal("unexpected contract code after loading the snapshot")
+	}
+
+	// the loaded state keeps executing blocks
+	execTestBlock(t, restarted, 3, signTestTx(t, key, etypes.NewTransaction(3, contract, big.NewInt(0), testGas, big.NewInt(0), nil)))
+	if _, err := restarted.ImportStateSnapshot(nil); err == nil {
+		t.Fatal("expected importing into a non empty state database to be rejected")
+	}
+}
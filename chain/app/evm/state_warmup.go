@@ -0,0 +1,241 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+)
+
+const (
+	warmupModeOff  = "off"     // no warmup
+	warmupModeHead = "head"    // read the account trie at the head root
+	warmupRecent   = "recent-" // recent-N, also read the receipts and indexes of the last N blocks
+
+	warmupProgressNodes = 10000 // trie nodes between progress logs
+)
+
+// parseWarmupMode returns whether warmup_mode asks for a warmup and how many
+// recent blocks it reads.
+func parseWarmupMode(mode string) (warm bool, recent uint64, err error) {
+	switch {
+	case mode == warmupModeOff:
+		return false, 0, nil
+	case mode == warmupModeHead:
+		return true, 0, nil
+	case strings.HasPrefix(mode, warmupRecent):
+		if recent, err = strconv.ParseUint(strings.TrimPrefix(mode, warmupRecent), 10, 64); err == nil && recent > 0 {
+			return true, recent, nil
+		}
+	}
+	return false, 0, fmt.Errorf("invalid warmup_mode %q", mode)
+}
+
+// stateWarmer reads the head of the account trie, breadth first up to a node
+// budget, and the receipts of recent blocks, so the first queries after a
+// restart don't hit a cold database. It runs aside block execution, only the
+// ready state waits for it.
+type stateWarmer struct {
+	db     ethdb.Database
+	warm   bool
+	recent uint64
+	budget int
+
+	ready    int32
+	nodes    uint64
+	receipts uint64
+	bytes    uint64
+
+	quit     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func newStateWarmer(db ethdb.Database, warm bool, recent uint64, budget int) *stateWarmer {
+	w := &stateWarmer{
+		db:     db,
+		warm:   warm,
+		recent: recent,
+		budget: budget,
+		quit:   make(chan struct{}),
+	}
+	if !warm {
+		w.ready = 1
+	}
+	return w
+}
+
+// Start warms the state of root, committed at height, in the background.
+func (w *stateWarmer) Start(root common.Hash, height uint64) {
+	if !w.warm {
+		return
+	}
+	w.wg.Add(1)
+	go w.run(root, height)
+}
+
+// Stop cancels the warmup, it must be called before closing db.
+func (w *stateWarmer) Stop() {
+	w.stopOnce.Do(func() { close(w.quit) })
+	w.wg.Wait()
+}
+
+// Ready reports whether the warmup is done, or not asked for.
+func (w *stateWarmer) Ready() bool {
+	return atomic.LoadInt32(&w.ready) == 1
+}
+
+// WarmedBytes returns the bytes read by the warmup so far.
+func (w *stateWarmer) WarmedBytes() uint64 {
+	return atomic.LoadUint64(&w.bytes)
+}
+
+func (w *stateWarmer) stopped() bool {
+	select {
+	case <-w.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *stateWarmer) read(key []byte) ([]byte, bool) {
+	value, err := w.db.Get(key)
+	if err != nil {
+		return nil, false
+	}
+	atomic.AddUint64(&w.bytes, uint64(len(key)+len(value)))
+	return value, true
+}
+
+func (w *stateWarmer) run(root common.Hash, height uint64) {
+	defer w.wg.Done()
+	start := time.Now()
+	if !w.warmTrie(root) || !w.warmReceipts(height) {
+		log.Info("state warmup cancelled", zap.Uint64("nodes", atomic.LoadUint64(&w.nodes)), zap.Uint64("bytes", w.WarmedBytes()))
+		return
+	}
+	atomic.StoreInt32(&w.ready, 1)
+	log.Info("state warmup done", zap.Uint64("nodes", atomic.LoadUint64(&w.nodes)), zap.Uint64("receipts", atomic.LoadUint64(&w.receipts)),
+		zap.Uint64("bytes", w.WarmedBytes()), zap.Duration("duration", time.Since(start)))
+}
+
+// warmTrie reads the trie nodes of root breadth first, returning false when stopped.
+func (w *stateWarmer) warmTrie(root common.Hash) bool {
+	if root == EmptyTrieRoot || root == (common.Hash{}) {
+		return true
+	}
+	queue := []common.Hash{root}
+	for len(queue) > 0 && atomic.LoadUint64(&w.nodes) < uint64(w.budget) {
+		if w.stopped() {
+			return false
+		}
+		hash := queue[0]
+		queue = queue[1:]
+		blob, ok := w.read(hash[:])
+		if !ok {
+			continue
+		}
+		if nodes := atomic.AddUint64(&w.nodes, 1); nodes%warmupProgressNodes == 0 {
+			log.Info("state warmup", zap.Uint64("nodes", nodes), zap.Uint64("bytes", w.WarmedBytes()))
+		}
+		queue = appendTrieChildren(queue, blob)
+	}
+	return true
+}
+
+// appendTrieChildren appends the hashes of the nodes referenced by the rlp
+// encoded trie node, children embedded in the node are walked in place.
+func appendTrieChildren(queue []common.Hash, node []byte) []common.Hash {
+	elems, _, err := rlp.SplitList(node)
+	if err != nil {
+		return queue
+	}
+	var children [][]byte
+	switch n, _ := rlp.CountValues(elems); n {
+	case 17: // branch, the 17th item is the value
+		for i := 0; i < 16; i++ {
+			var child []byte
+			if child, elems, err = splitRaw(elems); err != nil {
+				return queue
+			}
+			children = append(children, child)
+		}
+	case 2: // extension or leaf, told apart by the compact key flag
+		_, key, rest, err := rlp.Split(elems)
+		if err != nil || len(key) == 0 || key[0]>>4 > 1 {
+			return queue
+		}
+		child, _, err := splitRaw(rest)
+		if err != nil {
+			return queue
+		}
+		children = append(children, child)
+	}
+	for _, child := range children {
+		kind, content, _, err := rlp.Split(child)
+		switch {
+		case err != nil:
+		case kind == rlp.String && len(content) == common.HashLength:
+			queue = append(queue, common.BytesToHash(content))
+		case kind == rlp.List:
+			queue = appendTrieChildren(queue, child)
+		}
+	}
+	return queue
+}
+
+// splitRaw returns the first rlp item of b, with its header, and the rest
+func splitRaw(b []byte) (item, rest []byte, err error) {
+	if _, _, rest, err = rlp.Split(b); err != nil {
+		return nil, nil, err
+	}
+	return b[:len(b)-len(rest)], rest, nil
+}
+
+// warmReceipts reads the receipts and indexes of the recent blocks up to height,
+// returning false when stopped.
+func (w *stateWarmer) warmReceipts(height uint64) bool {
+	for h := height; h > 0 && height-h < w.recent; h-- {
+		if w.stopped() {
+			return false
+		}
+		w.read(touchedAccountsKey(h))
+		index, ok := w.read(blockReceiptsKey(h))
+		if !ok {
+			continue
+		}
+		var hashes []common.Hash
+		if err := rlp.DecodeBytes(index, &hashes); err != nil {
+			continue
+		}
+		for _, hash := range hashes {
+			if _, ok := w.read(receiptKey(hash)); ok {
+				atomic.AddUint64(&w.receipts, 1)
+			}
+		}
+	}
+	return true
+}
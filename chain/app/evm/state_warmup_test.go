@@ -0,0 +1,124 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func TestParseWarmupMode(t *testing.T) {
+	for mode, expected := range map[string]struct {
+		warm   bool
+		recent uint64
+	}{
+		"off":       {false, 0},
+		"head":      {true, 0},
+		"recent-16": {true, 16},
+	} {
+		warm, recent, err := parseWarmupMode(mode)
+		if err != nil || warm != expected.warm || recent != expected.recent {
+			t.Fatalf("%s: unexpected mode %v %d %v", mode, warm, recent, err)
+		}
+	}
+	for _, mode := range []string{"", "all", "recent-", "recent-0", "recent-x"} {
+		if _, _, err := parseWarmupMode(mode); err == nil {
+			t.Fatalf("expected mode %q to be rejected", mode)
+		}
+	}
+}
+
+func TestStateWarmer(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	to := common.HexToAddress("0x01")
+	for height := int64(1); height <= 3; height++ {
+		nonce := uint64(height - 1)
+		execTestBlock(t, app, height, signTestTx(t, key, etypes.NewTransaction(nonce, to, big.NewInt(0), testGas, big.NewInt(0), nil)))
+	}
+	addrs := make([]common.Address, 64)
+	for i := range addrs {
+		addrs[i] = common.BigToAddress(big.NewInt(int64(i + 100)))
+	}
+	fundTestAccounts(t, app, big.NewInt(1), addrs...)
+	root := app.getLastAppHash()
+
+	tr, err := estate.NewDatabase(app.stateDb).OpenTrie(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trieNodes uint64
+	for it := tr.NodeIterator(nil); it.Next(true); {
+		if it.Hash() != (common.Hash{}) {
+			trieNodes++
+		}
+	}
+
+	w := newStateWarmer(app.stateDb, true, 2, 1<<20)
+	if w.Ready() {
+		t.Fatal("expected the warmer not to be ready before warming")
+	}
+	w.Start(root, 3)
+	w.wg.Wait()
+	if !w.Ready() || w.nodes != trieNodes || w.receipts != 2 || w.WarmedBytes() == 0 {
+		t.Fatalf("expected %d trie nodes and 2 receipts warmed, got %d nodes %d receipts", trieNodes, w.nodes, w.receipts)
+	}
+
+	w = newStateWarmer(app.stateDb, true, 0, 5)
+	w.Start(root, 3)
+	w.wg.Wait()
+	if !w.Ready() || w.nodes != 5 || w.receipts != 0 {
+		t.Fatalf("expected the warmup to stop at the node budget, got %d nodes %d receipts", w.nodes, w.receipts)
+	}
+
+	w = newStateWarmer(app.stateDb, true, 0, 1<<20)
+	w.Stop()
+	w.Start(root, 3)
+	w.wg.Wait()
+	if w.Ready() || w.nodes != 0 {
+		t.Fatal("expected a stopped warmup not to be ready")
+	}
+}
+
+func TestWarmupStatus(t *testing.T) {
+	conf := viper.New()
+	conf.Set("warmup_mode", "recent-4")
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+	srv := httptest.NewServer(app.httpQueryHandler())
+	defer srv.Close()
+
+	app.warmer.wg.Wait()
+	var status httpQueryStatus
+	httpTestQuery(t, srv, http.MethodGet, "/status", "", http.StatusOK, &status)
+	if !status.Ready || status.Warmed == 0 {
+		t.Fatalf("expected the warmed app to be ready, got %+v", status)
+	}
+
+	conf = viper.New()
+	conf.Set("warmup_mode", "cold")
+	if _, err := NewEVMApp(conf); err == nil {
+		t.Fatal("expected invalid warmup_mode to be rejected")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// syncing_queries values, how queries are served while the app is syncing
+const (
+	syncingQueriesAnswer = "answer" // answer as usual
+	syncingQueriesWarn   = "warn"   // answer, with a syncing warning in the result log
+	syncingQueriesRefuse = "refuse" // fail with CodeType_Syncing
+)
+
+func validSyncingQueries(mode string) bool {
+	return mode == syncingQueriesAnswer || mode == syncingQueriesWarn || mode == syncingQueriesRefuse
+}
+
+// syncStatus compares the committed app height with the latest block stored by
+// the core. Right after a restart the core replays its stored blocks to the app,
+// until then queries read stale state.
+func (app *EVMApp) syncStatus() rtypes.SyncStatus {
+	height := atomic.LoadInt64(&app.committedHeight)
+	status := rtypes.SyncStatus{Height: uint64(height), Target: uint64(height)}
+	if app.core != nil {
+		if target := app.core.Height(); target > height {
+			status.Target = uint64(target)
+		}
+	}
+	status.Syncing = status.Target-status.Height >= app.syncLagThreshold
+	return status
+}
+
+// syncingQuery returns the log of queries served while syncing, empty when the
+// app is caught up or queries are answered as usual.
+func (app *EVMApp) syncingQuery(action byte) string {
+	if app.syncingQueries == syncingQueriesAnswer {
+		return ""
+	}
+	switch action {
+	case rtypes.QueryType_SyncStatus, rtypes.QueryType_DecodeTx, rtypes.QueryType_GenesisHash:
+		return ""
+	}
+	status := app.syncStatus()
+	if !status.Syncing {
+		return ""
+	}
+	return fmt.Sprintf("node syncing, height=%d, target=%d", status.Height, status.Target)
+}
+
+func (app *EVMApp) querySyncStatus() gtypes.Result {
+	status := app.syncStatus()
+	data, err := rlp.EncodeToBytes(&status)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+// catchingUp reports whether the block at height is replayed in catch_up_mode:
+// the core stored blocks past it, so it's committed history and not a block the
+// validators are agreeing on. The last stored block is never replayed in
+// catch_up_mode, its commit sends the mirror deliveries held back before it.
+// Executing a block is the same either way, as its result must match the one
+// the validators committed.
+func (app *EVMApp) catchingUp(height int64) bool {
+	return app.catchUpMode && app.core != nil && height < app.core.Height()
+}
@@ -0,0 +1,171 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// testCore is a core that has stored blocks up to height and the raw txs of txs
+type testCore struct {
+	height     int64
+	txs        map[common.Hash][]byte
+	validators *gtypes.ValidatorSet // of every height
+}
+
+func (c *testCore) Query(queryType byte, load []byte) (interface{}, error) {
+	if raw, ok := c.txs[common.BytesToHash(load)]; ok && queryType == gtypes.QueryTx {
+		return &gtypes.ResultTransaction{RawTransaction: raw}, nil
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func (c *testCore) GetBlockMeta(height int64) (*gtypes.BlockMeta, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (c *testCore) Height() int64 {
+	return c.height
+}
+
+func (c *testCore) ValidatorsAt(height int64) (*gtypes.ValidatorSet, error) {
+	if c.validators == nil {
+		return nil, fmt.Errorf("not supported")
+	}
+	return c.validators, nil
+}
+
+func queryTestSyncStatus(t *testing.T, app *EVMApp) rtypes.SyncStatus {
+	res := app.Query([]byte{rtypes.QueryType_SyncStatus})
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var status rtypes.SyncStatus
+	if err := rlp.DecodeBytes(res.Data, &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestSyncStatus(t *testing.T) {
+	for _, mode := range []string{syncingQueriesAnswer, syncingQueriesWarn, syncingQueriesRefuse} {
+		conf := viper.New()
+		conf.Set("syncing_queries", mode)
+		conf.Set("sync_lag_threshold", 3)
+		app, clean := newTestAppWithConfig(t, conf)
+		core := &testCore{}
+		app.SetCore(core)
+		execTestBlock(t, app, 1)
+
+		nonce := append([]byte{rtypes.QueryType_Nonce}, make([]byte, 20)...)
+		core.height = 3 // lag 2, in sync
+		if status := queryTestSyncStatus(t, app); status.Syncing || status.Height != 1 || status.Target != 3 {
+			t.Fatalf("%s: unexpected status %+v", mode, status)
+		}
+		if res := app.Query(nonce); res.IsErr() || res.Log != "" {
+			t.Fatalf("%s: unexpected query result %+v", mode, res)
+		}
+
+		core.height = 100000 // replaying after a restart
+		if status := queryTestSyncStatus(t, app); !status.Syncing || status.Height != 1 || status.Target != 100000 {
+			t.Fatalf("%s: unexpected status %+v", mode, status)
+		}
+		res := app.Query(nonce)
+		switch mode {
+		case syncingQueriesAnswer:
+			if res.IsErr() || res.Log != "" {
+				t.Fatalf("%s: unexpected query result %+v", mode, res)
+			}
+		case syncingQueriesWarn:
+			if res.IsErr() || res.Log != "node syncing, height=1, target=100000" {
+				t.Fatalf("%s: unexpected query result %+v", mode, res)
+			}
+		case syncingQueriesRefuse:
+			if res.Code != gtypes.CodeType_Syncing || !strings.Contains(res.Log, "target=100000") {
+				t.Fatalf("%s: unexpected query result %+v", mode, res)
+			}
+			srv := httptest.NewServer(app.httpQueryHandler())
+			var status httpQueryStatus
+			httpTestQuery(t, srv, http.MethodGet, "/status", "", http.StatusOK, &status)
+			if !status.Syncing || status.Target != 100000 {
+				t.Fatalf("unexpected http status %+v", status)
+			}
+			var qerr httpQueryError
+			httpTestQuery(t, srv, http.MethodGet, "/nonce?address=0x0000000000000000000000000000000000000000", "", http.StatusServiceUnavailable, &qerr)
+			srv.Close()
+		}
+
+		execTestBlock(t, app, 2)
+		core.height = 4 // caught up again
+		if res := app.Query(nonce); res.IsErr() || res.Log != "" {
+			t.Fatalf("%s: unexpected query result after catching up %+v", mode, res)
+		}
+		clean()
+	}
+
+	conf := viper.New()
+	conf.Set("syncing_queries", "ignore")
+	if _, err := NewEVMApp(conf); err == nil {
+		t.Fatal("expected invalid syncing_queries to be rejected")
+	}
+}
+
+func TestCatchUpMode(t *testing.T) {
+	for _, catchUp := range []bool{false, true} {
+		conf := viper.New()
+		conf.Set("catch_up_mode", catchUp)
+		app, clean := newTestAppWithConfig(t, conf)
+		sink := NewMemoryMirrorSink()
+		app.RegisterMirrorSink("memory", sink)
+		// the core stored the blocks up to 3, the app replays them
+		app.SetCore(&testCore{height: 3})
+
+		key, addr := testKey(t, testKeyA)
+		fundTestAccounts(t, app, big.NewInt(1000), addr)
+		raw := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(1), testGas, big.NewInt(0), nil))
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+		execTestBlock(t, app, 1, raw)
+		execTestBlock(t, app, 2)
+		app.mirror.deliverAll()
+		if _, ok := sink.Block(1); ok == catchUp {
+			t.Fatalf("catch_up_mode %v: unexpected mirror delivery %v of a replayed block", catchUp, ok)
+		}
+		// the pool is kept in sync with every block either way
+		if size := app.pool.Size(); size != 0 {
+			t.Fatalf("catch_up_mode %v: expected the replayed tx out of the pool, got %d txs", catchUp, size)
+		}
+
+		// the last stored block ends the catch up
+		execTestBlock(t, app, 3)
+		for height := uint64(1); height <= 3; height++ {
+			waitMirrorBlock(t, sink, height)
+		}
+		clean()
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"github.com/dappledger/AnnChain/eth/common"
+)
+
+// TouchedAccountsPrefix indexes the accounts modified by each block, stored as
+// the sorted concatenation of their addresses.
+var TouchedAccountsPrefix = []byte("blocktouched-")
+
+func touchedAccountsKey(height uint64) []byte {
+	key := make([]byte, len(TouchedAccountsPrefix)+8)
+	copy(key, TouchedAccountsPrefix)
+	binary.BigEndian.PutUint64(key[len(TouchedAccountsPrefix):], height)
+	return key
+}
+
+func (app *EVMApp) saveTouchedAccounts(height uint64, addrs []common.Address) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+	value := make([]byte, 0, len(addrs)*common.AddressLength)
+	for _, addr := range addrs {
+		value = append(value, addr[:]...)
+	}
+	return app.stateDb.Put(touchedAccountsKey(height), value)
+}
+
+// listTouchedAccounts lists the accounts modified by the block at the 8 bytes big
+// endian height, sorted by address, the token is the address of an account.
+// Coinbase is included when the block paid fees to it.
+func (app *EVMApp) listTouchedAccounts(load, token []byte, limit int) ([]listItem, []byte, error) {
+	if len(load) != 8 {
+		return nil, nil, errors.New("wrong height")
+	}
+	if len(token) != 0 && len(token) != common.AddressLength {
+		return nil, nil, errInvalidPageToken
+	}
+	var value []byte
+	if stored, err := app.stateDb.Get(touchedAccountsKey(binary.BigEndian.Uint64(load))); err == nil {
+		value = stored
+	}
+	n := len(value) / common.AddressLength
+	address := func(i int) []byte { return value[i*common.AddressLength : (i+1)*common.AddressLength] }
+	i := sort.Search(n, func(i int) bool { return bytes.Compare(address(i), token) >= 0 })
+	items := make([]listItem, 0)
+	for ; i < n && len(items) < limit; i++ {
+		items = append(items, listItem{address(i), common.BytesToAddress(address(i))})
+	}
+	if i < n {
+		return items, address(i), nil
+	}
+	return items, nil, nil
+}
@@ -0,0 +1,74 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func queryTestTouchedAccounts(t *testing.T, app *EVMApp, height uint64) []common.Address {
+	load := make([]byte, 8)
+	binary.BigEndian.PutUint64(load, height)
+	res := app.Query(append([]byte{rtypes.QueryType_BlockTouchedAccounts}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var addrs []common.Address
+	if _, err := decodePage(res.Data, &addrs); err != nil {
+		t.Fatal(err)
+	}
+	return addrs
+}
+
+func TestBlockTouchedAccounts(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	keyA, addrA := testKey(t, testKeyA)
+	keyB, addrB := testKey(t, testKeyB)
+	fundTestAccounts(t, app, big.NewInt(100), addrA)
+	to := common.HexToAddress("0xff00000000000000000000000000000000000001")
+	execTestBlock(t, app, 1,
+		signTestTx(t, keyA, etypes.NewTransaction(0, to, big.NewInt(10), testGas, big.NewInt(0), nil)),
+		signTestTx(t, keyB, etypes.NewTransaction(0, addrA, big.NewInt(0), testGas, big.NewInt(0), nil)),
+	)
+
+	// the empty coinbase collects the (zero) fees
+	expected := map[common.Address]bool{addrA: true, addrB: true, to: true, {}: true}
+	addrs := queryTestTouchedAccounts(t, app, 1)
+	if len(addrs) != len(expected) {
+		t.Fatalf("expected %d accounts, got %x", len(expected), addrs)
+	}
+	for i, addr := range addrs {
+		if !expected[addr] {
+			t.Fatalf("unexpected account %x", addr)
+		}
+		if i > 0 && string(addrs[i-1][:]) >= string(addr[:]) {
+			t.Fatal("accounts not sorted")
+		}
+	}
+
+	if addrs := queryTestTouchedAccounts(t, app, 2); len(addrs) != 0 {
+		t.Fatalf("expected no accounts for unknown block, got %x", addrs)
+	}
+	if res := app.Query([]byte{rtypes.QueryType_BlockTouchedAccounts, 1}); res.IsOK() {
+		t.Fatal("expected short height to be rejected")
+	}
+}
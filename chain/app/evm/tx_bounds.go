@@ -0,0 +1,85 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/params"
+)
+
+// The rlp encoding leaves the value and the gas price of a tx unbounded and the
+// nonce up to 2^64-1. CheckTx, the pool and, from tx_bounds_height, the block
+// execution all refuse txs out of the bounds below with the same errors, so a tx
+// CheckTx accepts is never invalidated by execution for its integers.
+var (
+	// ErrTxValueBounds rejects a value above 2^256-1 or max_tx_value
+	ErrTxValueBounds = errors.New("tx value out of bounds")
+	// ErrTxGasPriceBounds rejects a gas price above 2^256-1 or max_tx_gas_price
+	ErrTxGasPriceBounds = errors.New("tx gas price out of bounds")
+	// ErrTxNonceBounds rejects the nonce 2^64-1, the nonce of the sender can't
+	// be incremented past it
+	ErrTxNonceBounds = errors.New("tx nonce out of bounds")
+)
+
+// withTxBounds returns config with the max tx value and gas price set from decimal wei
+func withTxBounds(config *params.ChainConfig, maxValue, maxGasPrice string) (*params.ChainConfig, error) {
+	value, err := parseTxBound("max_tx_value", maxValue)
+	if err != nil {
+		return nil, err
+	}
+	gasPrice, err := parseTxBound("max_tx_gas_price", maxGasPrice)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil && gasPrice == nil {
+		return config, nil
+	}
+	bounded := *config
+	bounded.MaxTxValue, bounded.MaxTxGasPrice = value, gasPrice
+	return &bounded, nil
+}
+
+func parseTxBound(name, value string) (*big.Int, error) {
+	bound, ok := new(big.Int).SetString(value, 10)
+	if !ok || bound.Sign() < 0 || bound.BitLen() > 256 {
+		return nil, fmt.Errorf("invalid %s %q", name, value)
+	}
+	if bound.Sign() == 0 {
+		return nil, nil
+	}
+	return bound, nil
+}
+
+// checkTxBounds returns the error of the first integer of tx out of bounds
+func (app *EVMApp) checkTxBounds(tx *etypes.Transaction) error {
+	if outOfBounds(tx.Value(), app.chainConfig.MaxTxValue) {
+		return ErrTxValueBounds
+	}
+	if outOfBounds(tx.GasPrice(), app.chainConfig.MaxTxGasPrice) {
+		return ErrTxGasPriceBounds
+	}
+	if tx.Nonce() == math.MaxUint64 {
+		return ErrTxNonceBounds
+	}
+	return nil
+}
+
+func outOfBounds(v, max *big.Int) bool {
+	return v.Sign() < 0 || v.BitLen() > 256 || max != nil && v.Cmp(max) > 0
+}
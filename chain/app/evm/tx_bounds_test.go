@@ -0,0 +1,156 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// txBoundsErr keeps the bounds errors of err, the other errors depend on the
+// layer checking the tx
+func txBoundsErr(err error) error {
+	switch err {
+	case ErrTxValueBounds, ErrTxGasPriceBounds, ErrTxNonceBounds:
+		return err
+	}
+	return nil
+}
+
+func TestTxBounds(t *testing.T) {
+	conf := viper.New()
+	conf.Set("max_tx_value", "1000000000000000000000000000000")
+	conf.Set("max_tx_gas_price", "1000000000000")
+	conf.Set("tx_bounds_height", 1)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	pow := func(n uint) *big.Int { return new(big.Int).Lsh(big.NewInt(1), n) }
+	minus1 := func(v *big.Int) *big.Int { return new(big.Int).Sub(v, big.NewInt(1)) }
+	plus1 := func(v *big.Int) *big.Int { return new(big.Int).Add(v, big.NewInt(1)) }
+	maxValue, maxGasPrice := app.chainConfig.MaxTxValue, app.chainConfig.MaxTxGasPrice
+	extremes := []*big.Int{
+		big.NewInt(0), big.NewInt(1), minus1(pow(64)), pow(64), pow(255), minus1(pow(256)), pow(256), minus1(pow(512)),
+		maxValue, plus1(maxValue), maxGasPrice, plus1(maxGasPrice),
+	}
+	nonces := []uint64{0, 1, math.MaxInt64, math.MaxUint64 - 1, math.MaxUint64}
+
+	// a fixed seed, so a failing tx is the same on every run
+	rnd := rand.New(rand.NewSource(1))
+	expected := make(map[string]error)
+	var txs [][]byte
+	for i := 0; i < 200; i++ {
+		value, gasPrice := extremes[rnd.Intn(len(extremes))], extremes[rnd.Intn(len(extremes))]
+		nonce := nonces[rnd.Intn(len(nonces))]
+		key, _ := testKey(t, fmt.Sprintf("%064x", i+1))
+		raw := signTestTx(t, key, etypes.NewTransaction(nonce, common.HexToAddress("0x1234"), value, testGas, gasPrice, nil))
+		switch {
+		case value.Cmp(maxValue) > 0:
+			expected[string(raw)] = ErrTxValueBounds
+		case gasPrice.Cmp(maxGasPrice) > 0:
+			expected[string(raw)] = ErrTxGasPriceBounds
+		case nonce == math.MaxUint64:
+			expected[string(raw)] = ErrTxNonceBounds
+		default:
+			expected[string(raw)] = nil
+		}
+		txs = append(txs, raw)
+	}
+
+	for _, raw := range txs {
+		if err := txBoundsErr(app.CheckTx(raw)); err != expected[string(raw)] {
+			t.Fatalf("CheckTx: expected %v, got %v", expected[string(raw)], err)
+		}
+		if err := txBoundsErr(app.pool.ReceiveTx(raw)); err != expected[string(raw)] {
+			t.Fatalf("pool: expected %v, got %v", expected[string(raw)], err)
+		}
+	}
+	res := execTestBlock(t, app, 1, txs...)
+	executed := make(map[string]error)
+	for _, valid := range res.ValidTxs {
+		executed[string(valid)] = nil
+	}
+	for _, invalid := range res.InvalidTxs {
+		executed[string(invalid.Bytes)] = txBoundsErr(invalid.Error)
+	}
+	for _, raw := range txs {
+		err, ok := executed[string(raw)]
+		if !ok {
+			t.Fatal("expected every tx executed or invalidated")
+		}
+		if err != expected[string(raw)] {
+			t.Fatalf("execution: expected %v, got %v", expected[string(raw)], err)
+		}
+	}
+
+	// mangled encodings never panic, and are decoded or refused alike in every layer
+	for i := 0; i < 2000; i++ {
+		raw := common.CopyBytes(txs[rnd.Intn(len(txs))])
+		for n := rnd.Intn(4); n >= 0; n-- {
+			raw[rnd.Intn(len(raw))] = byte(rnd.Intn(256))
+		}
+		decodeErr := rlp.DecodeBytes(raw, new(etypes.Transaction))
+		checkErr, poolErr := app.CheckTx(raw), app.pool.ReceiveTx(raw)
+		if decodeErr != nil && (checkErr == nil || poolErr == nil) {
+			t.Fatalf("expected an undecodable tx refused, CheckTx %v, pool %v", checkErr, poolErr)
+		}
+		if txBoundsErr(checkErr) != txBoundsErr(poolErr) && poolErr != errTxExist {
+			t.Fatalf("expected the same bounds decision, CheckTx %v, pool %v", checkErr, poolErr)
+		}
+	}
+
+	// without a max, 2^256-1 still bounds the value and the gas price
+	if outOfBounds(minus1(pow(256)), nil) || !outOfBounds(pow(256), nil) {
+		t.Fatal("expected 2^256-1 to bound the integers without a max")
+	}
+}
+
+func TestTxBoundsConfig(t *testing.T) {
+	for _, value := range []string{"-1", "x", new(big.Int).Lsh(big.NewInt(1), 256).String()} {
+		conf := viper.New()
+		conf.Set("max_tx_value", value)
+		if _, err := NewEVMApp(conf); err == nil {
+			t.Fatalf("expected max_tx_value %s refused", value)
+		}
+	}
+}
+
+func TestTxBoundsHeight(t *testing.T) {
+	conf := viper.New()
+	conf.Set("max_tx_value", "1000")
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	// without tx_bounds_height the blocks run as they did before the bounds
+	key, _ := testKey(t, testKeyA)
+	raw := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(1001), testGas, big.NewInt(0), nil))
+	if err := app.CheckTx(raw); err != ErrTxValueBounds {
+		t.Fatalf("expected CheckTx to fail with %v, got %v", ErrTxValueBounds, err)
+	}
+	res := execTestBlock(t, app, 1, raw)
+	for _, invalid := range res.InvalidTxs {
+		if txBoundsErr(invalid.Error) != nil {
+			t.Fatalf("expected no bounds check before the activation height, got %v", invalid.Error)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// The encodings of a tx import stream
+const (
+	TxImportHex = "hex" // a hex encoded signed tx per line, 0x prefix optional, blank lines skipped
+	TxImportRLP = "rlp" // a rlp list of byte strings, each a rlp encoded signed tx
+)
+
+const (
+	txImportMaxErrors = 10
+	txImportWait      = time.Minute
+	txImportPoll      = 10 * time.Millisecond
+	txImportMaxTx     = 512 << 10           // max bytes of a raw tx
+	txImportMaxLine   = 2*txImportMaxTx + 2 // a max tx hex encoded, 0x prefixed
+	txImportBatch     = 256                 // txs whose senders are recovered at once
+)
+
+// ErrTxImportStalled stops an import the tx pool had no room for during the
+// import wait, the txs imported so far stay in the pool.
+var ErrTxImportStalled = errors.New("tx pool full for too long, import stopped")
+
+// TxImportOptions tunes ImportTransactions
+type TxImportOptions struct {
+	Format    string        // TxImportHex or TxImportRLP
+	MaxErrors int           // rejections detailed in the report, 0 for 10
+	Wait      time.Duration // max wait for room in the tx pool, 0 for a minute
+}
+
+// TxImportError is a tx rejected by an import, Index is its position in the stream
+type TxImportError struct {
+	Index  int         `json:"index"`
+	Hash   common.Hash `json:"hash"`
+	Reason string      `json:"reason"`
+}
+
+// TxImportReport sums up an import
+type TxImportReport struct {
+	Accepted int             `json:"accepted"`
+	Rejected int             `json:"rejected"`
+	Errors   []TxImportError `json:"errors"` // the first rejections
+}
+
+// ImportTransactions streams the signed txs encoded in r into the tx pool. Each
+// tx is checked like a newly received one, the import only waits while the tx
+// pool is full, so a stream of any size takes no more memory than the pool. The
+// txs are read in batches whose senders are recovered in parallel, then added in
+// stream order: a failing stream stops the import and the report of the txs
+// read so far is returned along with the error.
+func (app *EVMApp) ImportTransactions(r io.Reader, opts TxImportOptions) (*TxImportReport, error) {
+	if opts.MaxErrors <= 0 {
+		opts.MaxErrors = txImportMaxErrors
+	}
+	if opts.Wait <= 0 {
+		opts.Wait = txImportWait
+	}
+	report := &TxImportReport{Errors: make([]TxImportError, 0)}
+	next, err := txImportReader(r, opts.Format)
+	if err != nil {
+		return report, err
+	}
+	for index := 0; ; {
+		batch, end := readTxImportBatch(next)
+		app.recoverSenders(batch)
+		for _, item := range batch {
+			err := item.err
+			if err == nil {
+				if err = app.importTx(item.raw, opts.Wait); err == ErrTxImportStalled {
+					return report, err
+				}
+			}
+			if err != nil {
+				report.reject(index, item.raw, err, opts.MaxErrors)
+			} else {
+				report.Accepted++
+			}
+			index++
+		}
+		if end == io.EOF {
+			return report, nil
+		} else if end != nil {
+			return report, end
+		}
+	}
+}
+
+// txImportItem is a raw tx read from an import stream, or the error decoding it
+type txImportItem struct {
+	raw []byte
+	err error
+}
+
+// readTxImportBatch reads up to txImportBatch txs with next, and the error ending
+// the stream if it's reached.
+func readTxImportBatch(next func() ([]byte, error)) ([]txImportItem, error) {
+	batch := make([]txImportItem, 0, txImportBatch)
+	for len(batch) < txImportBatch {
+		raw, err := next()
+		if _, ok := err.(txImportStreamError); ok || err == io.EOF {
+			return batch, err
+		}
+		batch = append(batch, txImportItem{raw, err})
+	}
+	return batch, nil
+}
+
+// recoverSenders recovers the senders of the txs of batch in parallel, for the
+// sender cache to answer CheckTx and the tx pool.
+func (app *EVMApp) recoverSenders(batch []txImportItem) {
+	items := make(chan []byte)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for raw := range items {
+				tx := new(etypes.Transaction)
+				if rlp.DecodeBytes(raw, tx) == nil {
+					app.senders.sender(app.Signer, tx)
+				}
+			}
+		}()
+	}
+	for _, item := range batch {
+		if item.err == nil {
+			items <- item.raw
+		}
+	}
+	close(items)
+	wg.Wait()
+}
+
+func (report *TxImportReport) reject(index int, raw []byte, err error, maxErrors int) {
+	report.Rejected++
+	if len(report.Errors) >= maxErrors {
+		return
+	}
+	var hash common.Hash
+	tx := new(etypes.Transaction)
+	if rlp.DecodeBytes(raw, tx) == nil {
+		hash = tx.Hash()
+	}
+	report.Errors = append(report.Errors, TxImportError{Index: index, Hash: hash, Reason: err.Error()})
+}
+
+// importTx adds raw to the tx pool, waiting up to wait for the pool to have room.
+// A busy CheckTx or a full pool are waited for too.
+func (app *EVMApp) importTx(raw []byte, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		if app.pool.hasRoom() {
+			err := app.CheckTx(raw)
+			if err == nil {
+				err = app.pool.ReceiveTx(raw)
+			}
+			if err != ErrCheckTxBusy && err != errTxPoolWaitingQueueIsFull {
+				return err
+			}
+		}
+		if time.Now().After(deadline) {
+			return ErrTxImportStalled
+		}
+		time.Sleep(txImportPoll)
+	}
+}
+
+// txImportStreamError is a failure of the stream itself, which stops the import
+type txImportStreamError struct{ error }
+
+// txImportReader returns the iterator over the raw txs of r encoded in format,
+// io.EOF ends them. Malformed txs are returned as errors, the iteration goes on.
+func txImportReader(r io.Reader, format string) (func() ([]byte, error), error) {
+	switch format {
+	case TxImportHex:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), txImportMaxLine)
+		return func() ([]byte, error) {
+			for scanner.Scan() {
+				line := bytes.TrimSpace(scanner.Bytes())
+				if len(line) == 0 {
+					continue
+				}
+				if !bytes.HasPrefix(line, []byte("0x")) && !bytes.HasPrefix(line, []byte("0X")) {
+					line = append([]byte("0x"), line...)
+				}
+				return hexutil.Decode(string(line))
+			}
+			if err := scanner.Err(); err != nil {
+				return nil, txImportStreamError{err}
+			}
+			return nil, io.EOF
+		}, nil
+	case TxImportRLP:
+		stream := rlp.NewStream(r, 0)
+		if _, err := stream.List(); err != nil {
+			return nil, txImportStreamError{err}
+		}
+		return func() ([]byte, error) {
+			// the size is checked before Bytes allocates it
+			if _, size, err := stream.Kind(); err == rlp.EOL {
+				return nil, io.EOF
+			} else if err != nil {
+				return nil, txImportStreamError{err}
+			} else if size > txImportMaxTx {
+				return nil, txImportStreamError{fmt.Errorf("tx of %d bytes over the %d bytes limit", size, txImportMaxTx)}
+			}
+			raw, err := stream.Bytes()
+			if err != nil {
+				return nil, txImportStreamError{err}
+			}
+			return raw, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid tx import format %q", format)
+}
+
+// httpTxImportPath takes the stream in the body, ?format=hex|rlp, default hex,
+// and &errors=N, and answers the json TxImportReport. The import holds the
+// request as long as it runs.
+const httpTxImportPath = "/import"
+
+// startTxImport serves the tx import on laddr. It's an admin command: requests
+// must carry the token as "Authorization: Bearer <token>".
+func (app *EVMApp) startTxImport(laddr, token string) error {
+	if token == "" {
+		return errors.New("tx_import_token required to serve the tx import")
+	}
+	listener, err := net.Listen("tcp", laddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpTxImportPath, app.serveTxImport(token))
+	// no read and write timeouts, an import waits for the blocks emptying the pool
+	app.txImport = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: httpQueryReadTimeout,
+		MaxHeaderBytes:    httpQueryMaxHeader,
+	}
+	go func(srv *http.Server) {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("tx import server stopped", zap.Error(err))
+		}
+	}(app.txImport)
+	log.Info("tx import server started", zap.String("laddr", listener.Addr().String()))
+	return nil
+}
+
+func (app *EVMApp) serveTxImport(token string) http.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeHTTPQuery(w, http.StatusUnauthorized, &httpQueryError{gtypes.CodeType_Unauthorized, "unauthorized"})
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeHTTPQueryError(w, gtypes.CodeType_BaseInvalidInput, "method not allowed")
+			return
+		}
+		opts := TxImportOptions{Format: r.URL.Query().Get("format")}
+		if opts.Format == "" {
+			opts.Format = TxImportHex
+		}
+		if n := r.URL.Query().Get("errors"); n != "" {
+			var err error
+			if opts.MaxErrors, err = strconv.Atoi(n); err != nil {
+				writeHTTPQueryError(w, gtypes.CodeType_BaseInvalidInput, "invalid errors")
+				return
+			}
+		}
+		report, err := app.ImportTransactions(r.Body, opts)
+		if err != nil {
+			log.Warn("tx import stopped", zap.Int("accepted", report.Accepted), zap.Error(err))
+			code, status := gtypes.CodeType_BaseInvalidInput, http.StatusBadRequest
+			if err == ErrTxImportStalled {
+				code, status = gtypes.CodeType_ServerBusy, http.StatusServiceUnavailable
+			}
+			// the txs accepted before the failure stay in the pool, so the
+			// report comes along with the error
+			writeHTTPQuery(w, status, &struct {
+				*TxImportReport
+				Error *httpQueryError `json:"error"`
+			}{report, &httpQueryError{code, err.Error()}})
+			return
+		}
+		writeHTTPQuery(w, http.StatusOK, report)
+	}
+}
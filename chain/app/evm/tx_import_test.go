@@ -0,0 +1,215 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func TestImportTransactions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("imports 50k txs")
+	}
+	const senders, perSender = 50, 1000
+	conf := viper.New()
+	conf.Set("block_size", 1000)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	keys := make([]*ecdsa.PrivateKey, senders)
+	addrs := make([]common.Address, senders)
+	for i := range keys {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i], addrs[i] = key, crypto.PubkeyToAddress(key.PublicKey)
+	}
+	fundTestAccounts(t, app, big.NewInt(perSender), addrs...)
+	to := common.HexToAddress("0x1234")
+
+	file, err := ioutil.TempFile("", "tx-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	// signed by sender in parallel, written by nonce
+	signed := make([][][]byte, senders)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key *ecdsa.PrivateKey) {
+			defer wg.Done()
+			for nonce := uint64(0); nonce < perSender; nonce++ {
+				tx, _ := etypes.SignTx(etypes.NewTransaction(nonce, to, big.NewInt(1), testGas, big.NewInt(0), nil), EthSigner, key)
+				raw, _ := rlp.EncodeToBytes(tx)
+				signed[i] = append(signed[i], raw)
+			}
+		}(i, key)
+	}
+	wg.Wait()
+	w := bufio.NewWriter(file)
+	for nonce := 0; nonce < perSender; nonce++ {
+		for i := range keys {
+			w.WriteString("0x" + hex.EncodeToString(signed[i][nonce]) + "\n")
+		}
+	}
+	signed = nil
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		report *TxImportReport
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		report, err := app.ImportTransactions(file, TxImportOptions{Format: TxImportHex})
+		done <- result{report, err}
+	}()
+
+	// the pool holds at most 10 blocks of txs, the import goes on as blocks
+	// empty it
+	var imported *result
+	deadline := time.Now().Add(2 * time.Minute)
+	for height := int64(1); imported == nil || app.pool.Size() > 0; height++ {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool not emptied after %d blocks, size %d", height, app.pool.Size())
+		}
+		select {
+		case res := <-done:
+			imported = &res
+		default:
+		}
+		var txs [][]byte
+		for _, tx := range app.pool.Reap(1000) {
+			txs = append(txs, tx)
+		}
+		if len(txs) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		execTestBlock(t, app, height, txs...)
+	}
+	if imported.err != nil || imported.report.Accepted != senders*perSender || imported.report.Rejected != 0 {
+		t.Fatalf("unexpected import %+v %v", imported.report, imported.err)
+	}
+	if balance := app.state.GetBalance(to); balance.Int64() != senders*perSender {
+		t.Fatalf("expected every transfer committed, got %v", balance)
+	}
+}
+
+func TestImportTransactionsRejects(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	fundTestAccounts(t, app, big.NewInt(100), addr)
+	transfer := func(nonce uint64, value int64) []byte {
+		return signTestTx(t, key, etypes.NewTransaction(nonce, addr, big.NewInt(value), testGas, big.NewInt(0), nil))
+	}
+	txs := [][]byte{transfer(0, 1), {0x01}, transfer(1, 1000), transfer(1, 1)}
+	stream, err := rlp.EncodeToBytes(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := app.ImportTransactions(bytes.NewReader(stream), TxImportOptions{Format: TxImportRLP, MaxErrors: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accepted != 2 || report.Rejected != 2 || len(report.Errors) != 1 || report.Errors[0].Index != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// a truncated stream stops the import with the report so far, the reader
+	// hides its length like a network stream
+	truncated := io.MultiReader(bytes.NewReader(stream[:len(stream)-10]))
+	report, err = app.ImportTransactions(truncated, TxImportOptions{Format: TxImportRLP})
+	if err == nil || report.Rejected != 3 {
+		t.Fatalf("expected a truncated stream to fail after the first txs, got %+v %v", report, err)
+	}
+	// a tx size over the limit stops the import before it's read
+	huge := io.MultiReader(bytes.NewReader([]byte{0xfb, 0x40, 0x00, 0x00, 0x05, 0xbb, 0x40, 0x00, 0x00, 0x00}))
+	if _, err := app.ImportTransactions(huge, TxImportOptions{Format: TxImportRLP}); err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Fatalf("expected a tx over the size limit to stop the import, got %v", err)
+	}
+	if _, err := app.ImportTransactions(bytes.NewReader(stream), TxImportOptions{Format: "json"}); err == nil {
+		t.Fatal("expected an invalid format to be rejected")
+	}
+}
+
+func TestTxImportAuthorization(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+	if err := app.startTxImport("127.0.0.1:0", ""); err == nil {
+		t.Fatal("expected the tx import to require a token")
+	}
+	key, addr := testKey(t, testKeyA)
+	fundTestAccounts(t, app, big.NewInt(100), addr)
+	body := hex.EncodeToString(signTestTx(t, key, etypes.NewTransaction(0, addr, big.NewInt(1), testGas, big.NewInt(0), nil))) + "\n"
+
+	handler := app.serveTxImport("secret")
+	for _, c := range []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer other", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	} {
+		r, err := http.NewRequest(http.MethodPost, httpTxImportPath, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != c.status {
+			t.Fatalf("authorization %q: expected status %d, got %d %s", c.auth, c.status, w.Code, w.Body.String())
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		var report TxImportReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || report.Accepted != 1 {
+			t.Fatalf("unexpected report %s %v", w.Body.String(), err)
+		}
+	}
+}
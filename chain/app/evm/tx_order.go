@@ -0,0 +1,104 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/metrics"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// tx_order values, how the txs of a sender out of nonce order in a block are
+// handled. Reordering changes the block results, so every validator must run
+// the same tx_order.
+const (
+	txOrderOff     = "off"     // execute in block order
+	txOrderCheck   = "check"   // execute in block order, recording the out of order txs
+	txOrderReorder = "reorder" // record, then execute the txs of each sender in nonce order
+)
+
+var txOrderViolationsMeter = metrics.NewRegisteredMeter("evm/execute/order/violations", nil)
+
+func validTxOrder(mode string) bool {
+	return mode == txOrderOff || mode == txOrderCheck || mode == txOrderReorder
+}
+
+// orderBlockTxs returns the txs of the block at height to execute and the number
+// of txs following a tx of the same sender with a higher nonce. When reordering,
+// the txs of each sender are sorted by nonce into the positions the sender's txs
+// take in the block, so the txs of other senders keep their position. Txs which
+// can't be decoded or whose sender can't be recovered keep their position too,
+// and fail on execution.
+func (app *EVMApp) orderBlockTxs(height int64, txs gtypes.Txs) (gtypes.Txs, int) {
+	if app.txOrder == txOrderOff {
+		return txs, 0
+	}
+	type senderTx struct {
+		pos   int
+		nonce uint64
+	}
+	bySender := make(map[common.Address][]senderTx)
+	var senders []common.Address // in order of first appearance, to reorder deterministically
+	violations := 0
+	for i, raw := range txs {
+		tx := new(etypes.Transaction)
+		if err := rlp.DecodeBytes(raw, tx); err != nil {
+			continue
+		}
+		from, err := app.senders.sender(app.Signer, tx)
+		if err != nil {
+			continue
+		}
+		prev, ok := bySender[from]
+		if !ok {
+			senders = append(senders, from)
+		}
+		for _, p := range prev {
+			if p.nonce > tx.Nonce() {
+				violations++
+				log.Warn("block tx out of nonce order", zap.Int64("height", height), zap.Int("index", i),
+					zap.String("sender", from.Hex()), zap.Uint64("nonce", tx.Nonce()), zap.Uint64("after", p.nonce))
+				break
+			}
+		}
+		bySender[from] = append(prev, senderTx{pos: i, nonce: tx.Nonce()})
+	}
+	if violations == 0 {
+		return txs, 0
+	}
+	txOrderViolationsMeter.Mark(int64(violations))
+	if app.txOrder != txOrderReorder {
+		return txs, violations
+	}
+
+	ordered := make(gtypes.Txs, len(txs))
+	copy(ordered, txs)
+	for _, from := range senders {
+		stxs := bySender[from]
+		sorted := make([]senderTx, len(stxs))
+		copy(sorted, stxs)
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].nonce < sorted[j].nonce })
+		for i, stx := range stxs {
+			ordered[stx.pos] = txs[sorted[i].pos]
+		}
+	}
+	return ordered, violations
+}
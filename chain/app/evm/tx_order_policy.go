@@ -0,0 +1,119 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// ErrTxOrderPolicy invalidates every tx of a block out of the tx order policy
+var ErrTxOrderPolicy = errors.New("block txs out of the tx order policy")
+
+var txOrderPolicies = map[string]params.TxOrderPolicy{
+	"none":         params.TxOrderNone,
+	"hash":         params.TxOrderHash,
+	"sender-nonce": params.TxOrderSenderNonce,
+}
+
+// withTxOrderPolicy returns config with the block tx order policy of name set
+func withTxOrderPolicy(config *params.ChainConfig, name string) (*params.ChainConfig, error) {
+	policy, ok := txOrderPolicies[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid tx_order_policy %q", name)
+	}
+	if policy == params.TxOrderNone {
+		return config, nil
+	}
+	ordered := *config
+	ordered.TxOrderPolicy = policy
+	return &ordered, nil
+}
+
+// policyTx is a tx with the fields ordering it
+type policyTx struct {
+	raw    gtypes.Tx
+	hash   common.Hash
+	sender common.Address
+	nonce  uint64
+}
+
+// sortByTxOrderPolicy sorts txs in the canonical order of policy
+func sortByTxOrderPolicy(policy params.TxOrderPolicy, txs []policyTx) {
+	switch policy {
+	case params.TxOrderHash:
+		sort.SliceStable(txs, func(i, j int) bool { return bytes.Compare(txs[i].hash[:], txs[j].hash[:]) < 0 })
+	case params.TxOrderSenderNonce:
+		// a group is keyed by the hash of the sender's lowest nonce tx
+		lowest := make(map[common.Address]policyTx)
+		for _, tx := range txs {
+			if first, ok := lowest[tx.sender]; !ok || tx.nonce < first.nonce {
+				lowest[tx.sender] = tx
+			}
+		}
+		sort.SliceStable(txs, func(i, j int) bool {
+			if txs[i].sender != txs[j].sender {
+				gi, gj := lowest[txs[i].sender].hash, lowest[txs[j].sender].hash
+				return bytes.Compare(gi[:], gj[:]) < 0
+			}
+			return txs[i].nonce < txs[j].nonce
+		})
+	}
+}
+
+// checkTxOrderPolicy returns ErrTxOrderPolicy unless the eth txs of the block
+// follow the tx order policy. Txs which aren't eth txs, or whose sender can't be
+// recovered, are left out of the order and fail on execution.
+func (app *EVMApp) checkTxOrderPolicy(txs gtypes.Txs) error {
+	policy := app.chainConfig.TxOrderPolicy
+	if policy == params.TxOrderNone {
+		return nil
+	}
+	ptxs := make([]policyTx, 0, len(txs))
+	for _, raw := range txs {
+		tx := new(etypes.Transaction)
+		if err := rlp.DecodeBytes(raw, tx); err != nil {
+			continue
+		}
+		from, err := app.senders.sender(app.Signer, tx)
+		if err != nil {
+			continue
+		}
+		ptxs = append(ptxs, policyTx{raw: raw, hash: tx.Hash(), sender: from, nonce: tx.Nonce()})
+	}
+	sorted := make([]policyTx, len(ptxs))
+	copy(sorted, ptxs)
+	sortByTxOrderPolicy(policy, sorted)
+	for i := range ptxs {
+		if ptxs[i].hash != sorted[i].hash {
+			return ErrTxOrderPolicy
+		}
+	}
+	return nil
+}
+
+// rejectBlockTxs invalidates every tx of the block with err
+func rejectBlockTxs(txs gtypes.Txs, res *gtypes.ExecuteResult, err error) {
+	for _, raw := range txs {
+		res.InvalidTxs = append(res.InvalidTxs, gtypes.ExecuteInvalidTx{Bytes: raw, Error: err})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func TestTxOrderPolicy(t *testing.T) {
+	keyA, _ := testKey(t, testKeyA)
+	keyB, _ := testKey(t, testKeyB)
+	to := common.HexToAddress("0x01")
+	txs := [][]byte{
+		signTestTx(t, keyA, etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(0), nil)),
+		signTestTx(t, keyA, etypes.NewTransaction(1, to, big.NewInt(0), testGas, big.NewInt(0), nil)),
+		signTestTx(t, keyB, etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(0), nil)),
+	}
+
+	for _, policy := range []string{"hash", "sender-nonce"} {
+		var appHashes []common.Hash
+		for node := 0; node < 2; node++ {
+			conf := viper.New()
+			conf.Set("tx_order_policy", policy)
+			app, clean := newTestAppWithConfig(t, conf)
+			defer clean()
+
+			// the later nonce first, so both txs of the sender are promoted to pending together
+			for _, raw := range [][]byte{txs[1], txs[0], txs[2]} {
+				if err := app.pool.ReceiveTx(raw); err != nil {
+					t.Fatal(err)
+				}
+			}
+			app.pool.updateToState()
+			reaped := app.pool.Reap(-1)
+			// by hash, the second tx of a sender waits if its hash is lower than the first's
+			if len(reaped) < len(txs)-1 {
+				t.Fatalf("%s: expected at least %d txs reaped, got %d", policy, len(txs)-1, len(reaped))
+			}
+			reversed := make([][]byte, len(reaped))
+			for i, raw := range reaped {
+				reversed[len(reaped)-1-i] = raw
+			}
+
+			// out of order, the whole block is rejected without touching the state
+			appHash := app.getLastAppHash()
+			res := execTestBlock(t, app, 1, reversed...)
+			if len(res.ValidTxs) != 0 || len(res.InvalidTxs) != len(reaped) || res.InvalidTxs[0].Error != ErrTxOrderPolicy {
+				t.Fatalf("%s: expected the block rejected, got %d valid txs", policy, len(res.ValidTxs))
+			}
+			if app.getLastAppHash() != appHash {
+				t.Fatalf("%s: expected the rejected block to leave the state untouched", policy)
+			}
+
+			// the reaped order complies
+			block := make([][]byte, len(reaped))
+			for i, raw := range reaped {
+				block[i] = raw
+			}
+			if res := execTestBlock(t, app, 2, block...); len(res.ValidTxs) != len(reaped) {
+				t.Fatalf("%s: expected the reaped block accepted, got invalid txs %+v", policy, res.InvalidTxs)
+			}
+			appHashes = append(appHashes, app.getLastAppHash())
+		}
+		if appHashes[0] != appHashes[1] {
+			t.Fatalf("%s: nodes disagree on the app hash: %x %x", policy, appHashes[0], appHashes[1])
+		}
+	}
+
+	conf := viper.New()
+	conf.Set("tx_order_policy", "fifo")
+	if _, err := NewEVMApp(conf); err == nil {
+		t.Fatal("expected an unknown tx_order_policy rejected")
+	}
+}
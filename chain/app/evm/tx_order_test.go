@@ -0,0 +1,63 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func TestTxOrder(t *testing.T) {
+	for _, c := range []struct {
+		mode       string
+		violations int
+		valid      int
+	}{
+		{txOrderOff, 0, 2},
+		{txOrderCheck, 1, 2},
+		{txOrderReorder, 1, 3},
+	} {
+		conf := viper.New()
+		conf.Set("tx_order", c.mode)
+		app, clean := newTestAppWithConfig(t, conf)
+
+		keyA, _ := testKey(t, testKeyA)
+		keyB, _ := testKey(t, testKeyB)
+		to := common.HexToAddress("0x01")
+		second := signTestTx(t, keyA, etypes.NewTransaction(1, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+		other := signTestTx(t, keyB, etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+		first := signTestTx(t, keyA, etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(0), nil))
+		res := execTestBlock(t, app, 1, second, other, first)
+		clean()
+
+		if app.txOrderViolations != c.violations || len(res.ValidTxs) != c.valid {
+			t.Fatalf("%s: expected %d violations and %d valid txs, got %d and %d", c.mode, c.violations, c.valid, app.txOrderViolations, len(res.ValidTxs))
+		}
+		if c.mode == txOrderReorder && (!bytes.Equal(res.ValidTxs[0], first) || !bytes.Equal(res.ValidTxs[1], other) || !bytes.Equal(res.ValidTxs[2], second)) {
+			t.Fatal("expected the sender's txs swapped around the other sender's tx")
+		}
+	}
+
+	conf := viper.New()
+	conf.Set("tx_order", "sort")
+	if _, err := NewEVMApp(conf); err == nil {
+		t.Fatal("expected an unknown tx_order rejected")
+	}
+}
@@ -24,8 +24,11 @@ import (
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 
+	rtypes "github.com/dappledger/AnnChain/chain/types"
 	"github.com/dappledger/AnnChain/eth/common"
 	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/params"
 	"github.com/dappledger/AnnChain/eth/rlp"
 	"github.com/dappledger/AnnChain/gemmill/modules/go-clist"
 	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
@@ -35,6 +38,8 @@ import (
 const (
 	txEvictInterval = 1 * time.Minute
 	waitingLifeTime = 10 * time.Minute
+
+	errNonceTooLow = "nonce too low"
 )
 
 var (
@@ -48,6 +53,8 @@ type ethTxPool struct {
 	waitingBeats    map[common.Address]time.Time    // Last heartbeat from each known address
 	broadcastQueue  *clist.CList                    // list of txs to broadcast
 	all             map[common.Hash]types.Tx        // tx cache for lookup
+	arrivals        map[common.Hash]int64           // unix nanoseconds each pooled tx was received at
+	lastArrival     int64                           // arrival of the last tx received
 	extTxs          *clist.CList                    // extra transcations except Ethereum Transaction, eg. adminOP
 	mtx             sync.Mutex
 	app             *EVMApp
@@ -56,11 +63,18 @@ type ethTxPool struct {
 	pendingLimit    int           // pending queue size limit
 	height          int64
 	filter          []types.IFilter
+	reapPrevalidate bool                         // re-check nonce and balance of reaped txs against state
+	demoteBackoff   int64                        // blocks demoted txs are first held back for
+	holds           map[common.Address]*reapHold // accounts whose demoted txs are held back
+	duplicateNonce  string                       // duplicate_nonce policy
+	reapOrder       string                       // reap_order of pending txs
+	reapTieBreak    string                       // reap_tie_break of txs of the same gas price
 }
 
 func NewEthTxPool(app *EVMApp, conf *viper.Viper) *ethTxPool {
 	return &ethTxPool{
 		all:             make(map[common.Hash]types.Tx),
+		arrivals:        make(map[common.Hash]int64),
 		waiting:         make(map[common.Address]*txSortedMap),
 		waitingBeats:    make(map[common.Address]time.Time),
 		pending:         make(map[common.Address]*txSortedMap),
@@ -69,6 +83,12 @@ func NewEthTxPool(app *EVMApp, conf *viper.Viper) *ethTxPool {
 		waitingLimit:    conf.GetInt("block_size") * 10,
 		pendingLimit:    conf.GetInt("block_size") * 10,
 		waitingLifeTime: waitingLifeTime,
+		reapPrevalidate: conf.GetBool("reap_prevalidate"),
+		demoteBackoff:   conf.GetInt64("reap_demote_backoff"),
+		holds:           make(map[common.Address]*reapHold),
+		duplicateNonce:  conf.GetString("duplicate_nonce"),
+		reapOrder:       conf.GetString("reap_order"),
+		reapTieBreak:    conf.GetString("reap_tie_break"),
 		app:             app,
 	}
 }
@@ -86,21 +106,31 @@ func (tp *ethTxPool) loop() {
 		select {
 		case <-evict.C:
 			tp.Lock()
-			for addr := range tp.waitingBeats {
-				if time.Since(tp.waitingBeats[addr]) > tp.waitingLifeTime {
-					if tp.waiting[addr].Get(tp.safeGetNonce(addr)) != nil {
-						continue
-					}
-
-					// waiting queue of account does not have the pending nonce, delete all its waiting tx
-					for _, tx := range tp.waiting[addr].Flatten() {
-						delete(tp.all, tx.Hash())
-					}
-					delete(tp.waitingBeats, addr)
-					delete(tp.waiting, addr)
-				}
-			}
+			tp.evictStaleWaiting()
 			tp.Unlock()
+			tp.app.txStatus.prune(time.Now())
+			tp.app.senders.prune(time.Now())
+			tp.app.poolDrops.publish(tp.watermarks())
+		}
+	}
+}
+
+// evictStaleWaiting drops waiting txs of accounts not heard from within waitingLifeTime
+func (tp *ethTxPool) evictStaleWaiting() {
+	for addr := range tp.waitingBeats {
+		if time.Since(tp.waitingBeats[addr]) > tp.waitingLifeTime {
+			if tp.waiting[addr].Get(tp.safeGetNonce(addr)) != nil {
+				continue
+			}
+
+			// waiting queue of account does not have the pending nonce, delete all its waiting tx
+			for _, tx := range tp.waiting[addr].Flatten() {
+				tp.forget(tx.Hash())
+				tp.app.txStatus.expired(tx.Hash())
+				tp.app.poolDrops.record(rtypes.PoolDrop_Expired)
+			}
+			delete(tp.waitingBeats, addr)
+			delete(tp.waiting, addr)
 		}
 	}
 }
@@ -121,7 +151,8 @@ func (tp *ethTxPool) RegisterFilter(filter types.IFilter) {
 	tp.filter = append(tp.filter, filter)
 }
 
-// reap txpool txs to make block
+// reap txpool txs to make block, in the reap_order of the pool, see reapBefore
+// for the order of txs of the same gas price
 func (tp *ethTxPool) Reap(maxTxs int) []types.Tx {
 	tp.Lock()
 	defer tp.Unlock()
@@ -140,27 +171,144 @@ func (tp *ethTxPool) Reap(maxTxs int) []types.Tx {
 		allTxs = append(allTxs, extTxs...)
 	}
 
+	var (
+		validator *reapValidator
+		stale     = make(map[common.Address]etypes.Transactions)
+		demoted   = make(map[common.Address]reapDemoted)
+		// the block execution invalidates the txs beyond it
+		senderLimit = tp.app.chainConfig.MaxTxsPerSender
+		// normal txs reaped, put in the canonical order of the tx order policy
+		orderPolicy = tp.app.chainConfig.TxOrderPolicy
+		ordered     []policyTx
+		normalStart = len(allTxs)
+		// under reap_order price the txs of each sender, merged once all are known
+		byPrice  = tp.reapOrder == reapOrderPrice
+		bySender = make(map[common.Address][]*reapTx)
+		// the txs beyond the user gas of the block would be invalidated
+		gasBudget = &reapGasBudget{limit: tp.app.userBlockGas()}
+	)
+	if tp.reapPrevalidate {
+		tp.app.stateMtx.Lock()
+		validator = newReapValidator(tp.app.state)
+	}
+
 OUTLOOP: // reap normal txs
-	for _, accountTxs := range tp.pending {
-		for _, tx := range accountTxs.Flatten() {
+	for addr, accountTxs := range tp.pending {
+		txs := accountTxs.Flatten()
+		var reaped uint64
+		for i, tx := range txs {
+			if senderLimit > 0 && reaped == senderLimit {
+				break
+			}
+			// in ascending hash order the sender's txs after a lower hash would run
+			// before their nonce, they wait for the next block
+			if orderPolicy == params.TxOrderHash && i > 0 && bytes.Compare(tx.Hash().Bytes(), txs[i-1].Hash().Bytes()) < 0 {
+				break
+			}
+			// the account's txs are held back from the first one failing, rather
+			// than checked again on every proposal
+			if floor := tp.app.minGasPrice(tx.To()); tx.GasPrice().Cmp(floor) < 0 {
+				demoted[addr] = reapDemoted{txs: txs[i:], reason: errReapUnderpriced}
+				continue OUTLOOP
+			}
+			if validator != nil {
+				switch validator.check(addr, tx) {
+				case reapStale:
+					stale[addr] = append(stale[addr], tx)
+					continue
+				case reapUnexecutable:
+					demoted[addr] = reapDemoted{txs: txs[i:], reason: errReapUnexecutable}
+					continue OUTLOOP
+				}
+			}
 			txBytes, exist := tp.all[tx.Hash()]
 			if !exist {
 				// cache miss
 				txBytes, _ = rlp.EncodeToBytes(tx)
 			}
+			if byPrice {
+				bySender[addr] = append(bySender[addr], &reapTx{tx: tx, raw: txBytes, sender: addr, arrival: tp.arrivals[tx.Hash()]})
+				reaped++
+				continue
+			}
+			if !gasBudget.take(tx) {
+				continue OUTLOOP
+			}
 			allTxs = append(allTxs, txBytes)
+			if orderPolicy != params.TxOrderNone {
+				ordered = append(ordered, policyTx{raw: txBytes, hash: tx.Hash(), sender: addr, nonce: tx.Nonce()})
+			}
+			reaped++
 			if len(allTxs) == maxTxs {
 				break OUTLOOP
 			}
 		}
 	}
+	if validator != nil {
+		tp.app.stateMtx.Unlock()
+	}
+	if byPrice {
+		fits := func(rtx *reapTx) bool { return gasBudget.take(rtx.tx) }
+		for _, rtx := range sortByPrice(bySender, maxTxs-len(allTxs), tp.reapTieBreak, fits) {
+			allTxs = append(allTxs, rtx.raw)
+			if orderPolicy != params.TxOrderNone {
+				ordered = append(ordered, policyTx{raw: rtx.raw, hash: rtx.tx.Hash(), sender: rtx.sender, nonce: rtx.tx.Nonce()})
+			}
+		}
+	}
+	if orderPolicy != params.TxOrderNone {
+		sortByTxOrderPolicy(orderPolicy, ordered)
+		for i, ptx := range ordered {
+			allTxs[normalStart+i] = ptx.raw
+		}
+	}
+	tp.dropReapRejected(stale, demoted)
 	log.Debug("reap return txs", zap.Int("count", len(allTxs)))
 	return allTxs
 }
 
+// dropReapRejected removes txs rejected when reaping from pending, stale txs are
+// dropped, demoted ones go back to the waiting queue and are held back there.
+func (tp *ethTxPool) dropReapRejected(stale map[common.Address]etypes.Transactions, demoted map[common.Address]reapDemoted) {
+	for addr, txs := range stale {
+		pending := tp.pending[addr]
+		for _, tx := range txs {
+			pending.Remove(tx.Nonce())
+			tp.forget(tx.Hash())
+			tp.app.txStatus.evicted(tx.Hash(), errNonceTooLow)
+			tp.app.poolDrops.record(rtypes.PoolDrop_StaleNonce)
+		}
+	}
+	for addr, d := range demoted {
+		pending := tp.pending[addr]
+		until := tp.holdBack(addr)
+		for _, tx := range d.txs {
+			pending.Remove(tx.Nonce())
+			if err := tp.addWaiting(tx, addr); err != nil {
+				tp.forget(tx.Hash())
+				tp.app.txStatus.evicted(tx.Hash(), err.Error())
+				tp.app.poolDrops.recordErr(err)
+				continue
+			}
+			tp.app.txStatus.demoted(tx.Hash(), d.reason, uint64(until))
+		}
+		reapDemotedMeter.Mark(int64(len(d.txs)))
+	}
+	for addr, pending := range tp.pending {
+		if pending.Len() == 0 {
+			delete(tp.pending, addr)
+		}
+	}
+}
+
 // Try a new transaction in the tx pool. Tx may come from local rpc or remote node broadcast.
 func (tp *ethTxPool) ReceiveTx(rawTx types.Tx) error {
-	if types.IsAdminOP(rawTx) {
+	if types.IsEvidenceTx(rawTx) {
+		if err := tp.app.checkEvidenceTx(rawTx); err != nil {
+			return err
+		}
+	}
+	if types.IsAdminOP(rawTx) || types.IsEvidenceTx(rawTx) || types.IsAppMessageTx(rawTx) {
 		return tp.handleAdminOP(rawTx)
 	}
 
@@ -177,14 +325,14 @@ func (tp *ethTxPool) ReceiveTx(rawTx types.Tx) error {
 	return nil
 }
 
-// receive and handle adminOP txs
+// receive and handle adminOP, evidence and app message txs
 func (tp *ethTxPool) handleAdminOP(tx types.Tx) error {
 	tp.Lock()
 	defer tp.Unlock()
 
 	for e := tp.extTxs.Front(); e != nil; e = e.Next() {
 		extTx := e.Value.(types.Tx)
-		if bytes.Equal(tx, extTx) {
+		if bytes.Equal(tx, extTx) || sameMisbehavior(tx, extTx) {
 			return errTxExist
 		}
 	}
@@ -206,8 +354,16 @@ func (tp *ethTxPool) reapAdminOP(maxTxs int) []types.Tx {
 		return []types.Tx{}
 	}
 	txs := make([]types.Tx, 0, maxTxs)
+	evidence := uint64(0)
 	for e := tp.extTxs.Front(); e != nil && len(txs) < maxTxs; e = e.Next() {
 		extTx := e.Value.(types.Tx)
+		// the evidence a block can't record waits for the next one
+		if types.IsEvidenceTx(extTx) {
+			if limit := tp.app.misbehaviorBlockLimit; limit > 0 && evidence >= limit {
+				continue
+			}
+			evidence++
+		}
 		txs = append(txs, extTx)
 	}
 	return txs
@@ -245,18 +401,37 @@ func (tp *ethTxPool) CheckAndAdd(tx *etypes.Transaction, rawTx types.Tx) error {
 	if _, exist := tp.all[tx.Hash()]; exist {
 		return errTxExist
 	}
+	if err := tp.app.checkTxBounds(tx); err != nil {
+		return err
+	}
 
-	from, _ := etypes.Sender(tp.app.Signer, tx)
+	from, _ := tp.app.senders.sender(tp.app.Signer, tx)
 	currentNonce := tp.safeGetNonce(from)
 	if currentNonce > tx.Nonce() {
+		tp.app.poolDrops.record(rtypes.PoolDrop_StaleNonce)
 		return fmt.Errorf("nonce(%d) different with getNonce(%d)", tx.Nonce(), currentNonce)
 	}
-
-	if err := tp.addWaiting(tx, from); err != nil {
+	inPending, err := tp.takeNonce(tx, from)
+	if err != nil {
+		tp.app.poolDrops.recordErr(err)
 		return err
 	}
-	tp.all[tx.Hash()] = rawTx
-	if currentNonce == tx.Nonce() {
+
+	if !inPending {
+		if err := tp.addWaiting(tx, from); err != nil {
+			tp.app.poolDrops.recordErr(err)
+			return err
+		}
+	}
+	tp.received(tx.Hash(), rawTx)
+	to := crypto.CreateAddress(from, tx.Nonce())
+	if tx.To() != nil {
+		to = *tx.To()
+	}
+	tp.app.txStatus.accepted(tx.Hash(), []common.Address{from, to})
+	if inPending {
+		tp.app.txStatus.pending(tx.Hash())
+	} else if currentNonce == tx.Nonce() {
 		tp.promoteExecutables([]common.Address{from})
 	}
 	return nil
@@ -303,6 +478,50 @@ func (tp *ethTxPool) Size() int {
 	return tp.extTxs.Len() + len(tp.all)
 }
 
+// SenderTxs returns the pending txs of addr followed by its waiting txs, each
+// in nonce order.
+func (tp *ethTxPool) SenderTxs(addr common.Address) []rtypes.PoolTx {
+	tp.Lock()
+	defer tp.Unlock()
+	txs := make([]rtypes.PoolTx, 0)
+	appendTxs := func(queue *txSortedMap, waiting bool) {
+		if queue == nil {
+			return
+		}
+		for _, tx := range queue.Flatten() {
+			txs = append(txs, rtypes.PoolTx{Hash: tx.Hash(), Nonce: tx.Nonce(), Waiting: waiting})
+		}
+	}
+	appendTxs(tp.pending[addr], false)
+	appendTxs(tp.waiting[addr], true)
+	return txs
+}
+
+// hasRoom tells if the waiting queue, where the new txs are first added, isn't full
+func (tp *ethTxPool) hasRoom() bool {
+	tp.Lock()
+	defer tp.Unlock()
+	waitingTxCount := 0
+	for _, txs := range tp.waiting {
+		waitingTxCount += txs.Len()
+	}
+	return waitingTxCount < tp.waitingLimit
+}
+
+// senderTxs returns the pending txs of addr followed by its waiting txs, each
+// in nonce order.
+func (tp *ethTxPool) senderTxs(addr common.Address) etypes.Transactions {
+	tp.Lock()
+	defer tp.Unlock()
+	var txs etypes.Transactions
+	for _, queue := range []*txSortedMap{tp.pending[addr], tp.waiting[addr]} {
+		if queue != nil {
+			txs = append(txs, queue.Flatten()...)
+		}
+	}
+	return txs
+}
+
 // blocking get first element of broadcast queue
 func (tp *ethTxPool) TxsFrontWait() *clist.CElement {
 	return tp.broadcastQueue.FrontWait()
@@ -311,10 +530,15 @@ func (tp *ethTxPool) TxsFrontWait() *clist.CElement {
 // Remove all transactions from tx and cache
 func (tp *ethTxPool) Flush() {
 	tp.Lock()
+	for hash := range tp.all {
+		tp.app.txStatus.evicted(hash, "tx pool flushed")
+	}
 	tp.waiting = make(map[common.Address]*txSortedMap)
 	tp.pending = make(map[common.Address]*txSortedMap)
 	tp.waitingBeats = make(map[common.Address]time.Time)
 	tp.all = make(map[common.Hash]types.Tx)
+	tp.arrivals = make(map[common.Hash]int64)
+	tp.holds = make(map[common.Address]*reapHold)
 	tp.broadcastQueue = clist.New()
 	tp.extTxs = clist.New()
 	tp.Unlock()
@@ -350,15 +574,21 @@ func (tp *ethTxPool) promoteExecutables(addrs []common.Address) {
 		}
 		nonce := tp.safeGetNonce(addr)
 		waiting := tp.waiting[addr]
+		held := tp.heldBack(addr)
 
 		// Drop all transactions that are deemed too old (low nonce)
 		oldTxs := waiting.Forward(nonce)
 		for _, otx := range oldTxs {
-			delete(tp.all, otx.Hash())
+			tp.forget(otx.Hash())
+			tp.app.txStatus.evicted(otx.Hash(), errNonceTooLow)
+			tp.app.poolDrops.record(rtypes.PoolDrop_StaleNonce)
 		}
 
 		// Gather up to N executable transactions and promote them
-		txs := waiting.ReadyN(nonce, tp.pendingLimit-pendingTxCount)
+		var txs etypes.Transactions
+		if !held {
+			txs = waiting.ReadyN(nonce, tp.pendingLimit-pendingTxCount)
+		}
 
 		// Delete the entire queue entry if it became empty.
 		if waiting.Len() == 0 {
@@ -377,6 +607,10 @@ func (tp *ethTxPool) promoteExecutables(addrs []common.Address) {
 			// pending is not full, add
 			if err := tp.pending[addr].Add(tx); err == nil {
 				pendingTxCount++
+				tp.app.txStatus.pending(tx.Hash())
+			} else {
+				tp.forget(tx.Hash())
+				tp.app.txStatus.evicted(tx.Hash(), err.Error())
 			}
 		}
 	}
@@ -391,9 +625,17 @@ func (tp *ethTxPool) addWaiting(tx *etypes.Transaction, address common.Address)
 	}
 	if waitingTxCount >= tp.waitingLimit {
 		// waiting queue is full, try replace or return err
-		if tp.waiting[address] == nil || !tp.waiting[address].TryReplace(tx) {
+		waiting := tp.waiting[address]
+		if waiting == nil || waiting.Len() == 0 {
 			return errTxPoolWaitingQueueIsFull
 		}
+		replaced := waiting.Get(waiting.MaxNonce())
+		if !waiting.TryReplace(tx) {
+			return errTxPoolWaitingQueueIsFull
+		}
+		tp.forget(replaced.Hash())
+		tp.app.txStatus.replaced(replaced.Hash(), tx.Hash())
+		tp.app.poolDrops.record(rtypes.PoolDrop_Replaced)
 	} else {
 		if tp.waiting[address] == nil {
 			tp.waiting[address] = newTxSortedMap()
@@ -417,18 +659,25 @@ func (tp *ethTxPool) demoteUnexecutables() {
 		// Drop all transactions that are deemed too old (low nonce)
 		for _, tx := range accountTxs.Forward(nonce) {
 			hash := tx.Hash()
-			delete(tp.all, hash)
+			tp.forget(hash)
+			tp.app.txStatus.evicted(hash, errNonceTooLow)
+			tp.app.poolDrops.record(rtypes.PoolDrop_StaleNonce)
 		}
 
 		if accountTxs.Len() == 0 {
 			delete(tp.pending, addr)
+			if tp.waiting[addr] == nil {
+				delete(tp.holds, addr)
+			}
 		} else if accountTxs.Len() > 0 && accountTxs.Get(nonce) == nil {
 			// If there's a gap in front, alert (should never happen) and postpone all transactions
 			for _, tx := range accountTxs.items {
 				log.Warn("Demoting invalidated transaction", zap.String("hash", tx.Hash().Hex()))
 				if err := tp.addWaiting(tx, addr); err != nil {
 					// demote pending to waiting failed, waiting queue maybe full, delete tx
-					delete(tp.all, tx.Hash())
+					tp.forget(tx.Hash())
+					tp.app.txStatus.evicted(tx.Hash(), err.Error())
+					tp.app.poolDrops.recordErr(err)
 				}
 			}
 			// Delete the entire queue entry if it became empty.
@@ -0,0 +1,127 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+func queryTestPendingBySender(t *testing.T, app *EVMApp, addr common.Address) []rtypes.PoolTx {
+	res := app.Query(append([]byte{rtypes.QueryType_PendingBySender}, addr.Bytes()...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var txs []rtypes.PoolTx
+	if _, err := decodePage(res.Data, &txs); err != nil {
+		t.Fatal(err)
+	}
+	return txs
+}
+
+func TestQueryPendingBySender(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	hashes := make(map[uint64]common.Hash)
+	// the later nonces first, so 0 to 2 are promoted to pending together, 5 waits for a gap
+	for _, nonce := range []uint64{5, 2, 1, 0} {
+		raw := signTestTx(t, key, etypes.NewTransaction(nonce, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+		hashes[nonce] = txHash(raw)
+	}
+	app.pool.updateToState()
+
+	txs := queryTestPendingBySender(t, app, addr)
+	if len(txs) != 4 {
+		t.Fatalf("expected 4 txs, got %d", len(txs))
+	}
+	for i, nonce := range []uint64{0, 1, 2, 5} {
+		if txs[i].Nonce != nonce || txs[i].Hash != hashes[nonce] || txs[i].Waiting != (nonce == 5) {
+			t.Fatalf("unexpected pool tx %d %+v", i, txs[i])
+		}
+	}
+
+	_, other := testKey(t, testKeyB)
+	if txs := queryTestPendingBySender(t, app, other); len(txs) != 0 {
+		t.Fatalf("expected no txs of an unknown sender, got %d", len(txs))
+	}
+	if res := app.Query(append([]byte{rtypes.QueryType_PendingBySender}, 0x01)); res.IsOK() {
+		t.Fatal("expected an invalid address to be rejected")
+	}
+}
+
+func TestPoolDuplicateNonce(t *testing.T) {
+	for _, policy := range []string{duplicateNonceReject, duplicateNonceReplace} {
+		conf := viper.New()
+		conf.Set("duplicate_nonce", policy)
+		app, clean := newTestAppWithConfig(t, conf)
+
+		key, addr := testKey(t, testKeyA)
+		send := func(nonce uint64, gasPrice int64) (common.Hash, error) {
+			raw := signTestTx(t, key, etypes.NewTransaction(nonce, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(gasPrice), nil))
+			return txHash(raw), app.pool.ReceiveTx(raw)
+		}
+		// nonce 0 is pending, 5 waits behind a gap
+		pooled := make(map[uint64]common.Hash)
+		for _, nonce := range []uint64{0, 5} {
+			hash, err := send(nonce, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pooled[nonce] = hash
+		}
+
+		for _, nonce := range []uint64{0, 5} {
+			if _, err := send(nonce, 1); err == nil {
+				t.Fatalf("%s: expected the same gas price to be refused at nonce %d", policy, nonce)
+			}
+			hash, err := send(nonce, 2)
+			switch policy {
+			case duplicateNonceReject:
+				if err != ErrDuplicateNonce {
+					t.Fatalf("%s: expected %v at nonce %d, got %v", policy, ErrDuplicateNonce, nonce, err)
+				}
+			case duplicateNonceReplace:
+				if err != nil {
+					t.Fatalf("%s: expected the higher gas price to replace nonce %d, got %v", policy, nonce, err)
+				}
+				if status := app.txStatus.Get(pooled[nonce]); status.Status != rtypes.TxStatus_Replaced || status.ReplacedBy != hash {
+					t.Fatalf("%s: expected the tx at nonce %d replaced, got %+v", policy, nonce, status)
+				}
+				pooled[nonce] = hash
+			}
+		}
+
+		txs := app.pool.SenderTxs(addr)
+		if len(txs) != 2 || app.pool.Size() != 2 {
+			t.Fatalf("%s: expected 2 pooled txs, got %+v", policy, txs)
+		}
+		for i, nonce := range []uint64{0, 5} {
+			if txs[i].Nonce != nonce || txs[i].Hash != pooled[nonce] || txs[i].Waiting != (nonce == 5) {
+				t.Fatalf("%s: unexpected pool tx %d %+v", policy, i, txs[i])
+			}
+		}
+		clean()
+	}
+}
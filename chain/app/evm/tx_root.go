@@ -0,0 +1,59 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// TxRootPrefix indexes the merkle root of the txs of each block by height
+var TxRootPrefix = []byte("txroot-")
+
+func txRootKey(height uint64) []byte {
+	key := make([]byte, len(TxRootPrefix)+8)
+	copy(key, TxRootPrefix)
+	binary.BigEndian.PutUint64(key[len(TxRootPrefix):], height)
+	return key
+}
+
+// saveTxRoot stores the merkle root of the txs of block. It's the simple merkle
+// tree of the block header's DataHash, over the txs in block order, so it only
+// depends on the block and matches on every node; empty blocks have an empty
+// root.
+func (app *EVMApp) saveTxRoot(block *gtypes.Block) error {
+	root := block.Data.Txs.Hash()
+	return app.stateDb.Put(txRootKey(uint64(block.Height)), append([]byte{}, root...))
+}
+
+// queryTxRoot returns the rlp encoded tx root of the block at the 8 bytes big
+// endian height.
+func (app *EVMApp) queryTxRoot(load []byte) gtypes.Result {
+	if len(load) != 8 {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong height")
+	}
+	height := binary.BigEndian.Uint64(load)
+	root, err := app.stateDb.Get(txRootKey(height))
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, fmt.Sprintf("no tx root of height %d", height))
+	}
+	data, err := rlp.EncodeToBytes(root)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,76 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-merkle"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func queryTestTxRoot(t *testing.T, app *EVMApp, height uint64) gtypes.Result {
+	load := make([]byte, 8)
+	binary.BigEndian.PutUint64(load, height)
+	return app.Query(append([]byte{rtypes.QueryType_TxRoot}, load...))
+}
+
+func TestTxRoot(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	keyA, _ := testKey(t, testKeyA)
+	keyB, _ := testKey(t, testKeyB)
+	to := common.HexToAddress("0x1234")
+	txs := [][]byte{
+		signTestTx(t, keyA, etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(0), nil)),
+		signTestTx(t, keyB, etypes.NewTransaction(0, to, big.NewInt(0), testGas, big.NewInt(0), nil)),
+		signTestTx(t, keyA, etypes.NewTransaction(1, to, big.NewInt(0), testGas, big.NewInt(0), nil)),
+	}
+	execTestBlock(t, app, 1, txs...)
+	execTestBlock(t, app, 2)
+
+	// the simple merkle root of the tx hashes, in block order
+	h := func(raw []byte) []byte { return gtypes.Tx(raw).Hash() }
+	expected := merkle.SimpleHashFromTwoHashes(merkle.SimpleHashFromTwoHashes(h(txs[0]), h(txs[1])), h(txs[2]))
+
+	res := queryTestTxRoot(t, app, 1)
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var root []byte
+	if err := rlp.DecodeBytes(res.Data, &root); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root, expected) {
+		t.Fatalf("expected tx root %x, got %x", expected, root)
+	}
+
+	if res = queryTestTxRoot(t, app, 2); res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	if err := rlp.DecodeBytes(res.Data, &root); err != nil || len(root) != 0 {
+		t.Fatalf("expected the empty root of an empty block, got %x %v", root, err)
+	}
+	if res = queryTestTxRoot(t, app, 3); res.IsOK() {
+		t.Fatal("expected no tx root of an uncommitted block")
+	}
+}
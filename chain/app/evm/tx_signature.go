@@ -0,0 +1,42 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+var (
+	// ErrEmptySignature rejects the txs carrying no signature
+	ErrEmptySignature = errors.New("tx signature is empty")
+	// ErrInvalidSignature rejects the txs whose signature doesn't recover to a sender
+	ErrInvalidSignature = errors.New("tx signature is invalid")
+)
+
+// verifyTxSignature returns an error unless tx is signed and its signature
+// recovered to from without recoverErr. Without the check CheckTx goes on with
+// the zero address, whose nonce and balance have nothing to do with the tx.
+func verifyTxSignature(tx *etypes.Transaction, from common.Address, recoverErr error) error {
+	v, r, s := tx.RawSignatureValues()
+	if v.Sign() == 0 && r.Sign() == 0 && s.Sign() == 0 {
+		return ErrEmptySignature
+	}
+	if recoverErr != nil || from == (common.Address{}) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
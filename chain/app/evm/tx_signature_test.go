@@ -0,0 +1,75 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func encodeTestTx(t *testing.T, tx *etypes.Transaction) []byte {
+	raw, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestCheckTxSignature(t *testing.T) {
+	conf := viper.New()
+	conf.Set("check_tx_signature", true)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	tx := etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), nil)
+	if err := app.CheckTx(signTestTx(t, key, tx)); err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned := encodeTestTx(t, tx)
+	if err := app.CheckTx(unsigned); err != ErrEmptySignature {
+		t.Fatalf("expected %v, got %v", ErrEmptySignature, err)
+	}
+
+	sig, err := crypto.Sign(EthSigner.Hash(tx).Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// r over the curve order recovers no sender
+	for i := 0; i < 32; i++ {
+		sig[i] = 0xff
+	}
+	tampered, err := tx.WithSignature(EthSigner, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.CheckTx(encodeTestTx(t, tampered)); err != ErrInvalidSignature {
+		t.Fatalf("expected %v, got %v", ErrInvalidSignature, err)
+	}
+
+	// unchecked by default, the unsigned tx passes as the zero address
+	unchecked, clean2 := newTestApp(t)
+	defer clean2()
+	if err := unchecked.CheckTx(unsigned); err != nil {
+		t.Fatalf("expected the unsigned tx to pass unchecked, got %v", err)
+	}
+}
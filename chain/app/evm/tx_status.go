@@ -0,0 +1,231 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"go.uber.org/zap"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+)
+
+var TxStatusPrefix = []byte("txstatus-")
+
+// prefixIteratee is implemented by databases which can iterate keys under a prefix, eg. ethdb.LDBDatabase
+type prefixIteratee interface {
+	NewIteratorWithPrefix(prefix []byte) iterator.Iterator
+}
+
+func txStatusKey(hash common.Hash) []byte {
+	return append(append([]byte{}, TxStatusPrefix...), hash.Bytes()...)
+}
+
+type txStatusEntry struct {
+	hash     common.Hash
+	status   rtypes.TxStatus
+	accounts []common.Address
+}
+
+// txStatusRecord is the persisted status of a tx, with the sender and the
+// recipient of the txs the pool accepted. Records written before it existed are
+// a bare rtypes.TxStatus.
+type txStatusRecord struct {
+	Status   rtypes.TxStatus
+	Accounts []common.Address
+}
+
+// txStatusTracker keeps the status of txs seen by the pool, so clients can learn
+// what happened to a tx which never got a receipt. Entries are persisted, ordered
+// by last update, and bounded both by count and by the retention of terminal states.
+type txStatusTracker struct {
+	mtx       sync.Mutex
+	db        ethdb.Database
+	entries   map[common.Hash]*list.Element
+	order     *list.List // *txStatusEntry, oldest update at front
+	limit     int
+	retention time.Duration
+}
+
+func newTxStatusTracker(db ethdb.Database, limit int, retention time.Duration) *txStatusTracker {
+	t := &txStatusTracker{
+		db:        db,
+		entries:   make(map[common.Hash]*list.Element),
+		order:     list.New(),
+		limit:     limit,
+		retention: retention,
+	}
+	t.load()
+	return t
+}
+
+// load restores persisted statuses, only possible when db supports iterating.
+func (t *txStatusTracker) load() {
+	db, ok := t.db.(prefixIteratee)
+	if !ok {
+		return
+	}
+	var loaded []*txStatusEntry
+	it := db.NewIteratorWithPrefix(TxStatusPrefix)
+	for it.Next() {
+		entry := &txStatusEntry{hash: common.BytesToHash(it.Key()[len(TxStatusPrefix):])}
+		var record txStatusRecord
+		if err := rlp.DecodeBytes(it.Value(), &record); err == nil {
+			entry.status = record.Status
+			if len(record.Accounts) > 0 {
+				entry.accounts = record.Accounts
+			}
+		} else if err := rlp.DecodeBytes(it.Value(), &entry.status); err != nil {
+			log.Warn("decode tx status", zap.Error(err))
+			continue
+		}
+		loaded = append(loaded, entry)
+	}
+	it.Release()
+	sort.SliceStable(loaded, func(i, j int) bool { return loaded[i].status.Time < loaded[j].status.Time })
+
+	t.mtx.Lock()
+	for _, entry := range loaded {
+		t.entries[entry.hash] = t.order.PushBack(entry)
+	}
+	t.pruneLocked(time.Now())
+	t.mtx.Unlock()
+}
+
+// Get returns the status of the tx, TxStatus_Unknown if it has never been seen or was pruned.
+func (t *txStatusTracker) Get(hash common.Hash) rtypes.TxStatus {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if e, ok := t.entries[hash]; ok {
+		return e.Value.(*txStatusEntry).status
+	}
+	return rtypes.TxStatus{Status: rtypes.TxStatus_Unknown}
+}
+
+// accounts returns the sender and the recipient of the tx, as the pool accepted
+// it, nil for the txs it didn't.
+func (t *txStatusTracker) accounts(hash common.Hash) []common.Address {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if e, ok := t.entries[hash]; ok {
+		return e.Value.(*txStatusEntry).accounts
+	}
+	return nil
+}
+
+// accepted records the tx accepted by the pool, accounts being its sender and
+// its recipient, the created contract for a creation
+func (t *txStatusTracker) accepted(hash common.Hash, accounts []common.Address) {
+	t.set(hash, rtypes.TxStatus{Status: rtypes.TxStatus_Accepted}, accounts, false)
+}
+
+func (t *txStatusTracker) pending(hash common.Hash) {
+	t.set(hash, rtypes.TxStatus{Status: rtypes.TxStatus_Pending}, nil, false)
+}
+
+func (t *txStatusTracker) demoted(hash common.Hash, reason string, until uint64) {
+	t.set(hash, rtypes.TxStatus{Status: rtypes.TxStatus_Demoted, Reason: reason, Height: until}, nil, false)
+}
+
+func (t *txStatusTracker) committed(hash common.Hash, height uint64) {
+	t.set(hash, rtypes.TxStatus{Status: rtypes.TxStatus_Committed, Height: height}, nil, false)
+}
+
+// evicted, replaced and expired never overwrite a terminal status, a tx dropped by
+// the pool after being committed must still report the commit.
+func (t *txStatusTracker) evicted(hash common.Hash, reason string) {
+	t.set(hash, rtypes.TxStatus{Status: rtypes.TxStatus_Evicted, Reason: reason}, nil, true)
+}
+
+func (t *txStatusTracker) replaced(hash common.Hash, by common.Hash) {
+	t.set(hash, rtypes.TxStatus{Status: rtypes.TxStatus_Replaced, ReplacedBy: by}, nil, true)
+}
+
+func (t *txStatusTracker) expired(hash common.Hash) {
+	t.set(hash, rtypes.TxStatus{Status: rtypes.TxStatus_Expired}, nil, true)
+}
+
+// set updates the status of the tx, keeping its accounts when accounts is nil
+func (t *txStatusTracker) set(hash common.Hash, status rtypes.TxStatus, accounts []common.Address, keepTerminal bool) {
+	status.Time = uint64(time.Now().Unix())
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if e, ok := t.entries[hash]; ok {
+		entry := e.Value.(*txStatusEntry)
+		if keepTerminal && entry.status.IsTerminal() {
+			return
+		}
+		entry.status = status
+		if accounts != nil {
+			entry.accounts = accounts
+		}
+		accounts = entry.accounts
+		t.order.MoveToBack(e)
+	} else {
+		t.entries[hash] = t.order.PushBack(&txStatusEntry{hash: hash, status: status, accounts: accounts})
+	}
+
+	if data, err := rlp.EncodeToBytes(&txStatusRecord{Status: status, Accounts: accounts}); err != nil {
+		log.Warn("encode tx status", zap.Error(err))
+	} else if err := t.db.Put(txStatusKey(hash), data); err != nil {
+		log.Warn("persist tx status", zap.Error(err))
+	}
+
+	for t.limit > 0 && t.order.Len() > t.limit {
+		t.removeLocked(t.order.Front())
+	}
+}
+
+// prune removes terminal statuses older than retention.
+func (t *txStatusTracker) prune(now time.Time) {
+	t.mtx.Lock()
+	t.pruneLocked(now)
+	t.mtx.Unlock()
+}
+
+func (t *txStatusTracker) pruneLocked(now time.Time) {
+	deadline := uint64(now.Add(-t.retention).Unix())
+	for e := t.order.Front(); e != nil; {
+		entry := e.Value.(*txStatusEntry)
+		if entry.status.Time >= deadline {
+			// entries are ordered by update time, the rest are all newer
+			break
+		}
+		next := e.Next()
+		if entry.status.IsTerminal() {
+			t.removeLocked(e)
+		}
+		e = next
+	}
+	for t.limit > 0 && t.order.Len() > t.limit {
+		t.removeLocked(t.order.Front())
+	}
+}
+
+func (t *txStatusTracker) removeLocked(e *list.Element) {
+	entry := t.order.Remove(e).(*txStatusEntry)
+	delete(t.entries, entry.hash)
+	if err := t.db.Delete(txStatusKey(entry.hash)); err != nil {
+		log.Warn("delete tx status", zap.Error(err))
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func queryTestTxStatus(t *testing.T, app *EVMApp, hash common.Hash) rtypes.TxStatus {
+	res := app.Query(append([]byte{rtypes.QueryType_TxStatus}, hash.Bytes()...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	var status rtypes.TxStatus
+	if err := rlp.DecodeBytes(res.Data, &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestTxStatusCommitted(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	raw := signTestTx(t, key, etypes.NewTransaction(0, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+	if err := app.pool.ReceiveTx(raw); err != nil {
+		t.Fatal(err)
+	}
+	if status := queryTestTxStatus(t, app, txHash(raw)); status.Status != rtypes.TxStatus_Pending {
+		t.Fatalf("expected pending, got %v", status.Status)
+	}
+
+	execTestBlock(t, app, 1, raw)
+	status := queryTestTxStatus(t, app, txHash(raw))
+	if status.Status != rtypes.TxStatus_Committed || status.Height != 1 {
+		t.Fatalf("expected committed at 1, got %v at %d", status.Status, status.Height)
+	}
+}
+
+func TestTxStatusEvictedByCompetingNonce(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, _ := testKey(t, testKeyB)
+	pooled := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), []byte{1}))
+	competing := signTestTx(t, key, etypes.NewTransaction(0, common.HexToAddress("0x1234"), big.NewInt(0), testGas, big.NewInt(0), []byte{2}))
+	if err := app.pool.ReceiveTx(pooled); err != nil {
+		t.Fatal(err)
+	}
+
+	execTestBlock(t, app, 1, competing)
+	status := queryTestTxStatus(t, app, txHash(pooled))
+	if status.Status != rtypes.TxStatus_Evicted || status.Reason != errNonceTooLow {
+		t.Fatalf("expected evicted, got %v (%s)", status.Status, status.Reason)
+	}
+	if status := queryTestTxStatus(t, app, txHash(competing)); status.Status != rtypes.TxStatus_Committed {
+		t.Fatalf("expected committed, got %v", status.Status)
+	}
+}
+
+func TestTxStatusReplacedAndExpired(t *testing.T) {
+	conf := viper.New()
+	conf.Set("block_size", 1) // waiting queue holds 10 txs
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, _ := testKey(t, testKeyA)
+	var gapped [][]byte
+	for nonce := uint64(2); nonce <= 11; nonce++ {
+		raw := signTestTx(t, key, etypes.NewTransaction(nonce, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+		if err := app.pool.ReceiveTx(raw); err != nil {
+			t.Fatal(err)
+		}
+		gapped = append(gapped, raw)
+	}
+	if status := queryTestTxStatus(t, app, txHash(gapped[0])); status.Status != rtypes.TxStatus_Accepted {
+		t.Fatalf("expected accepted, got %v", status.Status)
+	}
+
+	lower := signTestTx(t, key, etypes.NewTransaction(1, common.Address{}, big.NewInt(0), testGas, big.NewInt(0), nil))
+	if err := app.pool.ReceiveTx(lower); err != nil {
+		t.Fatal(err)
+	}
+	status := queryTestTxStatus(t, app, txHash(gapped[9]))
+	if status.Status != rtypes.TxStatus_Replaced || status.ReplacedBy != txHash(lower) {
+		t.Fatalf("expected replaced by %x, got %v by %x", txHash(lower), status.Status, status.ReplacedBy)
+	}
+
+	app.pool.Lock()
+	app.pool.waitingLifeTime = 0
+	app.pool.evictStaleWaiting()
+	app.pool.Unlock()
+	expired := append([][]byte{lower}, gapped[:9]...)
+	for _, raw := range expired {
+		if status := queryTestTxStatus(t, app, txHash(raw)); status.Status != rtypes.TxStatus_Expired {
+			t.Fatalf("expected expired, got %v", status.Status)
+		}
+	}
+	if status := queryTestTxStatus(t, app, txHash(gapped[9])); status.Status != rtypes.TxStatus_Replaced {
+		t.Fatalf("terminal status overwritten by %v", status.Status)
+	}
+}
+
+func TestTxStatusUnknown(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	if status := queryTestTxStatus(t, app, common.HexToHash("0x01")); status.Status != rtypes.TxStatus_Unknown {
+		t.Fatalf("expected unknown, got %v", status.Status)
+	}
+	if res := app.Query([]byte{rtypes.QueryType_TxStatus, 0x01}); res.IsOK() {
+		t.Fatal("expected short hash to be rejected")
+	}
+}
+
+func TestTxStatusPersistAndPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tx-status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := ethdb.NewLDBDatabase(dir, 16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	committed, evicted, pending := common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03")
+	tracker := newTxStatusTracker(db, 10, time.Hour)
+	tracker.committed(committed, 5)
+	tracker.evicted(evicted, "gone")
+	accounts := []common.Address{common.HexToAddress("0x0a"), common.HexToAddress("0x0b")}
+	tracker.accepted(pending, accounts)
+	tracker.pending(pending)
+
+	tracker = newTxStatusTracker(db, 10, time.Hour)
+	if status := tracker.Get(committed); status.Status != rtypes.TxStatus_Committed || status.Height != 5 {
+		t.Fatalf("status not restored, got %v at %d", status.Status, status.Height)
+	}
+	if got := tracker.accounts(pending); len(got) != 2 || got[0] != accounts[0] || got[1] != accounts[1] {
+		t.Fatalf("expected the accounts of the accepted tx restored, got %v", got)
+	}
+	if got := tracker.accounts(committed); got != nil {
+		t.Fatalf("expected no accounts for a tx the pool didn't accept, got %v", got)
+	}
+
+	tracker.prune(time.Now().Add(2 * time.Hour))
+	if status := tracker.Get(committed); status.Status != rtypes.TxStatus_Unknown {
+		t.Fatalf("expected pruned, got %v", status.Status)
+	}
+	if status := tracker.Get(evicted); status.Status != rtypes.TxStatus_Unknown {
+		t.Fatalf("expected pruned, got %v", status.Status)
+	}
+	if status := tracker.Get(pending); status.Status != rtypes.TxStatus_Pending {
+		t.Fatalf("live status should be kept, got %v", status.Status)
+	}
+
+	tracker = newTxStatusTracker(db, 1, time.Hour)
+	if status := tracker.Get(committed); status.Status != rtypes.TxStatus_Unknown {
+		t.Fatalf("pruned status restored as %v", status.Status)
+	}
+	tracker.accepted(committed, nil)
+	if status := tracker.Get(pending); status.Status != rtypes.TxStatus_Unknown {
+		t.Fatalf("expected oldest status dropped over limit, got %v", status.Status)
+	}
+}
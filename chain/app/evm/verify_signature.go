@@ -0,0 +1,112 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"math/big"
+	"strings"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/accounts/abi"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const erc1271ABI = `[{"name":"isValidSignature","type":"function","constant":true,
+	"inputs":[{"name":"hash","type":"bytes32"},{"name":"signature","type":"bytes"}],
+	"outputs":[{"name":"magicValue","type":"bytes4"}]}]`
+
+var erc1271 abi.ABI
+
+func init() {
+	var err error
+	if erc1271, err = abi.JSON(strings.NewReader(erc1271ABI)); err != nil {
+		panic(err)
+	}
+}
+
+// queryVerifySignature answers the rlp encoded rtypes.VerifySignatureResult of
+// the rlp encoded rtypes.VerifySignatureQuery in load. The signature of an
+// account with code is checked by its ERC-1271 isValidSignature on the latest
+// state, otherwise the signer is recovered from it.
+func (app *EVMApp) queryVerifySignature(load []byte) gtypes.Result {
+	query := &rtypes.VerifySignatureQuery{}
+	if err := rlp.DecodeBytes(load, query); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	hash, err := rtypes.SignatureHash(query.Scheme, app.chainConfig.ChainID, query.Message)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+
+	app.stateMtx.Lock()
+	hasCode := app.state.GetCodeSize(query.Address) > 0
+	app.stateMtx.Unlock()
+
+	var result rtypes.VerifySignatureResult
+	if hasCode {
+		if result.Valid, err = app.verifyContractSignature(query.Address, hash, query.Signature); err != nil {
+			return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+		}
+	} else {
+		result.Recovered, result.Valid = recoverSigner(hash, query.Signature, query.Address)
+	}
+	data, err := rlp.EncodeToBytes(&result)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+// recoverSigner recovers the signer of hash from sig, and reports whether it is
+// addr. Malformed signatures recover nothing.
+func recoverSigner(hash, sig []byte, addr common.Address) (common.Address, bool) {
+	if len(sig) != 65 {
+		return common.Address{}, false
+	}
+	sig = append([]byte{}, sig...)
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64])
+	if !crypto.ValidateSignatureValues(sig[64], r, s, true) {
+		return common.Address{}, false
+	}
+	pub, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return common.Address{}, false
+	}
+	recovered := crypto.PubkeyToAddress(*pub)
+	return recovered, subtle.ConstantTimeCompare(recovered.Bytes(), addr.Bytes()) == 1
+}
+
+// verifyContractSignature calls isValidSignature(hash, sig) of the contract at
+// addr, a failing call or any answer but the magic value is an invalid signature.
+func (app *EVMApp) verifyContractSignature(addr common.Address, hash, sig []byte) (bool, error) {
+	var h [32]byte
+	copy(h[:], hash)
+	input, err := erc1271.Pack("isValidSignature", h, sig)
+	if err != nil {
+		return false, err
+	}
+	ret, err := app.simulateContract(callMessage(&rtypes.CallObject{To: &addr, Data: input}), 0, evmConfig)
+	if err != nil {
+		return false, err
+	}
+	return len(ret) >= 4 && bytes.Equal(ret[:4], rtypes.ERC1271MagicValue[:]), nil
+}
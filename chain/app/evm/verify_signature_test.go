@@ -0,0 +1,115 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// walletCode deploys an ERC-1271 wallet approving the single hash given
+func walletCode(hash []byte) []byte {
+	runtime := common.FromHex("600435" + "7f" + common.Bytes2Hex(hash) + "14602d57" + "60206000f3" +
+		"5b" + "7f" + common.Bytes2Hex(common.RightPadBytes(rtypes.ERC1271MagicValue[:], 32)) + "600052" + "60206000f3")
+	return append(common.FromHex("6057600c60003960576000f3"), runtime...)
+}
+
+func queryVerifySignature(t *testing.T, app *EVMApp, query *rtypes.VerifySignatureQuery) *rtypes.VerifySignatureResult {
+	load, err := rlp.EncodeToBytes(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := app.Query(append([]byte{rtypes.QueryType_VerifySignature}, load...))
+	if res.IsErr() {
+		t.Fatal(res.Log)
+	}
+	result := &rtypes.VerifySignatureResult{}
+	if err := rlp.DecodeBytes(res.Data, result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestVerifySignature(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	message := []byte("login 42")
+	for _, scheme := range []rtypes.SignatureScheme{rtypes.SignatureScheme_Keccak, rtypes.SignatureScheme_Personal, rtypes.SignatureScheme_Chain} {
+		hash, err := rtypes.SignatureHash(scheme, app.chainConfig.ChainID, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := crypto.Sign(hash, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := queryVerifySignature(t, app, &rtypes.VerifySignatureQuery{Address: addr, Message: message, Signature: sig, Scheme: scheme}); !res.Valid || res.Recovered != addr {
+			t.Fatalf("scheme %d: expected a valid signature by %x, got %+v", scheme, addr, res)
+		}
+		// personal_sign answers v as 27/28
+		sig[64] += 27
+		if res := queryVerifySignature(t, app, &rtypes.VerifySignatureQuery{Address: addr, Message: message, Signature: sig, Scheme: scheme}); !res.Valid {
+			t.Fatalf("scheme %d: expected v+27 accepted", scheme)
+		}
+		other := (scheme + 1) % 3
+		if res := queryVerifySignature(t, app, &rtypes.VerifySignatureQuery{Address: addr, Message: message, Signature: sig, Scheme: other}); res.Valid || res.Recovered == addr {
+			t.Fatalf("scheme %d: expected the signature invalid under scheme %d, got %+v", scheme, other, res)
+		}
+		if res := queryVerifySignature(t, app, &rtypes.VerifySignatureQuery{Address: common.HexToAddress("0x01"), Message: message, Signature: sig, Scheme: scheme}); res.Valid || res.Recovered != addr {
+			t.Fatalf("scheme %d: expected the signature invalid for another address, got %+v", scheme, res)
+		}
+	}
+	if res := queryVerifySignature(t, app, &rtypes.VerifySignatureQuery{Address: addr, Message: message, Signature: make([]byte, 64)}); res.Valid {
+		t.Fatal("expected a malformed signature invalid")
+	}
+	if res := app.Query(append([]byte{rtypes.QueryType_VerifySignature}, 0x01)); res.IsOK() {
+		t.Fatal("expected a malformed query rejected")
+	}
+}
+
+func TestVerifySignatureERC1271(t *testing.T) {
+	app, clean := newTestApp(t)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	approved, err := rtypes.SignatureHash(rtypes.SignatureScheme_Personal, nil, []byte("approve"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	execTestBlock(t, app, 1, signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), walletCode(approved))))
+	wallet := crypto.CreateAddress(addr, 0)
+
+	for _, c := range []struct {
+		message string
+		valid   bool
+	}{
+		{"approve", true},
+		{"reject", false},
+	} {
+		res := queryVerifySignature(t, app, &rtypes.VerifySignatureQuery{
+			Address: wallet, Message: []byte(c.message), Signature: []byte{0x01}, Scheme: rtypes.SignatureScheme_Personal,
+		})
+		if res.Valid != c.valid || res.Recovered != (common.Address{}) {
+			t.Fatalf("%s: expected valid %v, got %+v", c.message, c.valid, res)
+		}
+	}
+}
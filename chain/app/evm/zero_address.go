@@ -0,0 +1,71 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/params"
+)
+
+// ErrZeroAddressData rejects the txs sent to the zero address with data, which
+// are mistaken contract creations more often than not: the data would be lost.
+// CheckTx refuses them and, from zero_address_height, blocks carrying them have
+// them invalidated.
+var ErrZeroAddressData = errors.New("tx to the zero address carries data, contract creations have no recipient")
+
+var zeroAddressPolicies = map[string]params.ZeroAddressPolicy{
+	"reject": params.ZeroAddressReject,
+	"burn":   params.ZeroAddressBurn,
+}
+
+// withZeroAddressPolicy returns config with the zero address policy of name set
+func withZeroAddressPolicy(config *params.ChainConfig, name string) (*params.ChainConfig, error) {
+	policy, ok := zeroAddressPolicies[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid zero_address_policy %q", name)
+	}
+	if policy == params.ZeroAddressReject {
+		return config, nil
+	}
+	withPolicy := *config
+	withPolicy.ZeroAddressPolicy = policy
+	return &withPolicy, nil
+}
+
+func toZeroAddress(tx *etypes.Transaction) bool {
+	return tx.To() != nil && *tx.To() == (common.Address{})
+}
+
+func checkZeroAddress(tx *etypes.Transaction) error {
+	if toZeroAddress(tx) && len(tx.Data()) > 0 {
+		return ErrZeroAddressData
+	}
+	return nil
+}
+
+// burnZeroAddressValue takes back from the zero address the value the tx executed
+// by the block at height credited it with, when the chain burns it.
+func (app *EVMApp) burnZeroAddressValue(state *estate.StateDB, height int64, tx *etypes.Transaction, receipt *etypes.Receipt) {
+	if app.chainConfig.ZeroAddressPolicy != params.ZeroAddressBurn || !activeAt(app.zeroAddressHeight, height) || !toZeroAddress(tx) {
+		return
+	}
+	if receipt.Status == etypes.ReceiptStatusSuccessful && tx.Value().Sign() > 0 {
+		state.SubBalance(common.Address{}, tx.Value())
+	}
+}
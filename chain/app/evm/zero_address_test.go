@@ -0,0 +1,105 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+)
+
+func TestZeroAddressPolicy(t *testing.T) {
+	for _, policy := range []string{"reject", "burn"} {
+		conf := viper.New()
+		conf.Set("zero_address_policy", policy)
+		conf.Set("zero_address_height", 1)
+		app, clean := newTestAppWithConfig(t, conf)
+
+		key, addr := testKey(t, testKeyA)
+		fundTestAccounts(t, app, big.NewInt(1000), addr)
+		zero, value := common.Address{}, big.NewInt(100)
+
+		// nil recipient, empty and non-empty data: contract creations
+		res := execTestBlock(t, app, 1,
+			signTestTx(t, key, etypes.NewContractCreation(0, big.NewInt(0), testGas, big.NewInt(0), nil)),
+			signTestTx(t, key, etypes.NewContractCreation(1, big.NewInt(0), testGas, big.NewInt(0), logContractCode)))
+		if len(res.ValidTxs) != 2 {
+			t.Fatalf("%s: expected both creations valid, got %+v", policy, res.InvalidTxs)
+		}
+		if app.state.GetCodeSize(crypto.CreateAddress(addr, 1)) == 0 {
+			t.Fatalf("%s: expected the contract deployed", policy)
+		}
+
+		// zero address, non-empty data: refused
+		withData := signTestTx(t, key, etypes.NewTransaction(2, zero, value, testGas, big.NewInt(0), logContractCode))
+		if err := app.CheckTx(withData); err != ErrZeroAddressData {
+			t.Fatalf("%s: expected %v, got %v", policy, ErrZeroAddressData, err)
+		}
+		res = execTestBlock(t, app, 2, withData)
+		if len(res.InvalidTxs) != 1 || res.InvalidTxs[0].Error != ErrZeroAddressData || app.state.GetNonce(addr) != 2 {
+			t.Fatalf("%s: expected the tx invalidated with %v, got %+v", policy, ErrZeroAddressData, res.InvalidTxs)
+		}
+
+		// zero address, empty data: a value transfer, credited or burnt
+		transfer := signTestTx(t, key, etypes.NewTransaction(2, zero, value, testGas, big.NewInt(0), nil))
+		if err := app.CheckTx(transfer); err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		if res = execTestBlock(t, app, 3, transfer); len(res.ValidTxs) != 1 {
+			t.Fatalf("%s: expected the transfer valid, got %+v", policy, res.InvalidTxs)
+		}
+		if app.state.GetBalance(addr).Cmp(big.NewInt(900)) != 0 {
+			t.Fatalf("%s: expected the value taken from the sender, got balance %v", policy, app.state.GetBalance(addr))
+		}
+		credited := app.state.GetBalance(zero)
+		if (policy == "reject" && credited.Cmp(value) != 0) || (policy == "burn" && credited.Sign() != 0) {
+			t.Fatalf("%s: unexpected zero address balance %v", policy, credited)
+		}
+		clean()
+	}
+}
+
+func TestZeroAddressHeight(t *testing.T) {
+	conf := viper.New()
+	conf.Set("zero_address_policy", "burn")
+	conf.Set("zero_address_height", 2)
+	app, clean := newTestAppWithConfig(t, conf)
+	defer clean()
+
+	key, addr := testKey(t, testKeyA)
+	fundTestAccounts(t, app, big.NewInt(1000), addr)
+	zero, value := common.Address{}, big.NewInt(100)
+
+	// before the activation height the block runs as it did without the policy
+	withData := signTestTx(t, key, etypes.NewTransaction(0, zero, value, testGas, big.NewInt(0), logContractCode))
+	if err := app.CheckTx(withData); err != ErrZeroAddressData {
+		t.Fatalf("expected CheckTx to refuse with %v, got %v", ErrZeroAddressData, err)
+	}
+	if res := execTestBlock(t, app, 1, withData); len(res.ValidTxs) != 1 {
+		t.Fatalf("expected the tx valid before the activation height, got %+v", res.InvalidTxs)
+	}
+	if app.state.GetBalance(zero).Cmp(value) != 0 {
+		t.Fatalf("expected the value credited before the activation height, got %v", app.state.GetBalance(zero))
+	}
+
+	withData = signTestTx(t, key, etypes.NewTransaction(1, zero, value, testGas, big.NewInt(0), logContractCode))
+	if res := execTestBlock(t, app, 2, withData); len(res.InvalidTxs) != 1 || res.InvalidTxs[0].Error != ErrZeroAddressData {
+		t.Fatalf("expected the tx invalidated with %v, got %+v", ErrZeroAddressData, res.InvalidTxs)
+	}
+}
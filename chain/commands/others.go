@@ -16,9 +16,11 @@ package commands
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/spf13/cobra"
 
+	"github.com/dappledger/AnnChain/chain/app/evm"
 	"github.com/dappledger/AnnChain/chain/commands/global"
 	"github.com/dappledger/AnnChain/chain/types"
 	gtypes "github.com/dappledger/AnnChain/gemmill/types"
@@ -102,6 +104,46 @@ func resetCommandFunc(cmd *cobra.Command, args []string) {
 	resetPrivValidator(angineconf.GetString("priv_validator_file"))
 }
 
+func NewRollbackCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "rollback [height]",
+		Short: "Roll the app state back to the committed block at height, the node must be stopped",
+		Args:  cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			runtime, _ := cmd.Flags().GetString("runtime")
+			if err = global.CheckAndReadRuntimeConfig(runtime); err == nil {
+				setFlags(cmd, global.GConf())
+			}
+			return err
+		},
+		Run: rollbackCommandFunc,
+	}
+
+	return c
+}
+
+func rollbackCommandFunc(cmd *cobra.Command, args []string) {
+	height, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		fmt.Println("Invalid height: ", args[0])
+		os.Exit(1)
+	}
+	// the app database is locked by a running node, so opening it fails then
+	app, err := evm.NewEVMApp(global.GConf())
+	if err != nil {
+		fmt.Println("Open app error: ", err)
+		os.Exit(1)
+	}
+	err = app.RollbackToHeight(height)
+	app.Stop()
+	if err != nil {
+		fmt.Println("Rollback error: ", err)
+		os.Exit(1)
+	}
+	fmt.Println("Rolled back to height", height)
+}
+
 func resetPrivValidator(privValidatorFile string) {
 	var (
 		privValidator *gtypes.PrivValidator
@@ -47,6 +47,7 @@ func init() {
 		NewShowCommand(),
 		NewVersionCommand(),
 		NewResetCommand(),
+		NewRollbackCommand(),
 	)
 
 	cobra.EnablePrefixMatching = true
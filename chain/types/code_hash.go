@@ -0,0 +1,58 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/crypto"
+)
+
+// EmptyCodeHash is the code hash the state keeps for existing accounts without code
+var EmptyCodeHash = crypto.Keccak256Hash(nil)
+
+// NormalizeCodeHash maps every representation of "no code" to the zero hash:
+// the zero hash of missing accounts, the empty code hash of accounts without
+// code, and an empty hash. Other hashes, 32 bytes long, are kept.
+func NormalizeCodeHash(hash []byte) (common.Hash, bool) {
+	switch {
+	case len(hash) == 0:
+		return common.Hash{}, true
+	case len(hash) != common.HashLength:
+		return common.Hash{}, false
+	}
+	h := common.BytesToHash(hash)
+	if h == EmptyCodeHash {
+		return common.Hash{}, true
+	}
+	return h, true
+}
+
+// HasCode reports whether the code hash, in any representation, is the hash of
+// some code.
+func HasCode(hash []byte) bool {
+	h, ok := NormalizeCodeHash(hash)
+	return ok && h != (common.Hash{})
+}
+
+// CodeHashMatches reports whether the expected code hash is the hash of the
+// code the state keeps as actual, both normalized, so any representation of
+// "no code" matches any other.
+func CodeHashMatches(expected, actual []byte) bool {
+	e, ok := NormalizeCodeHash(expected)
+	if !ok {
+		return false
+	}
+	a, ok := NormalizeCodeHash(actual)
+	return ok && e == a
+}
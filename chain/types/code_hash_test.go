@@ -0,0 +1,51 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"testing"
+
+	"github.com/dappledger/AnnChain/eth/common"
+)
+
+func TestNormalizeCodeHash(t *testing.T) {
+	if EmptyCodeHash != common.HexToHash("c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470") {
+		t.Fatalf("empty code hash changed: %x", EmptyCodeHash)
+	}
+	code := common.HexToHash("0x1234")
+	for _, c := range []struct {
+		hash     []byte
+		expected common.Hash
+		ok       bool
+	}{
+		{nil, common.Hash{}, true},
+		{common.Hash{}.Bytes(), common.Hash{}, true},
+		{EmptyCodeHash.Bytes(), common.Hash{}, true},
+		{code.Bytes(), code, true},
+		{[]byte{0x01}, common.Hash{}, false},
+	} {
+		if h, ok := NormalizeCodeHash(c.hash); h != c.expected || ok != c.ok {
+			t.Fatalf("%x: expected %x %v, got %x %v", c.hash, c.expected, c.ok, h, ok)
+		}
+	}
+	if !CodeHashMatches(nil, EmptyCodeHash.Bytes()) || !CodeHashMatches(EmptyCodeHash.Bytes(), common.Hash{}.Bytes()) {
+		t.Fatal("expected the representations of no code to match")
+	}
+	if CodeHashMatches(code.Bytes(), EmptyCodeHash.Bytes()) || CodeHashMatches(code[:31], code.Bytes()) {
+		t.Fatal("expected a code hash to match only itself")
+	}
+	if HasCode(EmptyCodeHash.Bytes()) || HasCode(nil) || !HasCode(code.Bytes()) {
+		t.Fatal("unexpected HasCode")
+	}
+}
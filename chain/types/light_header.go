@@ -0,0 +1,53 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+
+	"github.com/dappledger/AnnChain/eth/common"
+)
+
+// LightHeader is the state progression of one committed block, for light
+// clients. Its canonical encoding is the rlp list of the fields in order, and
+// its hash the keccak256 of that encoding.
+type LightHeader struct {
+	Height         uint64
+	PrevAppHash    common.Hash // app hash committed by the previous block
+	BlockHash      []byte
+	NewAppHash     common.Hash // app hash committed by this block
+	ReceiptsHash   []byte      // merkle root of the block's receipts, as in CommitResult
+	LogsRoot       common.Hash // trie root of the block's logs, in receipt order
+	ValidatorsHash []byte      // hash of the validator set of the block
+}
+
+// Hash returns the keccak256 of the canonical rlp encoding of h.
+func (h *LightHeader) Hash() common.Hash {
+	return rlpHash(h)
+}
+
+// VerifyLightHeaderChain checks headers are consecutive blocks, each one
+// starting from the app hash the previous one committed.
+func VerifyLightHeaderChain(headers []*LightHeader) error {
+	for i := 1; i < len(headers); i++ {
+		prev, next := headers[i-1], headers[i]
+		if next.Height != prev.Height+1 {
+			return fmt.Errorf("light header %d follows height %d", next.Height, prev.Height)
+		}
+		if next.PrevAppHash != prev.NewAppHash {
+			return fmt.Errorf("light header %d doesn't start from the app hash of height %d", next.Height, prev.Height)
+		}
+	}
+	return nil
+}
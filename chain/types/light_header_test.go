@@ -0,0 +1,78 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func TestLightHeaderEncoding(t *testing.T) {
+	h := &LightHeader{
+		Height:         7,
+		PrevAppHash:    common.HexToHash("0x01"),
+		BlockHash:      common.FromHex("0x0202020202020202020202020202020202020202"),
+		NewAppHash:     common.HexToHash("0x03"),
+		ReceiptsHash:   common.FromHex("0x0404040404040404040404040404040404040404"),
+		LogsRoot:       common.HexToHash("0x05"),
+		ValidatorsHash: common.FromHex("0x0606060606060606060606060606060606060606"),
+	}
+	enc, err := rlp.EncodeToBytes(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := common.FromHex("f8a307" +
+		"a00000000000000000000000000000000000000000000000000000000000000001" +
+		"940202020202020202020202020202020202020202" +
+		"a00000000000000000000000000000000000000000000000000000000000000003" +
+		"940404040404040404040404040404040404040404" +
+		"a00000000000000000000000000000000000000000000000000000000000000005" +
+		"940606060606060606060606060606060606060606")
+	if !bytes.Equal(enc, golden) {
+		t.Fatalf("light header encoding changed: %x", enc)
+	}
+	if hash := h.Hash(); hash != common.HexToHash("326312d833f86b897304a4797fe821b980c080d5f8527e59191be7a365aed611") {
+		t.Fatalf("light header hash changed: %x", hash)
+	}
+
+	var decoded LightHeader
+	if err := rlp.DecodeBytes(enc, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Hash() != h.Hash() {
+		t.Fatal("expected the decoded light header to hash the same")
+	}
+}
+
+func TestVerifyLightHeaderChain(t *testing.T) {
+	headers := []*LightHeader{
+		{Height: 1, PrevAppHash: common.HexToHash("0x01"), NewAppHash: common.HexToHash("0x02")},
+		{Height: 2, PrevAppHash: common.HexToHash("0x02"), NewAppHash: common.HexToHash("0x03")},
+		{Height: 3, PrevAppHash: common.HexToHash("0x03"), NewAppHash: common.HexToHash("0x03")},
+	}
+	if err := VerifyLightHeaderChain(headers); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyLightHeaderChain([]*LightHeader{headers[0], headers[2]}); err == nil {
+		t.Fatal("expected a height gap to be rejected")
+	}
+	forked := *headers[1]
+	forked.PrevAppHash = common.HexToHash("0x04")
+	if err := VerifyLightHeaderChain([]*LightHeader{headers[0], &forked}); err == nil {
+		t.Fatal("expected a broken app hash link to be rejected")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/crypto"
+)
+
+type SignatureScheme = byte
+
+const (
+	// SignatureScheme_Keccak signs keccak256(message)
+	SignatureScheme_Keccak SignatureScheme = 0
+	// SignatureScheme_Personal signs the EIP-191 personal message hash, as personal_sign:
+	// keccak256("\x19Ethereum Signed Message:\n" || len(message) || message)
+	SignatureScheme_Personal SignatureScheme = 1
+	// SignatureScheme_Chain signs the message in the domain of one chain, so it can't
+	// be replayed on another chain or as a personal message:
+	// keccak256("\x19AnnChain Signed Message:\n" || chain id || ":" || len(message) || message)
+	SignatureScheme_Chain SignatureScheme = 2
+)
+
+// ERC1271MagicValue is answered by isValidSignature(bytes32,bytes) of contract
+// accounts for a valid signature.
+var ERC1271MagicValue = [4]byte{0x16, 0x26, 0xba, 0x7e}
+
+// VerifySignatureQuery asks whether Signature signs Message for Address
+type VerifySignatureQuery struct {
+	Address   common.Address
+	Message   []byte
+	Signature []byte // r || s || v, v is 0/1 or 27/28, any bytes for a contract account
+	Scheme    SignatureScheme
+}
+
+// VerifySignatureResult answers a VerifySignatureQuery
+type VerifySignatureResult struct {
+	Valid     bool
+	Recovered common.Address // signer recovered from the signature, zero for contract accounts
+}
+
+// SignatureHash returns the hash of message signed under scheme, chainID is only
+// used by SignatureScheme_Chain.
+func SignatureHash(scheme SignatureScheme, chainID *big.Int, message []byte) ([]byte, error) {
+	switch scheme {
+	case SignatureScheme_Keccak:
+		return crypto.Keccak256(message), nil
+	case SignatureScheme_Personal:
+		return crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d", len(message))), message), nil
+	case SignatureScheme_Chain:
+		if chainID == nil {
+			chainID = new(big.Int)
+		}
+		return crypto.Keccak256([]byte(fmt.Sprintf("\x19AnnChain Signed Message:\n%s:%d", chainID, len(message))), message), nil
+	default:
+		return nil, fmt.Errorf("unknown signature scheme %d", scheme)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/crypto"
+)
+
+func TestSignatureHash(t *testing.T) {
+	message := []byte("hello world")
+	for _, c := range []struct {
+		scheme   SignatureScheme
+		chainID  *big.Int
+		expected []byte
+	}{
+		{SignatureScheme_Keccak, nil, crypto.Keccak256(message)},
+		// personal_sign("hello world")
+		{SignatureScheme_Personal, nil, common.FromHex("0xd9eba16ed0ecae432b71fe008c98cc872bb4cc214d3220a36f365326cf807d68")},
+		{SignatureScheme_Chain, big.NewInt(1), crypto.Keccak256([]byte("\x19AnnChain Signed Message:\n1:11hello world"))},
+	} {
+		hash, err := SignatureHash(c.scheme, c.chainID, message)
+		if err != nil || !bytes.Equal(hash, c.expected) {
+			t.Fatalf("scheme %d: expected %x, got %x %v", c.scheme, c.expected, hash, err)
+		}
+	}
+	a, _ := SignatureHash(SignatureScheme_Chain, big.NewInt(1), message)
+	b, _ := SignatureHash(SignatureScheme_Chain, big.NewInt(2), message)
+	if bytes.Equal(a, b) {
+		t.Fatal("expected the chain scheme to depend on the chain id")
+	}
+	if _, err := SignatureHash(9, nil, message); err == nil {
+		t.Fatal("expected an unknown scheme to be rejected")
+	}
+}
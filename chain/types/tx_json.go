@@ -0,0 +1,176 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// TxJSON is the canonical json form of a signed evm tx, for SDKs that can't
+// produce the rlp encoding themselves. Numbers and byte strings are 0x hex:
+//
+//	{
+//	  "from":     "0x...",  // sender, optional when encoding, checked if given
+//	  "to":       "0x...",  // null or omitted for contract creation
+//	  "nonce":    "0x1",
+//	  "value":    "0x0",
+//	  "gas":      "0x5208",
+//	  "gasPrice": "0x0",
+//	  "data":     "0x",
+//	  "v": "0x1b", "r": "0x...", "s": "0x...", // homestead signature, v is 27 or 28
+//	  "hash":     "0x..."   // chain tx hash, output only
+//	}
+//
+// Txs are signed with the homestead signer, the one the evm app verifies with.
+type TxJSON struct {
+	From     *common.Address `json:"from,omitempty"`
+	To       *common.Address `json:"to"`
+	Nonce    hexutil.Uint64  `json:"nonce"`
+	Value    *hexutil.Big    `json:"value"`
+	Gas      hexutil.Uint64  `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Data     hexutil.Bytes   `json:"data"`
+	V        *hexutil.Big    `json:"v,omitempty"`
+	R        *hexutil.Big    `json:"r,omitempty"`
+	S        *hexutil.Big    `json:"s,omitempty"`
+	Hash     *common.Hash    `json:"hash,omitempty"`
+}
+
+var txJSONSigner = etypes.HomesteadSigner{}
+
+// ParseTxJSON parses the json form strictly, unknown fields are errors.
+func ParseTxJSON(data []byte) (*TxJSON, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	tx := &TxJSON{}
+	if err := dec.Decode(tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// DecodeTxJSON converts raw tx bytes to the json form, with sender and hash filled.
+func DecodeTxJSON(raw []byte) (*TxJSON, error) {
+	tx := &etypes.Transaction{}
+	if err := rlp.DecodeBytes(raw, tx); err != nil {
+		return nil, fmt.Errorf("decode tx: %v", err)
+	}
+	from, err := etypes.Sender(txJSONSigner, tx)
+	if err != nil {
+		return nil, fmt.Errorf("recover sender: %v", err)
+	}
+	v, r, s := tx.RawSignatureValues()
+	hash := common.BytesToHash(gtypes.Tx(raw).Hash())
+	return &TxJSON{
+		From:     &from,
+		To:       tx.To(),
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		Value:    (*hexutil.Big)(tx.Value()),
+		Gas:      hexutil.Uint64(tx.Gas()),
+		GasPrice: (*hexutil.Big)(tx.GasPrice()),
+		Data:     tx.Data(),
+		V:        (*hexutil.Big)(v),
+		R:        (*hexutil.Big)(r),
+		S:        (*hexutil.Big)(s),
+		Hash:     &hash,
+	}, nil
+}
+
+// EncodeTxJSON converts the json form of a signed tx to raw tx bytes.
+func EncodeTxJSON(j *TxJSON) ([]byte, error) {
+	if j.V == nil || j.R == nil || j.S == nil {
+		return nil, fmt.Errorf("missing signature")
+	}
+	v := j.V.ToInt()
+	if !v.IsUint64() || (v.Uint64() != 27 && v.Uint64() != 28) {
+		return nil, fmt.Errorf("invalid signature v %v", v)
+	}
+	r, s := j.R.ToInt().Bytes(), j.S.ToInt().Bytes()
+	if len(r) > 32 || len(s) > 32 {
+		return nil, fmt.Errorf("invalid signature r or s")
+	}
+	sig := make([]byte, 65)
+	copy(sig[32-len(r):32], r)
+	copy(sig[64-len(s):64], s)
+	sig[64] = byte(v.Uint64() - 27)
+
+	tx, err := j.unsigned().WithSignature(txJSONSigner, sig)
+	if err != nil {
+		return nil, err
+	}
+	return j.encode(tx)
+}
+
+// SignTxJSON signs the json form with key and returns raw tx bytes, signature
+// fields in j are ignored. It's meant for tests and tooling.
+func SignTxJSON(j *TxJSON, key *ecdsa.PrivateKey) ([]byte, error) {
+	tx, err := etypes.SignTx(j.unsigned(), txJSONSigner, key)
+	if err != nil {
+		return nil, err
+	}
+	return j.encode(tx)
+}
+
+func (j *TxJSON) unsigned() *etypes.Transaction {
+	value, gasPrice := new(big.Int), new(big.Int)
+	if j.Value != nil {
+		value = j.Value.ToInt()
+	}
+	if j.GasPrice != nil {
+		gasPrice = j.GasPrice.ToInt()
+	}
+	if j.To == nil {
+		return etypes.NewContractCreation(uint64(j.Nonce), value, uint64(j.Gas), gasPrice, j.Data)
+	}
+	return etypes.NewTransaction(uint64(j.Nonce), *j.To, value, uint64(j.Gas), gasPrice, j.Data)
+}
+
+// encode checks the sender against j.From, if given, and returns the rlp bytes.
+func (j *TxJSON) encode(tx *etypes.Transaction) ([]byte, error) {
+	from, err := etypes.Sender(txJSONSigner, tx)
+	if err != nil {
+		return nil, fmt.Errorf("recover sender: %v", err)
+	}
+	if j.From != nil && *j.From != from {
+		return nil, fmt.Errorf("sender mismatch, signed by %x, from %x", from, *j.From)
+	}
+	return rlp.EncodeToBytes(tx)
+}
+
+// DecodedInput is the json form of a tx input decoded with the ABI registered for
+// its To address. Method and Args are empty when the input couldn't be decoded,
+// Raw always holds the input.
+type DecodedInput struct {
+	Method string        `json:"method,omitempty"`
+	Args   []DecodedArg  `json:"args,omitempty"`
+	Raw    hexutil.Bytes `json:"raw"`
+}
+
+// DecodedArg is one argument of a decoded input, integers are decimal and byte
+// strings 0x hex.
+type DecodedArg struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
@@ -0,0 +1,115 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/crypto"
+)
+
+const testTxKey = "7d73c3dafd3c0215b8526b26f8dbdb93242fc7dcfbdfa1000d93436d577c3b94"
+
+// golden vector: the unsigned tx below signed with testTxKey
+var (
+	goldenUnsignedJSON = `{"to":"0x0100000000000000000000000000000000000001","nonce":"0x1","value":"0xa","gas":"0x5208","gasPrice":"0x1","data":"0x1234"}`
+	goldenSignedJSON   = `{"from":"0xaafbb065a30878528b214807863541fb2d15c555","to":"0x0100000000000000000000000000000000000001","nonce":"0x1","value":"0xa","gas":"0x5208","gasPrice":"0x1","data":"0x1234","v":"0x1c","r":"0xad063c77465afe8994b4c7afd555f3b4275e2a840e2b37e7a95c5238b95b24d9","s":"0x4d9c8a9df384fb84b630ff8c3e875450c694613469bb8189f2fb9dee57f7f0c0","hash":"0x184cf50cb8ac08b1ad9f962bc5da281f44c06437410aa4d96b51d0e3fbb85929"}`
+	goldenRaw          = common.FromHex("f86101018252089401000000000000000000000000000000000000010a8212341ca0ad063c77465afe8994b4c7afd555f3b4275e2a840e2b37e7a95c5238b95b24d9a04d9c8a9df384fb84b630ff8c3e875450c694613469bb8189f2fb9dee57f7f0c0")
+)
+
+func TestTxJSONGolden(t *testing.T) {
+	key, err := crypto.HexToECDSA(testTxKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := ParseTxJSON([]byte(goldenUnsignedJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := SignTxJSON(unsigned, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, goldenRaw) {
+		t.Fatalf("signed tx %x differs from golden", raw)
+	}
+
+	decoded, err := DecodeTxJSON(goldenRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != goldenSignedJSON {
+		t.Fatalf("decoded json differs from golden:\n%s", data)
+	}
+
+	signed, err := ParseTxJSON([]byte(goldenSignedJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err = EncodeTxJSON(signed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, goldenRaw) {
+		t.Fatalf("encoded tx %x differs from golden", raw)
+	}
+}
+
+func TestTxJSONRoundTrip(t *testing.T) {
+	key, err := crypto.HexToECDSA(testTxKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creation := &TxJSON{Nonce: 7, Gas: 100000, Data: common.FromHex("6000")}
+	raw, err := SignTxJSON(creation, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeTxJSON(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.To != nil || decoded.Nonce != 7 || decoded.Value.ToInt().Sign() != 0 || *decoded.From != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("unexpected decoded creation %+v", decoded)
+	}
+	reencoded, err := EncodeTxJSON(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, reencoded) {
+		t.Fatal("round trip changed the tx")
+	}
+
+	other := common.HexToAddress("0x01")
+	decoded.From = &other
+	if _, err := EncodeTxJSON(decoded); err == nil {
+		t.Fatal("expected sender mismatch to be rejected")
+	}
+	decoded.From, decoded.V = nil, nil
+	if _, err := EncodeTxJSON(decoded); err == nil {
+		t.Fatal("expected missing signature to be rejected")
+	}
+	if _, err := ParseTxJSON([]byte(`{"to":null,"nonce":"0x0","gas":"0x1","private":{"members":[]}}`)); err == nil {
+		t.Fatal("expected unknown field to be rejected")
+	}
+	if _, err := DecodeTxJSON([]byte{0x01, 0x02}); err == nil {
+		t.Fatal("expected garbage to be rejected")
+	}
+}
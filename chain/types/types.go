@@ -13,7 +13,11 @@
 
 package types
 
-import "github.com/dappledger/AnnChain/eth/common"
+import (
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+)
 
 type (
 	// LastBlockInfo used for crash recover
@@ -30,18 +34,478 @@ type (
 		Message string
 	}
 
+	// TxStatus records the latest known state of a tx accepted by the tx pool
+	TxStatus struct {
+		Status     TxStatusType
+		Height     uint64      // committed height for TxStatus_Committed, height held back until for TxStatus_Demoted
+		Reason     string      // why the tx left the pool or was demoted, only for TxStatus_Evicted and TxStatus_Demoted
+		ReplacedBy common.Hash // hash of the replacing tx, only for TxStatus_Replaced
+		Time       uint64      // unix time of the last update
+	}
+
+	// PoolTx is a tx of an account in the tx pool
+	PoolTx struct {
+		Hash    common.Hash
+		Nonce   uint64
+		Waiting bool // queued behind a nonce gap, not yet processable
+	}
+
+	// DestroyedStatus tells a self-destructed contract from a never deployed address
+	DestroyedStatus struct {
+		Destroyed bool
+		Height    uint64 // height of the block destroying the contract
+	}
+
+	// CallObject is an unsigned contract query, From isn't authenticated
+	CallObject struct {
+		From       common.Address
+		To         *common.Address `rlp:"nil"` // nil for a contract creation
+		Value      *big.Int
+		Gas        uint64 // 0 for the evm gas limit
+		GasPrice   *big.Int
+		Data       []byte
+		Height     uint64 // height of the queried state, 0 for the latest state
+		CheckNonce bool   // fail unless Nonce is the sender's nonce in the queried state
+		Nonce      uint64
+	}
+
+	// CallFrame is a call of a contract, Selector holds the first 4 bytes of its input
+	CallFrame struct {
+		Address  common.Address
+		Selector [4]byte
+	}
+
+	// CallDepthResult answers a contract query run with a call depth cap
+	CallDepthResult struct {
+		Ret    []byte      // output of the query, empty when capped
+		Capped bool        // a call beyond the cap was attempted
+		Path   []CallFrame // calls from the query target to the frame hitting the cap
+	}
+
+	// Misbehavior is validator misbehavior evidence recorded on chain
+	Misbehavior struct {
+		Offender       []byte // validator address
+		Height         uint64 // height of the conflicting votes
+		RecordedHeight uint64 // height of the block recording the evidence
+		EvidenceHash   []byte
+	}
+
+	// StateDiffQuery asks for the accounts differing between two committed state roots,
+	// in hashed address order from Start
+	StateDiffQuery struct {
+		RootA common.Hash
+		RootB common.Hash
+		Start common.Hash // hashed address the page starts at
+		Limit uint64      // max accounts of the page, 0 for the state_diff_limit of the node
+	}
+
+	// AccountDiff is an account added, removed or changed from RootA to RootB
+	AccountDiff struct {
+		Key     common.Hash     // hashed address
+		Address *common.Address `rlp:"nil"` // nil when the address preimage isn't known
+		Kind    StateDiffKind
+		Fields  []string // changed fields among nonce, balance, storage and code, only for StateDiff_Changed
+	}
+
+	// StateDiff is a page of a state diff
+	StateDiff struct {
+		Accounts []AccountDiff
+		More     bool        // the diff goes on from Next
+		Next     common.Hash // Start of the next page
+	}
+
+	// StorageDiffQuery asks for the storage slots of the contract Address differing
+	// between the states of HeightA and HeightB, in hashed slot order from Start
+	StorageDiffQuery struct {
+		Address common.Address
+		HeightA uint64
+		HeightB uint64
+		Start   common.Hash // hashed slot the page starts at
+		Limit   uint64      // max slots of the page, 0 for the state_diff_limit of the node
+	}
+
+	// SlotDiff is a storage slot changed from HeightA to HeightB, a zero value
+	// for a slot not set
+	SlotDiff struct {
+		KeyHash common.Hash  // hashed slot
+		Key     *common.Hash `rlp:"nil"` // nil when the slot preimage isn't known
+		Old     common.Hash
+		New     common.Hash
+	}
+
+	// StorageDiff is a page of a storage diff
+	StorageDiff struct {
+		Slots []SlotDiff
+		More  bool        // the diff goes on from Next
+		Next  common.Hash // Start of the next page
+	}
+
+	// SyncStatus compares the app's committed height with the latest block known to the core
+	SyncStatus struct {
+		Syncing bool   // the app lags the core by sync_lag_threshold blocks or more
+		Height  uint64 // committed app height
+		Target  uint64 // latest block height stored by the core
+	}
+
+	// PredictAddressQuery asks the address of the contract Sender would deploy
+	PredictAddressQuery struct {
+		Sender   common.Address
+		Nonce    uint64 // nonce of the deploying tx, for CREATE
+		Create2  bool   // a CREATE2 by the contract Sender, from Salt and InitCode
+		Salt     common.Hash
+		InitCode []byte
+	}
+
+	// AccountQuery asks the account Address on the state picked by Target
+	AccountQuery struct {
+		Target  QueryTarget
+		Height  uint64 // height of the state, only for QueryTarget_Height
+		Address common.Address
+	}
+
+	// StorageQuery asks the storage slots Keys of the account Address on the
+	// state picked by Target, see QueryType_StorageMulti
+	StorageQuery struct {
+		Target  QueryTarget
+		Height  uint64 // height of the state, only for QueryTarget_Height
+		Address common.Address
+		Keys    []common.Hash
+	}
+
+	// StorageValues are the values of the keys of a StorageQuery, in order, as
+	// read on the state of Height
+	StorageValues struct {
+		Height uint64 // height of the block committing the state read
+		Values []common.Hash
+	}
+
+	// AccountState is an account as read on the state of Height
+	AccountState struct {
+		Height   uint64 // height of the block committing the state read
+		Balance  *big.Int
+		Nonce    uint64
+		CodeSize uint64
+	}
+
+	// AccountAtRoot is an account read on the latest committed state along with
+	// the root of that state, the app hash of Height. Proof is the account trie
+	// proof of the account against AppHash, an absent account is proven too.
+	AccountAtRoot struct {
+		Height  uint64
+		AppHash common.Hash
+		Nonce   uint64
+		Balance *big.Int
+		Proof   [][]byte
+	}
+
+	// ChainRules are the forks active at Height, as picked by the fork schedule
+	ChainRules struct {
+		Height         uint64
+		ChainID        *big.Int
+		Homestead      bool
+		EIP150         bool
+		EIP155         bool
+		EIP158         bool
+		Byzantium      bool
+		Constantinople bool
+	}
+
+	// ConfigSnapshot is the consensus parameters the committed block at Height
+	// was executed with, those of the config epoch starting at EpochStart
+	ConfigSnapshot struct {
+		Height      uint64
+		EpochStart  uint64
+		Rules       ChainRules
+		EVMGasLimit uint64
+		ChainConfig []byte // json encoded chain config
+	}
+
+	// TxResult is the compact outcome of a committed tx
+	TxResult struct {
+		Status  TxResultStatus
+		GasUsed uint64
+		Height  uint64
+	}
+
+	// SimulateBlockQuery asks what a block of Txs on top of the committed block
+	// at ParentHeight would result in, see QueryType_SimulateBlock
+	SimulateBlockQuery struct {
+		ParentHeight uint64 // 0 for the latest block
+		Txs          [][]byte
+		Time         uint64          // unix time of the block, 0 for now
+		Coinbase     *common.Address `rlp:"nil"` // nil for the coinbase of the node
+	}
+
+	// SimulatedBlock is what executing a block would result in, its roots being
+	// those committing it would return
+	SimulatedBlock struct {
+		Height       uint64
+		AppHash      common.Hash
+		ReceiptsHash []byte
+		GasUsed      uint64
+		Receipts     []SimulatedReceipt // of the valid txs, in execution order
+		InvalidTxs   []SimulatedInvalidTx
+	}
+
+	// SimulatedReceipt sums up the receipt of a simulated tx
+	SimulatedReceipt struct {
+		TxHash          common.Hash
+		Status          TxResultStatus
+		GasUsed         uint64
+		ContractAddress common.Address
+		Logs            uint64
+	}
+
+	// SimulatedInvalidTx is a tx a simulated block would drop, and why
+	SimulatedInvalidTx struct {
+		TxHash common.Hash
+		Error  string
+	}
+
+	// PageQuery asks for a page of the list answered by the list query Query
+	// with Load, see QueryType_Page. Token is the Next of the previous page,
+	// empty for the first page.
+	PageQuery struct {
+		Query QueryType
+		Load  []byte
+		Token []byte
+		Limit uint64 // max items of the page, 0 for the max page size of the query
+	}
+
+	// Page is a page of a list, Items are the rlp encoded items of the list. Next
+	// is the token of the following page, empty once the list is complete.
+	// Truncated tells the page was cut short by the response size limit of the
+	// node rather than by its max items.
+	Page struct {
+		Items     [][]byte
+		Next      []byte
+		Truncated bool
+	}
+
+	// CommitStats records the db writes of committing one block
+	CommitStats struct {
+		Height          uint64
+		TrieNodes       uint64 // entries written by the state trie commit, code and preimages included
+		TrieBytes       uint64 // key and value bytes written by the state trie commit
+		ReceiptBytes    uint64 // key and value bytes written by the receipts batch
+		TrieDuration    uint64 // nanoseconds spent committing the state trie
+		ReceiptDuration uint64 // nanoseconds spent saving receipts
+		Creations       uint64 // contract creations executed by the block, internal ones included
+	}
+
+	// BlockSummary sums up a committed block, see QueryType_RecentBlocks
+	BlockSummary struct {
+		Height  uint64
+		AppHash common.Hash
+		Txs     uint64 // txs of the block, the invalid ones included
+		GasUsed uint64 // gas used by the valid txs
+		Time    uint64 // unix time of the block
+	}
+
+	// GasOverRange is the gas used by the blocks From to To, see
+	// QueryType_GasOverRange. GasUsed sums the gas of the Blocks with a summary,
+	// the Unavailable ones, committed before the summaries were indexed, aren't
+	// counted: the sum is partial when some are.
+	GasOverRange struct {
+		From        uint64
+		To          uint64
+		GasUsed     uint64
+		Blocks      uint64
+		Unavailable uint64
+	}
+
+	// AppMessageBatch is the messages a committed block sent to the sibling apps
+	// of the node, see QueryType_AppMessages. Txs are the app message txs, in
+	// nonce order, the node submits to the apps they're for. Root is their root
+	// hash, committed into the state of the block.
+	AppMessageBatch struct {
+		Height uint64
+		Root   common.Hash
+		Txs    [][]byte
+	}
+
+	// ReceiptProof is the receipt of a tx along with its merkle proof in the
+	// receipts trie of its block, see QueryType_ReceiptProof. Receipt is the
+	// consensus encoding of the receipt, the trie value at the rlp encoded Index,
+	// and ReceiptsRoot the receipts hash of block Height, which the header of
+	// the next block carries.
+	ReceiptProof struct {
+		Height       uint64
+		TxHash       common.Hash
+		Index        uint64 // position among the receipts of the block
+		ReceiptsRoot common.Hash
+		Receipt      []byte
+		Proof        [][]byte
+	}
+
+	// LogsQuery selects the logs of the blocks FromBlock to ToBlock included,
+	// see QueryType_Logs. ToBlock 0, or past the committed height, is the
+	// committed height. Topics[i] are the alternatives of the i-th topic, an
+	// empty position matches any topic.
+	LogsQuery struct {
+		FromBlock uint64
+		ToBlock   uint64
+		Addresses []common.Address // any address when empty
+		Topics    [][]common.Hash
+	}
+
+	// LogRecord is a log at its position in the chain. Height, TxIndex, the
+	// position of the receipt in its block, and LogIndex, the position of the
+	// log in its block, order the logs of the chain.
+	LogRecord struct {
+		Height    uint64
+		TxIndex   uint64
+		LogIndex  uint64
+		TxHash    common.Hash
+		BlockHash common.Hash
+		Address   common.Address
+		Topics    []common.Hash
+		Data      []byte
+	}
+
+	// PoolDropBucket counts the txs the tx pool refused or dropped in one minute,
+	// Counts is indexed by PoolDropReason
+	PoolDropBucket struct {
+		Minute uint64 // unix time of the start of the minute
+		Counts []uint64
+	}
+
+	// PoolWatermarks is the fill of the tx pool queues and of the CheckTx slots
+	// against their limits, a 0 limit for none
+	PoolWatermarks struct {
+		Pending      uint64
+		PendingLimit uint64
+		Waiting      uint64
+		WaitingLimit uint64
+		CheckTxs     uint64
+		CheckTxLimit uint64
+	}
+
+	// PoolDropStats is the drops of the tx pool by minute, the oldest first, with
+	// the pool watermarks at Time, see QueryType_PoolDropStats. The minutes
+	// without drops have no bucket.
+	PoolDropStats struct {
+		Buckets    []PoolDropBucket
+		Watermarks PoolWatermarks
+		Time       uint64 // unix time the stats were taken at
+	}
+
 	QueryType = byte
+
+	QueryTarget = byte
+
+	TxStatusType = byte
+
+	TxResultStatus = byte
+
+	StateDiffKind = byte
+
+	PoolDropReason = byte
+)
+
+const (
+	APIQueryTx                               = iota
+	QueryType_Contract             QueryType = 0
+	QueryType_Nonce                QueryType = 1
+	QueryType_Balance              QueryType = 2
+	QueryType_Receipt              QueryType = 3
+	QueryType_Existence            QueryType = 4
+	QueryType_PayLoad              QueryType = 5
+	QueryType_TxRaw                QueryType = 6
+	QueryTxLimit                   QueryType = 9
+	QueryTypeContractByHeight      QueryType = 10
+	QueryType_BalancesBatch        QueryType = 11
+	QueryType_TxStatus             QueryType = 12
+	QueryType_CommitStats          QueryType = 13
+	QueryType_DecodeTx             QueryType = 14
+	QueryType_BlockTouchedAccounts QueryType = 15
+	QueryType_SyncStatus           QueryType = 16
+	QueryType_IsDestroyed          QueryType = 17
+	QueryType_ContractDepthCapped  QueryType = 18
+	QueryType_DecodeInput          QueryType = 19
+	QueryType_Misbehavior          QueryType = 20
+	QueryType_Call                 QueryType = 21
+	QueryType_StateDiff            QueryType = 22
+	QueryType_LightHeader          QueryType = 23
+	QueryType_PendingBySender      QueryType = 24
+	QueryType_CodeSize             QueryType = 25
+	QueryType_RulesAt              QueryType = 26
+	QueryType_VerifySignature      QueryType = 27
+	QueryType_PredictAddress       QueryType = 28
+	QueryType_TxRoot               QueryType = 29
+	QueryType_GenesisHash          QueryType = 30
+	QueryType_ViewCall             QueryType = 31
+	QueryType_ContractCount        QueryType = 32
+	QueryType_Account              QueryType = 33
+	QueryType_AccountAtRoot        QueryType = 34
+	QueryType_ConfigAtHeight       QueryType = 35
+	QueryType_RawTx                QueryType = 36
+	QueryType_Page                 QueryType = 37
+	QueryType_TxResult             QueryType = 38
+	QueryType_SimulateBlock        QueryType = 39
+	QueryType_EVMHeader            QueryType = 40
+	QueryType_RecentBlocks         QueryType = 41
+	QueryType_AppMessages          QueryType = 42
+	QueryType_StorageMulti         QueryType = 43
+	QueryType_ReceiptProof         QueryType = 44
+	QueryType_Logs                 QueryType = 45
+	QueryType_StorageDiff          QueryType = 46
+	QueryType_PoolDropStats        QueryType = 47
+	QueryType_GasOverRange         QueryType = 48
+)
+
+// The states a query can read. Latest is what the queries without a target
+// read, it never shows the effects of a block before the block is committed.
+const (
+	QueryTarget_Latest  QueryTarget = 0 // state of the last committed block
+	QueryTarget_Pending QueryTarget = 1 // latest state with the pool txs of the account applied
+	QueryTarget_Height  QueryTarget = 2 // state committed at a given height
+)
+
+const (
+	TxStatus_Unknown   TxStatusType = 0
+	TxStatus_Accepted  TxStatusType = 1
+	TxStatus_Pending   TxStatusType = 2
+	TxStatus_Committed TxStatusType = 3
+	TxStatus_Evicted   TxStatusType = 4
+	TxStatus_Replaced  TxStatusType = 5
+	TxStatus_Expired   TxStatusType = 6
+	TxStatus_Demoted   TxStatusType = 7 // back in the waiting queue after failing when reaping a proposal
 )
 
+// The outcomes of a committed tx. The receipt status only tells a tx failed, the
+// failure is told apart by the gas it used: a revert gives the gas left back,
+// any other error, running out of gas included, uses all the gas of the tx.
 const (
-	APIQueryTx                          = iota
-	QueryType_Contract        QueryType = 0
-	QueryType_Nonce           QueryType = 1
-	QueryType_Balance         QueryType = 2
-	QueryType_Receipt         QueryType = 3
-	QueryType_Existence       QueryType = 4
-	QueryType_PayLoad         QueryType = 5
-	QueryType_TxRaw           QueryType = 6
-	QueryTxLimit              QueryType = 9
-	QueryTypeContractByHeight QueryType = 10
+	TxResult_Success  TxResultStatus = 0
+	TxResult_Reverted TxResultStatus = 1
+	TxResult_OutOfGas TxResultStatus = 2 // failed using all its gas
+	TxResult_Failed   TxResultStatus = 3 // failed, committed before the cause of failures was recorded
 )
+
+// The reasons the tx pool refuses or drops a tx, the indexes of
+// PoolDropBucket.Counts
+const (
+	PoolDrop_Underpriced PoolDropReason = 0 // gas price below the min, or a replacement not paying more
+	PoolDrop_PoolFull    PoolDropReason = 1 // no room left in the waiting queue
+	PoolDrop_Expired     PoolDropReason = 2 // waiting longer than the waiting lifetime
+	PoolDrop_Replaced    PoolDropReason = 3 // replaced by a tx of the same sender and nonce
+	PoolDrop_StaleNonce  PoolDropReason = 4 // nonce below the sender's
+
+	PoolDropReasons = 5
+)
+
+const (
+	StateDiff_Added   StateDiffKind = 1
+	StateDiff_Removed StateDiffKind = 2
+	StateDiff_Changed StateDiffKind = 3
+)
+
+// IsTerminal reports whether the tx has left the pool for good
+func (s *TxStatus) IsTerminal() bool {
+	switch s.Status {
+	case TxStatus_Committed, TxStatus_Evicted, TxStatus_Replaced, TxStatus_Expired:
+		return true
+	}
+	return false
+}
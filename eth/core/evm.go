@@ -33,7 +33,11 @@ type ChainContext interface {
 
 // NewEVMContext creates a new context for use in the EVM.
 func NewEVMContext(msg Message, header *types.Header, chain ChainContext, author *common.Address) vm.Context {
-	var beneficiary common.Address
+	// no consensus engine names the author, the header's coinbase collects the fees
+	beneficiary := header.Coinbase
+	if author != nil {
+		beneficiary = *author
+	}
 
 	return vm.Context{
 		CanTransfer: CanTransfer,
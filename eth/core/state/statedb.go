@@ -595,6 +595,32 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 	s.clearJournalAndRefund()
 }
 
+// DirtyAccounts returns the addresses of the accounts modified since the last commit.
+func (s *StateDB) DirtyAccounts() []common.Address {
+	addrs := make([]common.Address, 0, len(s.stateObjectsDirty)+len(s.journal.dirties))
+	for addr := range s.stateObjectsDirty {
+		addrs = append(addrs, addr)
+	}
+	for addr := range s.journal.dirties {
+		if _, ok := s.stateObjectsDirty[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// SuicidedAccounts returns the addresses of the accounts self-destructed since
+// the last commit, and not created again after that.
+func (s *StateDB) SuicidedAccounts() []common.Address {
+	var addrs []common.Address
+	for addr, stateObject := range s.stateObjects {
+		if stateObject.suicided {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
 // IntermediateRoot computes the current root hash of the state trie.
 // It is called in between transactions to get the root hash that
 // goes into transaction receipts.
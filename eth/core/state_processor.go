@@ -59,7 +59,8 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	// 	root = statedb.IntermediateRoot(config.IsEIP158(header.Number)).Bytes()
 	// }
 	//Edit by zhongan
-	statedb.Finalise(true)
+	// empty accounts are kept when the chain keeps a min account balance
+	statedb.Finalise(config.MinAccountBalance == nil)
 	*usedGas += gas
 
 	// Create a new receipt for the transaction, storing the intermediate root and gas used by the tx
@@ -216,8 +216,9 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, failed bo
 		log.Debug("VM returned with error", "err", vmerr)
 		// The only possible consensus-error would be if there wasn't
 		// sufficient balance to make the transfer happen. The first
-		// balance transfer may never fail.
-		if vmerr == vm.ErrInsufficientBalance {
+		// balance transfer may never fail. A tx taking the block past
+		// its log data cap is left out of the block.
+		if vmerr == vm.ErrInsufficientBalance || vmerr == vm.ErrBlockLogDataLimit {
 			return nil, 0, false, vmerr
 		}
 	}
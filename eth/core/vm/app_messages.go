@@ -0,0 +1,91 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vm
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/params"
+)
+
+// AppMessagesAddress is the precompile contracts call to send a message to a
+// sibling app of the node, active with ChainConfig.AppMessages. The input is
+// the destination app name, right padded to 32 bytes, the 32 bytes account of
+// the destination app the message is for, then the payload. The output is the
+// nonce of the message.
+//
+// Sending a message increments the nonce in AppMessagesNonceSlot and emits a
+// log of AppMessagesAddress: topics the destination app, the account, the
+// sender and the nonce, data the payload. The message is reverted along with
+// the call sending it.
+var AppMessagesAddress = common.BytesToAddress([]byte{253})
+
+// AppMessagesNonceSlot is the storage slot of AppMessagesAddress holding the
+// nonce of the last message sent
+var AppMessagesNonceSlot = common.Hash{}
+
+var ErrAppMessageInput = errors.New("app message input shorter than the destination app and account")
+
+// appMessageGas is the gas of a message: a storage write, a log of 4 topics and
+// the payload as log data.
+func appMessageGas(input []byte) uint64 {
+	gas := params.SstoreResetGas + params.LogGas + 4*params.LogTopicGas
+	if len(input) > 2*common.HashLength {
+		gas += uint64(len(input)-2*common.HashLength) * params.LogDataGas
+	}
+	return gas
+}
+
+// isAppMessages tells whether addr is the app messages precompile of the chain
+func (evm *EVM) isAppMessages(addr common.Address) bool {
+	return evm.chainConfig.AppMessages && addr == AppMessagesAddress
+}
+
+// sendAppMessage queues the message of input sent by caller. readOnly is the
+// static flag of the call, a message sent from a static call, or a call nested
+// in one, is a state change and fails.
+func (evm *EVM) sendAppMessage(caller common.Address, input []byte, readOnly bool) ([]byte, error) {
+	if in, ok := evm.interpreter.(*EVMInterpreter); readOnly || ok && in.readOnly {
+		return nil, errWriteProtection
+	}
+	if len(input) < 2*common.HashLength {
+		return nil, ErrAppMessageInput
+	}
+	payload := input[2*common.HashLength:]
+	if err := evm.useLogData(uint64(len(payload))); err != nil {
+		return nil, err
+	}
+	// a nonce keeps the precompile account from being removed as an empty one
+	if evm.StateDB.GetNonce(AppMessagesAddress) == 0 {
+		evm.StateDB.SetNonce(AppMessagesAddress, 1)
+	}
+	nonce := evm.StateDB.GetState(AppMessagesAddress, AppMessagesNonceSlot).Big()
+	nonce.Add(nonce, big.NewInt(1))
+	evm.StateDB.SetState(AppMessagesAddress, AppMessagesNonceSlot, common.BigToHash(nonce))
+	evm.StateDB.AddLog(&types.Log{
+		Address: AppMessagesAddress,
+		Topics: []common.Hash{
+			common.BytesToHash(input[:common.HashLength]),
+			common.BytesToHash(input[common.HashLength : 2*common.HashLength]),
+			common.BytesToHash(caller.Bytes()),
+			common.BigToHash(nonce),
+		},
+		Data:        common.CopyBytes(payload),
+		BlockNumber: evm.BlockNumber.Uint64(),
+	})
+	return common.BigToHash(nonce).Bytes(), nil
+}
@@ -27,4 +27,8 @@ var (
 	ErrInsufficientBalance      = errors.New("insufficient balance for transfer")
 	ErrContractAddressCollision = errors.New("contract address collision")
 	ErrNoCompatibleInterpreter  = errors.New("no compatible interpreter")
+	ErrLogDataLimit             = errors.New("log data limit exceeded")
+	ErrBlockLogDataLimit        = errors.New("log data limit of the block reached")
+	ErrCreationLimit            = errors.New("contract creations limit of the block reached")
+	ErrMinAccountBalance        = errors.New("transfer leaves the sender below the min account balance")
 )
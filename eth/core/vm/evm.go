@@ -52,6 +52,12 @@ func run(evm *EVM, contract *Contract, input []byte, readOnly bool) ([]byte, err
 		*/
 		precompiles := PrecompiledContractsByzantium
 
+		if evm.isAppMessages(*contract.CodeAddr) {
+			if !useGas(&evm.gasLeft, appMessageGas(input)) {
+				return nil, ErrOutOfGas
+			}
+			return evm.sendAppMessage(contract.Caller(), input, readOnly)
+		}
 		if p := precompiles[*contract.CodeAddr]; p != nil {
 			gas := p.RequiredGas(input)
 			if useGas(&evm.gasLeft, gas) {
@@ -142,6 +148,12 @@ type EVM struct {
 	// gasLeft is similar to msg.gasLimit, which is a precompiled value that prevents tx
 	// from running for a long time to block generating block.
 	gasLeft uint64
+	// logData is the log data bytes emitted by the tx, reverted frames included
+	logData uint64
+	// creations is the contract creations of the tx, reverted frames included
+	creations uint64
+	// memory is the memory bytes of the running call frames
+	memory uint64
 }
 
 // NewEVM returns a new EVM. The returned EVM is not thread safe and should
@@ -185,6 +197,62 @@ func (evm *EVM) GasLeft() uint64 {
 	return evm.gasLeft
 }
 
+// useLogData counts size bytes of log data against the per tx and per block
+// caps of the chain config. Once a cap is exceeded every later log fails, and
+// the outermost call or create fails too: past the tx cap the tx reverts, past
+// the block cap it's left out of the block, see ErrBlockLogDataLimit.
+func (evm *EVM) useLogData(size uint64) error {
+	evm.logData += size
+	return evm.logDataErr()
+}
+
+// useCreation counts a contract creation against the per block cap of the
+// chain config. Past the cap creations fail: an internal CREATE or CREATE2
+// pushes 0, the caller goes on.
+func (evm *EVM) useCreation() error {
+	c := evm.chainConfig.MaxCreationsPerBlock
+	if c > 0 && evm.vmConfig.BlockCreations+evm.creations >= c {
+		return ErrCreationLimit
+	}
+	evm.creations++
+	if creations := evm.vmConfig.Creations; creations != nil {
+		*creations = evm.creations
+	}
+	return nil
+}
+
+// useMemory counts size bytes of memory expansion of the running call frame,
+// recording the peak in vmConfig.MemoryPeak.
+func (evm *EVM) useMemory(size uint64) {
+	evm.memory += size
+	if peak := evm.vmConfig.MemoryPeak; peak != nil && evm.memory > *peak {
+		*peak = evm.memory
+	}
+}
+
+// logDataErr returns the error of the first log data cap exceeded, the per tx
+// cap first: a tx over it reverts its logs, which then take no block log data.
+func (evm *EVM) logDataErr() error {
+	c := evm.chainConfig
+	if c.MaxTxLogData > 0 && evm.logData > c.MaxTxLogData {
+		return ErrLogDataLimit
+	}
+	if c.MaxBlockLogData > 0 && evm.vmConfig.BlockLogData+evm.logData > c.MaxBlockLogData {
+		return ErrBlockLogDataLimit
+	}
+	return nil
+}
+
+// outerLogDataErr returns the error of the outermost call or create ending with
+// err: a tx over the block cap fails with ErrBlockLogDataLimit whatever ended it.
+func (evm *EVM) outerLogDataErr(err error) error {
+	switch logErr := evm.logDataErr(); {
+	case logErr == ErrBlockLogDataLimit, logErr != nil && err == nil:
+		return logErr
+	}
+	return err
+}
+
 // Cancel cancels any running EVM operation. This may be called concurrently and
 // it's safe to be called multiple times.
 func (evm *EVM) Cancel() {
@@ -206,12 +274,12 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	}
 
 	// Fail if we're trying to execute above the call depth limit
-	if evm.depth > int(params.CallCreateDepth) {
+	if evm.depth > evm.callDepthLimit() {
 		return nil, gas, ErrDepth
 	}
 	// Fail if we're trying to transfer more than the available balance
-	if !evm.Context.CanTransfer(evm.StateDB, caller.Address(), value) {
-		return nil, gas, ErrInsufficientBalance
+	if err := evm.canTransfer(caller.Address(), value); err != nil {
+		return nil, gas, err
 	}
 
 	var (
@@ -228,7 +296,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 		*/
 		precompiles := PrecompiledContractsByzantium
 
-		if precompiles[addr] == nil && evm.ChainConfig().IsEIP158(evm.BlockNumber) && value.Sign() == 0 {
+		if precompiles[addr] == nil && !evm.isAppMessages(addr) && evm.ChainConfig().IsEIP158(evm.BlockNumber) && value.Sign() == 0 {
 			// Calling a non existing account, don't do anything, but ping the tracer
 			if evm.vmConfig.Debug && evm.depth == 0 {
 				evm.vmConfig.Tracer.CaptureStart(caller.Address(), addr, false, input, gas, value)
@@ -256,6 +324,9 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 		}()
 	}
 	ret, err = run(evm, contract, input, false)
+	if evm.depth == 0 {
+		err = evm.outerLogDataErr(err)
+	}
 
 	// When an error was returned by the EVM or when setting the creation code
 	// above we revert to the snapshot and consume any gas remaining. Additionally
@@ -282,7 +353,7 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 	}
 
 	// Fail if we're trying to execute above the call depth limit
-	if evm.depth > int(params.CallCreateDepth) {
+	if evm.depth > evm.callDepthLimit() {
 		return nil, gas, ErrDepth
 	}
 	// Fail if we're trying to transfer more than the available balance
@@ -320,7 +391,7 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 		return nil, gas, nil
 	}
 	// Fail if we're trying to execute above the call depth limit
-	if evm.depth > int(params.CallCreateDepth) {
+	if evm.depth > evm.callDepthLimit() {
 		return nil, gas, ErrDepth
 	}
 
@@ -352,7 +423,7 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 		return nil, gas, nil
 	}
 	// Fail if we're trying to execute above the call depth limit
-	if evm.depth > int(params.CallCreateDepth) {
+	if evm.depth > evm.callDepthLimit() {
 		return nil, gas, ErrDepth
 	}
 
@@ -397,15 +468,40 @@ func (c *codeAndHash) Hash() common.Hash {
 	return c.hash
 }
 
+// canTransfer checks addr can transfer value, keeping the chain's min account
+// balance in the account when one is set.
+func (evm *EVM) canTransfer(addr common.Address, value *big.Int) error {
+	if !evm.Context.CanTransfer(evm.StateDB, addr, value) {
+		return ErrInsufficientBalance
+	}
+	if floor := evm.chainConfig.MinAccountBalance; floor != nil && value.Sign() > 0 {
+		if new(big.Int).Sub(evm.StateDB.GetBalance(addr), value).Cmp(floor) < 0 {
+			return ErrMinAccountBalance
+		}
+	}
+	return nil
+}
+
+// callDepthLimit is the depth of the call stack calls and creates fail above
+func (evm *EVM) callDepthLimit() int {
+	if evm.vmConfig.MaxCallDepth > 0 && evm.vmConfig.MaxCallDepth < params.CallCreateDepth {
+		return int(evm.vmConfig.MaxCallDepth)
+	}
+	return int(params.CallCreateDepth)
+}
+
 // create creates a new contract using code as deployment code.
 func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64, value *big.Int, address common.Address) ([]byte, common.Address, uint64, error) {
 	// Depth check execution. Fail if we're trying to execute above the
 	// limit.
-	if evm.depth > int(params.CallCreateDepth) {
+	if evm.depth > evm.callDepthLimit() {
 		return nil, common.Address{}, gas, ErrDepth
 	}
-	if !evm.CanTransfer(evm.StateDB, caller.Address(), value) {
-		return nil, common.Address{}, gas, ErrInsufficientBalance
+	if err := evm.canTransfer(caller.Address(), value); err != nil {
+		return nil, common.Address{}, gas, err
+	}
+	if err := evm.useCreation(); err != nil {
+		return nil, common.Address{}, gas, err
 	}
 	nonce := evm.StateDB.GetNonce(caller.Address())
 	evm.StateDB.SetNonce(caller.Address(), nonce+1)
@@ -439,6 +535,9 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 	start := time.Now()
 
 	ret, err := run(evm, contract, nil, false)
+	if evm.depth == 0 {
+		err = evm.outerLogDataErr(err)
+	}
 
 	// check whether the max code size has been exceeded
 	maxCodeSizeExceeded := evm.ChainConfig().IsEIP158(evm.BlockNumber) && len(ret) > params.MaxCodeSize
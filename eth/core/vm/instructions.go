@@ -898,6 +898,10 @@ func makeLog(size int) executionFunc {
 		}
 
 		d := memory.Get(mStart.Int64(), mSize.Int64())
+		if err := interpreter.evm.useLogData(uint64(len(d))); err != nil {
+			interpreter.intPool.put(mStart, mSize)
+			return nil, err
+		}
 		interpreter.evm.StateDB.AddLog(&types.Log{
 			Address: contract.Address(),
 			Topics:  topics,
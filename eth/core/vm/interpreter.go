@@ -49,6 +49,20 @@ type Config struct {
 
 	// gasLimit for interpreter run
 	EVMGasLimit uint64
+	// max depth of the call stack, 0 for params.CallCreateDepth. Only
+	// simulations may lower it, consensus execution keeps the standard depth
+	MaxCallDepth uint64
+	// log data bytes emitted by the previous txs of the block, counted
+	// against ChainConfig.MaxBlockLogData
+	BlockLogData uint64
+	// contract creations of the previous txs of the block, counted against
+	// ChainConfig.MaxCreationsPerBlock
+	BlockCreations uint64
+	// when set, records the contract creations of the execution
+	Creations *uint64
+	// when set, records the peak memory bytes of the execution, the memory
+	// of all the running call frames summed
+	MemoryPeak *uint64
 }
 
 // Interpreter is used to run Ethereum based contracts and will utilise the
@@ -193,6 +207,7 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 
 	// Reclaim the stack as an int pool when the execution stops
 	defer func() { in.intPool.put(stack.data...) }()
+	defer func() { in.evm.memory -= uint64(mem.Len()) }()
 
 	if in.cfg.Debug {
 		defer func() {
@@ -252,7 +267,9 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		}
 
 		if memorySize > 0 {
+			size := uint64(mem.Len())
 			mem.Resize(memorySize)
+			in.evm.useMemory(uint64(mem.Len()) - size)
 		}
 
 		if in.cfg.Debug {
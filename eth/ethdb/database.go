@@ -59,8 +59,14 @@ type LDBDatabase struct {
 	log log.Logger // Contextual logger tracking the database path
 }
 
-// NewLDBDatabase returns a LevelDB wrapped object.
+// NewLDBDatabase returns a LevelDB wrapped object, recovering a corrupted database.
 func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
+	return OpenLDBDatabase(file, cache, handles, true)
+}
+
+// OpenLDBDatabase returns a LevelDB wrapped object. A corrupted database fails
+// to open with an errors.ErrCorrupted unless recoverCorrupted is set.
+func OpenLDBDatabase(file string, cache int, handles int, recoverCorrupted bool) (*LDBDatabase, error) {
 	logger := log.New("database", file)
 
 	// Ensure we have some minimal caching and file guarantees
@@ -79,7 +85,8 @@ func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
 		WriteBuffer:            cache / 4 * opt.MiB, // Two of these are used internally
 		Filter:                 filter.NewBloomFilter(10),
 	})
-	if _, corrupted := err.(*errors.ErrCorrupted); corrupted {
+	if _, corrupted := err.(*errors.ErrCorrupted); corrupted && recoverCorrupted {
+		logger.Warn("Recovering corrupted database", "err", err)
 		db, err = leveldb.RecoverFile(file, nil)
 	}
 	// (Re)check for errors and abort if opening of the db failed
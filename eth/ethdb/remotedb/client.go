@@ -0,0 +1,275 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotedb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// requestTimeout bounds one request to the server
+const requestTimeout = 30 * time.Second
+
+// Database is an ethdb.Database kept by a remote Server. Values read or
+// written are cached, which holds as long as the client is the only writer.
+type Database struct {
+	endpoint string
+	auth     string // Authorization header of the requests
+	client   *http.Client
+	cache    *lru.Cache // nil when disabled
+
+	// writes hold mtx, and cache misses its read lock from fetching the value
+	// to caching it, so a value read before a write isn't cached after it
+	mtx sync.RWMutex
+}
+
+// Dial returns the Database served at endpoint with token, eg.
+// http://10.0.0.2:46680, caching up to cacheSize values, 0 to disable the cache.
+func Dial(endpoint, token string, cacheSize int) (*Database, error) {
+	db := &Database{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		auth:     "Bearer " + token,
+		client:   &http.Client{Timeout: requestTimeout},
+	}
+	if cacheSize > 0 {
+		cache, err := lru.New(cacheSize)
+		if err != nil {
+			return nil, err
+		}
+		db.cache = cache
+	}
+	if _, err := db.post(pathHas, nil); err != nil {
+		return nil, fmt.Errorf("remote database %s: %v", endpoint, err)
+	}
+	return db, nil
+}
+
+// post sends body to path and returns the answer, nil for a missing key
+func (db *Database) post(path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, db.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Authorization", db.auth)
+	res, err := db.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		if data == nil {
+			data = []byte{}
+		}
+		return data, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(data)))
+	}
+}
+
+func (db *Database) send(req *writeRequest) error {
+	body, err := rlp.EncodeToBytes(req)
+	if err != nil {
+		return err
+	}
+	_, err = db.post(pathWrite, body)
+	return err
+}
+
+// commit sends the last part of a batch and updates the cache with ops, every
+// op of the batch.
+func (db *Database) commit(req *writeRequest, ops []writeOp) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	err := db.send(req)
+	if db.cache == nil {
+		return err
+	}
+	for _, op := range ops {
+		if op.Delete || err != nil {
+			db.cache.Remove(string(op.Key))
+		} else {
+			db.cache.Add(string(op.Key), op.Value)
+		}
+	}
+	return err
+}
+
+func (db *Database) Put(key []byte, value []byte) error {
+	ops := []writeOp{{Key: common.CopyBytes(key), Value: common.CopyBytes(value)}}
+	return db.commit(&writeRequest{Ops: ops, Commit: true}, ops)
+}
+
+func (db *Database) Delete(key []byte) error {
+	ops := []writeOp{{Delete: true, Key: common.CopyBytes(key)}}
+	return db.commit(&writeRequest{Ops: ops, Commit: true}, ops)
+}
+
+// Get returns the value of key, leveldb.ErrNotFound when it's missing
+func (db *Database) Get(key []byte) ([]byte, error) {
+	if db.cache != nil {
+		if value, ok := db.cache.Get(string(key)); ok {
+			return common.CopyBytes(value.([]byte)), nil
+		}
+	}
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	value, err := db.post(pathGet, key)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, leveldb.ErrNotFound
+	}
+	if db.cache != nil {
+		db.cache.Add(string(key), common.CopyBytes(value))
+	}
+	return value, nil
+}
+
+func (db *Database) Has(key []byte) (bool, error) {
+	if db.cache != nil && db.cache.Contains(string(key)) {
+		return true, nil
+	}
+	data, err := db.post(pathHas, key)
+	if err != nil {
+		return false, err
+	}
+	return len(data) == 1 && data[0] == 0x01, nil
+}
+
+// Close drops the cache, the remote database stays open
+func (db *Database) Close() {
+	if db.cache != nil {
+		db.cache.Purge()
+	}
+}
+
+// NewBatch returns a batch sending its content to the server in parts of
+// ethdb.IdealBatchSize while it's filled, so writing it only waits for the
+// last part. Nothing is applied until Write, which applies it all at once or
+// fails. A batch is empty once written.
+func (db *Database) NewBatch() ethdb.Batch {
+	b := &batch{db: db}
+	b.clear()
+	return b
+}
+
+type batch struct {
+	db    *Database
+	id    uint64
+	ops   []writeOp // ops not sent yet
+	all   []writeOp // every op, to update the cache
+	parts uint64    // parts sent
+	size  int
+	part  int // value size of ops
+
+	sending sync.WaitGroup
+	errMtx  sync.Mutex
+	err     error // of the first part failing
+}
+
+func (b *batch) Put(key, value []byte) error {
+	b.add(writeOp{Key: common.CopyBytes(key), Value: common.CopyBytes(value)}, len(value))
+	return nil
+}
+
+func (b *batch) Delete(key []byte) error {
+	b.add(writeOp{Delete: true, Key: common.CopyBytes(key)}, 1)
+	return nil
+}
+
+func (b *batch) add(op writeOp, size int) {
+	b.ops, b.all = append(b.ops, op), append(b.all, op)
+	b.size += size
+	b.part += size
+	if b.part >= ethdb.IdealBatchSize {
+		b.sendPart()
+	}
+}
+
+func (b *batch) sendPart() {
+	req := &writeRequest{Batch: b.id, Part: b.parts, Ops: b.ops}
+	b.ops, b.part = nil, 0
+	b.parts++
+	b.sending.Add(1)
+	go func() {
+		defer b.sending.Done()
+		if err := b.db.send(req); err != nil {
+			b.errMtx.Lock()
+			if b.err == nil {
+				b.err = err
+			}
+			b.errMtx.Unlock()
+		}
+	}()
+}
+
+func (b *batch) Write() error {
+	b.sending.Wait()
+	err := b.err
+	if err == nil {
+		err = b.db.commit(&writeRequest{Batch: b.id, Part: b.parts, Ops: b.ops, Commit: true}, b.all)
+	} else {
+		b.abort()
+	}
+	b.clear()
+	return err
+}
+
+func (b *batch) ValueSize() int {
+	return b.size
+}
+
+// Reset empties the batch, dropping the parts the server staged
+func (b *batch) Reset() {
+	b.sending.Wait()
+	b.abort()
+	b.clear()
+}
+
+func (b *batch) abort() {
+	if b.parts > 0 {
+		// best effort, the server drops them after stagedBatchTTL anyway
+		b.db.send(&writeRequest{Batch: b.id, Abort: true})
+	}
+}
+
+func (b *batch) clear() {
+	var id [8]byte
+	rand.Read(id[:])
+	b.id = binary.BigEndian.Uint64(id[:])
+	b.ops, b.all, b.parts, b.size, b.part, b.err = nil, nil, 0, 0, 0, nil
+}
@@ -0,0 +1,122 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotedb
+
+import (
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+var errBackward = errors.New("remotedb: backward iteration not supported")
+
+// NewIteratorWithPrefix iterates forward the keys under prefix, fetching them
+// by pages. Pages are read one after the other, so unlike a leveldb iterator
+// it doesn't iterate a snapshot: keys written meanwhile may or may not show.
+func (db *Database) NewIteratorWithPrefix(prefix []byte) iterator.Iterator {
+	return &pageIterator{db: db, prefix: common.CopyBytes(prefix), pos: -1}
+}
+
+type pageIterator struct {
+	util.BasicReleaser
+	db     *Database
+	prefix []byte
+	page   iteratePage
+	pos    int // in page, -1 before the first Next
+	err    error
+}
+
+// fetch reads the page starting at start, positioned on its first key
+func (it *pageIterator) fetch(start []byte) bool {
+	body, err := rlp.EncodeToBytes(&iterateRequest{Prefix: it.prefix, Start: start, Limit: maxPageEntries})
+	if err == nil {
+		var data []byte
+		if data, err = it.db.post(pathIterate, body); err == nil {
+			it.page = iteratePage{}
+			err = rlp.DecodeBytes(data, &it.page)
+		}
+	}
+	if err != nil {
+		it.err, it.page = err, iteratePage{}
+	}
+	it.pos = 0
+	return it.Valid()
+}
+
+func (it *pageIterator) First() bool {
+	if it.err != nil || it.Released() {
+		return false
+	}
+	return it.fetch(nil)
+}
+
+func (it *pageIterator) Seek(key []byte) bool {
+	if it.err != nil || it.Released() {
+		return false
+	}
+	return it.fetch(key)
+}
+
+func (it *pageIterator) Next() bool {
+	if it.err != nil || it.Released() {
+		return false
+	}
+	if it.pos < 0 {
+		return it.First()
+	}
+	if it.pos < len(it.page.Keys) {
+		it.pos++
+	}
+	if it.pos == len(it.page.Keys) && it.page.More {
+		// the next page starts right after the last key
+		return it.fetch(append(common.CopyBytes(it.page.Keys[it.pos-1]), 0x00))
+	}
+	return it.Valid()
+}
+
+func (it *pageIterator) Last() bool {
+	it.err = errBackward
+	return false
+}
+
+func (it *pageIterator) Prev() bool {
+	it.err = errBackward
+	return false
+}
+
+func (it *pageIterator) Valid() bool {
+	return it.err == nil && !it.Released() && it.pos >= 0 && it.pos < len(it.page.Keys)
+}
+
+func (it *pageIterator) Key() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.page.Keys[it.pos]
+}
+
+func (it *pageIterator) Value() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.page.Values[it.pos]
+}
+
+func (it *pageIterator) Error() error {
+	return it.err
+}
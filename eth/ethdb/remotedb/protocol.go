@@ -0,0 +1,69 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remotedb serves a database over http and implements ethdb.Database
+// on top of it, so an app can execute on one machine and keep its state on
+// another.
+//
+// Every request is a POST carrying the server token as "Authorization: Bearer
+// <token>", the others are refused. Get and Has carry the raw key and answer the raw
+// value, 404 for a missing key. Writes and iterations carry rlp encoded
+// requests. A batch may be sent in several parts, staged by the server under
+// the batch id until the part marked Commit, which applies them all in one
+// atomic write.
+package remotedb
+
+const (
+	pathGet     = "/get"
+	pathHas     = "/has"
+	pathWrite   = "/write"
+	pathIterate = "/iterate"
+)
+
+const (
+	// maxPageEntries and maxPageBytes bound one iteration page
+	maxPageEntries = 1024
+	maxPageBytes   = 1 << 20
+	// maxRequestBytes bounds the body of one request, larger ones are refused
+	maxRequestBytes = 64 << 20
+)
+
+// DefaultListenAddr is where ListenAndServe serves without an address, the
+// loopback only
+const DefaultListenAddr = "127.0.0.1:46680"
+
+type writeOp struct {
+	Delete bool
+	Key    []byte
+	Value  []byte
+}
+
+type writeRequest struct {
+	Batch  uint64 // id drawn by the client
+	Part   uint64 // index of the part, the number of staged parts when committing
+	Ops    []writeOp
+	Commit bool // apply the staged parts then Ops at once
+	Abort  bool // drop the staged parts
+}
+
+type iterateRequest struct {
+	Prefix []byte
+	Start  []byte // first key to return, the prefix itself when empty
+	Limit  uint64
+}
+
+type iteratePage struct {
+	Keys   [][]byte
+	Values [][]byte
+	More   bool // keys past the page are left
+}
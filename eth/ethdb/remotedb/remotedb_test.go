@@ -0,0 +1,204 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotedb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/dappledger/AnnChain/eth/ethdb"
+)
+
+const testToken = "remotedb-test-token"
+
+func newTestRemote(t *testing.T, cacheSize int) (*Database, *ethdb.LDBDatabase, *httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "remotedb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := ethdb.NewLDBDatabase(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewServer(local, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	db, err := Dial(server.URL, testToken, cacheSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, local, server, func() {
+		server.Close()
+		db.Close()
+		local.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRemoteDatabase(t *testing.T) {
+	for _, cacheSize := range []int{0, 100} {
+		db, local, _, clean := newTestRemote(t, cacheSize)
+
+		if _, err := db.Get([]byte("a")); err != leveldb.ErrNotFound {
+			t.Fatalf("expected %v, got %v", leveldb.ErrNotFound, err)
+		}
+		if err := db.Put([]byte("a"), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put([]byte("empty"), nil); err != nil {
+			t.Fatal(err)
+		}
+		for key, expected := range map[string][]byte{"a": []byte("1"), "empty": {}} {
+			if value, err := db.Get([]byte(key)); err != nil || !bytes.Equal(value, expected) {
+				t.Fatalf("expected %s = %q, got %q %v", key, expected, value, err)
+			}
+			if ok, err := db.Has([]byte(key)); err != nil || !ok {
+				t.Fatalf("expected %s to exist, got %v", key, err)
+			}
+		}
+		if value, err := local.Get([]byte("a")); err != nil || string(value) != "1" {
+			t.Fatalf("expected the put stored by the server, got %q %v", value, err)
+		}
+		if err := db.Delete([]byte("a")); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := db.Has([]byte("a")); err != nil || ok {
+			t.Fatalf("expected a deleted, got %v %v", ok, err)
+		}
+		if _, err := db.Get([]byte("a")); err != leveldb.ErrNotFound {
+			t.Fatalf("expected %v, got %v", leveldb.ErrNotFound, err)
+		}
+		clean()
+	}
+}
+
+func TestRemoteBatch(t *testing.T) {
+	db, local, server, clean := newTestRemote(t, 100)
+	defer clean()
+
+	// enough values for several parts sent before Write
+	value := bytes.Repeat([]byte{0x01}, 1024)
+	batch := db.NewBatch()
+	for i := 0; i < 300; i++ {
+		if err := batch.Put([]byte(fmt.Sprintf("key-%03d", i)), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a later op of a key wins
+	batch.Put([]byte("key-000"), []byte("last"))
+	batch.Delete([]byte("key-001"))
+	if ok, _ := local.Has([]byte("key-100")); ok {
+		t.Fatal("expected nothing applied before Write")
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := local.Get([]byte("key-000")); err != nil || string(v) != "last" {
+		t.Fatalf("expected the last put of key-000, got %q %v", v, err)
+	}
+	if ok, _ := local.Has([]byte("key-001")); ok {
+		t.Fatal("expected key-001 deleted")
+	}
+	if v, err := db.Get([]byte("key-299")); err != nil || !bytes.Equal(v, value) {
+		t.Fatalf("unexpected key-299 %q %v", v, err)
+	}
+
+	// a failed write applies nothing
+	batch.Put([]byte("lost"), value)
+	server.Close()
+	if err := batch.Write(); err == nil {
+		t.Fatal("expected the write to fail with the server down")
+	}
+	if ok, _ := local.Has([]byte("lost")); ok {
+		t.Fatal("expected a failed write to apply nothing")
+	}
+}
+
+func TestRemoteIterator(t *testing.T) {
+	db, _, _, clean := newTestRemote(t, 0)
+	defer clean()
+
+	batch := db.NewBatch()
+	n := maxPageEntries*2 + 10
+	for i := 0; i < n; i++ {
+		batch.Put([]byte(fmt.Sprintf("p-%05d", i)), []byte{byte(i)})
+	}
+	batch.Put([]byte("q-00000"), []byte{0x01})
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	it := db.NewIteratorWithPrefix([]byte("p-"))
+	count := 0
+	for it.Next() {
+		if string(it.Key()) != fmt.Sprintf("p-%05d", count) || it.Value()[0] != byte(count) {
+			t.Fatalf("unexpected entry %d %q %x", count, it.Key(), it.Value())
+		}
+		count++
+	}
+	if it.Error() != nil || count != n {
+		t.Fatalf("expected %d entries, got %d %v", n, count, it.Error())
+	}
+	it.Release()
+
+	it = db.NewIteratorWithPrefix([]byte("p-"))
+	defer it.Release()
+	if !it.Seek([]byte("p-02000")) || string(it.Key()) != "p-02000" {
+		t.Fatalf("expected the seek to land on p-02000, got %q", it.Key())
+	}
+	if it.Prev() || it.Error() == nil {
+		t.Fatal("expected backward iteration to fail")
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	if _, err := NewServer(nil, ""); err == nil {
+		t.Fatal("expected the server to require a token")
+	}
+	_, _, server, clean := newTestRemote(t, 0)
+	defer clean()
+
+	for _, token := range []string{"", "wrong"} {
+		if _, err := Dial(server.URL, token, 0); err == nil {
+			t.Fatalf("expected dialing with token %q to be refused", token)
+		}
+	}
+	res, err := http.Post(server.URL+pathHas, "application/octet-stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected %d without a token, got %d", http.StatusUnauthorized, res.StatusCode)
+	}
+}
+
+func TestServerRequestLimit(t *testing.T) {
+	db, _, _, clean := newTestRemote(t, 0)
+	defer clean()
+
+	if _, err := db.post(pathWrite, make([]byte, maxRequestBytes+1)); err == nil || !strings.Contains(err.Error(), "413") {
+		t.Fatalf("expected the oversized body to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotedb
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// stagedBatchTTL is how long the staged parts of a batch never committed nor
+// aborted, eg. by a crashed client, are kept
+const stagedBatchTTL = 10 * time.Minute
+
+// Backend is the database a Server serves, eg. ethdb.LDBDatabase, missing keys
+// being leveldb.ErrNotFound
+type Backend interface {
+	ethdb.Database
+	NewIteratorWithPrefix(prefix []byte) iterator.Iterator
+}
+
+type stagedBatch struct {
+	parts   map[uint64][]writeOp
+	touched time.Time
+}
+
+// Server serves a Backend to remote Database clients
+type Server struct {
+	db    Backend
+	token []byte // expected Authorization header
+
+	mtx    sync.Mutex
+	staged map[uint64]*stagedBatch
+}
+
+// NewServer returns a Server of db, to mount on an http server, serving the
+// clients dialing with token. db is neither closed nor otherwise owned by the
+// Server.
+func NewServer(db Backend, token string) (*Server, error) {
+	if token == "" {
+		return nil, errors.New("remotedb: token required to serve the database")
+	}
+	return &Server{db: db, token: []byte("Bearer " + token), staged: make(map[uint64]*stagedBatch)}, nil
+}
+
+// ListenAndServe serves s on laddr, DefaultListenAddr when empty, until it fails
+func (s *Server) ListenAndServe(laddr string) error {
+	if laddr == "" {
+		laddr = DefaultListenAddr
+	}
+	return http.ListenAndServe(laddr, s)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), s.token) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestBytes+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxRequestBytes {
+		http.Error(w, fmt.Sprintf("request body over %d bytes", maxRequestBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	switch r.URL.Path {
+	case pathGet:
+		s.get(w, body)
+	case pathHas:
+		s.has(w, body)
+	case pathWrite:
+		s.write(w, body)
+	case pathIterate:
+		s.iterate(w, body)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, key []byte) {
+	value, err := s.db.Get(key)
+	if err == leveldb.ErrNotFound {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(value)
+}
+
+func (s *Server) has(w http.ResponseWriter, key []byte) {
+	ok, err := s.db.Has(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ok {
+		w.Write([]byte{0x01})
+	} else {
+		w.Write([]byte{0x00})
+	}
+}
+
+func (s *Server) write(w http.ResponseWriter, body []byte) {
+	var req writeRequest
+	if err := rlp.DecodeBytes(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ops, err := s.stage(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ops == nil {
+		return
+	}
+	batch := s.db.NewBatch()
+	for _, op := range ops {
+		var err error
+		if op.Delete {
+			err = batch.Delete(op.Key)
+		} else {
+			err = batch.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := batch.Write(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// stage records the ops of req and returns every op of the batch, in part
+// order, once req commits it, nil otherwise.
+func (s *Server) stage(req *writeRequest) ([]writeOp, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	for id, staged := range s.staged {
+		if now.Sub(staged.touched) > stagedBatchTTL {
+			delete(s.staged, id)
+		}
+	}
+	staged := s.staged[req.Batch]
+	if req.Abort {
+		delete(s.staged, req.Batch)
+		return nil, nil
+	}
+	if !req.Commit {
+		if staged == nil {
+			staged = &stagedBatch{parts: make(map[uint64][]writeOp)}
+			s.staged[req.Batch] = staged
+		}
+		staged.parts[req.Part], staged.touched = req.Ops, now
+		return nil, nil
+	}
+	delete(s.staged, req.Batch)
+	var ops []writeOp
+	for part := uint64(0); part < req.Part; part++ {
+		if staged == nil || staged.parts[part] == nil {
+			return nil, fmt.Errorf("batch %d misses part %d", req.Batch, part)
+		}
+		ops = append(ops, staged.parts[part]...)
+	}
+	return append(ops, req.Ops...), nil
+}
+
+func (s *Server) iterate(w http.ResponseWriter, body []byte) {
+	var req iterateRequest
+	if err := rlp.DecodeBytes(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := req.Limit
+	if limit == 0 || limit > maxPageEntries {
+		limit = maxPageEntries
+	}
+	it := s.db.NewIteratorWithPrefix(req.Prefix)
+	defer it.Release()
+
+	var (
+		page  iteratePage
+		size  int
+		valid bool
+	)
+	if len(req.Start) > 0 && bytes.HasPrefix(req.Start, req.Prefix) {
+		valid = it.Seek(req.Start)
+	} else {
+		valid = it.First()
+	}
+	for ; valid; valid = it.Next() {
+		if uint64(len(page.Keys)) >= limit || size >= maxPageBytes {
+			page.More = true
+			break
+		}
+		page.Keys = append(page.Keys, append([]byte{}, it.Key()...))
+		page.Values = append(page.Values, append([]byte{}, it.Value()...))
+		size += len(it.Key()) + len(it.Value())
+	}
+	if err := it.Error(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := rlp.EncodeToBytes(&page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
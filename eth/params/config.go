@@ -111,16 +111,16 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, new(EthashConfig), nil}
+	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, 0, 0, 0, 0, nil, TxOrderNone, ZeroAddressReject, ReceiptsHashSimple, false, nil, nil, 0, 0, new(EthashConfig), nil}
 
 	// AllCliqueProtocolChanges contains every protocol change (EIPs) introduced
 	// and accepted by the Ethereum core developers into the Clique consensus.
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
+	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, 0, 0, 0, 0, nil, TxOrderNone, ZeroAddressReject, ReceiptsHashSimple, false, nil, nil, 0, 0, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, new(EthashConfig), nil}
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, 0, 0, 0, 0, nil, TxOrderNone, ZeroAddressReject, ReceiptsHashSimple, false, nil, nil, 0, 0, new(EthashConfig), nil}
 	TestRules       = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -160,11 +160,81 @@ type ChainConfig struct {
 	ConstantinopleBlock *big.Int `json:"constantinopleBlock,omitempty"` // Constantinople switch block (nil = no fork, 0 = already activated)
 	EWASMBlock          *big.Int `json:"ewasmBlock,omitempty"`          // EWASM switch block (nil = no fork, 0 = already activated)
 
+	// Log data caps in bytes, 0 for no cap. A tx exceeding MaxTxLogData fails, see
+	// vm.ErrLogDataLimit, one taking its block past MaxBlockLogData is left out of it,
+	// see vm.ErrBlockLogDataLimit
+	MaxTxLogData    uint64 `json:"maxTxLogData,omitempty"`
+	MaxBlockLogData uint64 `json:"maxBlockLogData,omitempty"`
+
+	// Max txs of one sender in a block, 0 for no limit. The txs beyond it are invalid
+	MaxTxsPerSender uint64 `json:"maxTxsPerSender,omitempty"`
+
+	// Max contract creations of a block, internal ones included, 0 for no limit.
+	// See vm.ErrCreationLimit
+	MaxCreationsPerBlock uint64 `json:"maxCreationsPerBlock,omitempty"`
+
+	// Min balance in wei a transfer may leave its sender with, nil for no min.
+	// Empty accounts aren't deleted when it's set, see vm.ErrMinAccountBalance
+	MinAccountBalance *big.Int `json:"minAccountBalance,omitempty"`
+
+	// Canonical order the txs of a block must follow, blocks out of it are rejected
+	TxOrderPolicy TxOrderPolicy `json:"txOrderPolicy,omitempty"`
+
+	// Treatment of the txs sent to the zero address, see ZeroAddressPolicy
+	ZeroAddressPolicy ZeroAddressPolicy `json:"zeroAddressPolicy,omitempty"`
+
+	// Algorithm hashing the receipts of a block, see ReceiptsHashAlgo
+	ReceiptsHash ReceiptsHashAlgo `json:"receiptsHash,omitempty"`
+
+	// Whether contracts may send messages to the sibling apps of the node, see
+	// vm.AppMessagesAddress
+	AppMessages bool `json:"appMessages,omitempty"`
+
+	// Max value and gas price in wei of a tx, nil for only the 2^256-1 bound.
+	// The txs beyond them are invalid
+	MaxTxValue    *big.Int `json:"maxTxValue,omitempty"`
+	MaxTxGasPrice *big.Int `json:"maxTxGasPrice,omitempty"`
+
+	// Gas of a block, 0 for no limit, and the part of it reserved for the system
+	// work of the block, the inbound app messages, 0 for unmetered system work.
+	// The user txs take at most the limit minus the reserve
+	BlockGasLimit    uint64 `json:"blockGasLimit,omitempty"`
+	SystemGasReserve uint64 `json:"systemGasReserve,omitempty"`
+
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
 }
 
+// TxOrderPolicy is a canonical order of the txs of a block
+type TxOrderPolicy uint8
+
+const (
+	TxOrderNone TxOrderPolicy = iota // any order
+	TxOrderHash                      // ascending tx hash
+	// txs grouped by sender in nonce order, groups in ascending hash of their first tx
+	TxOrderSenderNonce
+)
+
+// ZeroAddressPolicy is the treatment of the txs sent to the zero address. Txs
+// carrying data are invalid under every policy, a contract creation has a nil
+// recipient, never the zero address.
+type ZeroAddressPolicy uint8
+
+const (
+	ZeroAddressReject ZeroAddressPolicy = iota // the value is credited to the zero address account
+	ZeroAddressBurn                            // the value is burnt, the zero address account is left untouched
+)
+
+// ReceiptsHashAlgo is the algorithm hashing the receipts of a block. A block
+// without receipts has no receipts hash under every algorithm.
+type ReceiptsHashAlgo uint8
+
+const (
+	ReceiptsHashSimple    ReceiptsHashAlgo = iota // simple merkle hash of the storage encodings
+	ReceiptsHashDeriveSha                         // root of the ethereum receipts trie, as in the header ReceiptHash
+)
+
 // EthashConfig is the consensus engine configs for proof-of-work based sealing.
 type EthashConfig struct{}
 
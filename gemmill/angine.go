@@ -640,6 +640,11 @@ func (e *Angine) GetValidators() (int64, *types.ValidatorSet) {
 	return e.stateMachine.LastBlockHeight, e.stateMachine.Validators
 }
 
+// ValidatorsAt returns the validator set of the block at height
+func (e *Angine) ValidatorsAt(height int64) (*types.ValidatorSet, error) {
+	return e.stateMachine.LoadValidators(height)
+}
+
 func (e *Angine) GetP2PNetInfo() (bool, []string, []*types.Peer) {
 	listening := e.p2pSwitch.IsListening()
 	listeners := []string{}
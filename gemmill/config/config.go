@@ -260,3 +260,22 @@ func DefaultConfig() (conf *viper.Viper) {
 
 	return conf
 }
+
+// nodeKeys are the keys the node reads without a default, or the older config
+// templates wrote
+var nodeKeys = []string{
+	"consensus",
+	"raft.empty_block_interval",
+	"connection_reset_wait",
+	"genesis_json_file",
+	"pprof",
+	"statistic",
+	"node_laddr",
+	"api_laddr",
+}
+
+// Keys returns the keys of the node config, lower-cased like viper keys. The app
+// sharing the config file tells the keys unknown to both with it.
+func Keys() []string {
+	return append(SetDefaults("", DefaultConfig()).AllKeys(), nodeKeys...)
+}
@@ -952,7 +952,7 @@ func (cs *ConsensusState) createProposalBlock() (block *types.Block, blockParts
 	extxs := []types.Tx{}
 	txs := []types.Tx{}
 	for _, tx := range alltxs {
-		if types.IsAdminOP(tx) {
+		if types.IsAdminOP(tx) || types.IsEvidenceTx(tx) || types.IsAppMessageTx(tx) {
 			extxs = append(extxs, tx)
 		} else {
 			txs = append(txs, tx)
@@ -1406,6 +1406,23 @@ func (cs *ConsensusState) addProposalBlockPart(height int64, part *types.Part, v
 	return added, nil
 }
 
+// publishEvidence sends the conflicting votes to the mempool as an evidence tx,
+// the app records it when a block carries it.
+func (cs *ConsensusState) publishEvidence(conflict *types.ErrVoteConflictingVotes) {
+	_, val := cs.Validators.GetByAddress(conflict.VoteA.ValidatorAddress)
+	if val == nil && cs.LastValidators != nil {
+		_, val = cs.LastValidators.GetByAddress(conflict.VoteA.ValidatorAddress)
+	}
+	if val == nil {
+		return
+	}
+	ev := types.NewDuplicateVoteEvidence(val.PubKey, conflict.VoteA, conflict.VoteB)
+	log.Warn("Found conflicting vote. Publish evidence", zap.Int64("height", ev.Height()), zap.Binary("validator", ev.Address()))
+	if err := cs.mempool.ReceiveTx(types.TagEvidenceTx(ev)); err != nil {
+		log.Warn("publish evidence", zap.Error(err))
+	}
+}
+
 // Attempt to add the vote. if its a duplicate signature, dupeout the validator
 func (cs *ConsensusState) tryAddVote(vote *types.Vote, peerKey string) (bool, error) {
 	added, err := cs.addVote(vote, peerKey)
@@ -1420,7 +1437,7 @@ func (cs *ConsensusState) tryAddVote(vote *types.Vote, peerKey string) (bool, er
 				log.Warn("Found conflicting vote from ourselves. Did you unsafe_reset a validator?", zap.Int64("height", vote.Height), zap.Int64("round", vote.Round), zap.Binary("type", []byte{vote.Type}))
 				return added, err
 			}
-			log.Warn("Found conflicting vote. Publish evidence (TODO)")
+			cs.publishEvidence(err.(*types.ErrVoteConflictingVotes))
 			return added, err
 		} else {
 			// Probably an invalid signature. Bad peer.
@@ -210,6 +210,7 @@ func (s *State) SetBlockAndValidators(header *types.Header, blockPartsHeader typ
 	}
 	s.setBlockAndValidators(header.Height, nonEmptyHeight, types.BlockID{Hash: header.Hash(), PartsHeader: blockPartsHeader},
 		header.Time, prevValSet, nextValSet)
+	s.saveValidators(header.Height+1, nextValSet)
 }
 
 func (s *State) setBlockAndValidators(height int64, nonEmptyHeight int64, blockID types.BlockID, blockTime time.Time,
@@ -250,6 +251,24 @@ func GetState(config *viper.Viper, stateDB dbm.DB) *State {
 //-----------------------------------------------------------------------------
 // Genesis
 
+func genesisValidators(genDoc *types.GenesisDoc) *types.ValidatorSet {
+	// Make validators slice
+	validators := make([]*types.Validator, len(genDoc.Validators))
+	for i, val := range genDoc.Validators {
+		pubKey := val.PubKey
+		address := pubKey.Address()
+
+		// Make validator
+		validators[i] = &types.Validator{
+			Address:     address,
+			PubKey:      pubKey,
+			VotingPower: val.Amount,
+			IsCA:        val.IsCA,
+		}
+	}
+	return types.NewValidatorSet(validators)
+}
+
 func MakeGenesisStateFromFile(db dbm.DB, genDocFile string) *State {
 	genDocJSON, err := ioutil.ReadFile(genDocFile)
 	if err != nil {
@@ -268,21 +287,7 @@ func MakeGenesisState(db dbm.DB, genDoc *types.GenesisDoc) *State {
 		genDoc.GenesisTime = time.Now()
 	}
 
-	// Make validators slice
-	validators := make([]*types.Validator, len(genDoc.Validators))
-	for i, val := range genDoc.Validators {
-		pubKey := val.PubKey
-		address := pubKey.Address()
-
-		// Make validator
-		validators[i] = &types.Validator{
-			Address:     address,
-			PubKey:      pubKey,
-			VotingPower: val.Amount,
-			IsCA:        val.IsCA,
-		}
-	}
-	validatorSet := types.NewValidatorSet(validators)
+	validatorSet := genesisValidators(genDoc)
 	lastValidatorSet := types.NewValidatorSet(nil)
 
 	// TODO: genDoc doesn't need to provide receiptsHash
@@ -0,0 +1,104 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"bytes"
+
+	"github.com/dappledger/AnnChain/gemmill/go-wire"
+	gcmn "github.com/dappledger/AnnChain/gemmill/modules/go-common"
+	dbm "github.com/dappledger/AnnChain/gemmill/modules/go-db"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// ErrNoValidators is returned for a height whose validator set isn't recorded,
+// one executed before the sets were
+type ErrNoValidators struct {
+	Height int64
+}
+
+func (e ErrNoValidators) Error() string {
+	return gcmn.Fmt("Could not find validators of height %d", e.Height)
+}
+
+// validatorsInfo is the record of the validator set of a height. The set is only
+// stored at the heights it changed at, LastHeightChanged points there.
+type validatorsInfo struct {
+	ValidatorSet      *types.ValidatorSet
+	LastHeightChanged int64
+}
+
+func calcValidatorsKey(height int64) []byte {
+	return []byte(gcmn.Fmt("validatorsKey:%v", height))
+}
+
+func loadValidatorsInfo(db dbm.DB, height int64) *validatorsInfo {
+	buf := db.Get(calcValidatorsKey(height))
+	if len(buf) == 0 {
+		return nil
+	}
+	info, n, err := new(validatorsInfo), new(int), new(error)
+	wire.ReadBinaryPtr(&info, bytes.NewReader(buf), 0, n, err)
+	if *err != nil {
+		gcmn.Exit(gcmn.Fmt("Data has been corrupted or its spec has changed: %v\n", *err))
+	}
+	return info
+}
+
+// sameValidators tells whether a and b hold the same validators with the same
+// power, whatever their proposer accums
+func sameValidators(a, b *types.ValidatorSet) bool {
+	if a.Size() != b.Size() {
+		return false
+	}
+	for i, val := range a.Validators {
+		other := b.Validators[i]
+		if !bytes.Equal(val.Address, other.Address) || val.VotingPower != other.VotingPower {
+			return false
+		}
+	}
+	return true
+}
+
+// saveValidators records valSet as the validator set of height
+func (s *State) saveValidators(height int64, valSet *types.ValidatorSet) {
+	info := &validatorsInfo{ValidatorSet: valSet, LastHeightChanged: height}
+	if prevInfo := loadValidatorsInfo(s.db, height-1); prevInfo != nil {
+		if prev, err := s.LoadValidators(height - 1); err == nil && sameValidators(prev, valSet) {
+			info = &validatorsInfo{LastHeightChanged: prevInfo.LastHeightChanged}
+		}
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.db.Set(calcValidatorsKey(height), wire.BinaryBytes(info))
+}
+
+// LoadValidators returns the validator set of the block at height, the
+// genesis validators for the first block. Safe for concurrent use.
+func (s *State) LoadValidators(height int64) (*types.ValidatorSet, error) {
+	info := loadValidatorsInfo(s.db, height)
+	if info == nil {
+		if height == 1 && s.GenesisDoc != nil {
+			return genesisValidators(s.GenesisDoc), nil
+		}
+		return nil, ErrNoValidators{height}
+	}
+	if info.ValidatorSet == nil {
+		if info = loadValidatorsInfo(s.db, info.LastHeightChanged); info == nil || info.ValidatorSet == nil {
+			return nil, ErrNoValidators{height}
+		}
+	}
+	return info.ValidatorSet, nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"testing"
+
+	"github.com/dappledger/AnnChain/gemmill/go-crypto"
+	dbm "github.com/dappledger/AnnChain/gemmill/modules/go-db"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func TestLoadValidators(t *testing.T) {
+	first, second := crypto.GenPrivKeyEd25519().PubKey(), crypto.GenPrivKeyEd25519().PubKey()
+	s := MakeGenesisState(dbm.NewMemDB(), &types.GenesisDoc{
+		ChainID:    "test",
+		Validators: []types.GenesisValidator{{PubKey: first, Amount: 1}},
+	})
+	changed := types.NewValidatorSet([]*types.Validator{types.NewValidator(first, 1, false), types.NewValidator(second, 1, false)})
+	s.saveValidators(2, s.Validators.Copy())
+	s.saveValidators(3, changed)
+	s.saveValidators(4, changed.Copy())
+
+	for height, size := range map[int64]int{1: 1, 2: 1, 3: 2, 4: 2} {
+		valSet, err := s.LoadValidators(height)
+		if err != nil {
+			t.Fatalf("height %d: %v", height, err)
+		}
+		if valSet.Size() != size {
+			t.Fatalf("expected %d validators at height %d, got %d", size, height, valSet.Size())
+		}
+	}
+	if _, err := s.LoadValidators(5); err == nil {
+		t.Fatal("expected no validators of an unrecorded height")
+	}
+	if info := loadValidatorsInfo(s.db, 4); info.ValidatorSet != nil || info.LastHeightChanged != 3 {
+		t.Fatalf("expected an unchanged set stored as the height it changed at, got %+v", info)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/dappledger/AnnChain/gemmill/go-wire"
+)
+
+var (
+	// AppMessageTag prefixes app message txs, they are carried by Block.Data.ExTxs
+	// like adminOP txs
+	AppMessageTag = []byte("zamg")
+
+	ErrAppMessageInvalid = errors.New("invalid app message")
+)
+
+// AppMessage is a message an app of a node sends to a sibling app. The node
+// routes the messages an app committed to the app they're for, as app message
+// txs. Nonce numbers the messages of the sending app from 1, so the receiving
+// app drops the ones it already got.
+type AppMessage struct {
+	From    string `json:"from"`    // app sending the message
+	To      string `json:"to"`      // app the message is for
+	Nonce   uint64 `json:"nonce"`   // nonce of the message among the ones From sent
+	Sender  []byte `json:"sender"`  // account of From sending the message
+	Target  []byte `json:"target"`  // account of To the message is for
+	Payload []byte `json:"payload"` // message data, opaque to the node
+}
+
+func TagAppMessageTx(msg *AppMessage) []byte {
+	return WrapTx(AppMessageTag, wire.BinaryBytes(msg))
+}
+
+func IsAppMessageTx(tx []byte) bool {
+	return bytes.HasPrefix(tx, AppMessageTag)
+}
+
+func DecodeAppMessageTx(tx []byte) (*AppMessage, error) {
+	if !IsAppMessageTx(tx) {
+		return nil, ErrAppMessageInvalid
+	}
+	var n int
+	var err error
+	msg := wire.ReadBinary(&AppMessage{}, bytes.NewReader(UnwrapTx(tx)), len(tx), &n, &err).(*AppMessage)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAppMessageTx(t *testing.T) {
+	msg := &AppMessage{From: "evm", To: "kv", Nonce: 3, Sender: []byte("sender"), Target: []byte("target"), Payload: []byte("payload")}
+	tx := TagAppMessageTx(msg)
+	if !IsAppMessageTx(tx) || IsAdminOP(tx) || IsEvidenceTx(tx) {
+		t.Fatal("expected an app message tx")
+	}
+	decoded, err := DecodeAppMessageTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Fatalf("expected the decoded message to match, got %+v", decoded)
+	}
+	if _, err := DecodeAppMessageTx(TagAdminOPTx([]byte("op"))); err != ErrAppMessageInvalid {
+		t.Fatal("expected an adminOP tx to be refused")
+	}
+}
@@ -43,6 +43,11 @@ type Application interface {
 type Core interface {
 	Query(byte, []byte) (interface{}, error)
 	GetBlockMeta(height int64) (*BlockMeta, error)
+	// Height returns the height of the latest block stored by the core, the app
+	// catches up to it when replaying blocks
+	Height() int64
+	// ValidatorsAt returns the validator set of the block at height
+	ValidatorsAt(height int64) (*ValidatorSet, error)
 }
 
 // type AppMaker func(config.Config) Application
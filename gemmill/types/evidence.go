@@ -0,0 +1,107 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/dappledger/AnnChain/gemmill/go-crypto"
+	"github.com/dappledger/AnnChain/gemmill/go-wire"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-merkle"
+)
+
+var (
+	// EvidenceTag prefixes evidence txs, they are carried by Block.Data.ExTxs
+	// like adminOP txs
+	EvidenceTag = []byte("zaev")
+
+	ErrEvidenceInvalid = errors.New("invalid evidence")
+)
+
+// DuplicateVoteEvidence proves a validator signed two different votes of the same
+// height, round and type.
+type DuplicateVoteEvidence struct {
+	PubKey crypto.PubKey `json:"pub_key"`
+	VoteA  *Vote         `json:"vote_a"`
+	VoteB  *Vote         `json:"vote_b"`
+}
+
+// NewDuplicateVoteEvidence orders the conflicting votes by block hash, so both
+// orders give the same evidence.
+func NewDuplicateVoteEvidence(pubKey crypto.PubKey, voteA, voteB *Vote) *DuplicateVoteEvidence {
+	if voteA.BlockID.Key() > voteB.BlockID.Key() {
+		voteA, voteB = voteB, voteA
+	}
+	return &DuplicateVoteEvidence{PubKey: pubKey, VoteA: voteA, VoteB: voteB}
+}
+
+func (ev *DuplicateVoteEvidence) Address() []byte {
+	return ev.PubKey.Address()
+}
+
+func (ev *DuplicateVoteEvidence) Height() int64 {
+	return ev.VoteA.Height
+}
+
+func (ev *DuplicateVoteEvidence) Hash() []byte {
+	return merkle.SimpleHashFromBinary(ev)
+}
+
+// Verify checks the votes conflict and are both signed by PubKey on chainID. It
+// doesn't check PubKey belongs to a validator.
+func (ev *DuplicateVoteEvidence) Verify(chainID string) error {
+	if ev.PubKey == nil || ev.VoteA == nil || ev.VoteB == nil {
+		return ErrEvidenceInvalid
+	}
+	a, b := ev.VoteA, ev.VoteB
+	if a.Height != b.Height || a.Round != b.Round || a.Type != b.Type {
+		return ErrEvidenceInvalid
+	}
+	if !bytes.Equal(a.ValidatorAddress, b.ValidatorAddress) || !bytes.Equal(a.ValidatorAddress, ev.PubKey.Address()) {
+		return ErrEvidenceInvalid
+	}
+	if a.BlockID.Equals(b.BlockID) {
+		return ErrEvidenceInvalid
+	}
+	if a.Signature == nil || b.Signature == nil {
+		return ErrVoteInvalidSignature
+	}
+	if !ev.PubKey.VerifyBytes(SignBytes(chainID, a), a.Signature) || !ev.PubKey.VerifyBytes(SignBytes(chainID, b), b.Signature) {
+		return ErrVoteInvalidSignature
+	}
+	return nil
+}
+
+func TagEvidenceTx(ev *DuplicateVoteEvidence) []byte {
+	return WrapTx(EvidenceTag, wire.BinaryBytes(ev))
+}
+
+func IsEvidenceTx(tx []byte) bool {
+	return bytes.HasPrefix(tx, EvidenceTag)
+}
+
+func DecodeEvidenceTx(tx []byte) (*DuplicateVoteEvidence, error) {
+	if !IsEvidenceTx(tx) {
+		return nil, ErrEvidenceInvalid
+	}
+	var n int
+	var err error
+	ev := wire.ReadBinary(&DuplicateVoteEvidence{}, bytes.NewReader(UnwrapTx(tx)), len(tx), &n, &err).(*DuplicateVoteEvidence)
+	if err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dappledger/AnnChain/gemmill/go-crypto"
+)
+
+func signEvidenceVote(priv crypto.PrivKeyEd25519, round int64, blockHash string) *Vote {
+	vote := &Vote{
+		ValidatorAddress: priv.PubKey().Address(),
+		Height:           1,
+		Round:            round,
+		Type:             VoteTypePrecommit,
+		BlockID:          BlockID{Hash: []byte(blockHash)},
+	}
+	vote.Signature = priv.Sign(SignBytes("chain", vote))
+	return vote
+}
+
+func TestDuplicateVoteEvidence(t *testing.T) {
+	priv := crypto.GenPrivKeyEd25519()
+	voteA, voteB := signEvidenceVote(priv, 0, "a"), signEvidenceVote(priv, 0, "b")
+	ev := NewDuplicateVoteEvidence(priv.PubKey(), voteB, voteA)
+	if err := ev.Verify("chain"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ev.Hash(), NewDuplicateVoteEvidence(priv.PubKey(), voteA, voteB).Hash()) {
+		t.Fatal("expected the vote order not to change the evidence")
+	}
+
+	tx := TagEvidenceTx(ev)
+	if !IsEvidenceTx(tx) || IsAdminOP(tx) {
+		t.Fatal("expected an evidence tx")
+	}
+	decoded, err := DecodeEvidenceTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Hash(), ev.Hash()) || decoded.Verify("chain") != nil {
+		t.Fatal("expected the decoded evidence to match")
+	}
+
+	for _, bad := range []*DuplicateVoteEvidence{
+		{PubKey: priv.PubKey(), VoteA: voteA, VoteB: voteA},
+		{PubKey: priv.PubKey(), VoteA: voteA, VoteB: signEvidenceVote(priv, 1, "b")},
+		{PubKey: crypto.GenPrivKeyEd25519().PubKey(), VoteA: voteA, VoteB: voteB},
+	} {
+		if bad.Verify("chain") == nil {
+			t.Fatalf("expected evidence %v to be rejected", bad)
+		}
+	}
+	if ev.Verify("other") == nil {
+		t.Fatal("expected evidence of another chain to be rejected")
+	}
+}
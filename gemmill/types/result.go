@@ -29,8 +29,9 @@ type NewRoundResult struct {
 }
 
 type CommitResult struct {
-	AppHash      []byte
-	ReceiptsHash []byte
+	AppHash         []byte
+	ReceiptsHash    []byte
+	LightHeaderHash []byte // hash of the app's light header of the block, if it keeps one
 }
 
 type ExecuteInvalidTx struct {
@@ -26,6 +26,8 @@ const (
 	CodeType_InsufficientFunds CodeType = 5
 	CodeType_UnknownRequest    CodeType = 6
 	CodeType_InvalidTx         CodeType = 7
+	CodeType_Syncing           CodeType = 8
+	CodeType_ServerBusy        CodeType = 9
 	// Reserved for basecoin, 100 ~ 199
 	CodeType_BaseDuplicateAddress     CodeType = 101
 	CodeType_BaseEncodingError        CodeType = 102
@@ -63,6 +65,8 @@ var CodeType_name = map[int32]string{
 	4:   "Unauthorized",
 	5:   "InsufficientFunds",
 	6:   "UnknownRequest",
+	8:   "Syncing",
+	9:   "ServerBusy",
 	101: "BaseDuplicateAddress",
 	102: "BaseEncodingError",
 	103: "BaseInsufficientFees",
@@ -97,6 +101,8 @@ var CodeType_value = map[string]int32{
 	"Unauthorized":             4,
 	"InsufficientFunds":        5,
 	"UnknownRequest":           6,
+	"Syncing":                  8,
+	"ServerBusy":               9,
 	"BaseDuplicateAddress":     101,
 	"BaseEncodingError":        102,
 	"BaseInsufficientFees":     103,
@@ -151,6 +151,7 @@ type ResultInfo struct {
 	Version          string `json:"version"`
 	LastBlockHeight  int64  `json:"last_block_height"`
 	LastBlockAppHash []byte `json:"last_block_app_hash"`
+	GenesisHash      []byte `json:"genesis_hash,omitempty"`
 }
 
 type ResultQuery struct {